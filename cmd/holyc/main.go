@@ -1,21 +1,32 @@
 package main
 
 import (
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"holyc-compiler/pkg/codegen"
-	"holyc-compiler/pkg/lexer"
-	"holyc-compiler/pkg/parser"
+	"holyc-compiler/pkg/compiler"
+	"holyc-compiler/pkg/diag"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: holyc <file.HC> [--hex | --asm | --bin] [-o output]\n")
+		fmt.Fprintf(os.Stderr, "Usage: holyc <file.HC> [--hex | --asm | --asm-source | --bin | --json] [-o output] [--source-map compact|json] [--diagnostics text|json] [-O0|-O1|-O2|-Os] [--cost gas|size] [--ir | --dump-ir] [--gas-schedule file.json] [--gas-report] [--gas-budget N]\n")
+		fmt.Fprintf(os.Stderr, "       holyc <file.HC>... --json [-o output] [options]\n")
+		fmt.Fprintf(os.Stderr, "       holyc verify <file.hcb>...\n")
+		fmt.Fprintf(os.Stderr, "       holyc jumps <file.hcb>\n")
 		os.Exit(1)
 	}
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(verifyFiles(os.Args[2:]))
+	case "jumps":
+		os.Exit(printJumps(os.Args[2:]))
+	}
 
 	filename := os.Args[1]
 	src, err := os.ReadFile(filename)
@@ -26,6 +37,16 @@ func main() {
 
 	mode := "asm"
 	outFile := ""
+	schedule := codegen.DefaultGasSchedule()
+	gasReport := false
+	gasBudget := -1
+	level := codegen.O0
+	viaIR := false
+	withSource := false
+	sourceMap := ""
+	diagFormat := "text"
+	var costModel *codegen.CostModel
+	var others []string
 	for i := 2; i < len(os.Args); i++ {
 		switch os.Args[i] {
 		case "--hex":
@@ -34,6 +55,17 @@ func main() {
 			mode = "bin"
 		case "--asm":
 			mode = "asm"
+		case "--asm-source":
+			mode = "asm"
+			withSource = true
+		case "--dump-ir":
+			mode = "ir"
+		case "--json":
+			mode = "json"
+		case "--ir":
+			viaIR = true
+		case "-O", "-O0", "-O1", "-O2", "-Os":
+			level, _ = codegen.ParseOptLevel(os.Args[i])
 		case "-o":
 			if i+1 < len(os.Args) {
 				i++
@@ -42,84 +74,333 @@ func main() {
 				fmt.Fprintf(os.Stderr, "-o requires a filename\n")
 				os.Exit(1)
 			}
+		case "--cost":
+			if i+1 >= len(os.Args) {
+				fmt.Fprintf(os.Stderr, "--cost requires gas or size\n")
+				os.Exit(1)
+			}
+			i++
+			m, err := codegen.ParseCostModel(os.Args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			costModel = &m
+		case "--gas-schedule":
+			if i+1 >= len(os.Args) {
+				fmt.Fprintf(os.Stderr, "--gas-schedule requires a filename\n")
+				os.Exit(1)
+			}
+			i++
+			s, err := codegen.LoadGasSchedule(os.Args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error loading gas schedule: %v\n", err)
+				os.Exit(1)
+			}
+			schedule = s
+		case "--source-map":
+			if i+1 >= len(os.Args) || (os.Args[i+1] != "compact" && os.Args[i+1] != "json") {
+				fmt.Fprintf(os.Stderr, "--source-map requires compact or json\n")
+				os.Exit(1)
+			}
+			i++
+			sourceMap = os.Args[i]
+		case "--diagnostics":
+			if i+1 >= len(os.Args) || (os.Args[i+1] != "text" && os.Args[i+1] != "json") {
+				fmt.Fprintf(os.Stderr, "--diagnostics requires text or json\n")
+				os.Exit(1)
+			}
+			i++
+			diagFormat = os.Args[i]
+		case "--gas-report":
+			gasReport = true
+		case "--gas-budget":
+			if i+1 >= len(os.Args) {
+				fmt.Fprintf(os.Stderr, "--gas-budget requires a gas amount\n")
+				os.Exit(1)
+			}
+			i++
+			n, err := strconv.Atoi(os.Args[i])
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "invalid gas budget: %s\n", os.Args[i])
+				os.Exit(1)
+			}
+			gasBudget = n
+		default:
+			if !strings.HasPrefix(os.Args[i], "-") {
+				others = append(others, os.Args[i])
+			}
 		}
 	}
+	if len(others) > 0 && mode != "json" {
+		fmt.Fprintf(os.Stderr, "several source files require --json\n")
+		os.Exit(1)
+	}
+	binFile := outFile
+	if binFile == "" || mode != "bin" {
+		binFile = strings.TrimSuffix(filename, ".HC") + ".hcb"
+	}
 	if outFile == "" && mode == "bin" {
-		outFile = strings.TrimSuffix(filename, ".HC") + ".hcb"
+		outFile = binFile
 	}
 
-	// 1. Lexer
-	l := lexer.NewLexer(string(src), filename)
-
-	// 2. Parser
-	p := parser.NewParser(l)
-	program := p.Parse()
+	sources := []compiler.Source{{Name: filename, Text: string(src)}}
+	opts := compiler.Options{Level: level, Cost: costModel, Schedule: schedule, ViaIR: viaIR, AsmSource: withSource}
+	if mode == "json" {
+		// Chaque fichier est un contrat, compilé à part.
+		contracts := [][]compiler.Source{sources}
+		for _, path := range others {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			contracts = append(contracts, []compiler.Source{{Name: path, Text: string(text)}})
+		}
+		os.Exit(writeArtifacts(contracts, opts, diagFormat, gasBudget, outFile))
+	}
+	if mode == "ir" {
+		prog, diags := compiler.LowerIR(sources, opts)
+		reportDiagnostics(diags, diagFormat, sources)
+		if prog == nil {
+			os.Exit(1)
+		}
+		fmt.Print(prog)
+		return
+	}
 
-	if len(p.Errors) > 0 {
-		fmt.Fprintf(os.Stderr, "\n%d parse error(s)\n", len(p.Errors))
+	// Les erreurs de génération n'arrêtent pas la compilation : le code
+	// produit reste utile pour repérer ce qui manque (un appel sans CALL).
+	art, diags := compiler.Compile(sources, opts)
+	reportDiagnostics(diags, diagFormat, sources)
+	if art == nil {
 		os.Exit(1)
 	}
 
-	// 3. Code generation
-	cg := codegen.NewCodeGen()
-	instructions := cg.Generate(program)
-
-	if len(cg.Errors) > 0 {
-		fmt.Fprintf(os.Stderr, "\n%d codegen warning(s)\n", len(cg.Errors))
+	if gasReport || gasBudget >= 0 {
+		printGasReport(art.WorstCase, gasBudget)
+		if gasBudget >= 0 {
+			if over := art.OverBudget(gasBudget); len(over) > 0 {
+				fmt.Fprintf(os.Stderr, "\ngas budget %d exceeded by: %s\n", gasBudget, strings.Join(over, ", "))
+				os.Exit(1)
+			}
+		}
 	}
 
-	// 4. Output
 	switch mode {
 	case "asm":
-		printAsm(instructions)
+		fmt.Print(art.Asm)
 	case "hex":
-		printHex(instructions)
+		fmt.Printf("%X\n", art.Bytecode)
 	case "bin":
-		writeBinFile(instructions, outFile)
+		writeBinFile(art.Bytecode, outFile)
+	}
+	if sourceMap != "" {
+		writeSourceMap(art.SourceMap, sourceMap, binFile+".map")
 	}
 }
 
-func printAsm(code []codegen.Instruction) {
-	totalGas := 0
-	for i, inst := range code {
-		gas := inst.Gas()
-		totalGas += gas
-		fmt.Printf("  %04d  %-20s  ; 0x%02X  gas=%d\n", i, inst.String(), byte(inst.Op), gas)
+// writeArtifacts compile chaque contrat et écrit son document JSON sur
+// stdout, ou dans path s'il n'est pas vide. Un contrat qui ne compile pas ou
+// dépasse le budget de gas n'a pas de document ; retourne alors 1.
+func writeArtifacts(contracts [][]compiler.Source, opts compiler.Options, diagFormat string, gasBudget int, path string) int {
+	out := io.Writer(os.Stdout)
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %s: %v\n", path, err)
+			return 1
+		}
+		out = f
+	}
+	status := 0
+	for _, sources := range contracts {
+		art, diags := compiler.Compile(sources, opts)
+		reportDiagnostics(diags, diagFormat, sources)
+		if art == nil {
+			status = 1
+			continue
+		}
+		if gasBudget >= 0 {
+			if over := art.OverBudget(gasBudget); len(over) > 0 {
+				fmt.Fprintf(os.Stderr, "\n%s: gas budget %d exceeded by: %s\n", art.Name, gasBudget, strings.Join(over, ", "))
+				status = 1
+				continue
+			}
+		}
+		if err := compiler.WriteJSON(out, art); err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding %s: %v\n", art.Name, err)
+			status = 1
+		}
 	}
-	fmt.Printf("\n; Total: %d instructions, estimated gas: %d\n", len(code), totalGas)
+	if f != nil {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	}
+	return status
 }
 
-func printHex(code []codegen.Instruction) {
-	for _, inst := range code {
-		fmt.Printf("%02X", byte(inst.Op))
-		if inst.Op.IsPush() {
-			n := inst.Op.PushSize()
-			buf := make([]byte, 8)
-			binary.LittleEndian.PutUint64(buf, uint64(inst.Operand))
-			for i := 0; i < n; i++ {
-				fmt.Printf("%02X", buf[i])
-			}
+// reportDiagnostics affiche ds, déjà triés, sur stderr : au format
+// text, chacun avec sa ligne du source et un caret, suivis du nombre
+// d'erreurs et d'avertissements ; au format json, en un tableau (vide s'il
+// n'y a rien à signaler).
+func reportDiagnostics(ds []diag.Diagnostic, format string, sources []compiler.Source) {
+	if format == "json" {
+		if err := diag.WriteJSON(os.Stderr, ds); err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding diagnostics: %v\n", err)
 		}
+		return
 	}
-	fmt.Println()
+	if len(ds) == 0 {
+		return
+	}
+	texts := make(map[string]string, len(sources))
+	for _, src := range sources {
+		texts[src.Name] = src.Text
+	}
+	diag.Render(os.Stderr, ds, texts)
+	errors, warnings := diag.Count(ds)
+	fmt.Fprintf(os.Stderr, "\n%d error(s), %d warning(s)\n", errors, warnings)
 }
 
-func writeBinFile(code []codegen.Instruction, path string) {
-	f, err := os.Create(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating %s: %v\n", path, err)
-		os.Exit(1)
+func printGasReport(report []codegen.WorstCase, budget int) {
+	fmt.Fprintf(os.Stderr, "; Worst-case gas\n")
+	for _, wc := range report {
+		name := wc.Name
+		if wc.Public {
+			name = "public " + name
+		}
+		if !wc.Unbounded {
+			fmt.Fprintf(os.Stderr, ";   %-24s %d\n", name, wc.Gas)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, ";   %-24s unbounded (>= %d)\n", name, wc.Gas)
+		for _, i := range wc.UnboundedLoops {
+			fmt.Fprintf(os.Stderr, ";     loop at %04d has no bound (#pragma bound N or [[bound(N)]])\n", i)
+		}
+		if wc.Recursive {
+			fmt.Fprintf(os.Stderr, ";     recursive call cycle\n")
+		}
+		if wc.Irreducible {
+			fmt.Fprintf(os.Stderr, ";     irreducible control flow\n")
+		}
+		for _, i := range wc.DynamicJumps {
+			fmt.Fprintf(os.Stderr, ";     dynamic jump at %04d\n", i)
+		}
+		for _, i := range wc.DynamicCosts {
+			fmt.Fprintf(os.Stderr, ";     unbounded dynamic cost at %04d\n", i)
+		}
 	}
-	defer f.Close()
+}
 
-	for _, inst := range code {
-		f.Write([]byte{byte(inst.Op)})
-		if inst.Op.IsPush() {
-			n := inst.Op.PushSize()
-			buf := make([]byte, 8)
-			binary.LittleEndian.PutUint64(buf, uint64(inst.Operand))
-			f.Write(buf[:n])
+// writeSourceMap écrit m au format format (compact ou json) dans path.
+func writeSourceMap(m *codegen.SourceMap, format, path string) {
+	data := []byte(m.Compact())
+	if format == "json" {
+		var err error
+		if data, err = json.MarshalIndent(m, "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding source map: %v\n", err)
+			os.Exit(1)
 		}
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", path, err)
+		os.Exit(1)
 	}
 	fmt.Fprintf(os.Stderr, "wrote %s\n", path)
 }
+
+func writeBinFile(bytecode []byte, path string) {
+	if err := os.WriteFile(path, bytecode, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+}
+
+// readHCB lit et décode un fichier .hcb, en signalant l'erreur sur stderr.
+func readHCB(path string) ([]codegen.Instruction, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		return nil, false
+	}
+	code, err := codegen.Decode(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return nil, false
+	}
+	return code, true
+}
+
+// verifyFiles vérifie la pile et les sauts de chaque fichier .hcb ; retourne
+// le code de sortie (1 si un fichier est illisible ou invalide).
+func verifyFiles(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "verify requires at least one .hcb file\n")
+		return 1
+	}
+	status := 0
+	for _, path := range paths {
+		code, ok := readHCB(path)
+		if !ok {
+			status = 1
+			continue
+		}
+		chk := codegen.VerifyStack(code)
+		for _, e := range chk.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, e)
+		}
+		bad := codegen.AnalyzeJumps(code).Bad()
+		for _, j := range bad {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, j)
+		}
+		if n := len(chk.Errors) + len(bad); n > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d error(s)\n", path, n)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: ok (%d instructions, max stack height %d)\n", path, len(code), chk.MaxHeight)
+	}
+	return status
+}
+
+// printJumps affiche l'analyse des JUMPDEST et des sauts d'un fichier .hcb ;
+// retourne 1 si un saut atteignable n'a pas de destination valide.
+func printJumps(paths []string) int {
+	if len(paths) != 1 {
+		fmt.Fprintf(os.Stderr, "jumps requires one .hcb file\n")
+		return 1
+	}
+	code, ok := readHCB(paths[0])
+	if !ok {
+		return 1
+	}
+	a := codegen.AnalyzeJumps(code)
+	var dests []string
+	for off, valid := range a.Dests {
+		if valid {
+			dests = append(dests, fmt.Sprintf("0x%04X", off))
+		}
+	}
+	fmt.Printf("; JUMPDEST: %s\n", strings.Join(dests, " "))
+	for _, j := range a.Jumps {
+		if j.Reachable {
+			fmt.Printf("  %v\n", j)
+		} else {
+			fmt.Printf("  %v (unreachable)\n", j)
+		}
+	}
+	for _, r := range a.Unreachable {
+		fmt.Printf("; unreachable code: %04d-%04d\n", r[0], r[1]-1)
+	}
+	if len(a.Bad()) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package codegen
+
+// absVal est une valeur de la pile abstraite : connue si elle provient d'une
+// constante propagée (PUSH, DUP, opcodes purs sur constantes).
+type absVal struct {
+	v     uint64
+	known bool
+}
+
+// absStack est une pile abstraite de constantes, sommet = dernier élément.
+// Lire sous le fond donne une valeur inconnue.
+type absStack struct {
+	vals []absVal
+}
+
+func (s *absStack) push(v absVal) { s.vals = append(s.vals, v) }
+
+func (s *absStack) pop() absVal {
+	if len(s.vals) == 0 {
+		return absVal{}
+	}
+	v := s.vals[len(s.vals)-1]
+	s.vals = s.vals[:len(s.vals)-1]
+	return v
+}
+
+// peek retourne stack[n] (0 = sommet).
+func (s *absStack) peek(n int) absVal {
+	if n >= len(s.vals) {
+		return absVal{}
+	}
+	return s.vals[len(s.vals)-1-n]
+}
+
+// forget remplace la pile par n valeurs inconnues.
+func (s *absStack) forget(n int) {
+	s.vals = append(s.vals[:0], make([]absVal, n)...)
+}
+
+// step applique inst à la pile. Un JUMPDEST, où plusieurs chemins peuvent
+// se rejoindre, et un opcode inconnu rendent toutes les valeurs inconnues.
+func (s *absStack) step(inst Instruction) {
+	op := inst.Op
+	switch {
+	case op == OP_PUSH0 || op.IsPush():
+		s.push(absVal{uint64(inst.Operand), true})
+		return
+	case op >= OP_DUP1 && op <= OP_DUP8:
+		s.push(s.peek(int(op - OP_DUP1)))
+		return
+	case op >= OP_SWAP1 && op <= OP_SWAP8:
+		n := int(op-OP_SWAP1) + 1
+		for len(s.vals) <= n {
+			s.vals = append([]absVal{{}}, s.vals...)
+		}
+		top := len(s.vals) - 1
+		s.vals[top], s.vals[top-n] = s.vals[top-n], s.vals[top]
+		return
+	}
+	info, ok := opcodeInfo[op]
+	if !ok || op == OP_JUMPDEST {
+		s.forget(len(s.vals))
+		return
+	}
+	args := make([]absVal, info.Args)
+	for i := range args {
+		args[i] = s.pop()
+	}
+	s.results(op, args, info.Results)
+}
+
+// results empile les n résultats de op, évalués si tous les opérandes sont connus.
+func (s *absStack) results(op Opcode, args []absVal, n int) {
+	vals := make([]uint64, len(args))
+	allKnown := true
+	for i, a := range args {
+		vals[i] = a.v
+		allKnown = allKnown && a.known
+	}
+	if allKnown {
+		if res, ok := Eval(op, vals...); ok && len(res) == n {
+			for i := len(res) - 1; i >= 0; i-- {
+				s.push(absVal{res[i], true})
+			}
+			return
+		}
+	}
+	for i := 0; i < n; i++ {
+		s.push(absVal{})
+	}
+}
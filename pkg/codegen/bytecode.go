@@ -0,0 +1,40 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encode produit le bytecode (format .hcb) : chaque opcode sur un octet,
+// suivi pour PUSH1-PUSH8 de son immédiat en little-endian.
+func Encode(code []Instruction) []byte {
+	out := make([]byte, 0, Offsets(code)[len(code)])
+	var buf [8]byte
+	for _, inst := range code {
+		out = append(out, byte(inst.Op))
+		if n := inst.Op.PushSize(); n > 0 {
+			binary.LittleEndian.PutUint64(buf[:], uint64(inst.Operand))
+			out = append(out, buf[:n]...)
+		}
+	}
+	return out
+}
+
+// Decode relit un bytecode .hcb. Les octets qui ne correspondent à aucun
+// opcode connu sont conservés tels quels (Opcode inconnu) pour que le
+// vérificateur puisse les signaler ; seul un PUSH tronqué est une erreur.
+func Decode(data []byte) ([]Instruction, error) {
+	var code []Instruction
+	for pc := 0; pc < len(data); {
+		op := Opcode(data[pc])
+		n := op.PushSize()
+		if pc+1+n > len(data) {
+			return code, fmt.Errorf("offset 0x%X: %s truncated (%d of %d immediate bytes)", pc, op, len(data)-pc-1, n)
+		}
+		var buf [8]byte
+		copy(buf[:], data[pc+1:pc+1+n])
+		code = append(code, Instruction{Op: op, Operand: int64(binary.LittleEndian.Uint64(buf[:]))})
+		pc += 1 + n
+	}
+	return code, nil
+}
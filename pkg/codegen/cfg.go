@@ -0,0 +1,194 @@
+package codegen
+
+import "math"
+
+// Block est un bloc de base : les instructions [Start, End), sans saut
+// entrant ailleurs qu'en Start ni saut sortant ailleurs qu'en End-1.
+type Block struct {
+	Index int
+	Start int
+	End   int
+	Succs []int // blocs successeurs (saut et/ou chute)
+	Preds []int
+
+	// Exit indique que le bloc termine l'exécution (STOP, RETURN, REVERT)
+	// ou sort du code par la fin.
+	Exit bool
+	// DynamicJump indique un JUMP/JUMPI dont la destination n'est pas une
+	// constante, ou ne désigne pas un JUMPDEST.
+	DynamicJump bool
+}
+
+// CFG est le graphe de flot de contrôle d'une séquence d'instructions.
+type CFG struct {
+	Code    []Instruction
+	Blocks  []*Block
+	blockOf []int // instruction → index de bloc
+}
+
+// BlockOf retourne le bloc contenant l'instruction i.
+func (g *CFG) BlockOf(i int) *Block {
+	return g.Blocks[g.blockOf[i]]
+}
+
+func isTerminator(op Opcode) bool {
+	switch op {
+	case OP_STOP, OP_RETURN, OP_REVERT, OP_JUMP, OP_INVALID:
+		return true
+	}
+	return false
+}
+
+// jumpTarget retourne l'index de l'instruction visée par le JUMP/JUMPI en i,
+// si la destination est une constante (voir jumpConstants) et désigne un
+// JUMPDEST. ok vaut false pour un saut dynamique ou invalide.
+func jumpTarget(code []Instruction, byOffset map[int]int, consts map[int]uint64, i int) (int, bool) {
+	dest, ok := consts[i]
+	if !ok || dest > math.MaxInt32 {
+		return 0, false
+	}
+	target, ok := byOffset[int(dest)]
+	if !ok || code[target].Op != OP_JUMPDEST {
+		return 0, false
+	}
+	return target, true
+}
+
+// BuildCFG découpe le code en blocs de base et relie les sauts dont la
+// destination est une constante connue statiquement.
+func BuildCFG(code []Instruction) *CFG {
+	offsets := Offsets(code)
+	byOffset := make(map[int]int, len(code))
+	for i := range code {
+		byOffset[offsets[i]] = i
+	}
+	consts := jumpConstants(code)
+
+	leader := make([]bool, len(code)+1)
+	leader[0] = true
+	for i, inst := range code {
+		if inst.Op == OP_JUMPDEST {
+			leader[i] = true
+		}
+		if isTerminator(inst.Op) || inst.Op == OP_JUMPI {
+			leader[i+1] = true
+		}
+	}
+
+	g := &CFG{Code: code, blockOf: make([]int, len(code))}
+	for i := 0; i < len(code); {
+		b := &Block{Index: len(g.Blocks), Start: i}
+		i++
+		for i < len(code) && !leader[i] {
+			i++
+		}
+		b.End = i
+		g.Blocks = append(g.Blocks, b)
+		for j := b.Start; j < b.End; j++ {
+			g.blockOf[j] = b.Index
+		}
+	}
+
+	for _, b := range g.Blocks {
+		last := b.End - 1
+		op := code[last].Op
+		if op == OP_JUMP || op == OP_JUMPI {
+			if t, ok := jumpTarget(code, byOffset, consts, last); ok {
+				b.Succs = append(b.Succs, g.blockOf[t])
+			} else {
+				b.DynamicJump = true
+			}
+		}
+		switch {
+		case op == OP_JUMP:
+		case isTerminator(op):
+			b.Exit = true
+		case b.End < len(code):
+			b.Succs = append(b.Succs, g.blockOf[b.End])
+		default:
+			b.Exit = true
+		}
+		b.Succs = dedupInts(b.Succs)
+		for _, s := range b.Succs {
+			g.Blocks[s].Preds = append(g.Blocks[s].Preds, b.Index)
+		}
+	}
+	return g
+}
+
+func dedupInts(xs []int) []int {
+	if len(xs) < 2 || xs[0] != xs[1] {
+		return xs
+	}
+	return xs[:1]
+}
+
+// Dominators calcule, pour les blocs de l'ensemble region (nil = tous)
+// atteignables depuis entry, l'ensemble des blocs qui les dominent.
+// dom[b][d] vaut true si d domine b.
+func (g *CFG) Dominators(entry int, region map[int]bool) map[int]map[int]bool {
+	in := func(b int) bool { return region == nil || region[b] }
+
+	// Blocs atteignables depuis entry dans la région, en ordre de parcours.
+	var order []int
+	seen := map[int]bool{entry: true}
+	stack := []int{entry}
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, b)
+		for _, s := range g.Blocks[b].Succs {
+			if in(s) && !seen[s] {
+				seen[s] = true
+				stack = append(stack, s)
+			}
+		}
+	}
+
+	dom := make(map[int]map[int]bool, len(order))
+	for _, b := range order {
+		dom[b] = make(map[int]bool, len(order))
+		if b == entry {
+			dom[b][b] = true
+			continue
+		}
+		for _, d := range order {
+			dom[b][d] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order {
+			if b == entry {
+				continue
+			}
+			var next map[int]bool
+			for _, p := range g.Blocks[b].Preds {
+				if !seen[p] {
+					continue
+				}
+				if next == nil {
+					next = make(map[int]bool, len(dom[p]))
+					for d := range dom[p] {
+						next[d] = true
+					}
+					continue
+				}
+				for d := range next {
+					if !dom[p][d] {
+						delete(next, d)
+					}
+				}
+			}
+			if next == nil {
+				next = make(map[int]bool)
+			}
+			next[b] = true
+			if len(next) != len(dom[b]) {
+				dom[b] = next
+				changed = true
+			}
+		}
+	}
+	return dom
+}
@@ -2,9 +2,10 @@ package codegen
 
 import (
 	"fmt"
-	"os"
+	"sort"
 	"strings"
 
+	"holyc-compiler/pkg/diag"
 	"holyc-compiler/pkg/lexer"
 	"holyc-compiler/pkg/parser"
 )
@@ -12,8 +13,86 @@ import (
 // CodeGen transforme l'AST en une séquence d'Instructions (opcodes de la VM).
 type CodeGen struct {
 	code     []Instruction
-	Errors   []string
 	builtins map[string]builtinInfo
+
+	// Funcs décrit la région de chaque fonction dans le code généré.
+	Funcs []FuncInfo
+	// LoopBounds associe l'étiquette du JUMPDEST d'en-tête de chaque boucle
+	// annotée (#pragma bound, [[bound]]) à son nombre maximal d'itérations.
+	LoopBounds map[int]int64
+
+	labels int
+	fn     *FuncInfo  // fonction en cours de génération
+	span   lexer.Span // nœud du source à l'origine des instructions émises
+
+	// Hauteur de la pile d'opérandes au point d'émission courant, et hauteur
+	// attendue à chaque étiquette. dead vaut true après un saut inconditionnel
+	// ou une fin d'exécution, jusqu'à la prochaine étiquette.
+	height       int
+	labelHeights map[int]int
+	dead         bool
+	shadow       absStack // constantes connues sur la pile (voir liveConst)
+
+	scopes   []map[string]int // nom de variable → adresse mémoire
+	nextSlot int
+
+	defines   map[string]parser.Node // #define NOM valeur
+	expanding map[string]bool        // macros en cours de substitution
+	declared  map[string]bool        // fonctions déclarées (voir NotBuiltin)
+
+	// Passes choisit les optimisations (voir OptLevel.Passes). Folded et
+	// Peepholed comptent les expressions repliées et les réécritures de
+	// l'optimiseur à lucarne, par règle.
+	Passes    Passes
+	Folded    int
+	Peepholed map[string]int
+	// TailCalls compte les appels terminaux changés en sauts (voir
+	// EliminateTailCalls) ; Inlined rend compte de l'inlining, fonction par
+	// fonction.
+	TailCalls int
+	Inlined   []InlineDecision
+	// inlinedBranches marque les if et while dont la condition n'a pu
+	// devenir constante que par l'inlining : EliminateDeadCode les élague
+	// sans avertir.
+	inlinedBranches map[parser.Node]bool
+	// propagated marque les variables dont FoldConstants a remplacé les
+	// lectures par leur valeur : EliminateDeadCode les supprime sans
+	// avertir.
+	propagated map[*parser.VarDecl]bool
+	// Eliminated liste le code mort supprimé (voir EliminateDeadCode).
+	Eliminated []string
+	// Hoisted, Reduced et Unrolled comptent les expressions invariantes
+	// sorties des boucles, les variables d'induction dérivées et les boucles
+	// déroulées (voir OptimizeLoops).
+	Hoisted  int
+	Reduced  int
+	Unrolled int
+	// CachedLoads et MergedStores comptent les SLOAD remplacés par une valeur
+	// connue et les SSTORE supprimés (voir OptimizeStorage).
+	CachedLoads  int
+	MergedStores int
+	// Reused compte les calculs de sous-expressions communes évités.
+	Reused int
+	// Schedule est le barème qui guide les choix de séquences (nil = défaut).
+	Schedule *GasSchedule
+	// Diagnostics reçoit les erreurs et les avertissements, situés au nœud
+	// en cours de génération ; aucun n'interrompt la génération.
+	Diagnostics []diag.Diagnostic
+}
+
+// LocalsBase est l'adresse mémoire du premier emplacement de variable : les
+// variables occupent chacune un mot de 8 octets à partir de là. La mémoire
+// sous LocalsBase reste au programme (0x00-0x07 sert au retour de valeur).
+const LocalsBase = 0x100
+
+// FuncInfo décrit la région d'une fonction dans le code généré : les
+// instructions [Start, End), émises à l'endroit où la fonction est déclarée.
+type FuncInfo struct {
+	Name   string
+	Public bool
+	Start  int
+	End    int
+	Calls  []string // fonctions non builtin appelées depuis le corps
 }
 
 type builtinInfo struct {
@@ -21,103 +100,222 @@ type builtinInfo struct {
 	argCount int
 }
 
+// builtins associe chaque fonction intégrée à son opcode et à son nombre
+// d'arguments.
+var builtins = map[string]builtinInfo{
+	"Add":        {OP_ADD, 2},
+	"Mul":        {OP_MUL, 2},
+	"Sub":        {OP_SUB, 2},
+	"Div":        {OP_DIV, 2},
+	"SDiv":       {OP_SDIV, 2},
+	"Mod":        {OP_MOD, 2},
+	"SMod":       {OP_SMOD, 2},
+	"AddMod":     {OP_ADDMOD, 3},
+	"MulMod":     {OP_MULMOD, 3},
+	"Exp":        {OP_EXP, 2},
+	"SignExtend": {OP_SIGNEXTEND, 2},
+	"MulHi":      {OP_MULHI, 2},
+	"ModExp":     {OP_MODEXP, 3},
+	"AddCarry":   {OP_ADDCARRY, 3},
+	"FixMul18":   {OP_FIXMUL18, 2},
+	"Clz":        {OP_CLZ, 1},
+	"FixDiv18":   {OP_FIXDIV18, 2},
+	"Hash":       {OP_HASH, 2},
+	"Rol":        {OP_ROL, 2},
+	"Ror":        {OP_ROR, 2},
+	"Popcnt":     {OP_POPCNT, 1},
+	"Bswap":      {OP_BSWAP, 1},
+	// État du contrat
+	"Address":        {OP_ADDRESS, 0},
+	"Balance":        {OP_BALANCE, 1},
+	"Origin":         {OP_ORIGIN, 0},
+	"Caller":         {OP_CALLER, 0},
+	"CallValue":      {OP_CALLVALUE, 0},
+	"CallDataLoad":   {OP_CALLDATALOAD, 1},
+	"CallDataSize":   {OP_CALLDATASIZE, 0},
+	"CallDataCopy":   {OP_CALLDATACOPY, 3},
+	"CodeSize":       {OP_CODESIZE, 0},
+	"CodeCopy":       {OP_CODECOPY, 3},
+	"GasPrice":       {OP_GASPRICE, 0},
+	"ExtCodeSize":    {OP_EXTCODESIZE, 1},
+	"ExtCodeCopy":    {OP_EXTCODECOPY, 4},
+	"ReturnDataSize": {OP_RETURNDATASIZE, 0},
+	"ReturnDataCopy": {OP_RETURNDATACOPY, 3},
+	"ExtCodeHash":    {OP_EXTCODEHASH, 1},
+	// Contexte de bloc
+	"BlockHash":   {OP_BLOCKHASH, 1},
+	"Coinbase":    {OP_COINBASE, 0},
+	"Timestamp":   {OP_TIMESTAMP, 0},
+	"Number":      {OP_NUMBER, 0},
+	"PrevRandao":  {OP_PREVRANDAO, 0},
+	"GasLimit":    {OP_GASLIMIT, 0},
+	"ChainId":     {OP_CHAINID, 0},
+	"SelfBalance": {OP_SELFBALANCE, 0},
+	"BaseFee":     {OP_BASEFEE, 0},
+	// Pile et mémoire
+	"SLoad":  {OP_SLOAD, 1},
+	"SStore": {OP_SSTORE, 2},
+	"Pc":     {OP_PC, 0},
+	"MSize":  {OP_MSIZE, 0},
+	"Gas":    {OP_GAS, 0},
+	"TLoad":  {OP_TLOAD, 1},
+	"TStore": {OP_TSTORE, 2},
+	"MCopy":   {OP_MCOPY, 3},
+	"MLoad":   {OP_MLOAD, 1},
+	"MStore":  {OP_MSTORE, 2},
+	"MStore8": {OP_MSTORE8, 2},
+	"Pop":      {OP_POP, 1},
+	// Mémoire sous-64 bits
+	"MLoad16":  {OP_MLOAD16, 1},
+	"MLoad16S": {OP_MLOAD16S, 1},
+	"MLoad32":  {OP_MLOAD32, 1},
+	"MLoad32S": {OP_MLOAD32S, 1},
+	"MStore16": {OP_MSTORE16, 2},
+	"MStore32": {OP_MSTORE32, 2},
+	"Sext8":    {OP_SEXT8, 1},
+	"Sext16":   {OP_SEXT16, 1},
+	"Sext32":   {OP_SEXT32, 1},
+	"Trunc8":   {OP_TRUNC8, 1},
+	"Trunc16":  {OP_TRUNC16, 1},
+	"Trunc32":  {OP_TRUNC32, 1},
+}
+
+// Builtin retourne l'opcode et le nombre d'arguments de la fonction intégrée name.
+func Builtin(name string) (op Opcode, args int, ok bool) {
+	info, ok := builtins[name]
+	return info.op, info.argCount, ok
+}
+
+// BuiltinNames retourne les noms des fonctions intégrées, triés.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeclaredFuncs retourne les noms des fonctions déclarées dans prog.
+func DeclaredFuncs(prog *parser.Program) map[string]bool {
+	funcs := make(map[string]bool)
+	walk(prog, func(n parser.Node) {
+		if fn, ok := n.(*parser.FuncDecl); ok {
+			funcs[fn.Name] = true
+		}
+	})
+	return funcs
+}
+
+// NotBuiltin retourne l'erreur, située à span, d'un appel à n qui n'est pas
+// un builtin. Si son nom n'est pas celui d'une fonction de funcs mais
+// ressemble à celui d'un builtin, l'erreur propose le builtin, avec un
+// fix-it sur le nom.
+func NotBuiltin(n *parser.CallExpr, span lexer.Span, funcs map[string]bool) diag.Diagnostic {
+	d := diag.Errorf("not-builtin", span, "function '%s' not a builtin (no CALL opcode in current set)", n.Func)
+	if funcs[n.Func] {
+		return d
+	}
+	if name := diag.Suggest(n.Func, BuiltinNames()); name != "" {
+		d.Message += fmt.Sprintf("; did you mean '%s'?", name)
+		if start := n.Span().Start; start.Line != 0 {
+			end := start
+			end.Col += len(n.Func)
+			d.Fixes = []diag.FixIt{{Range: lexer.Span{Start: start, End: end}, Text: name}}
+		}
+	}
+	return d
+}
+
 func NewCodeGen() *CodeGen {
 	return &CodeGen{
-		builtins: map[string]builtinInfo{
-			"Add":        {OP_ADD, 2},
-			"Mul":        {OP_MUL, 2},
-			"Sub":        {OP_SUB, 2},
-			"Div":        {OP_DIV, 2},
-			"SDiv":       {OP_SDIV, 2},
-			"Mod":        {OP_MOD, 2},
-			"SMod":       {OP_SMOD, 2},
-			"AddMod":     {OP_ADDMOD, 3},
-			"MulMod":     {OP_MULMOD, 3},
-			"Exp":        {OP_EXP, 2},
-			"SignExtend": {OP_SIGNEXTEND, 2},
-			"MulHi":      {OP_MULHI, 2},
-			"ModExp":     {OP_MODEXP, 3},
-			"AddCarry":   {OP_ADDCARRY, 3},
-			"FixMul18":   {OP_FIXMUL18, 2},
-			"Clz":        {OP_CLZ, 1},
-			"FixDiv18":   {OP_FIXDIV18, 2},
-			"Hash":       {OP_HASH, 2},
-			"Rol":        {OP_ROL, 2},
-			"Ror":        {OP_ROR, 2},
-			"Popcnt":     {OP_POPCNT, 1},
-			"Bswap":      {OP_BSWAP, 1},
-			// État du contrat
-			"Address":        {OP_ADDRESS, 0},
-			"Balance":        {OP_BALANCE, 1},
-			"Origin":         {OP_ORIGIN, 0},
-			"Caller":         {OP_CALLER, 0},
-			"CallValue":      {OP_CALLVALUE, 0},
-			"CallDataLoad":   {OP_CALLDATALOAD, 1},
-			"CallDataSize":   {OP_CALLDATASIZE, 0},
-			"CallDataCopy":   {OP_CALLDATACOPY, 3},
-			"CodeSize":       {OP_CODESIZE, 0},
-			"CodeCopy":       {OP_CODECOPY, 3},
-			"GasPrice":       {OP_GASPRICE, 0},
-			"ExtCodeSize":    {OP_EXTCODESIZE, 1},
-			"ExtCodeCopy":    {OP_EXTCODECOPY, 4},
-			"ReturnDataSize": {OP_RETURNDATASIZE, 0},
-			"ReturnDataCopy": {OP_RETURNDATACOPY, 3},
-			"ExtCodeHash":    {OP_EXTCODEHASH, 1},
-			// Contexte de bloc
-			"BlockHash":   {OP_BLOCKHASH, 1},
-			"Coinbase":    {OP_COINBASE, 0},
-			"Timestamp":   {OP_TIMESTAMP, 0},
-			"Number":      {OP_NUMBER, 0},
-			"PrevRandao":  {OP_PREVRANDAO, 0},
-			"GasLimit":    {OP_GASLIMIT, 0},
-			"ChainId":     {OP_CHAINID, 0},
-			"SelfBalance": {OP_SELFBALANCE, 0},
-			"BaseFee":     {OP_BASEFEE, 0},
-			// Pile et mémoire
-			"SLoad":  {OP_SLOAD, 1},
-			"SStore": {OP_SSTORE, 2},
-			"Pc":     {OP_PC, 0},
-			"MSize":  {OP_MSIZE, 0},
-			"Gas":    {OP_GAS, 0},
-			"TLoad":  {OP_TLOAD, 1},
-			"TStore": {OP_TSTORE, 2},
-			"MCopy":   {OP_MCOPY, 3},
-			"MLoad":   {OP_MLOAD, 1},
-			"MStore":  {OP_MSTORE, 2},
-			"MStore8": {OP_MSTORE8, 2},
-			"Pop":      {OP_POP, 1},
-			// Mémoire sous-64 bits
-			"MLoad16":  {OP_MLOAD16, 1},
-			"MLoad16S": {OP_MLOAD16S, 1},
-			"MLoad32":  {OP_MLOAD32, 1},
-			"MLoad32S": {OP_MLOAD32S, 1},
-			"MStore16": {OP_MSTORE16, 2},
-			"MStore32": {OP_MSTORE32, 2},
-			"Sext8":    {OP_SEXT8, 1},
-			"Sext16":   {OP_SEXT16, 1},
-			"Sext32":   {OP_SEXT32, 1},
-			"Trunc8":   {OP_TRUNC8, 1},
-			"Trunc16":  {OP_TRUNC16, 1},
-			"Trunc32":  {OP_TRUNC32, 1},
-		},
-	}
-}
-
-func (cg *CodeGen) errorf(format string, args ...any) {
-	msg := fmt.Sprintf("codegen: "+format, args...)
-	cg.Errors = append(cg.Errors, msg)
-	fmt.Fprintln(os.Stderr, msg)
+		LoopBounds:   make(map[int]int64),
+		labelHeights: make(map[int]int),
+		scopes:       []map[string]int{{}},
+		nextSlot:     LocalsBase,
+		defines:      make(map[string]parser.Node),
+		expanding:    make(map[string]bool),
+		builtins:     builtins,
+	}
+}
+
+// errorf signale une erreur au nœud en cours de génération.
+func (cg *CodeGen) errorf(code, format string, args ...any) {
+	cg.errorAt(code, cg.span, format, args...)
+}
+
+// errorAt signale une erreur sur span.
+func (cg *CodeGen) errorAt(code string, span lexer.Span, format string, args ...any) {
+	cg.Diagnostics = append(cg.Diagnostics, diag.Errorf(code, span, format, args...))
+}
+
+// warnAt signale un avertissement sur span.
+func (cg *CodeGen) warnAt(code string, span lexer.Span, format string, args ...any) {
+	cg.Diagnostics = append(cg.Diagnostics, diag.Warnf(code, span, format, args...))
+}
+
+// add ajoute une instruction et met à jour la hauteur de pile.
+// Avec Passes.DeadCode, rien n'est émis entre un terminateur et l'étiquette
+// suivante : aucun chemin n'y mène.
+func (cg *CodeGen) add(inst Instruction) {
+	if cg.dead && cg.Passes.DeadCode && inst.Op != OP_JUMPDEST {
+		return
+	}
+	if inst.Span.IsZero() {
+		inst.Span = cg.span
+	}
+	cg.code = append(cg.code, inst)
+	if inst.Label != 0 && inst.Op.IsPush() {
+		cg.shadow.push(absVal{}) // adresse résolue par ResolveLabels
+	} else {
+		cg.shadow.step(inst)
+	}
+	cg.height += stackDelta(inst.Op)
+	if len(cg.shadow.vals) != cg.height {
+		cg.shadow.forget(max(cg.height, 0))
+	}
+	if isTerminator(inst.Op) {
+		cg.dead = true
+	}
+}
+
+// stackDelta retourne la variation de hauteur de pile produite par op.
+func stackDelta(op Opcode) int {
+	if info, ok := opcodeInfo[op]; ok {
+		return info.Results - info.Args
+	}
+	return 0
 }
 
 func (cg *CodeGen) emit(op Opcode) {
-	cg.code = append(cg.code, Instruction{Op: op})
+	cg.add(Instruction{Op: op})
 }
 
+// emitPush empile val. Avec Passes.Strength, par la séquence la moins
+// coûteuse selon Passes.Cost : PUSH direct, SHL ou NOT d'une constante plus courte
+// (voir constSequences), ou DUP d'une copie de val déjà sur la pile.
 func (cg *CodeGen) emitPush(val int64) {
+	if !cg.Passes.Strength {
+		cg.add(PushInstr(val))
+		return
+	}
+	best := cg.materialize(uint64(val))
+	if n := cg.liveConst(uint64(val)); n >= 0 {
+		dup := []Instruction{{Op: OP_DUP1 + Opcode(n)}}
+		if cg.less(cg.cost(dup), cg.cost(best)) {
+			best = dup
+		}
+	}
+	for _, inst := range best {
+		cg.add(inst)
+	}
+}
+
+// PushInstr retourne le plus petit PUSH (PUSH0, PUSH1-PUSH8) de val.
+func PushInstr(val int64) Instruction {
 	uval := uint64(val)
 	if uval == 0 {
-		cg.code = append(cg.code, Instruction{Op: OP_PUSH0})
-		return
+		return Instruction{Op: OP_PUSH0}
 	}
 	n := 0
 	v := uval
@@ -132,37 +330,261 @@ func (cg *CodeGen) emitPush(val int64) {
 		n = 8
 	}
 	op := Opcode(byte(OP_PUSH1) + byte(n-1))
-	cg.code = append(cg.code, Instruction{Op: op, Operand: val})
+	return Instruction{Op: op, Operand: val}
+}
+
+// emitPop retire n valeurs du sommet de la pile.
+func (cg *CodeGen) emitPop(n int) {
+	for i := 0; i < n; i++ {
+		cg.emit(OP_POP)
+	}
+}
+
+// newLabel réserve une nouvelle étiquette de saut.
+func (cg *CodeGen) newLabel() int {
+	cg.labels++
+	return cg.labels
+}
+
+// emitLabel place le JUMPDEST de l'étiquette l. La hauteur de pile doit y
+// être la même par chute et par chacun des sauts qui la visent.
+func (cg *CodeGen) emitLabel(l int) {
+	if h, ok := cg.labelHeights[l]; ok {
+		if !cg.dead && h != cg.height {
+			cg.errorf("stack-height", "stack height mismatch at label L%d: %d by jump, %d by fallthrough", l, h, cg.height)
+		}
+		cg.height = h
+	}
+	cg.dead = false
+	cg.labelHeights[l] = cg.height
+	cg.add(Instruction{Op: OP_JUMPDEST, Label: l})
+}
+
+// noteJump enregistre la hauteur de pile attendue à l'étiquette l.
+func (cg *CodeGen) noteJump(l int) {
+	if h, ok := cg.labelHeights[l]; ok && h != cg.height {
+		cg.errorf("stack-height", "stack height mismatch at label L%d: %d and %d", l, h, cg.height)
+		return
+	}
+	cg.labelHeights[l] = cg.height
+}
+
+// emitJump saute vers l ; la destination est au sommet de la pile (PUSH2 l, JUMP).
+func (cg *CodeGen) emitJump(l int) {
+	cg.noteJump(l)
+	cg.add(Instruction{Op: OP_PUSH2, Label: l})
+	cg.emit(OP_JUMP)
 }
 
-// Generate compile le programme entier et retourne le bytecode.
+// emitJumpI saute vers l si la valeur sous le sommet est non nulle.
+func (cg *CodeGen) emitJumpI(l int) {
+	cg.add(Instruction{Op: OP_PUSH2, Label: l})
+	cg.emit(OP_JUMPI)
+	cg.noteJump(l)
+}
+
+// Generate compile le programme entier et retourne le bytecode, avec les
+// optimisations choisies par Passes.
 func (cg *CodeGen) Generate(prog *parser.Program) []Instruction {
+	cg.OptimizeAST(prog)
+	cg.declared = DeclaredFuncs(prog)
 	for _, decl := range prog.Decls {
-		cg.genNode(decl)
+		cg.genStmt(decl)
 	}
 	cg.emit(OP_STOP)
+	return cg.finish()
+}
+
+// OptimizeAST applique à l'AST les passes choisies par Passes, dans
+// l'ordre : appels terminaux, inlining, repliement des constantes, élimination du code mort
+// (un avertissement par élément supprimé), boucles, accès au stockage,
+// sous-expressions communes. Generate l'appelle ; les autres générateurs (ir.Lower) doivent
+// l'appeler eux-mêmes.
+func (cg *CodeGen) OptimizeAST(prog *parser.Program) {
+	if cg.Passes.TailCalls {
+		cg.TailCalls = cg.EliminateTailCalls(prog)
+	} else {
+		cg.CheckTailCalls(prog)
+	}
+	if cg.Passes.Inline {
+		cg.Inlined = cg.InlineFunctions(prog)
+	}
+	if cg.Passes.Fold {
+		cg.Folded = cg.FoldConstants(prog)
+	}
+	if cg.Passes.DeadCode {
+		cg.Eliminated = cg.EliminateDeadCode(prog)
+	}
+	if cg.Passes.Loops {
+		cg.Hoisted, cg.Reduced, cg.Unrolled = cg.OptimizeLoops(prog)
+	}
+	if cg.Passes.Storage {
+		cg.CachedLoads, cg.MergedStores = cg.OptimizeStorage(prog)
+	}
+	if cg.Passes.CSE {
+		cg.Reused = cg.EliminateCommonSubexpressions(prog)
+	}
+}
+
+// Assemble termine un code produit hors de CodeGen (par l'ordonnancement
+// de l'IR) comme Generate termine le sien : avec Passes.DeadCode, les
+// instructions qui suivent un saut ou une fin d'exécution sont retirées
+// jusqu'à la prochaine étiquette ou au prochain corps de fonction, puis
+// viennent l'optimiseur à lucarne selon Passes, la résolution des
+// étiquettes et les vérifications. funcs et bounds tiennent lieu de Funcs
+// et LoopBounds.
+func (cg *CodeGen) Assemble(code []Instruction, funcs []FuncInfo, bounds map[int]int64) []Instruction {
+	starts := make(map[int]bool)
+	for _, fn := range funcs {
+		starts[fn.Start] = true
+	}
+	index := make([]int, len(code)+1)
+	for i, inst := range code {
+		if inst.Op == OP_JUMPDEST || starts[i] {
+			cg.dead = false // corps de fonction émis en ligne, comme dans genStmt
+		}
+		index[i] = len(cg.code)
+		cg.add(inst)
+	}
+	index[len(code)] = len(cg.code)
+	cg.Funcs = funcs
+	for i := range cg.Funcs {
+		fn := &cg.Funcs[i]
+		fn.Start, fn.End = index[fn.Start], index[fn.End]
+	}
+	for l, n := range bounds {
+		cg.LoopBounds[l] = n
+	}
+	return cg.finish()
+}
+
+// finish applique les passes de fin de génération au code de cg.
+func (cg *CodeGen) finish() []Instruction {
+	if cg.Passes.Peephole {
+		cg.peephole()
+	}
+	if err := ResolveLabels(cg.code); err != nil {
+		cg.errorAt("label", lexer.Span{}, "%v", err)
+		return cg.code
+	}
+	for _, err := range VerifyStack(cg.code).Errors {
+		cg.errorAt("stack-check", cg.code[err.Index].Span, "stack check: %v", err)
+	}
+	for _, j := range AnalyzeJumps(cg.code).Bad() {
+		cg.errorAt("jump-check", cg.code[j.Index].Span, "jump check: %v", j)
+	}
 	return cg.code
 }
 
+// peephole optimise le code généré et recale les régions de fonctions.
+func (cg *CodeGen) peephole() {
+	res := Peephole(cg.code, PeepholeRules)
+	remap := func(old int) int {
+		for i, o := range res.Origin {
+			if o >= old {
+				return i
+			}
+		}
+		return len(res.Code)
+	}
+	for i := range cg.Funcs {
+		fn := &cg.Funcs[i]
+		fn.Start, fn.End = remap(fn.Start), remap(fn.End)
+	}
+	cg.code = res.Code
+	cg.Peepholed = res.Applied
+}
+
+// ---- Variables ----
+
+func (cg *CodeGen) pushScope() { cg.scopes = append(cg.scopes, map[string]int{}) }
+func (cg *CodeGen) popScope()  { cg.scopes = cg.scopes[:len(cg.scopes)-1] }
+
+// declare réserve l'emplacement mémoire d'une variable dans la portée courante.
+func (cg *CodeGen) declare(name string) int {
+	scope := cg.scopes[len(cg.scopes)-1]
+	if _, ok := scope[name]; ok {
+		cg.errorf("redeclared", "'%s' redeclared in this scope", name)
+	}
+	addr := cg.nextSlot
+	cg.nextSlot += 8
+	scope[name] = addr
+	return addr
+}
+
+// lookup retrouve l'adresse d'une variable, de la portée la plus interne à la plus externe.
+func (cg *CodeGen) lookup(name string) (int, bool) {
+	for i := len(cg.scopes) - 1; i >= 0; i-- {
+		if addr, ok := cg.scopes[i][name]; ok {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// emitLoad pousse la valeur de la variable en addr.
+func (cg *CodeGen) emitLoad(addr int) {
+	cg.emitPush(int64(addr))
+	cg.emit(OP_MLOAD)
+}
+
+// emitStore dépile le sommet dans la variable en addr.
+func (cg *CodeGen) emitStore(addr int) {
+	cg.emitPush(int64(addr))
+	cg.emit(OP_MSTORE)
+}
+
+// ---- Statements ----
+
+// situate fait de node, s'il est situé dans le source, l'origine des
+// instructions émises jusqu'à l'appel de la fonction retournée. Les nœuds
+// d'une macro restent situés à son utilisation.
+func (cg *CodeGen) situate(node parser.Node) (restore func()) {
+	saved := cg.span
+	if node != nil && len(cg.expanding) == 0 && !node.Span().IsZero() {
+		cg.span = node.Span()
+	}
+	return func() { cg.span = saved }
+}
+
+// genStmt génère une instruction et vérifie qu'elle laisse la pile à la
+// hauteur où elle l'a trouvée.
+func (cg *CodeGen) genStmt(node parser.Node) {
+	defer cg.situate(node)()
+	before := cg.height
+	cg.genNode(node)
+	if !cg.dead && cg.height != before {
+		cg.errorf("stack-height", "stack unbalanced after %T: height %d, expected %d", node, cg.height, before)
+		cg.height = before
+	}
+}
+
 func (cg *CodeGen) genNode(node parser.Node) {
 	switch n := node.(type) {
+	case nil:
+		return
 	case *parser.Program:
 		for _, d := range n.Decls {
-			cg.genNode(d)
+			cg.genStmt(d)
 		}
 	case *parser.Block:
+		cg.pushScope()
 		for _, s := range n.Stmts {
-			cg.genNode(s)
+			cg.genStmt(s)
 		}
+		cg.popScope()
 	case *parser.ExprStmt:
-		cg.genExpr(n.Expr)
+		cg.genEffect(n.Expr)
 	case *parser.VarDecl:
 		if n.Init != nil {
-			cg.genExpr(n.Init)
+			cg.genValue(n.Init)
+			cg.emitStore(cg.declare(n.Name))
+		} else {
+			cg.declare(n.Name)
 		}
 	case *parser.ReturnStmt:
 		if n.Value != nil {
-			cg.genExpr(n.Value)
+			cg.genValue(n.Value)
 			cg.emitPush(0)
 			cg.emit(OP_MSTORE)
 			cg.emitPush(8)
@@ -173,36 +595,129 @@ func (cg *CodeGen) genNode(node parser.Node) {
 			cg.emitPush(0)
 			cg.emit(OP_RETURN)
 		}
+	case *parser.DefineDecl:
+		cg.defines[n.Name] = n.Value
 	case *parser.FuncDecl:
+		fn := FuncInfo{Name: n.Name, Public: n.Public, Start: len(cg.code)}
+		cg.fn = &fn
+		cg.pushScope()
+		for _, param := range n.Params {
+			if param.Name != "" {
+				cg.declare(param.Name)
+			}
+		}
 		if n.Body != nil {
-			cg.genNode(n.Body)
+			cg.genStmt(n.Body)
 		}
+		cg.popScope()
+		cg.fn = nil
+		// Le corps est émis en ligne : l'exécution peut y entrer par chute.
+		cg.dead = false
+		fn.End = len(cg.code)
+		cg.Funcs = append(cg.Funcs, fn)
 	case *parser.IfStmt:
-		cg.genExpr(n.Cond)
-		cg.genNode(n.Body)
+		elseLabel := cg.newLabel()
+		cg.genValue(n.Cond)
+		cg.emit(OP_ISZERO)
+		cg.emitJumpI(elseLabel)
+		cg.genStmt(n.Body)
 		if n.Else != nil {
-			cg.genNode(n.Else)
+			end := cg.newLabel()
+			cg.emitJump(end)
+			cg.emitLabel(elseLabel)
+			cg.genStmt(n.Else)
+			cg.emitLabel(end)
+		} else {
+			cg.emitLabel(elseLabel)
 		}
 	case *parser.WhileStmt:
-		cg.genExpr(n.Cond)
-		cg.genNode(n.Body)
+		head, end := cg.newLabel(), cg.newLabel()
+		cg.emitLabel(head)
+		if n.Bound > 0 {
+			cg.LoopBounds[head] = n.Bound
+		}
+		cg.genValue(n.Cond)
+		cg.emit(OP_ISZERO)
+		cg.emitJumpI(end)
+		cg.genStmt(n.Body)
+		cg.emitJump(head)
+		cg.emitLabel(end)
 	case *parser.ForStmt:
+		cg.pushScope()
 		if n.Init != nil {
-			cg.genNode(n.Init)
+			if _, ok := n.Init.(*parser.VarDecl); ok {
+				cg.genStmt(n.Init)
+			} else {
+				cg.genEffect(n.Init)
+			}
+		}
+		head, end := cg.newLabel(), cg.newLabel()
+		cg.emitLabel(head)
+		if n.Bound > 0 {
+			cg.LoopBounds[head] = n.Bound
 		}
 		if n.Cond != nil {
-			cg.genExpr(n.Cond)
+			cg.genValue(n.Cond)
+			cg.emit(OP_ISZERO)
+			cg.emitJumpI(end)
 		}
-		cg.genNode(n.Body)
+		cg.genStmt(n.Body)
 		if n.Post != nil {
-			cg.genExpr(n.Post)
+			cg.genEffect(n.Post)
 		}
+		cg.emitJump(head)
+		cg.emitLabel(end)
+		cg.popScope()
 	default:
-		cg.errorf("unhandled node type: %T", node)
+		cg.errorf("unsupported", "unhandled node type: %T", node)
+	}
+}
+
+// ---- Expressions ----
+
+// genEffect génère une expression pour ses seuls effets : les valeurs
+// qu'elle laisse sur la pile sont retirées.
+func (cg *CodeGen) genEffect(node parser.Node) {
+	defer cg.situate(node)()
+	switch n := node.(type) {
+	case *parser.AssignExpr:
+		cg.genAssign(n, false)
+		return
+	case *parser.PostfixExpr:
+		cg.genIncDec(n.Operand, n.Op, false, false)
+		return
+	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			cg.genIncDec(n.Operand, n.Op, true, false)
+			return
+		}
+	}
+	cg.emitPop(cg.genExpr(node))
+}
+
+// genValue génère une expression qui doit laisser exactement une valeur :
+// les résultats supplémentaires (ADDCARRY : cout sous sum) sont retirés.
+func (cg *CodeGen) genValue(node parser.Node) {
+	reported := len(cg.Diagnostics)
+	n := cg.genExpr(node)
+	switch {
+	case n == 0:
+		// Un appel déjà signalé (pas un builtin) ne l'est pas deux fois.
+		if !diag.HasErrors(cg.Diagnostics[reported:]) {
+			cg.errorf("no-value", "expression %T has no value", node)
+		}
+		cg.emitPush(0)
+	case n > 1:
+		for i := 1; i < n; i++ {
+			cg.emit(OP_SWAP1)
+			cg.emit(OP_POP)
+		}
 	}
 }
 
-func (cg *CodeGen) genExpr(node parser.Node) {
+// genExpr génère une expression et retourne le nombre de valeurs poussées.
+func (cg *CodeGen) genExpr(node parser.Node) int {
+	defer cg.situate(node)()
 	switch n := node.(type) {
 	case *parser.IntLiteral:
 		cg.emitPush(n.Value)
@@ -211,129 +726,272 @@ func (cg *CodeGen) genExpr(node parser.Node) {
 	case *parser.StringLiteral:
 		cg.emitPush(0)
 	case *parser.Identifier:
+		if addr, ok := cg.lookup(n.Name); ok {
+			cg.emitLoad(addr)
+			return 1
+		}
+		if value, ok := cg.defines[n.Name]; ok {
+			return cg.genMacro(n.Name, value)
+		}
+		cg.errorf("undefined-variable", "undefined variable '%s'", n.Name)
 		cg.emitPush(0)
 	case *parser.BinaryExpr:
 		cg.genBinaryExpr(n)
 	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			cg.genIncDec(n.Operand, n.Op, true, true)
+			return 1
+		}
 		cg.genUnaryExpr(n)
 	case *parser.CallExpr:
-		cg.genCallExpr(n)
+		return cg.genCallExpr(n)
+	case *parser.InlineExpr:
+		cg.pushScope()
+		defer cg.popScope()
+		for _, s := range n.Stmts {
+			cg.genStmt(s)
+		}
+		if n.Value == nil {
+			return 0
+		}
+		return cg.genExpr(n.Value)
 	case *parser.AssignExpr:
-		cg.genExpr(n.Value)
+		cg.genAssign(n, true)
 	case *parser.PostfixExpr:
-		cg.genExpr(n.Operand)
+		cg.genIncDec(n.Operand, n.Op, false, true)
 	case *parser.SizeofExpr:
-		cg.emitPush(typeSizeOf(n.TypeName))
+		cg.emitPush(TypeSizeOf(n.TypeName))
 	case *parser.CastExpr:
-		cg.genExpr(n.Expr)
+		cg.genValue(n.Expr)
 	case *parser.IndexExpr:
-		cg.genExpr(n.Array)
-		cg.genExpr(n.Index)
+		cg.genIndexAddr(n)
+		cg.emit(OP_MLOAD)
 	case *parser.MemberExpr:
-		cg.genExpr(n.Object)
+		cg.genValue(n.Object)
 	default:
-		cg.errorf("unhandled expression: %T", node)
+		cg.errorf("unsupported", "unhandled expression: %T", node)
+		return 0
 	}
+	return 1
 }
 
-func (cg *CodeGen) genBinaryExpr(n *parser.BinaryExpr) {
-	cg.genExpr(n.Left)
-	cg.genExpr(n.Right)
-
-	switch n.Op {
-	case lexer.TOK_PLUS:
-		cg.emit(OP_ADD)
-	case lexer.TOK_MINUS:
-		cg.emit(OP_SUB)
-	case lexer.TOK_STAR:
-		cg.emit(OP_MUL)
-	case lexer.TOK_SLASH:
-		cg.emit(OP_SDIV)
-	case lexer.TOK_PERCENT:
-		cg.emit(OP_SMOD)
-	case lexer.TOK_BACKTICK:
-		cg.emit(OP_EXP)
-	case lexer.TOK_AMP:
-		cg.emit(OP_AND)
-	case lexer.TOK_PIPE:
-		cg.emit(OP_OR)
-	case lexer.TOK_CARET:
-		cg.emit(OP_XOR)
-	case lexer.TOK_SHL:
-		cg.emit(OP_SHL)
-	case lexer.TOK_SHR:
-		cg.emit(OP_SHR)
-	case lexer.TOK_LT:
-		cg.emit(OP_SLT)
-	case lexer.TOK_GT:
-		cg.emit(OP_SGT)
-	case lexer.TOK_EQ:
-		cg.emit(OP_EQ)
-	case lexer.TOK_NEQ:
-		cg.emit(OP_EQ)
-		cg.emit(OP_ISZERO)
-	case lexer.TOK_LTE:
-		cg.emit(OP_SGT)
-		cg.emit(OP_ISZERO)
-	case lexer.TOK_GTE:
-		cg.emit(OP_SLT)
-		cg.emit(OP_ISZERO)
-	case lexer.TOK_AND_AND:
-		cg.emit(OP_ISZERO)
-		cg.emit(OP_ISZERO)
-		cg.emit(OP_SWAP1)
-		cg.emit(OP_ISZERO)
-		cg.emit(OP_ISZERO)
-		cg.emit(OP_AND)
-	case lexer.TOK_OR_OR:
-		cg.emit(OP_OR)
-		cg.emit(OP_ISZERO)
-		cg.emit(OP_ISZERO)
+// genMacro substitue la valeur d'un #define.
+func (cg *CodeGen) genMacro(name string, value parser.Node) int {
+	switch {
+	case value == nil:
+		cg.errorf("macro", "macro '%s' has no expression value", name)
+	case cg.expanding[name]:
+		cg.errorf("macro", "macro '%s' expands to itself", name)
 	default:
-		cg.errorf("unknown binary op: %d", n.Op)
+		cg.expanding[name] = true
+		defer delete(cg.expanding, name)
+		return cg.genExpr(value)
 	}
+	cg.emitPush(0)
+	return 1
 }
 
-func (cg *CodeGen) genUnaryExpr(n *parser.UnaryExpr) {
-	cg.genExpr(n.Operand)
-	switch n.Op {
-	case lexer.TOK_MINUS:
-		cg.emitPush(0)
-		cg.emit(OP_SWAP1)
-		cg.emit(OP_SUB)
-	case lexer.TOK_TILDE:
-		cg.emit(OP_NOT)
-	case lexer.TOK_BANG:
-		cg.emit(OP_ISZERO)
-	case lexer.TOK_PLUS_PLUS, lexer.TOK_MINUS_MINUS:
+// compoundOps associe chaque affectation composée à son opérateur binaire.
+var compoundOps = map[lexer.TokenType]lexer.TokenType{
+	lexer.TOK_PLUS_EQ:    lexer.TOK_PLUS,
+	lexer.TOK_MINUS_EQ:   lexer.TOK_MINUS,
+	lexer.TOK_STAR_EQ:    lexer.TOK_STAR,
+	lexer.TOK_SLASH_EQ:   lexer.TOK_SLASH,
+	lexer.TOK_PERCENT_EQ: lexer.TOK_PERCENT,
+	lexer.TOK_AMP_EQ:     lexer.TOK_AMP,
+	lexer.TOK_PIPE_EQ:    lexer.TOK_PIPE,
+	lexer.TOK_CARET_EQ:   lexer.TOK_CARET,
+	lexer.TOK_SHL_EQ:     lexer.TOK_SHL,
+	lexer.TOK_SHR_EQ:     lexer.TOK_SHR,
+}
+
+// genAssign génère target op= value ; si keep, la valeur affectée reste sur la pile.
+func (cg *CodeGen) genAssign(n *parser.AssignExpr, keep bool) {
+	value := n.Value
+	if op, ok := compoundOps[n.Op]; ok {
+		value = &parser.BinaryExpr{Op: op, Left: n.Target, Right: n.Value}
+	}
+	cg.genValue(value)
+	if keep {
+		cg.emit(OP_DUP1)
+	}
+	cg.genStoreTo(n.Target)
+}
+
+// genIncDec génère ++x, --x (prefix) ou x++, x-- ; si keep, la valeur de
+// l'expression (nouvelle pour prefix, ancienne sinon) reste sur la pile.
+func (cg *CodeGen) genIncDec(target parser.Node, op lexer.TokenType, prefix, keep bool) {
+	if op == lexer.TOK_MINUS_MINUS {
 		cg.emitPush(1)
-		if n.Op == lexer.TOK_PLUS_PLUS {
-			cg.emit(OP_ADD)
+	}
+	cg.genValue(target)
+	if keep && !prefix {
+		if op == lexer.TOK_MINUS_MINUS {
+			cg.emit(OP_SWAP1)
+			cg.emit(OP_DUP2)
 		} else {
-			cg.emit(OP_SUB)
+			cg.emit(OP_DUP1)
 		}
 	}
+	if op == lexer.TOK_MINUS_MINUS {
+		cg.emit(OP_SUB)
+	} else {
+		cg.emitPush(1)
+		cg.emit(OP_ADD)
+	}
+	if keep && prefix {
+		cg.emit(OP_DUP1)
+	}
+	cg.genStoreTo(target)
 }
 
-func (cg *CodeGen) genCallExpr(n *parser.CallExpr) {
+// genStoreTo dépile le sommet dans une lvalue (variable ou p[i]).
+func (cg *CodeGen) genStoreTo(target parser.Node) {
+	switch t := target.(type) {
+	case *parser.Identifier:
+		addr, ok := cg.lookup(t.Name)
+		if !ok {
+			cg.errorf("undefined-variable", "undefined variable '%s'", t.Name)
+			cg.emit(OP_POP)
+			return
+		}
+		cg.emitStore(addr)
+	case *parser.IndexExpr:
+		cg.genIndexAddr(t)
+		cg.emit(OP_MSTORE)
+	default:
+		cg.errorf("invalid-assignment", "cannot assign to %T", target)
+		cg.emit(OP_POP)
+	}
+}
+
+// genIndexAddr pousse l'adresse de p[i] : p + 8*i (éléments de 8 octets).
+func (cg *CodeGen) genIndexAddr(n *parser.IndexExpr) {
+	cg.genValue(n.Index)
+	cg.emitPush(3)
+	cg.emit(OP_SHL)
+	cg.genValue(n.Array)
+	cg.emit(OP_ADD)
+}
+
+// binaryOp décrit la traduction d'un opérateur binaire : la séquence
+// d'opcodes appliquée aux deux opérandes. Comme dans l'EVM, le premier
+// opérande d'un opcode est au sommet de la pile (OPCODES.md : SUB calcule
+// sommet - second) ; leftOnTop indique donc que l'opérande gauche est poussé
+// en dernier, comme pour les opérateurs non commutatifs. Les décalages
+// (shift, val) prennent au contraire le nombre de bits au sommet : leur
+// opérande droit y finit.
+type binaryOp struct {
+	ops       []Opcode
+	leftOnTop bool
+}
+
+// binaryOps est partagé par la génération de code et le repliement de
+// constantes (FoldConstants), qui suivent ainsi exactement la même sémantique.
+var binaryOps = map[lexer.TokenType]binaryOp{
+	lexer.TOK_PLUS:     {[]Opcode{OP_ADD}, false},
+	lexer.TOK_MINUS:    {[]Opcode{OP_SUB}, true},
+	lexer.TOK_STAR:     {[]Opcode{OP_MUL}, false},
+	lexer.TOK_SLASH:    {[]Opcode{OP_SDIV}, true},
+	lexer.TOK_PERCENT:  {[]Opcode{OP_SMOD}, true},
+	lexer.TOK_BACKTICK: {[]Opcode{OP_EXP}, true},
+	lexer.TOK_AMP:      {[]Opcode{OP_AND}, false},
+	lexer.TOK_PIPE:     {[]Opcode{OP_OR}, false},
+	lexer.TOK_CARET:    {[]Opcode{OP_XOR}, false},
+	lexer.TOK_SHL:      {[]Opcode{OP_SHL}, false},
+	lexer.TOK_SHR:      {[]Opcode{OP_SHR}, false},
+	lexer.TOK_LT:       {[]Opcode{OP_SLT}, true},
+	lexer.TOK_GT:       {[]Opcode{OP_SGT}, true},
+	lexer.TOK_EQ:       {[]Opcode{OP_EQ}, false},
+	lexer.TOK_NEQ:      {[]Opcode{OP_EQ, OP_ISZERO}, false},
+	lexer.TOK_LTE:      {[]Opcode{OP_SGT, OP_ISZERO}, true},
+	lexer.TOK_GTE:      {[]Opcode{OP_SLT, OP_ISZERO}, true},
+	lexer.TOK_AND_AND:  {[]Opcode{OP_ISZERO, OP_ISZERO, OP_SWAP1, OP_ISZERO, OP_ISZERO, OP_AND}, false},
+	lexer.TOK_OR_OR:    {[]Opcode{OP_OR, OP_ISZERO, OP_ISZERO}, false},
+}
+
+// unaryOps donne la séquence d'opcodes de chaque opérateur unaire.
+var unaryOps = map[lexer.TokenType][]Opcode{
+	lexer.TOK_MINUS: {OP_PUSH0, OP_SUB},
+	lexer.TOK_TILDE: {OP_NOT},
+	lexer.TOK_BANG:  {OP_ISZERO},
+}
+
+// BinaryOp retourne la traduction de l'opérateur binaire tok (voir binaryOp).
+func BinaryOp(tok lexer.TokenType) (ops []Opcode, leftOnTop bool, ok bool) {
+	bin, ok := binaryOps[tok]
+	return bin.ops, bin.leftOnTop, ok
+}
+
+// UnaryOp retourne la séquence d'opcodes de l'opérateur unaire tok.
+func UnaryOp(tok lexer.TokenType) ([]Opcode, bool) {
+	ops, ok := unaryOps[tok]
+	return ops, ok
+}
+
+// CompoundOp retourne l'opérateur binaire d'une affectation composée (+=...).
+func CompoundOp(tok lexer.TokenType) (lexer.TokenType, bool) {
+	op, ok := compoundOps[tok]
+	return op, ok
+}
+
+func (cg *CodeGen) genBinaryExpr(n *parser.BinaryExpr) {
+	bin, ok := binaryOps[n.Op]
+	if !ok {
+		cg.errorf("unsupported", "unknown binary op: %d", n.Op)
+		cg.emitPush(0)
+		return
+	}
+	if cg.Passes.Strength && len(bin.ops) == 1 && cg.genReduced(bin.ops[0], n.Left, n.Right) {
+		return
+	}
+	if bin.leftOnTop {
+		cg.genValue(n.Right)
+		cg.genValue(n.Left)
+	} else {
+		cg.genValue(n.Left)
+		cg.genValue(n.Right)
+	}
+	for _, op := range bin.ops {
+		cg.emit(op)
+	}
+}
+
+func (cg *CodeGen) genUnaryExpr(n *parser.UnaryExpr) {
+	cg.genValue(n.Operand)
+	for _, op := range unaryOps[n.Op] {
+		cg.emit(op)
+	}
+}
+
+// genCallExpr génère un appel de builtin et retourne le nombre de valeurs
+// poussées (Results de l'opcode).
+func (cg *CodeGen) genCallExpr(n *parser.CallExpr) int {
 	if info, ok := cg.builtins[n.Func]; ok {
 		if len(n.Args) != info.argCount {
-			cg.errorf("%s expects %d args, got %d", n.Func, info.argCount, len(n.Args))
-			return
+			cg.errorf("argument-count", "%s expects %d args, got %d", n.Func, info.argCount, len(n.Args))
+			return 0
+		}
+		if cg.Passes.Strength && len(n.Args) == 2 && cg.genReduced(info.op, n.Args[0], n.Args[1]) {
+			return 1
 		}
-		for _, arg := range n.Args {
-			cg.genExpr(arg)
+		// Le premier argument doit finir au sommet : on pousse à l'envers.
+		for i := len(n.Args) - 1; i >= 0; i-- {
+			cg.genValue(n.Args[i])
 		}
 		cg.emit(info.op)
-		return
+		return opcodeInfo[info.op].Results
 	}
-	cg.errorf("function '%s' not a builtin (no CALL opcode in current set)", n.Func)
-	for _, arg := range n.Args {
-		cg.genExpr(arg)
+	if cg.fn != nil {
+		cg.fn.Calls = append(cg.fn.Calls, n.Func)
 	}
+	cg.Diagnostics = append(cg.Diagnostics, NotBuiltin(n, cg.span, cg.declared))
+	return 0
 }
 
-func typeSizeOf(name string) int64 {
+// TypeSizeOf retourne la taille en octets d'un type (sizeof) ; les
+// pointeurs et types inconnus occupent un mot de 8 octets.
+func TypeSizeOf(name string) int64 {
 	switch strings.TrimSpace(strings.TrimRight(name, " *")) {
 	case "U0", "I0":
 		return 0
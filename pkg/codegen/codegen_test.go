@@ -0,0 +1,138 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+// messages retourne le texte des diagnostics de cg de gravité sev.
+func messages(cg *CodeGen, sev diag.Severity) []string {
+	var out []string
+	for _, d := range cg.Diagnostics {
+		if d.Severity == sev {
+			out = append(out, d.Message)
+		}
+	}
+	return out
+}
+
+func TestVariables(t *testing.T) {
+	cg, code := generate(t, `I64 a = 5;
+I64 b;
+b = a * 2;
+a += 3;
+I64 c = a++;
+I64 d = --b;
+{
+  I64 a = 100;
+  SStore(9, a);
+}
+I64 p = 0x400;
+p[2] = 77;
+SStore(1, a);
+SStore(2, b);
+SStore(3, c);
+SStore(4, d);
+SStore(5, p[2]);
+SStore(6, MLoad(0x410));
+`)
+	if diag.HasErrors(cg.Diagnostics) {
+		t.Fatal(messages(cg, diag.Error))
+	}
+	want := "stop ret= storage={1:9 2:9 3:8 4:9 5:77 6:77 9:100}"
+	if got := run(code).String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestVariableErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"I64 x = 1;\nI64 x = 2;", "'x' redeclared in this scope"},
+		{"SStore(1, y);", "undefined variable 'y'"},
+		{"z = 3;", "undefined variable 'z'"},
+		{"3 = 4;", "cannot assign to"},
+	}
+	for _, tt := range tests {
+		cg, _ := generate(t, tt.src)
+		errs := messages(cg, diag.Error)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want %q", tt.src, errs, tt.want)
+		}
+	}
+}
+
+func TestStatementsLeaveTheStackEmpty(t *testing.T) {
+	cg, code := generate(t, `I64 x = 2;
+x + 1;
+SLoad(x);
+AddCarry(1, 2, 3);
+x = 7;
+x++;
+--x;
+I64 s = AddCarry(0xFFFFFFFFFFFFFFFF, 1, 0);
+for (x = 0; x < 3; x++) { x * 2; }
+while (x < 5) x += 1;
+if (x == 5) SStore(1, s); else SStore(1, 1);
+SStore(2, x);
+`)
+	if diag.HasErrors(cg.Diagnostics) {
+		t.Fatal(messages(cg, diag.Error))
+	}
+	o := run(code)
+	if want := "stop ret= storage={1:0 2:5}"; o.String() != want {
+		t.Errorf("got %s, want %s", o, want)
+	}
+	if len(o.stack) != 0 {
+		t.Errorf("stack left with %d values: %v", len(o.stack), o.stack)
+	}
+}
+
+func TestExpressionWithoutValue(t *testing.T) {
+	cg, _ := generate(t, "I64 x = SStore(1, 2);")
+	errs := messages(cg, diag.Error)
+	if len(errs) == 0 || !strings.Contains(errs[0], "has no value") {
+		t.Errorf("errors %q, want a value error", errs)
+	}
+}
+
+func TestStackHeightMismatchAtLabel(t *testing.T) {
+	cg := NewCodeGen()
+	l := cg.newLabel()
+	cg.emitPush(1)
+	cg.emitPush(1)
+	cg.emitJumpI(l) // une valeur sur la pile au saut
+	cg.emitPush(5)  // deux par chute
+	cg.emitLabel(l)
+	errs := messages(cg, diag.Error)
+	if len(errs) != 1 || !strings.Contains(errs[0], "stack height mismatch at label L1: 1 by jump, 2 by fallthrough") {
+		t.Errorf("errors %q", errs)
+	}
+}
+
+// TestNotBuiltinSuggestion vérifie qu'un builtin mal orthographié est
+// proposé, avec un fix-it sur le nom, mais pas à la place d'une fonction
+// déclarée.
+func TestNotBuiltinSuggestion(t *testing.T) {
+	cg, _ := generate(t, "I64 x = 2;\nSStore(1, Sload(x));\n")
+	if len(cg.Diagnostics) != 1 {
+		t.Fatalf("diagnostics %v", cg.Diagnostics)
+	}
+	d := cg.Diagnostics[0]
+	want := "test.HC:2:11: error: function 'Sload' not a builtin (no CALL opcode in current set); did you mean 'SLoad'? [not-builtin]"
+	if d.String() != want {
+		t.Errorf("got %q, want %q", d.String(), want)
+	}
+	if len(d.Fixes) != 1 || d.Fixes[0].Text != "SLoad" || d.Fixes[0].Range.Start.Col != 11 || d.Fixes[0].Range.End.Col != 16 {
+		t.Errorf("fixes %+v", d.Fixes)
+	}
+
+	cg, _ = generate(t, "I64 Sload(I64 k) { return k; }\nSStore(1, Sload(2));\n")
+	if len(cg.Diagnostics) != 1 || strings.Contains(cg.Diagnostics[0].Message, "did you mean") || len(cg.Diagnostics[0].Fixes) > 0 {
+		t.Errorf("declared function: %v", cg.Diagnostics)
+	}
+}
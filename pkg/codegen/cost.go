@@ -0,0 +1,173 @@
+package codegen
+
+import (
+	"fmt"
+	"math/bits"
+
+	"holyc-compiler/pkg/parser"
+)
+
+// CostModel désigne ce que minimisent les décisions de coût (réduction de
+// force, matérialisation des constantes).
+type CostModel int
+
+const (
+	CostGas  CostModel = iota // gas statique d'abord, taille à égalité
+	CostSize                  // taille du bytecode d'abord, gas à égalité
+)
+
+func (m CostModel) String() string {
+	if m == CostSize {
+		return "size"
+	}
+	return "gas"
+}
+
+// ParseCostModel lit un modèle de coût : "gas" ou "size".
+func ParseCostModel(s string) (CostModel, error) {
+	switch s {
+	case "gas":
+		return CostGas, nil
+	case "size":
+		return CostSize, nil
+	}
+	return CostGas, fmt.Errorf("unknown cost model %q (want gas or size)", s)
+}
+
+// seqCost est le coût statique d'une séquence d'instructions.
+type seqCost struct {
+	gas  int
+	size int // octets
+}
+
+// schedule retourne le barème des décisions de coût.
+func (cg *CodeGen) schedule() *GasSchedule {
+	if cg.Schedule == nil {
+		return defaultSchedule
+	}
+	return cg.Schedule
+}
+
+// cost retourne le coût statique d'une séquence selon le barème courant.
+func (cg *CodeGen) cost(seq []Instruction) seqCost {
+	var c seqCost
+	for _, inst := range seq {
+		c.gas += cg.schedule().Cost(inst.Op)
+		c.size += inst.Size()
+	}
+	return c
+}
+
+// costOf retourne le coût du code que génèrent stmts seules, avec les
+// passes sur le code (réduction de force, lucarne) mais sans les passes sur
+// l'AST, qui modifieraient stmts. Les variables lues sans y être déclarées
+// reçoivent un emplacement, sans code.
+func (cg *CodeGen) costOf(stmts ...parser.Node) seqCost {
+	scratch := NewCodeGen()
+	scratch.Schedule = cg.Schedule
+	scratch.Passes = cg.Passes
+	declared := make(map[string]bool)
+	for _, s := range stmts {
+		walk(s, func(n parser.Node) {
+			switch n := n.(type) {
+			case *parser.VarDecl:
+				declared[n.Name] = true
+			case *parser.FuncDecl:
+				for _, p := range n.Params {
+					declared[p.Name] = true
+				}
+			case *parser.Identifier:
+				if !declared[n.Name] {
+					declared[n.Name] = true
+					scratch.declare(n.Name)
+				}
+			}
+		})
+	}
+	for _, s := range stmts {
+		scratch.genStmt(s)
+	}
+	return cg.cost(scratch.finish())
+}
+
+// less indique si a coûte strictement moins que b selon Passes.Cost.
+func (cg *CodeGen) less(a, b seqCost) bool {
+	first, second := a.gas-b.gas, a.size-b.size
+	if cg.Passes.Cost == CostSize {
+		first, second = second, first
+	}
+	return first < 0 || first == 0 && second < 0
+}
+
+// materialize retourne la séquence la moins coûteuse qui empile v, parmi
+// celles de constSequences.
+func (cg *CodeGen) materialize(v uint64) []Instruction {
+	seqs := constSequences(v)
+	best := seqs[0]
+	for _, seq := range seqs[1:] {
+		if cg.less(cg.cost(seq), cg.cost(best)) {
+			best = seq
+		}
+	}
+	return best
+}
+
+// Materialize retourne la séquence qu'émet Generate pour empiler v avec
+// passes et le barème s (nil : barème par défaut) : le plus petit PUSH sans
+// Passes.Strength, la séquence la moins coûteuse de materialize sinon.
+// L'ordonnancement de l'IR matérialise ainsi ses constantes comme le
+// générateur direct.
+func Materialize(v int64, passes Passes, s *GasSchedule) []Instruction {
+	if !passes.Strength {
+		return []Instruction{PushInstr(v)}
+	}
+	cg := &CodeGen{Passes: passes, Schedule: s}
+	return cg.materialize(uint64(v))
+}
+
+// expand remplace chaque PUSH de constante de seq par sa matérialisation.
+func (cg *CodeGen) expand(seq []Instruction) []Instruction {
+	var out []Instruction
+	for _, inst := range seq {
+		if constPush(inst) {
+			out = append(out, cg.materialize(uint64(inst.Operand))...)
+		} else {
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+// liveConst retourne la profondeur (0 = sommet) d'une copie de v parmi les
+// 8 premiers éléments de la pile, que DUP1-DUP8 peuvent atteindre, ou -1.
+func (cg *CodeGen) liveConst(v uint64) int {
+	if cg.dead || len(cg.shadow.vals) != cg.height {
+		return -1
+	}
+	for n := 0; n < 8 && n < cg.height; n++ {
+		if s := cg.shadow.peek(n); s.known && s.v == v {
+			return n
+		}
+	}
+	return -1
+}
+
+// constSequences énumère des séquences empilant v : le PUSH direct, v = m << k
+// (PUSH m PUSH k SHL) et, pour les valeurs proches de 2^64, le NOT de l'une
+// de ces formes appliquée à ^v (PUSH1 0xFF NOT pour 0xFFFF...FF00). Le PUSH
+// direct est en tête : à coût égal, il est préféré.
+func constSequences(v uint64) [][]Instruction {
+	seqs := shiftedConst(v)
+	for _, seq := range shiftedConst(^v) {
+		seqs = append(seqs, append(seq, Instruction{Op: OP_NOT}))
+	}
+	return seqs
+}
+
+func shiftedConst(v uint64) [][]Instruction {
+	seqs := [][]Instruction{{PushInstr(int64(v))}}
+	if k := bits.TrailingZeros64(v); v != 0 && k > 0 {
+		seqs = append(seqs, []Instruction{PushInstr(int64(v >> k)), PushInstr(int64(k)), {Op: OP_SHL}})
+	}
+	return seqs
+}
@@ -0,0 +1,66 @@
+package codegen
+
+import "testing"
+
+func TestParseCostModel(t *testing.T) {
+	for _, m := range []CostModel{CostGas, CostSize} {
+		got, err := ParseCostModel(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseCostModel(%q) = %v, %v", m, got, err)
+		}
+	}
+	if _, err := ParseCostModel("speed"); err == nil {
+		t.Error("ParseCostModel(\"speed\"): no error")
+	}
+}
+
+func TestMaterialize(t *testing.T) {
+	tests := []struct {
+		v         int64
+		gas, size string
+	}{
+		{0, "PUSH0", "PUSH0"},
+		{0x42, "PUSH1 0x42", "PUSH1 0x42"},
+		{-1, "PUSH8 0xFFFFFFFFFFFFFFFF", "PUSH0; NOT"},
+		{^0xFF, "PUSH8 0xFFFFFFFFFFFFFF00", "PUSH1 0xFF; NOT"},
+		{1 << 60, "PUSH8 0x1000000000000000", "PUSH1 0x1; PUSH1 0x3C; SHL"},
+		{0x1234 << 40, "PUSH7 0x12340000000000", "PUSH2 0x48D; PUSH1 0x2A; SHL"},
+	}
+	for _, tt := range tests {
+		for _, m := range []CostModel{CostGas, CostSize} {
+			cg := NewCodeGen()
+			cg.Passes.Cost = m
+			want := tt.gas
+			if m == CostSize {
+				want = tt.size
+			}
+			if got := listing(cg.materialize(uint64(tt.v))); got != want {
+				t.Errorf("materialize(%#x) with %s: %s, want %s", uint64(tt.v), m, got, want)
+			}
+		}
+	}
+}
+
+func TestMaterializedConstantsKeepTheirValue(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, -2, ^0xFF, ^0x1234, 1 << 60, 0x1234 << 40, -1 << 63} {
+		for _, seq := range constSequences(uint64(v)) {
+			code := append(seq, PushInstr(1), Instruction{Op: OP_SSTORE})
+			if got := run(code).storage[1]; got != uint64(v) {
+				t.Errorf("%s pushes %#x, want %#x", listing(seq), got, uint64(v))
+			}
+		}
+	}
+}
+
+func TestEmitPushDupsLiveConstant(t *testing.T) {
+	src := `SStore(0x1234567890, 0x1234567890);`
+	cg := NewCodeGen()
+	cg.Passes = Passes{Strength: true, Peephole: true}
+	code := cg.Generate(folded(t, src))
+	if got, want := listing(code), "PUSH5 0x1234567890; DUP1; SSTORE; STOP"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := run(code).String(); got != "stop ret= storage={78187493520:78187493520}" {
+		t.Errorf("run: %s", got)
+	}
+}
@@ -0,0 +1,394 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// cseEntry est une sous-expression calculée une fois, dans la variable
+// déclarée par decl, et relue à chacune de ses occurrences.
+type cseEntry struct {
+	decl  *parser.VarDecl // I64 CSE.n = expression
+	vars  map[string]bool // variables lues par l'expression
+	state bool            // l'expression lit le stockage
+	uses  int             // occurrences remplacées encore présentes
+}
+
+// cseEffects résume ce qu'un fragment change aux valeurs des expressions.
+type cseEffects struct {
+	assigned map[string]bool // variables affectées ou déclarées
+	state    bool            // SSTORE, TSTORE ou appel de fonction
+}
+
+// cse porte l'état de EliminateCommonSubexpressions.
+type cse struct {
+	cg      *CodeGen
+	defines map[string]bool
+	entries []*cseEntry
+	origin  map[parser.Node]parser.Node // occurrence remplacée → expression d'origine
+	owner   map[parser.Node]*cseEntry
+	vars    int
+}
+
+// EliminateCommonSubexpressions calcule une seule fois les sous-expressions
+// répétées de chaque liste d'instructions, et retourne le nombre de calculs
+// évités. Une sous-expression est faite d'opérateurs et de builtins dont
+// l'opcode est Pure, Context ou StateRead (voir Purity), sur des constantes
+// et des variables ; sa première occurrence devient une variable déclarée
+// avant l'instruction (sur la pile avec StackLocals, où une relecture est
+// un DUP), et les suivantes la relisent tant qu'aucune de ses variables n'a
+// été affectée, ni, si elle lit le stockage, le stockage écrit. Comme pour
+// OptimizeStorage, un if, une boucle ou un bloc travaille sur une copie et
+// oublie ensuite ce qu'il modifie, et une sous-expression n'est gardée que
+// si le modèle de coût y gagne : relire CALLER ou CHAINID coûte plus cher
+// que les recalculer.
+func (cg *CodeGen) EliminateCommonSubexpressions(prog *parser.Program) int {
+	c := &cse{cg: cg, defines: make(map[string]bool), origin: make(map[parser.Node]parser.Node), owner: make(map[parser.Node]*cseEntry)}
+	walk(prog, func(n parser.Node) {
+		if d, ok := n.(*parser.DefineDecl); ok {
+			c.defines[d.Name] = true
+		}
+	})
+	prog.Decls = c.list(prog.Decls, map[string]*cseEntry{})
+
+	reused := 0
+	dropped := make(map[*parser.VarDecl]bool)
+	for _, e := range c.entries {
+		if e.uses > 1 && c.worth(e) {
+			reused += e.uses - 1
+		} else {
+			dropped[e.decl] = true
+		}
+	}
+	var restore func(parser.Node) parser.Node
+	restore = func(n parser.Node) parser.Node {
+		if d, ok := n.(*parser.VarDecl); ok && dropped[d] {
+			return nil
+		}
+		if e, ok := c.owner[n]; ok && dropped[e.decl] {
+			// L'expression d'origine peut contenir d'autres occurrences.
+			return transform(c.origin[n], restore)
+		}
+		return n
+	}
+	transform(prog, restore)
+	return reused
+}
+
+// worth compare le coût de uses calculs de l'expression à celui d'un
+// calcul gardé en variable puis relu.
+func (c *cse) worth(e *cseEntry) bool {
+	cg := c.cg
+	value := c.sequence(e.decl.Init)
+	read := []Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}}
+	keep := append(append([]Instruction(nil), value...), PushInstr(LocalsBase), Instruction{Op: OP_MSTORE})
+	var again []Instruction
+	for i := 0; i < e.uses; i++ {
+		keep = append(keep, read...)
+		again = append(again, value...)
+	}
+	return cg.less(cg.cost(keep), cg.cost(again))
+}
+
+// sequence retourne les instructions qui calculent node, sans réduction
+// de force : l'estimation de son coût.
+func (c *cse) sequence(node parser.Node) []Instruction {
+	switch n := node.(type) {
+	case *parser.IntLiteral:
+		return c.cg.materialize(uint64(n.Value))
+	case *parser.BinaryExpr:
+		ops, _, _ := BinaryOp(n.Op)
+		return append(append(c.sequence(n.Left), c.sequence(n.Right)...), instructions(ops)...)
+	case *parser.UnaryExpr:
+		ops, _ := UnaryOp(n.Op)
+		return append(c.sequence(n.Operand), instructions(ops)...)
+	case *parser.CallExpr:
+		var seq []Instruction
+		for _, a := range n.Args {
+			seq = append(seq, c.sequence(a)...)
+		}
+		op, _, _ := Builtin(n.Func)
+		return append(seq, Instruction{Op: op})
+	}
+	return []Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}}
+}
+
+func instructions(ops []Opcode) []Instruction {
+	seq := make([]Instruction, len(ops))
+	for i, op := range ops {
+		seq[i] = Instruction{Op: op}
+	}
+	return seq
+}
+
+// key retourne la forme canonique de node, ou false si node n'est pas fait
+// que d'opérations sans effet sur des constantes et des variables.
+func (c *cse) key(node parser.Node) (string, bool) {
+	switch n := node.(type) {
+	case *parser.IntLiteral:
+		return fmt.Sprint(n.Value), true
+	case *parser.Identifier:
+		return n.Name, !c.defines[n.Name]
+	case *parser.BinaryExpr:
+		if _, _, ok := BinaryOp(n.Op); !ok {
+			return "", false
+		}
+		l, okl := c.key(n.Left)
+		r, okr := c.key(n.Right)
+		return fmt.Sprintf("(%d %s %s)", n.Op, l, r), okl && okr
+	case *parser.UnaryExpr:
+		if _, ok := UnaryOp(n.Op); !ok {
+			return "", false
+		}
+		k, ok := c.key(n.Operand)
+		return fmt.Sprintf("(%d %s)", n.Op, k), ok
+	case *parser.CallExpr:
+		op, args, ok := Builtin(n.Func)
+		if !ok || len(n.Args) != args || opcodeInfo[op].Results != 1 {
+			return "", false
+		}
+		switch op.Purity() {
+		case Pure, Context, StateRead:
+		default:
+			return "", false
+		}
+		parts := []string{n.Func}
+		for _, a := range n.Args {
+			k, ok := c.key(a)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, k)
+		}
+		return "(" + strings.Join(parts, " ") + ")", true
+	}
+	return "", false
+}
+
+// entry décrit la sous-expression node, sans encore lui donner de variable.
+func entry(node parser.Node) *cseEntry {
+	e := &cseEntry{vars: make(map[string]bool)}
+	walk(node, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.Identifier:
+			e.vars[n.Name] = true
+		case *parser.CallExpr:
+			if op, _, ok := Builtin(n.Func); ok && op.Purity() == StateRead {
+				e.state = true
+			}
+		}
+	})
+	return e
+}
+
+// stale indique si eff peut changer la valeur de e.
+func (e *cseEntry) stale(eff cseEffects) bool {
+	if e.state && eff.state {
+		return true
+	}
+	for name := range e.vars {
+		if eff.assigned[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- Listes d'instructions ----
+
+// list traite une liste d'instructions où les sous-expressions de avail
+// sont disponibles.
+func (c *cse) list(stmts []parser.Node, avail map[string]*cseEntry) []parser.Node {
+	var out []parser.Node
+	for _, stmt := range stmts {
+		switch n := stmt.(type) {
+		case *parser.FuncDecl:
+			// Le corps est émis en ligne : on y entre par appel ou par chute.
+			if n.Body != nil {
+				n.Body.Stmts = c.list(n.Body.Stmts, map[string]*cseEntry{})
+			}
+			clear(avail)
+		case *parser.ExprStmt:
+			if a, ok := n.Expr.(*parser.AssignExpr); ok && a.Op == lexer.TOK_ASSIGN {
+				if id, ok := a.Target.(*parser.Identifier); ok {
+					a.Value, out = c.value(a.Value, out, avail)
+					c.invalidate(avail, cseEffects{assigned: map[string]bool{id.Name: true}})
+					break
+				}
+			}
+			if call, ok := n.Expr.(*parser.CallExpr); ok {
+				if op, _, builtin := Builtin(call.Func); builtin && op.Purity() == Effect {
+					// Les arguments sont évalués avant l'effet.
+					out = c.exprs(call.Args, out, avail, true)
+					c.invalidate(avail, c.effects(call))
+					break
+				}
+			}
+			n.Expr, out = c.value(n.Expr, out, avail)
+		case *parser.VarDecl:
+			n.Init, out = c.value(n.Init, out, avail)
+			c.invalidate(avail, cseEffects{assigned: map[string]bool{n.Name: true}})
+		case *parser.ReturnStmt:
+			n.Value, out = c.value(n.Value, out, avail)
+		case *parser.IfStmt:
+			n.Cond, out = c.expr(n.Cond, out, avail, true)
+			n.Body = c.nested(n.Body, avail)
+			n.Else = c.nested(n.Else, avail)
+		case *parser.WhileStmt:
+			c.invalidate(avail, c.effects(n))
+			n.Cond, out = c.expr(n.Cond, out, avail, false)
+			n.Body = c.nested(n.Body, avail)
+		case *parser.ForStmt:
+			c.invalidate(avail, c.effects(n))
+			n.Body = c.nested(n.Body, avail)
+		case *parser.Block:
+			c.nested(n, avail)
+		}
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// nested traite le corps d'un if, d'une boucle ou d'un bloc avec une copie
+// de avail, puis retire de avail ce que le corps peut modifier.
+func (c *cse) nested(node parser.Node, avail map[string]*cseEntry) parser.Node {
+	if node == nil {
+		return nil
+	}
+	inner := make(map[string]*cseEntry, len(avail))
+	for k, e := range avail {
+		inner[k] = e
+	}
+	if b, ok := node.(*parser.Block); ok {
+		b.Stmts = c.list(b.Stmts, inner)
+	} else if stmts := c.list([]parser.Node{node}, inner); len(stmts) == 1 {
+		node = stmts[0]
+	} else {
+		node = &parser.Block{Stmts: stmts}
+	}
+	c.invalidate(avail, c.effects(node))
+	return node
+}
+
+// value traite la valeur entière d'une instruction ; un corps inliné y est
+// traité comme une liste d'instructions (voir storageOpt.value).
+func (c *cse) value(node parser.Node, out []parser.Node, avail map[string]*cseEntry) (parser.Node, []parser.Node) {
+	in, ok := node.(*parser.InlineExpr)
+	if !ok {
+		return c.expr(node, out, avail, true)
+	}
+	inner := make(map[string]*cseEntry, len(avail))
+	for k, e := range avail {
+		inner[k] = e
+	}
+	in.Stmts = c.list(in.Stmts, inner)
+	in.Value, in.Stmts = c.expr(in.Value, in.Stmts, inner, true)
+	c.invalidate(avail, c.effects(in))
+	return in, out
+}
+
+// ---- Expressions ----
+
+// expr traite l'expression d'une instruction (voir exprs).
+func (c *cse) expr(node parser.Node, out []parser.Node, avail map[string]*cseEntry, create bool) (parser.Node, []parser.Node) {
+	if node == nil {
+		return nil, out
+	}
+	nodes := []parser.Node{node}
+	out = c.exprs(nodes, out, avail, create)
+	return nodes[0], out
+}
+
+// exprs remplace, dans les expressions d'une même instruction, chaque
+// sous-expression disponible par sa variable, et, si create, déclare avant
+// l'instruction une variable pour chacune des autres. Calculer ainsi une
+// sous-expression avant l'instruction ne change rien tant que l'instruction
+// n'en modifie pas les variables ; celles qu'elle modifie restent en place.
+func (c *cse) exprs(nodes []parser.Node, out []parser.Node, avail map[string]*cseEntry, create bool) []parser.Node {
+	eff := c.effects(nodes...)
+	c.invalidate(avail, eff)
+	replace := func(n parser.Node) parser.Node {
+		switch n.(type) {
+		case *parser.BinaryExpr, *parser.UnaryExpr, *parser.CallExpr:
+		default:
+			return n
+		}
+		key, ok := c.key(n)
+		if !ok {
+			return n
+		}
+		e, ok := avail[key]
+		if !ok {
+			if !create {
+				return n
+			}
+			if e = entry(n); e.stale(eff) {
+				return n
+			}
+			c.vars++
+			e.decl = &parser.VarDecl{TypeName: "I64", Name: fmt.Sprintf("CSE.%d", c.vars), Init: n}
+			c.entries = append(c.entries, e)
+			avail[key] = e
+			out = append(out, e.decl)
+		} else {
+			// Les occurrences contenues dans n disparaissent avec lui.
+			walk(n, func(m parser.Node) {
+				if inner, ok := c.owner[m]; ok {
+					inner.uses--
+				}
+			})
+		}
+		e.uses++
+		use := &parser.Identifier{Name: e.decl.Name}
+		c.origin[use] = n
+		c.owner[use] = e
+		return use
+	}
+	for i, node := range nodes {
+		nodes[i] = transform(node, replace)
+	}
+	return out
+}
+
+// effects résume nodes (voir cseEffects).
+func (c *cse) effects(nodes ...parser.Node) cseEffects {
+	eff := cseEffects{assigned: make(map[string]bool)}
+	target := func(n parser.Node) {
+		if id, ok := n.(*parser.Identifier); ok {
+			eff.assigned[id.Name] = true
+		}
+	}
+	visit := func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.VarDecl:
+			eff.assigned[n.Name] = true
+		case *parser.AssignExpr:
+			target(n.Target)
+		case *parser.PostfixExpr:
+			target(n.Operand)
+		case *parser.UnaryExpr:
+			if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+				target(n.Operand)
+			}
+		case *parser.CallExpr:
+			op, _, builtin := Builtin(n.Func)
+			eff.state = eff.state || !builtin || op == OP_SSTORE || op == OP_TSTORE
+		}
+	}
+	for _, node := range nodes {
+		walk(node, visit)
+	}
+	return eff
+}
+
+// invalidate retire de avail les sous-expressions que eff peut changer.
+func (c *cse) invalidate(avail map[string]*cseEntry, eff cseEffects) {
+	for k, e := range avail {
+		if e.stale(eff) {
+			delete(avail, k)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package codegen
+
+import (
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+func TestPurity(t *testing.T) {
+	tests := []struct {
+		op   Opcode
+		want Purity
+	}{
+		{OP_ADD, Pure}, {OP_MULMOD, Pure}, {OP_DUP1, Pure}, {OP_PUSH8, Pure},
+		{OP_CALLER, Context}, {OP_CALLDATALOAD, Context},
+		{OP_SLOAD, StateRead},
+		{OP_MLOAD, MemoryRead}, {OP_HASH, MemoryRead},
+		{OP_GAS, Volatile}, {OP_PC, Volatile},
+		{OP_SSTORE, Effect}, {OP_MSTORE, Effect}, {OP_JUMP, Effect}, {Opcode(0xEF), Effect},
+	}
+	for _, tt := range tests {
+		if got := tt.op.Purity(); got != tt.want {
+			t.Errorf("%s: purity %d, want %d", tt.op, got, tt.want)
+		}
+	}
+	// Un opcode pur est évaluable : Eval le connaît.
+	for op := Opcode(0); op < 0xFF; op++ {
+		if op.Purity() != Pure || op.IsPush() || op == OP_PUSH0 || op >= OP_DUP1 && op <= OP_SWAP8 || op == OP_POP {
+			continue
+		}
+		args, _ := op.StackEffect()
+		if _, ok := Eval(op, make([]uint64, args)...); !ok {
+			t.Errorf("%s is Pure but Eval does not evaluate it", op)
+		}
+	}
+}
+
+func TestEliminateCommonSubexpressions(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		reused int
+	}{
+		{"pure", `I64 a = SLoad(0) + 3;
+I64 b = SLoad(1) | 1;
+SStore(1, MulMod(a, b, 1000) + MulMod(a, b, 1000) * 2);
+`, 1},
+		{"state", `I64 k = SLoad(0) + 1;
+SStore(1, SLoad(k * 3) + SLoad(k * 3));
+`, 1},
+		// Affecter a rend la première valeur périmée.
+		{"assigned", `I64 a = SLoad(0) + 3;
+I64 x = MulMod(a, a, 77);
+a = a + 1;
+SStore(1, x + MulMod(a, a, 77));
+`, 0},
+		// Un SSTORE peut changer ce que lit SLOAD : seul k * 3 est réutilisé.
+		{"stored", `I64 k = SLoad(0) + 1;
+I64 x = SLoad(k * 3);
+SStore(k * 3, x + 1);
+SStore(5, SLoad(k * 3) + x);
+`, 2},
+		// Relire une variable coûte plus que CALLER.
+		{"context", `SStore(1, Caller() + Caller());
+`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg, code := inlineAt(t, tt.src, O2)
+			if diag.HasErrors(cg.Diagnostics) {
+				t.Fatal(messages(cg, diag.Error))
+			}
+			if cg.Reused != tt.reused {
+				t.Errorf("%d subexpression(s) reused, want %d", cg.Reused, tt.reused)
+			}
+			if got, want := run(code).String(), run(compileAt(t, tt.src, O1)).String(); got != want {
+				t.Errorf("-O2: %s, -O1: %s", got, want)
+			}
+		})
+	}
+}
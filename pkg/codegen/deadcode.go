@@ -0,0 +1,494 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// eliminator porte l'état de EliminateDeadCode.
+type eliminator struct {
+	cg    *CodeGen // reçoit les avertissements ; nil les tait
+	items []string // éléments supprimés, dans l'ordre
+}
+
+// note enregistre un élément supprimé et le signale par un avertissement
+// situé au nœud supprimé, sauf pour un if ou un while que seul l'inlining a
+// rendu constant (le source n'y est pour rien).
+func (d *eliminator) note(node parser.Node, format string, args ...any) {
+	item := d.record(format, args...)
+	if d.cg != nil && !d.cg.inlinedBranches[node] {
+		d.cg.warnAt("dead-code", node.Span(), "eliminated dead code: %s", item)
+	}
+}
+
+// record enregistre un élément supprimé sans avertissement et le retourne.
+func (d *eliminator) record(format string, args ...any) string {
+	item := fmt.Sprintf(format, args...)
+	d.items = append(d.items, item)
+	return item
+}
+
+// EliminateDeadCode supprime en place le code mort du programme et retourne
+// la liste de ce qui a été supprimé :
+//   - les instructions qui suivent un return (ou un builtin qui termine
+//     l'exécution) dans la même liste ; les déclarations de fonctions et de
+//     macros restent, et une variable garde sa déclaration sans son
+//     initialisation ;
+//   - les if dont la condition est constante (remplacés par la branche
+//     prise) et les while (0) ;
+//   - les fonctions qu'aucun point d'entrée n'appelle : le code de premier
+//     niveau et les fonctions public, ou, s'il n'y en a pas, les fonctions
+//     qui ne sont appelées nulle part (un appel inliné compte comme appel) ;
+//     une fonction inlinée à chaque appel est listée sans avertissement ;
+//   - les variables jamais lues, avec leurs affectations : la valeur
+//     affectée reste si elle a des effets ; une variable dont FoldConstants
+//     a propagé la valeur à toutes les lectures est listée sans
+//     avertissement.
+//
+// Les variables introduites par l'inlining (nom contenant un point) sont
+// supprimées sans être listées. À lancer après InlineFunctions et
+// FoldConstants, qui rendent des conditions constantes et des variables
+// inutiles.
+func (cg *CodeGen) EliminateDeadCode(prog *parser.Program) []string {
+	d := &eliminator{cg: cg}
+	prog.Decls = d.list(prog.Decls, "top level")
+	d.functions(prog)
+	for d.variables(prog) {
+	}
+	return d.items
+}
+
+// ---- Instructions inatteignables et conditions constantes ----
+
+// list élague une liste d'instructions.
+func (d *eliminator) list(stmts []parser.Node, where string) []parser.Node {
+	var out []parser.Node
+	dead, dropped := false, 0
+	var first parser.Node // première instruction supprimée
+	for _, s := range stmts {
+		s = d.stmt(s, where)
+		if s == nil {
+			continue
+		}
+		if dead {
+			switch n := s.(type) {
+			case *parser.FuncDecl, *parser.DefineDecl:
+			case *parser.VarDecl:
+				if n.Init != nil {
+					if dropped == 0 {
+						first = n.Init
+					}
+					n.Init = nil
+					dropped++
+				}
+			default:
+				if dropped == 0 {
+					first = s
+				}
+				dropped++
+				continue
+			}
+		}
+		out = append(out, s)
+		dead = dead || terminates(s)
+	}
+	if dropped > 0 {
+		d.note(first, "%d unreachable statement(s) after return in %s", dropped, where)
+	}
+	return out
+}
+
+// stmt élague une instruction ; nil la supprime.
+func (d *eliminator) stmt(node parser.Node, where string) parser.Node {
+	switch n := node.(type) {
+	case *parser.Block:
+		n.Stmts = d.list(n.Stmts, where)
+	case *parser.FuncDecl:
+		if n.Body != nil {
+			n.Body.Stmts = d.list(n.Body.Stmts, fmt.Sprintf("'%s'", n.Name))
+		}
+	case *parser.IfStmt:
+		if v, ok := constOf(n.Cond); ok {
+			d.note(n, "if with constant condition (%s) in %s", truth(v), where)
+			if v != 0 {
+				return d.stmt(n.Body, where)
+			}
+			if n.Else == nil {
+				return nil
+			}
+			return d.stmt(n.Else, where)
+		}
+		d.inlined(n.Cond, where)
+		n.Body = d.stmt(n.Body, where)
+		if n.Else != nil {
+			n.Else = d.stmt(n.Else, where)
+		}
+	case *parser.WhileStmt:
+		if v, ok := constOf(n.Cond); ok && v == 0 {
+			d.note(n, "while with constant condition (false) in %s", where)
+			return nil
+		}
+		d.inlined(n.Cond, where)
+		n.Body = d.stmt(n.Body, where)
+	case *parser.ForStmt:
+		for _, p := range []*parser.Node{&n.Init, &n.Cond, &n.Post} {
+			d.inlined(*p, where)
+		}
+		n.Body = d.stmt(n.Body, where)
+	default:
+		d.inlined(node, where)
+	}
+	return node
+}
+
+// inlined élague les corps inlinés contenus dans node.
+func (d *eliminator) inlined(node parser.Node, where string) {
+	walk(node, func(n parser.Node) {
+		if e, ok := n.(*parser.InlineExpr); ok {
+			e.Stmts = d.list(e.Stmts, where)
+		}
+	})
+}
+
+func truth(v uint64) string {
+	if v != 0 {
+		return "true"
+	}
+	return "false"
+}
+
+// terminates indique si l'exécution ne continue jamais après node.
+func terminates(node parser.Node) bool {
+	switch n := node.(type) {
+	case *parser.ReturnStmt:
+		return true
+	case *parser.Block:
+		return len(n.Stmts) > 0 && terminates(n.Stmts[len(n.Stmts)-1])
+	case *parser.IfStmt:
+		return n.Else != nil && terminates(n.Body) && terminates(n.Else)
+	case *parser.ExprStmt:
+		if call, ok := n.Expr.(*parser.CallExpr); ok {
+			op, _, builtin := Builtin(call.Func)
+			return builtin && isTerminator(op)
+		}
+	}
+	return false
+}
+
+// ---- Fonctions ----
+
+// functions retire les fonctions qu'aucun point d'entrée n'atteint.
+func (d *eliminator) functions(prog *parser.Program) {
+	funcs := make(map[string]*parser.FuncDecl)
+	called := make(map[string]bool)
+	inlined := make(map[string]bool)
+	public := false
+	for _, decl := range prog.Decls {
+		if fn, ok := decl.(*parser.FuncDecl); ok {
+			funcs[fn.Name] = fn
+			public = public || fn.Public
+		}
+	}
+	walk(prog, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.CallExpr:
+			called[n.Func] = true
+		case *parser.InlineExpr:
+			called[n.Func] = true
+			inlined[n.Func] = true
+		}
+	})
+
+	reached := make(map[string]bool)
+	var reach func(node parser.Node)
+	reach = func(node parser.Node) {
+		walk(node, func(n parser.Node) {
+			call, ok := n.(*parser.CallExpr)
+			if !ok || reached[call.Func] || funcs[call.Func] == nil {
+				return
+			}
+			reached[call.Func] = true
+			reach(funcs[call.Func])
+		})
+	}
+	for _, decl := range prog.Decls {
+		fn, ok := decl.(*parser.FuncDecl)
+		switch {
+		case !ok:
+			reach(decl)
+		case fn.Public || !public && !called[fn.Name]:
+			reached[fn.Name] = true
+			reach(fn)
+		}
+	}
+
+	var out []parser.Node
+	for _, decl := range prog.Decls {
+		fn, ok := decl.(*parser.FuncDecl)
+		switch {
+		case ok && !reached[fn.Name] && inlined[fn.Name]:
+			d.record("function '%s' (inlined at every call site)", fn.Name)
+			continue
+		case ok && !reached[fn.Name]:
+			d.note(fn, "unused function '%s'", fn.Name)
+			continue
+		}
+		out = append(out, decl)
+	}
+	prog.Decls = out
+}
+
+// ---- Variables ----
+
+// resolver associe les lectures et écritures de variables à leur
+// déclaration, avec les portées de CodeGen.
+type resolver struct {
+	scopes    []map[string]*parser.VarDecl // nil : paramètre
+	defines   map[string]parser.Node
+	expanding map[string]bool
+	reads     map[*parser.VarDecl]int
+	writes    map[*parser.AssignExpr]*parser.VarDecl // affectations simples
+}
+
+func (r *resolver) pushScope() { r.scopes = append(r.scopes, map[string]*parser.VarDecl{}) }
+func (r *resolver) popScope()  { r.scopes = r.scopes[:len(r.scopes)-1] }
+
+func (r *resolver) lookup(name string) (*parser.VarDecl, bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if decl, ok := r.scopes[i][name]; ok {
+			return decl, true
+		}
+	}
+	return nil, false
+}
+
+func (r *resolver) stmts(stmts []parser.Node) {
+	for _, s := range stmts {
+		r.node(s)
+	}
+}
+
+func (r *resolver) node(node parser.Node) {
+	switch n := node.(type) {
+	case nil:
+	case *parser.Program:
+		r.stmts(n.Decls)
+	case *parser.DefineDecl:
+		r.defines[n.Name] = n.Value
+	case *parser.VarDecl:
+		r.node(n.Init)
+		r.scopes[len(r.scopes)-1][n.Name] = n
+	case *parser.FuncDecl:
+		r.pushScope()
+		for _, p := range n.Params {
+			r.scopes[len(r.scopes)-1][p.Name] = nil
+		}
+		if n.Body != nil {
+			r.stmts(n.Body.Stmts)
+		}
+		r.popScope()
+	case *parser.Block:
+		r.pushScope()
+		r.stmts(n.Stmts)
+		r.popScope()
+	case *parser.InlineExpr:
+		r.pushScope()
+		r.stmts(n.Stmts)
+		r.node(n.Value)
+		r.popScope()
+	case *parser.ForStmt:
+		r.pushScope()
+		r.node(n.Init)
+		r.node(n.Cond)
+		r.node(n.Body)
+		r.node(n.Post)
+		r.popScope()
+	case *parser.ExprStmt:
+		r.node(n.Expr)
+	case *parser.ReturnStmt:
+		r.node(n.Value)
+	case *parser.IfStmt:
+		r.node(n.Cond)
+		r.node(n.Body)
+		r.node(n.Else)
+	case *parser.WhileStmt:
+		r.node(n.Cond)
+		r.node(n.Body)
+	case *parser.Identifier:
+		if decl, ok := r.lookup(n.Name); ok {
+			if decl != nil {
+				r.reads[decl]++
+			}
+		} else if value, ok := r.defines[n.Name]; ok && !r.expanding[n.Name] {
+			// Une macro est lue là où elle est substituée.
+			r.expanding[n.Name] = true
+			r.node(value)
+			delete(r.expanding, n.Name)
+		}
+	case *parser.AssignExpr:
+		if id, ok := n.Target.(*parser.Identifier); ok && n.Op == lexer.TOK_ASSIGN {
+			r.node(n.Value)
+			if decl, ok := r.lookup(id.Name); ok && decl != nil {
+				r.writes[n] = decl
+			}
+			return
+		}
+		r.node(n.Target)
+		r.node(n.Value)
+	case *parser.BinaryExpr:
+		r.node(n.Left)
+		r.node(n.Right)
+	case *parser.UnaryExpr:
+		r.node(n.Operand)
+	case *parser.PostfixExpr:
+		r.node(n.Operand)
+	case *parser.CallExpr:
+		for _, a := range n.Args {
+			r.node(a)
+		}
+	case *parser.IndexExpr:
+		r.node(n.Array)
+		r.node(n.Index)
+	case *parser.MemberExpr:
+		r.node(n.Object)
+	case *parser.CastExpr:
+		r.node(n.Expr)
+	}
+}
+
+// variables retire les variables jamais lues et leurs affectations ; elle
+// indique si quelque chose a été retiré (une valeur supprimée peut rendre
+// une autre variable inutile).
+func (d *eliminator) variables(prog *parser.Program) bool {
+	r := &resolver{
+		scopes:    []map[string]*parser.VarDecl{{}},
+		defines:   make(map[string]parser.Node),
+		expanding: make(map[string]bool),
+		reads:     make(map[*parser.VarDecl]int),
+		writes:    make(map[*parser.AssignExpr]*parser.VarDecl),
+	}
+	r.node(prog)
+	unused := func(decl *parser.VarDecl) bool { return decl != nil && r.reads[decl] == 0 }
+
+	changed := false
+	kept := make(map[parser.Node]bool) // valeurs d'affectations supprimées
+	drop := func(node parser.Node) parser.Node {
+		switch n := node.(type) {
+		case *parser.VarDecl:
+			if !unused(n) {
+				return n
+			}
+			changed = true
+			switch {
+			case strings.Contains(n.Name, "."):
+			case d.cg != nil && d.cg.propagated[n]:
+				d.record("variable '%s' (propagated to its reads)", n.Name)
+			default:
+				d.note(n, "unused variable '%s'", n.Name)
+			}
+			if n.Init != nil && hasEffects(n.Init) {
+				return &parser.ExprStmt{Expr: n.Init}
+			}
+			return nil
+		case *parser.ExprStmt:
+			if kept[n.Expr] && !hasEffects(n.Expr) {
+				return nil
+			}
+		case *parser.AssignExpr:
+			if unused(r.writes[n]) {
+				changed = true
+				kept[n.Value] = true
+				return n.Value
+			}
+		}
+		return node
+	}
+	transform(prog, drop)
+	return changed
+}
+
+// hasEffects indique si l'évaluation de node peut modifier l'état :
+// affectations, incréments, appels (builtins compris) et corps inlinés.
+func hasEffects(node parser.Node) bool {
+	found := false
+	walk(node, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.AssignExpr, *parser.PostfixExpr, *parser.CallExpr, *parser.InlineExpr:
+			found = true
+		case *parser.UnaryExpr:
+			found = found || n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS
+		}
+	})
+	return found
+}
+
+// transform remplace chaque nœud de node, enfants d'abord, par f(nœud) ;
+// une instruction remplacée par nil est retirée de sa liste.
+func transform(node parser.Node, f func(parser.Node) parser.Node) parser.Node {
+	list := func(nodes []parser.Node) []parser.Node {
+		out := nodes[:0]
+		for _, s := range nodes {
+			if s = transform(s, f); s != nil {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *parser.Program:
+		n.Decls = list(n.Decls)
+	case *parser.Block:
+		n.Stmts = list(n.Stmts)
+	case *parser.InlineExpr:
+		n.Stmts = list(n.Stmts)
+		n.Value = transform(n.Value, f)
+	case *parser.FuncDecl:
+		if n.Body != nil {
+			n.Body.Stmts = list(n.Body.Stmts)
+		}
+	case *parser.VarDecl:
+		n.Init = transform(n.Init, f)
+	case *parser.ExprStmt:
+		n.Expr = transform(n.Expr, f)
+	case *parser.ReturnStmt:
+		n.Value = transform(n.Value, f)
+	case *parser.IfStmt:
+		n.Cond, n.Body, n.Else = transform(n.Cond, f), transform(n.Body, f), transform(n.Else, f)
+	case *parser.WhileStmt:
+		n.Cond, n.Body = transform(n.Cond, f), transform(n.Body, f)
+	case *parser.ForStmt:
+		n.Init, n.Cond = transform(n.Init, f), transform(n.Cond, f)
+		n.Post, n.Body = transform(n.Post, f), transform(n.Body, f)
+		if s, ok := n.Init.(*parser.ExprStmt); ok {
+			n.Init = s.Expr // déclaration remplacée par son initialisation
+		}
+	case *parser.BinaryExpr:
+		n.Left, n.Right = transform(n.Left, f), transform(n.Right, f)
+	case *parser.UnaryExpr:
+		n.Operand = transform(n.Operand, f)
+	case *parser.AssignExpr:
+		n.Target, n.Value = transform(n.Target, f), transform(n.Value, f)
+	case *parser.PostfixExpr:
+		n.Operand = transform(n.Operand, f)
+	case *parser.CallExpr:
+		for i, a := range n.Args {
+			n.Args[i] = transform(a, f)
+		}
+	case *parser.IndexExpr:
+		n.Array, n.Index = transform(n.Array, f), transform(n.Index, f)
+	case *parser.MemberExpr:
+		n.Object = transform(n.Object, f)
+	case *parser.CastExpr:
+		n.Expr = transform(n.Expr, f)
+	}
+	out := f(node)
+	if out != nil && out.Span().IsZero() {
+		out.SetSpan(node.Span()) // le remplaçant prend la place du nœud
+	}
+	return out
+}
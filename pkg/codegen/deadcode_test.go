@@ -0,0 +1,101 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // éléments supprimés, séparés par « ; »
+	}{
+		{"SStore(1, 2);\nreturn;\nSStore(3, 4);\nI64 x = 5;\nSStore(6, x);\n",
+			"3 unreachable statement(s) after return in top level; variable 'x' (propagated to its reads)"},
+		{"if (1) SStore(1, 2); else SStore(3, 4);\nwhile (0) SStore(5, 6);\n",
+			"if with constant condition (true) in top level; while with constant condition (false) in top level"},
+		{"if (SLoad(0)) {\n  if (SLoad(1)) return; else return;\n  SStore(1, 1);\n}\nSStore(2, 2);\n",
+			"1 unreachable statement(s) after return in top level"},
+		// Helper, inlinée dans Api, n'est plus appelée.
+		{"public I64 Api() { return Helper(); }\nI64 Helper() { return SLoad(1); }\nI64 Unused() { return 2; }\n",
+			"function 'Helper' (inlined at every call site); unused function 'Unused'"},
+		// Sans fonction public, une fonction jamais appelée est un point d'entrée.
+		{"I64 Entry() { return 1; }\n", ""},
+		{"I64 x = SLoad(1);\nI64 y = 3;\ny = SLoad(2) + 1;\nSStore(4, 5);\n",
+			"unused variable 'x'; unused variable 'y'"},
+		// z ne devient inutile qu'une fois w supprimée.
+		{"I64 z = SLoad(1);\nI64 w = z * 2;\n",
+			"unused variable 'w'; unused variable 'z'"},
+	}
+	for _, tt := range tests {
+		cg, _ := inlineAt(t, tt.src, O2)
+		if diag.HasErrors(cg.Diagnostics) {
+			t.Errorf("%q: %v", tt.src, messages(cg, diag.Error))
+			continue
+		}
+		if got := strings.Join(cg.Eliminated, "; "); got != tt.want {
+			t.Errorf("%q: eliminated %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+// TestEliminatedValuesKeepTheirEffects vérifie que les valeurs affectées à
+// une variable supprimée restent évaluées quand elles ont des effets.
+func TestEliminatedValuesKeepTheirEffects(t *testing.T) {
+	src := "I64 n = 0;\nI64 x = n++;\nx = SStore(1, 9);\nSStore(2, n);\n"
+	cg, code := inlineAt(t, src, O2)
+	if got := strings.Join(cg.Eliminated, "; "); got != "unused variable 'x'" {
+		t.Errorf("eliminated %q", got)
+	}
+	if got, want := run(code).String(), "stop ret= storage={1:9 2:1}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	warns := messages(cg, diag.Warning)
+	if len(warns) != 1 || !strings.HasPrefix(warns[0], "eliminated dead code: ") {
+		t.Errorf("warnings = %q", warns)
+	}
+}
+
+// TestDeadCodeWarningsArePositioned vérifie que chaque élément supprimé
+// est signalé à sa place dans le source.
+func TestDeadCodeWarningsArePositioned(t *testing.T) {
+	src := "I64 Unused() { return 1; }\nI64 x = 2;\nSStore(1, 3);\npublic I64 Get() { return 4; }\n"
+	cg, _ := inlineAt(t, src, O2)
+	var got []string
+	for _, d := range cg.Diagnostics {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"test.HC:1:1: warning: eliminated dead code: unused function 'Unused' [dead-code]",
+		"test.HC:2:1: warning: eliminated dead code: unused variable 'x' [dead-code]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestPropagatedVariablesNotReported vérifie qu'une variable lue dans le
+// source, dont le repliement a propagé la valeur, est supprimée sans
+// avertissement.
+func TestPropagatedVariablesNotReported(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"I64 a = 7;\nSStore(2, a);\n", "variable 'a' (propagated to its reads)"},
+		{"#define N 4\nI64 k = N;\nSStore(1, k * 2);\n", "variable 'k' (propagated to its reads)"},
+		{"I64 x = 2 * 3;\nI64 y = SLoad(0);\nSStore(1, x + 4);\n", "variable 'x' (propagated to its reads); unused variable 'y'"},
+	}
+	for _, tt := range tests {
+		cg, _ := inlineAt(t, tt.src, O2)
+		if got := strings.Join(cg.Eliminated, "; "); got != tt.want {
+			t.Errorf("%q: eliminated %q, want %q", tt.src, got, tt.want)
+		}
+		// Seule y, jamais lue, est signalée.
+		if w := messages(cg, diag.Warning); len(w) != strings.Count(tt.want, "unused") {
+			t.Errorf("%q: warnings %q", tt.src, w)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package codegen
+
+import (
+	"fmt"
+	"math"
+)
+
+// GasCost encadre un coût en gas : Min == Max quand il est exact, Unbounded
+// quand aucune borne haute ne peut être déterminée statiquement (taille de
+// copie ou de hash inconnue, offset mémoire inconnu...).
+type GasCost struct {
+	Min       int
+	Max       int
+	Unbounded bool
+}
+
+// Exact indique si le coût est connu à l'unité près.
+func (c GasCost) Exact() bool { return !c.Unbounded && c.Min == c.Max }
+
+// Add additionne deux coûts borne à borne.
+func (c GasCost) Add(o GasCost) GasCost {
+	return GasCost{Min: c.Min + o.Min, Max: c.Max + o.Max, Unbounded: c.Unbounded || o.Unbounded}
+}
+
+func (c GasCost) String() string {
+	switch {
+	case c.Unbounded:
+		return fmt.Sprintf("%d+", c.Min)
+	case c.Min == c.Max:
+		return fmt.Sprintf("%d", c.Min)
+	}
+	return fmt.Sprintf("%d..%d", c.Min, c.Max)
+}
+
+func exactGas(n int) GasCost { return GasCost{Min: n, Max: n} }
+
+// GasEstimate est le résultat de EstimateGas.
+type GasEstimate struct {
+	Instrs []GasCost // coût de chaque instruction, statique + dynamique
+	Total  GasCost
+
+	// Plus haute adresse mémoire touchée (octets) ; MemUnbounded si un accès
+	// à offset ou taille inconnus peut la repousser arbitrairement.
+	MemHighWater int
+	MemUnbounded bool
+}
+
+type gasEstimator struct {
+	sched *GasSchedule
+	absStack
+
+	// Plus haute adresse mémoire touchée : au moins memLo, au plus memHi
+	// (memHiInf si un accès inconnu a pu étendre la mémoire sans limite).
+	memLo    uint64
+	memHi    uint64
+	memHiInf bool
+
+	highWater uint64 // plus haute adresse connue sur tout le parcours
+	unknown   bool   // un accès d'offset ou de taille inconnus a été vu
+
+	// Au JUMPDEST, la mémoire a pu être étendue par n'importe quel accès du
+	// code : sa borne haute devient celle de tout le code (joinHi, joinInf),
+	// relevée par une première passe. Sa borne basse redescend à ce que
+	// touche à coup sûr le début du code, exécuté jusqu'au premier saut ou
+	// JUMPDEST par tous les chemins (joinLo, figée à ce point).
+	joinHi  uint64
+	joinInf bool
+	joinLo  uint64
+	joined  bool
+}
+
+// EstimateGas calcule le coût de chaque instruction en appliquant les règles
+// dynamiques (EXP, HASH, copies, SSTORE, extension mémoire) sur les opérandes
+// constants qu'il peut suivre le long du code. Le code est parcouru en ligne
+// droite : à chaque JUMPDEST, les constantes suivies sont oubliées et la
+// mémoire peut avoir toute taille entre 0 et la plus haute adresse touchée
+// par le code, qu'une première passe relève.
+func EstimateGas(code []Instruction, s *GasSchedule) *GasEstimate {
+	if s == nil {
+		s = defaultSchedule
+	}
+	first := &gasEstimator{sched: s}
+	for _, inst := range code {
+		first.account(inst)
+	}
+	e := &gasEstimator{sched: s, joinHi: first.highWater, joinInf: first.unknown}
+	est := &GasEstimate{Instrs: make([]GasCost, len(code))}
+	for i, inst := range code {
+		cost := e.account(inst)
+		est.Instrs[i] = cost
+		est.Total = est.Total.Add(cost)
+	}
+	est.MemHighWater = int(e.highWater)
+	est.MemUnbounded = e.unknown
+	return est
+}
+
+func (e *gasEstimator) account(inst Instruction) GasCost {
+	op := inst.Op
+	cost := exactGas(e.sched.Cost(op))
+
+	switch {
+	case op == OP_PUSH0 || op.IsPush(),
+		op >= OP_DUP1 && op <= OP_DUP8,
+		op >= OP_SWAP1 && op <= OP_SWAP8:
+		e.step(inst)
+		return cost
+	case op == OP_JUMPDEST:
+		e.step(inst)
+		e.join()
+		e.memLo = e.joinLo
+		if e.joinHi > e.memHi {
+			e.memHi = e.joinHi
+		}
+		e.memHiInf = e.memHiInf || e.joinInf
+		return cost
+	}
+
+	if op == OP_JUMP || op == OP_JUMPI {
+		e.join()
+	}
+	info, ok := opcodeInfo[op]
+	if !ok {
+		return cost
+	}
+	args := make([]absVal, info.Args)
+	for i := range args {
+		args[i] = e.pop()
+	}
+
+	switch op {
+	case OP_EXP:
+		cost = cost.Add(e.expGas(args[1]))
+	case OP_HASH:
+		cost = cost.Add(e.wordGas(args[1], e.sched.HashWord))
+		cost = cost.Add(e.touch(args[0], args[1]))
+	case OP_CALLDATACOPY, OP_CODECOPY, OP_RETURNDATACOPY:
+		cost = cost.Add(e.wordGas(args[2], e.sched.CopyWord))
+		cost = cost.Add(e.touch(args[0], args[2]))
+	case OP_EXTCODECOPY:
+		cost = cost.Add(e.wordGas(args[3], e.sched.CopyWord))
+		cost = cost.Add(e.touch(args[1], args[3]))
+	case OP_MCOPY:
+		cost = cost.Add(e.wordGas(args[2], e.sched.CopyWord))
+		cost = cost.Add(e.touch(args[1], args[2]))
+		cost = cost.Add(e.touch(args[0], args[2]))
+	case OP_MLOAD, OP_MSTORE:
+		cost = cost.Add(e.touch(args[0], absVal{8, true}))
+	case OP_MSTORE8:
+		cost = cost.Add(e.touch(args[0], absVal{1, true}))
+	case OP_MLOAD16, OP_MLOAD16S, OP_MSTORE16:
+		cost = cost.Add(e.touch(args[0], absVal{2, true}))
+	case OP_MLOAD32, OP_MLOAD32S, OP_MSTORE32:
+		cost = cost.Add(e.touch(args[0], absVal{4, true}))
+	case OP_RETURN, OP_REVERT:
+		cost = cost.Add(e.touch(args[0], args[1]))
+	case OP_SSTORE:
+		cost = cost.Add(GasCost{Min: e.sched.SStoreMin, Max: e.sched.SStoreMax})
+	}
+
+	e.results(op, args, info.Results)
+	return cost
+}
+
+// join fige joinLo au premier point de contrôle du code.
+func (e *gasEstimator) join() {
+	if !e.joined {
+		e.joinLo, e.joined = e.memLo, true
+	}
+}
+
+// expGas : +ExpByte par octet non nul de l'exposant (au plus 8).
+func (e *gasEstimator) expGas(exp absVal) GasCost {
+	if !exp.known {
+		return GasCost{Min: 0, Max: 8 * e.sched.ExpByte}
+	}
+	return exactGas(expBytes(exp.v) * e.sched.ExpByte)
+}
+
+// wordGas : perWord par mot de 32 octets de size.
+func (e *gasEstimator) wordGas(size absVal, perWord int) GasCost {
+	if !size.known {
+		return GasCost{Unbounded: perWord > 0}
+	}
+	return scaledGas(words(size.v), perWord)
+}
+
+// touch facture l'extension mémoire d'un accès à [off, off+size).
+func (e *gasEstimator) touch(off, size absVal) GasCost {
+	if size.known && size.v == 0 {
+		return GasCost{}
+	}
+	if !off.known || !size.known || off.v+size.v < off.v {
+		e.memHiInf, e.unknown = true, true
+		return GasCost{Unbounded: e.sched.MemWord > 0}
+	}
+	end := off.v + size.v
+
+	// Borne basse : la mémoire a pu déjà être étendue jusqu'à memHi.
+	var cost GasCost
+	if !e.memHiInf && words(end) > words(e.memHi) {
+		cost.Min = scaledGas(words(end)-words(e.memHi), e.sched.MemWord).Min
+	}
+	if words(end) > words(e.memLo) {
+		hi := scaledGas(words(end)-words(e.memLo), e.sched.MemWord)
+		cost.Max, cost.Unbounded = hi.Max, hi.Unbounded
+	}
+	if end > e.highWater {
+		e.highWater = end
+	}
+	if end > e.memLo {
+		e.memLo = end
+	}
+	if end > e.memHi {
+		e.memHi = end
+	}
+	return cost
+}
+
+func words(n uint64) uint64 { return n/32 + (n%32+31)/32 }
+
+// scaledGas retourne n*perWord, non borné au-delà de ce qu'un int peut représenter.
+func scaledGas(n uint64, perWord int) GasCost {
+	if perWord == 0 || n == 0 {
+		return GasCost{}
+	}
+	if n > uint64(math.MaxInt32)/uint64(perWord) {
+		return GasCost{Unbounded: true}
+	}
+	return exactGas(int(n) * perWord)
+}
@@ -0,0 +1,86 @@
+package codegen
+
+import "testing"
+
+// pushOp retourne le PUSH le plus court de v.
+func pushOp(v int64) Instruction {
+	if v == 0 {
+		return Instruction{Op: OP_PUSH0}
+	}
+	n := 1
+	for u := uint64(v) >> 8; u != 0; u >>= 8 {
+		n++
+	}
+	return Instruction{Op: OP_PUSH1 + Opcode(n-1), Operand: v}
+}
+
+func TestEstimateGas(t *testing.T) {
+	code := []Instruction{
+		pushOp(0x0101), pushOp(3), {Op: OP_EXP}, // 3 ** 0x0101 : deux octets non nuls
+		{Op: OP_CALLER}, pushOp(2), {Op: OP_EXP}, // exposant inconnu
+		pushOp(7), pushOp(0x100), {Op: OP_MSTORE}, // [0x100, 0x108) : 9 mots
+		pushOp(7), pushOp(0x108), {Op: OP_MSTORE}, // [0x108, 0x110) : toujours 9 mots
+		pushOp(1), pushOp(2), {Op: OP_SSTORE},
+		{Op: OP_CALLER}, pushOp(0), {Op: OP_HASH}, // taille inconnue
+	}
+	want := []string{
+		"3", "3", "108",
+		"2", "3", "8..408",
+		"3", "3", "30",
+		"3", "3", "3",
+		"3", "3", "100..22100",
+		"2", "2", "30+",
+	}
+	est := EstimateGas(code, nil)
+	for i, c := range est.Instrs {
+		if c.String() != want[i] {
+			t.Errorf("%04d %s: gas=%s, want %s", i, code[i], c, want[i])
+		}
+	}
+	if got := est.Total.String(); got != "312+" {
+		t.Errorf("total = %s, want 312+", got)
+	}
+	if est.MemHighWater != 0x110 || !est.MemUnbounded {
+		t.Errorf("memory high-water = %d (unbounded %v), want %d (unbounded)", est.MemHighWater, est.MemUnbounded, 0x110)
+	}
+}
+
+func TestEstimateGasFollowsConstants(t *testing.T) {
+	// L'exposant est calculé : 2 + 0xFE = 0x100, un seul octet non nul.
+	code := []Instruction{pushOp(0xFE), pushOp(2), {Op: OP_ADD}, {Op: OP_DUP1}, {Op: OP_EXP}}
+	s := DefaultGasSchedule()
+	s.ExpByte = 10
+	est := EstimateGas(code, s)
+	if got := est.Instrs[4].String(); got != "18" {
+		t.Errorf("EXP gas=%s, want 18", got)
+	}
+}
+
+// TestEstimateGasAtJumpDest vérifie qu'après un JUMPDEST l'extension de la
+// mémoire est bornée par la mémoire que touche le code entier, et non
+// inconnue.
+func TestEstimateGasAtJumpDest(t *testing.T) {
+	code := []Instruction{
+		pushOp(7), pushOp(0x100), {Op: OP_MSTORE}, // [0x100, 0x108) : 9 mots, avant tout saut
+		pushOp(1), pushOp(7), {Op: OP_JUMPI},
+		{Op: OP_JUMPDEST},
+		pushOp(7), pushOp(0x40), {Op: OP_MSTORE}, // sous les 9 mots touchés par tous les chemins
+		pushOp(7), pushOp(0x200), {Op: OP_MSTORE}, // [0x200, 0x208) : 17 mots, peut-être déjà étendue
+	}
+	want := []string{
+		"3", "3", "30",
+		"3", "3", "10",
+		"1",
+		"3", "3", "3",
+		"3", "3", "3..27",
+	}
+	est := EstimateGas(code, nil)
+	for i, c := range est.Instrs {
+		if c.String() != want[i] {
+			t.Errorf("%04d %s: gas=%s, want %s", i, code[i], c, want[i])
+		}
+	}
+	if est.MemHighWater != 0x208 || est.MemUnbounded {
+		t.Errorf("memory high-water = %d (unbounded %v), want %d", est.MemHighWater, est.MemUnbounded, 0x208)
+	}
+}
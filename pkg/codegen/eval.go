@@ -0,0 +1,200 @@
+package codegen
+
+import "math/bits"
+
+// fixed18 est l'échelle des opcodes virgule fixe FIXMUL18/FIXDIV18.
+const fixed18 = 1_000_000_000_000_000_000
+
+// Eval calcule le résultat d'un opcode pur avec la sémantique exacte de la VM.
+// Comme sur la pile, args[0] est le premier opérande listé dans OPCODES.md,
+// c'est-à-dire le sommet ; les résultats sont rendus dans le même ordre
+// (ADDCARRY → sum, cout). ok vaut false si l'opcode lit l'état de la VM
+// (mémoire, stockage, contexte) ou si son résultat n'est pas défini.
+func Eval(op Opcode, args ...uint64) (results []uint64, ok bool) {
+	info, known := opcodeInfo[op]
+	if !known || len(args) != info.Args {
+		return nil, false
+	}
+	one := func(v uint64) ([]uint64, bool) { return []uint64{v}, true }
+	bool64 := func(b bool) ([]uint64, bool) {
+		if b {
+			return one(1)
+		}
+		return one(0)
+	}
+
+	switch op {
+	case OP_ADD:
+		return one(args[0] + args[1])
+	case OP_MUL:
+		return one(args[0] * args[1])
+	case OP_SUB:
+		return one(args[0] - args[1])
+	case OP_DIV:
+		if args[1] == 0 {
+			return one(0)
+		}
+		return one(args[0] / args[1])
+	case OP_SDIV:
+		if args[1] == 0 {
+			return one(0)
+		}
+		return one(uint64(int64(args[0]) / int64(args[1])))
+	case OP_MOD:
+		if args[1] == 0 {
+			return one(0)
+		}
+		return one(args[0] % args[1])
+	case OP_SMOD:
+		if args[1] == 0 {
+			return one(0)
+		}
+		return one(uint64(int64(args[0]) % int64(args[1])))
+	case OP_ADDMOD:
+		if args[2] == 0 {
+			return one(0)
+		}
+		lo, carry := bits.Add64(args[0], args[1], 0)
+		return one(bits.Rem64(carry, lo, args[2]))
+	case OP_MULMOD:
+		if args[2] == 0 {
+			return one(0)
+		}
+		hi, lo := bits.Mul64(args[0], args[1])
+		return one(bits.Rem64(hi, lo, args[2]))
+	case OP_EXP:
+		return one(pow64(args[0], args[1]))
+	case OP_SIGNEXTEND:
+		if args[0] >= 7 {
+			return one(args[1])
+		}
+		shift := 64 - 8*(args[0]+1)
+		return one(uint64(int64(args[1]<<shift) >> shift))
+	case OP_MULHI:
+		hi, _ := bits.Mul64(args[0], args[1])
+		return one(hi)
+	case OP_MODEXP:
+		return one(modExp(args[0], args[1], args[2]))
+	case OP_ADDCARRY:
+		sum, c1 := bits.Add64(args[0], args[1], 0)
+		sum, c2 := bits.Add64(sum, args[2], 0)
+		return []uint64{sum, c1 + c2}, true
+	case OP_FIXMUL18:
+		hi, lo := bits.Mul64(args[0], args[1])
+		if hi >= fixed18 {
+			return nil, false
+		}
+		q, _ := bits.Div64(hi, lo, fixed18)
+		return one(q)
+	case OP_FIXDIV18:
+		if args[1] == 0 {
+			return one(0)
+		}
+		hi, lo := bits.Mul64(args[0], fixed18)
+		if hi >= args[1] {
+			return nil, false
+		}
+		q, _ := bits.Div64(hi, lo, args[1])
+		return one(q)
+
+	case OP_LT:
+		return bool64(args[0] < args[1])
+	case OP_GT:
+		return bool64(args[0] > args[1])
+	case OP_SLT:
+		return bool64(int64(args[0]) < int64(args[1]))
+	case OP_SGT:
+		return bool64(int64(args[0]) > int64(args[1]))
+	case OP_EQ:
+		return bool64(args[0] == args[1])
+	case OP_ISZERO:
+		return bool64(args[0] == 0)
+	case OP_AND:
+		return one(args[0] & args[1])
+	case OP_OR:
+		return one(args[0] | args[1])
+	case OP_XOR:
+		return one(args[0] ^ args[1])
+	case OP_NOT:
+		return one(^args[0])
+	case OP_BYTE:
+		if args[0] >= 8 {
+			return one(0)
+		}
+		return one((args[1] >> (56 - 8*args[0])) & 0xFF)
+	case OP_SHL:
+		if args[0] >= 64 {
+			return one(0)
+		}
+		return one(args[1] << args[0])
+	case OP_SHR:
+		if args[0] >= 64 {
+			return one(0)
+		}
+		return one(args[1] >> args[0])
+	case OP_SAR:
+		if args[0] >= 64 {
+			return one(uint64(int64(args[1]) >> 63))
+		}
+		return one(uint64(int64(args[1]) >> args[0]))
+	case OP_CLZ:
+		return one(uint64(bits.LeadingZeros64(args[0])))
+
+	case OP_ROL:
+		return one(bits.RotateLeft64(args[1], int(args[0]&63)))
+	case OP_ROR:
+		return one(bits.RotateLeft64(args[1], -int(args[0]&63)))
+	case OP_POPCNT:
+		return one(uint64(bits.OnesCount64(args[0])))
+	case OP_BSWAP:
+		return one(bits.ReverseBytes64(args[0]))
+
+	case OP_SEXT8:
+		return one(uint64(int64(int8(args[0]))))
+	case OP_SEXT16:
+		return one(uint64(int64(int16(args[0]))))
+	case OP_SEXT32:
+		return one(uint64(int64(int32(args[0]))))
+	case OP_TRUNC8:
+		return one(args[0] & 0xFF)
+	case OP_TRUNC16:
+		return one(args[0] & 0xFFFF)
+	case OP_TRUNC32:
+		return one(args[0] & 0xFFFFFFFF)
+	}
+	return nil, false
+}
+
+// pow64 calcule base^exp modulo 2^64 (EXP).
+func pow64(base, exp uint64) uint64 {
+	result := uint64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// modExp calcule base^exp % mod sans débordement (MODEXP, 0 si mod=0).
+func modExp(base, exp, mod uint64) uint64 {
+	if mod == 0 {
+		return 0
+	}
+	mulmod := func(a, b uint64) uint64 {
+		hi, lo := bits.Mul64(a, b)
+		return bits.Rem64(hi, lo, mod)
+	}
+	result := uint64(1) % mod
+	base %= mod
+	for exp > 0 {
+		if exp&1 == 1 {
+			result = mulmod(result, base)
+		}
+		base = mulmod(base, base)
+		exp >>= 1
+	}
+	return result
+}
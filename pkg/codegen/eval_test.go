@@ -0,0 +1,121 @@
+package codegen
+
+import (
+	"math"
+	"testing"
+)
+
+// neg rend la représentation U64 d'un entier signé.
+func neg(v int64) uint64 { return uint64(v) }
+
+func TestEval(t *testing.T) {
+	const minI64 = uint64(1) << 63
+	tests := []struct {
+		op   Opcode
+		args []uint64 // args[0] au sommet
+		want []uint64 // nil : non évaluable
+	}{
+		{OP_ADD, []uint64{math.MaxUint64, 2}, []uint64{1}},
+		{OP_SUB, []uint64{7, 2}, []uint64{5}},
+		{OP_SUB, []uint64{2, 7}, []uint64{neg(-5)}},
+		{OP_MUL, []uint64{1 << 32, 1 << 32}, []uint64{0}},
+		{OP_DIV, []uint64{7, 2}, []uint64{3}},
+		{OP_DIV, []uint64{7, 0}, []uint64{0}},
+		{OP_DIV, []uint64{neg(-1), 2}, []uint64{math.MaxInt64}},
+		{OP_SDIV, []uint64{neg(-7), 2}, []uint64{neg(-3)}},
+		{OP_SDIV, []uint64{7, 0}, []uint64{0}},
+		{OP_SDIV, []uint64{minI64, neg(-1)}, []uint64{minI64}},
+		{OP_MOD, []uint64{7, 0}, []uint64{0}},
+		{OP_MOD, []uint64{neg(-1), 10}, []uint64{5}},
+		{OP_SMOD, []uint64{neg(-7), 3}, []uint64{neg(-1)}},
+		{OP_SMOD, []uint64{7, neg(-3)}, []uint64{1}},
+		{OP_SMOD, []uint64{minI64, neg(-1)}, []uint64{0}},
+		{OP_SMOD, []uint64{7, 0}, []uint64{0}},
+		{OP_ADDMOD, []uint64{math.MaxUint64, 1, 10}, []uint64{6}}, // 2^64 % 10
+		{OP_ADDMOD, []uint64{3, 4, 0}, []uint64{0}},
+		{OP_MULMOD, []uint64{10, 20, 7}, []uint64{4}},
+		{OP_MULMOD, []uint64{1 << 32, 1 << 32, 1<<64 - 59}, []uint64{59}}, // 2^64 mod (2^64-59)
+		{OP_MULMOD, []uint64{3, 4, 0}, []uint64{0}},
+		{OP_EXP, []uint64{2, 10}, []uint64{1024}},
+		{OP_EXP, []uint64{2, 64}, []uint64{0}},
+		{OP_EXP, []uint64{0, 0}, []uint64{1}},
+		{OP_EXP, []uint64{neg(-1), 3}, []uint64{neg(-1)}},
+		{OP_SIGNEXTEND, []uint64{0, 0x80}, []uint64{neg(-128)}},
+		{OP_SIGNEXTEND, []uint64{0, 0x17F}, []uint64{0x7F}},
+		{OP_SIGNEXTEND, []uint64{1, 0x8000}, []uint64{neg(-32768)}},
+		{OP_SIGNEXTEND, []uint64{7, 0x80}, []uint64{0x80}},
+		{OP_SIGNEXTEND, []uint64{100, 0x80}, []uint64{0x80}},
+		{OP_MULHI, []uint64{1 << 63, 4}, []uint64{2}},
+		{OP_MODEXP, []uint64{3, 4, 5}, []uint64{1}}, // 81 % 5
+		{OP_MODEXP, []uint64{3, 4, 0}, []uint64{0}},
+		{OP_MODEXP, []uint64{5, 0, 1}, []uint64{0}},
+		{OP_ADDCARRY, []uint64{math.MaxUint64, 1, 1}, []uint64{1, 1}},
+		{OP_ADDCARRY, []uint64{2, 3, 0}, []uint64{5, 0}},
+		{OP_FIXMUL18, []uint64{2_000_000_000_000_000_000, 1_500_000_000_000_000_000}, []uint64{3_000_000_000_000_000_000}},
+		{OP_FIXMUL18, []uint64{math.MaxUint64, math.MaxUint64}, nil},
+		{OP_FIXDIV18, []uint64{3, 2}, []uint64{1_500_000_000_000_000_000}},
+		{OP_FIXDIV18, []uint64{3, 0}, []uint64{0}},
+		{OP_FIXDIV18, []uint64{math.MaxUint64, 1}, nil},
+
+		{OP_LT, []uint64{1, 2}, []uint64{1}},
+		{OP_LT, []uint64{neg(-1), 2}, []uint64{0}},
+		{OP_GT, []uint64{neg(-1), 2}, []uint64{1}},
+		{OP_SLT, []uint64{neg(-1), 2}, []uint64{1}},
+		{OP_SGT, []uint64{neg(-1), 2}, []uint64{0}},
+		{OP_EQ, []uint64{5, 5}, []uint64{1}},
+		{OP_ISZERO, []uint64{0}, []uint64{1}},
+		{OP_ISZERO, []uint64{9}, []uint64{0}},
+		{OP_AND, []uint64{0xF0, 0x3C}, []uint64{0x30}},
+		{OP_OR, []uint64{0xF0, 0x0F}, []uint64{0xFF}},
+		{OP_XOR, []uint64{0xFF, 0x0F}, []uint64{0xF0}},
+		{OP_NOT, []uint64{0}, []uint64{math.MaxUint64}},
+		{OP_BYTE, []uint64{0, 0x1122334455667788}, []uint64{0x11}},
+		{OP_BYTE, []uint64{7, 0x1122334455667788}, []uint64{0x88}},
+		{OP_BYTE, []uint64{8, 0x1122334455667788}, []uint64{0}},
+		{OP_BYTE, []uint64{math.MaxUint64, 0x1122334455667788}, []uint64{0}},
+		{OP_SHL, []uint64{4, 1}, []uint64{16}},
+		{OP_SHL, []uint64{63, 3}, []uint64{minI64}},
+		{OP_SHL, []uint64{64, 1}, []uint64{0}},
+		{OP_SHR, []uint64{4, 0x100}, []uint64{0x10}},
+		{OP_SHR, []uint64{1, neg(-2)}, []uint64{math.MaxInt64}},
+		{OP_SHR, []uint64{64, neg(-1)}, []uint64{0}},
+		{OP_SAR, []uint64{1, neg(-8)}, []uint64{neg(-4)}},
+		{OP_SAR, []uint64{1, 8}, []uint64{4}},
+		{OP_SAR, []uint64{64, neg(-8)}, []uint64{neg(-1)}},
+		{OP_SAR, []uint64{200, 8}, []uint64{0}},
+		{OP_CLZ, []uint64{0}, []uint64{64}},
+		{OP_CLZ, []uint64{1}, []uint64{63}},
+		{OP_ROL, []uint64{4, minI64 | 1}, []uint64{0x18}},
+		{OP_ROL, []uint64{68, 1}, []uint64{16}},
+		{OP_ROR, []uint64{1, 1}, []uint64{minI64}},
+		{OP_POPCNT, []uint64{0xFF00FF}, []uint64{16}},
+		{OP_BSWAP, []uint64{0x1122334455667788}, []uint64{0x8877665544332211}},
+		{OP_SEXT8, []uint64{0xFF}, []uint64{neg(-1)}},
+		{OP_SEXT16, []uint64{0x18000}, []uint64{neg(-32768)}},
+		{OP_SEXT32, []uint64{0x7FFFFFFF}, []uint64{0x7FFFFFFF}},
+		{OP_TRUNC8, []uint64{0x1FF}, []uint64{0xFF}},
+		{OP_TRUNC16, []uint64{0x12345}, []uint64{0x2345}},
+		{OP_TRUNC32, []uint64{neg(-1)}, []uint64{0xFFFFFFFF}},
+
+		// Mauvais nombre d'arguments, ou opcode qui lit l'état.
+		{OP_ADD, []uint64{1}, nil},
+		{OP_SLOAD, []uint64{0}, nil},
+	}
+	for _, tt := range tests {
+		got, ok := Eval(tt.op, tt.args...)
+		if ok != (tt.want != nil) {
+			t.Errorf("%s%v: ok = %v, want %v", tt.op, tt.args, ok, tt.want != nil)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s%v = %#x, want %#x", tt.op, tt.args, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s%v = %#x, want %#x", tt.op, tt.args, got, tt.want)
+				break
+			}
+		}
+	}
+}
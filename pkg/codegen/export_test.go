@@ -0,0 +1,8 @@
+package codegen
+
+// Exports pour les tests externes (package codegen_test), qui peuvent
+// importer pkg/ir.
+var (
+	Run      = run
+	Programs = programs
+)
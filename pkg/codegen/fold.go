@@ -0,0 +1,342 @@
+package codegen
+
+import (
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// folder porte l'état de FoldConstants.
+type folder struct {
+	cg      *CodeGen
+	defines map[string]parser.Node     // valeur (repliée) de chaque #define
+	consts  map[string]int64           // variables propagées
+	vars    map[string]*parser.VarDecl // déclaration de chaque variable propagée
+	decls   map[string]int             // nombre de déclarations de chaque nom
+	written map[string]bool            // noms réaffectés (=, op=, ++, --)
+	folded  int
+}
+
+// FoldConstants replie en place les expressions constantes du programme :
+// littéraux, sizeof, #define, opérateurs et builtins purs (MulMod, ModExp,
+// Clz, Popcnt, Bswap...) sont évalués par Eval, avec la sémantique exacte
+// de la VM (calcul modulo 2^64, division par zéro = 0, variantes signées
+// ou non selon l'opcode émis). Une variable déclarée une seule fois avec
+// une valeur constante et jamais réaffectée est propagée à ses lectures.
+// Retourne le nombre d'expressions remplacées par un littéral.
+func (cg *CodeGen) FoldConstants(prog *parser.Program) int {
+	cg.propagated = make(map[*parser.VarDecl]bool)
+	f := &folder{
+		cg:      cg,
+		defines: make(map[string]parser.Node),
+		consts:  make(map[string]int64),
+		vars:    make(map[string]*parser.VarDecl),
+		decls:   make(map[string]int),
+		written: make(map[string]bool),
+	}
+	f.scan(prog)
+	for i, d := range prog.Decls {
+		prog.Decls[i] = f.node(d)
+	}
+	return f.folded
+}
+
+// scan relève les déclarations et les affectations de chaque nom.
+func (f *folder) scan(node parser.Node) {
+	walk(node, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.VarDecl:
+			f.decls[n.Name]++
+		case *parser.FuncDecl:
+			for _, p := range n.Params {
+				f.decls[p.Name]++
+			}
+		case *parser.AssignExpr:
+			f.markWritten(n.Target)
+		case *parser.PostfixExpr:
+			f.markWritten(n.Operand)
+		case *parser.UnaryExpr:
+			if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+				f.markWritten(n.Operand)
+			}
+		}
+	})
+}
+
+func (f *folder) markWritten(target parser.Node) {
+	if id, ok := target.(*parser.Identifier); ok {
+		f.written[id.Name] = true
+	}
+}
+
+// literal retourne le littéral remplaçant l'expression constante orig, à
+// sa place dans le source.
+func (f *folder) literal(orig parser.Node, v uint64) parser.Node {
+	f.folded++
+	lit := &parser.IntLiteral{Value: int64(v)}
+	lit.SetSpan(orig.Span())
+	return lit
+}
+
+// node replie les sous-expressions de node et retourne le nœud à garder.
+func (f *folder) node(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case *parser.DefineDecl:
+		if n.Value != nil {
+			n.Value = f.node(n.Value)
+			f.defines[n.Name] = n.Value
+		}
+	case *parser.VarDecl:
+		if n.Init == nil {
+			break
+		}
+		n.Init = f.node(n.Init)
+		if v, ok := constOf(n.Init); ok && f.decls[n.Name] == 1 && !f.written[n.Name] {
+			f.consts[n.Name] = int64(v)
+			f.vars[n.Name] = n
+		}
+	case *parser.FuncDecl:
+		for i := range n.Params {
+			if n.Params[i].Default != nil {
+				n.Params[i].Default = f.node(n.Params[i].Default)
+			}
+		}
+		if n.Body != nil {
+			f.node(n.Body)
+		}
+	case *parser.Block:
+		for i, s := range n.Stmts {
+			n.Stmts[i] = f.node(s)
+		}
+	case *parser.ExprStmt:
+		n.Expr = f.node(n.Expr)
+	case *parser.ReturnStmt:
+		if n.Value != nil {
+			n.Value = f.node(n.Value)
+		}
+	case *parser.IfStmt:
+		n.Cond = f.node(n.Cond)
+		n.Body = f.node(n.Body)
+		if n.Else != nil {
+			n.Else = f.node(n.Else)
+		}
+	case *parser.WhileStmt:
+		n.Cond = f.node(n.Cond)
+		n.Body = f.node(n.Body)
+	case *parser.ForStmt:
+		for _, p := range []*parser.Node{&n.Init, &n.Cond, &n.Post, &n.Body} {
+			if *p != nil {
+				*p = f.node(*p)
+			}
+		}
+
+	case *parser.Identifier:
+		if v, ok := f.consts[n.Name]; ok {
+			f.cg.propagated[f.vars[n.Name]] = true
+			return f.literal(node, uint64(v))
+		}
+		if value, ok := f.defines[n.Name]; ok && f.decls[n.Name] == 0 {
+			if v, ok := constOf(value); ok {
+				return f.literal(node, v)
+			}
+		}
+	case *parser.SizeofExpr:
+		return f.literal(node, uint64(TypeSizeOf(n.TypeName)))
+	case *parser.FloatLiteral:
+		return f.literal(node, uint64(int64(n.Value)))
+	case *parser.CastExpr:
+		n.Expr = f.node(n.Expr)
+		if v, ok := constOf(n.Expr); ok {
+			return f.literal(node, v)
+		}
+	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			f.lvalue(n.Operand)
+			break
+		}
+		n.Operand = f.node(n.Operand)
+		ops, known := unaryOps[n.Op]
+		if v, ok := constOf(n.Operand); ok && known {
+			if r, ok := evalOps(ops, []uint64{v}); ok {
+				return f.literal(node, r)
+			}
+		}
+	case *parser.BinaryExpr:
+		n.Left, n.Right = f.node(n.Left), f.node(n.Right)
+		bin, known := binaryOps[n.Op]
+		l, lok := constOf(n.Left)
+		r, rok := constOf(n.Right)
+		if !known || !lok || !rok {
+			break
+		}
+		stack := []uint64{l, r} // sommet = dernier élément
+		if bin.leftOnTop {
+			stack = []uint64{r, l}
+		}
+		if v, ok := evalOps(bin.ops, stack); ok {
+			return f.literal(node, v)
+		}
+	case *parser.CallExpr:
+		args := make([]uint64, len(n.Args))
+		allConst := true
+		for i := range n.Args {
+			n.Args[i] = f.node(n.Args[i])
+			v, ok := constOf(n.Args[i])
+			args[i], allConst = v, allConst && ok
+		}
+		info, builtin := f.cg.builtins[n.Func]
+		if !builtin || !allConst || len(args) != info.argCount {
+			break
+		}
+		// Le premier argument est au sommet, comme args[0] pour Eval.
+		if res, ok := Eval(info.op, args...); ok && len(res) > 0 {
+			return f.literal(node, res[0])
+		}
+	case *parser.AssignExpr:
+		f.lvalue(n.Target)
+		n.Value = f.node(n.Value)
+	case *parser.PostfixExpr:
+		f.lvalue(n.Operand)
+	case *parser.IndexExpr:
+		n.Array = f.node(n.Array)
+		n.Index = f.node(n.Index)
+	case *parser.MemberExpr:
+		n.Object = f.node(n.Object)
+	case *parser.InlineExpr:
+		for i, s := range n.Stmts {
+			n.Stmts[i] = f.node(s)
+		}
+		if n.Value != nil {
+			n.Value = f.node(n.Value)
+		}
+	}
+	return node
+}
+
+// lvalue replie les sous-expressions d'une cible d'affectation sans
+// remplacer la variable affectée elle-même.
+func (f *folder) lvalue(target parser.Node) {
+	if ix, ok := target.(*parser.IndexExpr); ok {
+		ix.Array = f.node(ix.Array)
+		ix.Index = f.node(ix.Index)
+	}
+}
+
+// constOf retourne la valeur d'un littéral entier.
+func constOf(node parser.Node) (uint64, bool) {
+	if lit, ok := node.(*parser.IntLiteral); ok {
+		return uint64(lit.Value), true
+	}
+	return 0, false
+}
+
+// evalOps exécute une séquence d'opcodes purs (et PUSH0, SWAP1) sur une pile
+// de constantes dont le sommet est le dernier élément, et retourne l'unique
+// valeur restante.
+func evalOps(ops []Opcode, stack []uint64) (uint64, bool) {
+	stack = append([]uint64(nil), stack...)
+	for _, op := range ops {
+		switch op {
+		case OP_PUSH0:
+			stack = append(stack, 0)
+			continue
+		case OP_SWAP1:
+			if len(stack) < 2 {
+				return 0, false
+			}
+			top := len(stack) - 1
+			stack[top], stack[top-1] = stack[top-1], stack[top]
+			continue
+		}
+		info, ok := opcodeInfo[op]
+		if !ok || len(stack) < info.Args {
+			return 0, false
+		}
+		args := make([]uint64, info.Args)
+		for i := range args {
+			args[i] = stack[len(stack)-1-i]
+		}
+		res, ok := Eval(op, args...)
+		if !ok {
+			return 0, false
+		}
+		stack = stack[:len(stack)-info.Args]
+		for i := len(res) - 1; i >= 0; i-- {
+			stack = append(stack, res[i])
+		}
+	}
+	if len(stack) != 1 {
+		return 0, false
+	}
+	return stack[0], true
+}
+
+// walk appelle visit sur node et sur chacun de ses descendants.
+func walk(node parser.Node, visit func(parser.Node)) {
+	if node == nil {
+		return
+	}
+	visit(node)
+	switch n := node.(type) {
+	case *parser.Program:
+		for _, d := range n.Decls {
+			walk(d, visit)
+		}
+	case *parser.DefineDecl:
+		walk(n.Value, visit)
+	case *parser.VarDecl:
+		walk(n.Init, visit)
+	case *parser.FuncDecl:
+		for _, p := range n.Params {
+			walk(p.Default, visit)
+		}
+		if n.Body != nil {
+			walk(n.Body, visit)
+		}
+	case *parser.Block:
+		for _, s := range n.Stmts {
+			walk(s, visit)
+		}
+	case *parser.ExprStmt:
+		walk(n.Expr, visit)
+	case *parser.ReturnStmt:
+		walk(n.Value, visit)
+	case *parser.IfStmt:
+		walk(n.Cond, visit)
+		walk(n.Body, visit)
+		walk(n.Else, visit)
+	case *parser.WhileStmt:
+		walk(n.Cond, visit)
+		walk(n.Body, visit)
+	case *parser.ForStmt:
+		walk(n.Init, visit)
+		walk(n.Cond, visit)
+		walk(n.Post, visit)
+		walk(n.Body, visit)
+	case *parser.BinaryExpr:
+		walk(n.Left, visit)
+		walk(n.Right, visit)
+	case *parser.UnaryExpr:
+		walk(n.Operand, visit)
+	case *parser.CallExpr:
+		for _, a := range n.Args {
+			walk(a, visit)
+		}
+	case *parser.IndexExpr:
+		walk(n.Array, visit)
+		walk(n.Index, visit)
+	case *parser.MemberExpr:
+		walk(n.Object, visit)
+	case *parser.AssignExpr:
+		walk(n.Target, visit)
+		walk(n.Value, visit)
+	case *parser.PostfixExpr:
+		walk(n.Operand, visit)
+	case *parser.CastExpr:
+		walk(n.Expr, visit)
+	case *parser.InlineExpr:
+		for _, s := range n.Stmts {
+			walk(s, visit)
+		}
+		walk(n.Value, visit)
+	}
+}
@@ -0,0 +1,221 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// folded replie src et retourne le programme.
+func folded(t *testing.T, src string) *parser.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	NewCodeGen().FoldConstants(prog)
+	return prog
+}
+
+// initOf retourne la valeur initiale repliée de la variable name, ou false
+// si elle n'est pas un littéral.
+func initOf(prog *parser.Program, name string) (int64, bool) {
+	for _, d := range prog.Decls {
+		if v, ok := d.(*parser.VarDecl); ok && v.Name == name {
+			lit, ok := v.Init.(*parser.IntLiteral)
+			if !ok {
+				return 0, false
+			}
+			return lit.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestFoldConstants(t *testing.T) {
+	const minI64 = -1 << 63
+	tests := []struct {
+		expr string
+		want int64
+	}{
+		{"7 - 2", 5},
+		{"2 - 7", -5},
+		{"-7 / 2", -3},
+		{"-7 % 3", -1},
+		{"7 / 0", 0},
+		{"7 % 0", 0},
+		{"(1 << 63) / -1", minI64},
+		{"(1 << 63) % -1", 0},
+		{"1 << 64", 0},
+		{"-8 >> 1", 0x7FFFFFFFFFFFFFFC},
+		{"2 ` 10", 1024},
+		{"2 ` 64", 0},
+		{"-1 < 2", 1},
+		{"3 >= 3", 1},
+		{"3 != 3", 0},
+		{"!5", 0},
+		{"~0", -1},
+		{"-(3)", -3},
+		{"2 && 0", 0},
+		{"0 || 9", 1},
+		{"sizeof(I64) + sizeof(U8)", 9},
+		{"MulMod(10, 20, 7)", 4},
+		{"Div(-1, 2)", 0x7FFFFFFFFFFFFFFF},
+		{"Clz(1) + Popcnt(0xFF)", 71},
+	}
+	for _, tt := range tests {
+		prog := folded(t, "I64 r = "+tt.expr+";")
+		if got, ok := initOf(prog, "r"); !ok || got != tt.want {
+			t.Errorf("%s: folded to %d (literal %v), want %d", tt.expr, got, ok, tt.want)
+		}
+	}
+}
+
+func TestFoldPropagatesDefinesAndConstants(t *testing.T) {
+	prog := folded(t, `#define N 4
+#define M (N * 3)
+I64 a = 7;
+I64 b = a * 2 + M;
+I64 c = 1;
+c = 2;
+I64 d = c + 1;
+`)
+	if got, ok := initOf(prog, "b"); !ok || got != 26 {
+		t.Errorf("b = %d (literal %v), want 26", got, ok)
+	}
+	if _, ok := initOf(prog, "d"); ok {
+		t.Error("d folded although c is reassigned")
+	}
+}
+
+func TestFoldPreservesBehavior(t *testing.T) {
+	src := `#define K 5
+I64 x = SLoad(0) + K * 2;
+I64 k = 3;
+for (I64 i = 0; i < k + 1; i++)
+  x = x * (k - 1) + i % 2;
+SStore(1, x);
+SStore(2, (1 << 40) / -7);
+`
+	_, code := generate(t, src)
+	want := run(code).String()
+	cg := NewCodeGen()
+	prog := folded(t, src)
+	if got := run(cg.Generate(prog)).String(); got != want {
+		t.Errorf("folded: %s, unfolded: %s", got, want)
+	}
+}
+
+func TestFoldKeepsSpans(t *testing.T) {
+	prog := folded(t, "I64 x = 3 * (4 + 1);\n")
+	lit, ok := prog.Decls[0].(*parser.VarDecl).Init.(*parser.IntLiteral)
+	if !ok || lit.Value != 15 {
+		t.Fatalf("init = %#v, want 15", prog.Decls[0].(*parser.VarDecl).Init)
+	}
+	if s := lit.Span(); s.Start.Line != 1 || s.Start.Col != 9 || s.End.Col != 20 {
+		t.Errorf("folded literal at %v, want 1:9-1:20", s)
+	}
+}
+
+// foldValues sont les opérandes des tests de repliement : bornes, signes,
+// décalages hors limites.
+var foldValues = []int64{0, 1, -1, 2, 7, -8, 63, 64, 255, 1 << 40, -(1 << 62), 9223372036854775807}
+
+// foldsLikeVM vérifie que chaque valeur stockée par src est repliée en
+// littéral, et que le programme replié stocke les mêmes valeurs que le
+// programme exécuté tel quel par la VM.
+func foldsLikeVM(t *testing.T, src string) {
+	t.Helper()
+	_, code := generate(t, src)
+	want := run(code).String()
+	prog := folded(t, src)
+	walk(prog, func(n parser.Node) {
+		if call, ok := n.(*parser.CallExpr); ok && call.Func == "SStore" {
+			if _, ok := call.Args[1].(*parser.IntLiteral); !ok {
+				t.Errorf("SStore at %v not folded", call.Span())
+			}
+		}
+	})
+	if got := run(NewCodeGen().Generate(prog)).String(); got != want {
+		t.Errorf("folded %s, executed %s:\n%s", got, want, src)
+	}
+}
+
+// TestFoldAgreesWithVM compare, pour chaque opérateur, le repliement à
+// l'exécution sur des opérandes aux limites.
+func TestFoldAgreesWithVM(t *testing.T) {
+	binary := []string{"+", "-", "*", "/", "%", "`", "&", "|", "^", "<<", ">>", "<", ">", "==", "!=", "<=", ">=", "&&", "||"}
+	for _, op := range binary {
+		t.Run(op, func(t *testing.T) {
+			var b strings.Builder
+			key := 0
+			for _, x := range foldValues {
+				for _, y := range foldValues {
+					fmt.Fprintf(&b, "I64 a%d = %d;\nI64 b%d = %d;\nSStore(%d, a%d %s b%d);\n", key, x, key, y, key, key, op, key)
+					key++
+				}
+			}
+			foldsLikeVM(t, b.String())
+		})
+	}
+	for _, op := range []string{"-", "~", "!"} {
+		t.Run("unary"+op, func(t *testing.T) {
+			var b strings.Builder
+			for key, x := range foldValues {
+				fmt.Fprintf(&b, "I64 a%d = %d;\nSStore(%d, %sa%d);\n", key, x, key, op, key)
+			}
+			foldsLikeVM(t, b.String())
+		})
+	}
+}
+
+// TestFoldAgreesWithVMOnBuiltins fait de même pour chaque builtin pur qui
+// produit une valeur, sur les opérandes dont Eval définit le résultat (un
+// dépassement de FixMul18 n'est pas replié).
+func TestFoldAgreesWithVMOnBuiltins(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		op, n, _ := Builtin(name)
+		if _, results := op.StackEffect(); op.Purity() != Pure || results != 1 || n == 0 {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			var b strings.Builder
+			operands := foldValues
+			if n == 3 {
+				operands = []int64{0, 1, -1, 7, 64, -(1 << 62)}
+			}
+			args := make([]int64, n)
+			key := 0
+			var each func(i int)
+			each = func(i int) {
+				if i == n {
+					uargs := make([]uint64, n)
+					for j, v := range args {
+						uargs[j] = uint64(v)
+					}
+					if _, ok := Eval(op, uargs...); !ok {
+						return
+					}
+					list := make([]string, n)
+					for j, v := range args {
+						fmt.Fprintf(&b, "I64 a%d_%d = %d;\n", key, j, v)
+						list[j] = fmt.Sprintf("a%d_%d", key, j)
+					}
+					fmt.Fprintf(&b, "SStore(%d, %s(%s));\n", key, name, strings.Join(list, ", "))
+					key++
+					return
+				}
+				for _, v := range operands {
+					args[i] = v
+					each(i + 1)
+				}
+			}
+			each(0)
+			foldsLikeVM(t, b.String())
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// GasSchedule fixe le coût statique de chaque opcode et les paramètres des
+// règles dynamiques (« gas† » dans OPCODES.md). Les réseaux (testnet,
+// mainnet...) ne facturent pas tous BALANCE, SLOAD ou EXTCODE* au même prix :
+// un barème peut être chargé depuis un fichier et passé à l'affichage asm,
+// à l'estimateur de gas ou à la VM.
+type GasSchedule struct {
+	Name string
+	gas  map[Opcode]int
+
+	ExpByte   int // EXP : par octet non nul de l'exposant
+	HashWord  int // HASH : par mot de 32 octets haché
+	CopyWord  int // *COPY : par mot de 32 octets copié
+	MemWord   int // extension mémoire : par mot de 32 octets alloué
+	SStoreMin int // SSTORE : slot chaud, valeur inchangée
+	SStoreMax int // SSTORE : slot froid, zéro → non-zéro
+}
+
+// gasScheduleFile est le format JSON d'un barème :
+//
+//	{"name": "testnet", "gas": {"BALANCE": 100, "SLOAD": 200}, "dynamic": {"exp_byte": 10}}
+//
+// Les opcodes et paramètres absents gardent leur valeur par défaut.
+type gasScheduleFile struct {
+	Name    string         `json:"name"`
+	Gas     map[string]int `json:"gas"`
+	Dynamic struct {
+		ExpByte   *int `json:"exp_byte,omitempty"`
+		HashWord  *int `json:"hash_word,omitempty"`
+		CopyWord  *int `json:"copy_word,omitempty"`
+		MemWord   *int `json:"mem_word,omitempty"`
+		SStoreMin *int `json:"sstore_min,omitempty"`
+		SStoreMax *int `json:"sstore_max,omitempty"`
+	} `json:"dynamic"`
+}
+
+// DefaultGasSchedule retourne le barème de référence décrit dans OPCODES.md.
+func DefaultGasSchedule() *GasSchedule {
+	s := &GasSchedule{
+		Name:      "default",
+		gas:       make(map[Opcode]int, len(opcodeInfo)),
+		ExpByte:   50,
+		HashWord:  6,
+		CopyWord:  3,
+		MemWord:   3,
+		SStoreMin: 100,
+		SStoreMax: 22100,
+	}
+	for op, info := range opcodeInfo {
+		s.gas[op] = info.Gas
+	}
+	return s
+}
+
+// LoadGasSchedule lit un barème JSON et l'applique par-dessus le barème par défaut.
+func LoadGasSchedule(path string) (*GasSchedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGasSchedule(data)
+}
+
+// ParseGasSchedule décode un barème JSON (voir gasScheduleFile).
+func ParseGasSchedule(data []byte) (*GasSchedule, error) {
+	var f gasScheduleFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gas schedule: %v", err)
+	}
+	s := DefaultGasSchedule()
+	if f.Name != "" {
+		s.Name = f.Name
+	}
+	for name, gas := range f.Gas {
+		op, ok := OpcodeByName(name)
+		if !ok {
+			return nil, fmt.Errorf("gas schedule %s: unknown opcode %q", s.Name, name)
+		}
+		if gas < 0 {
+			return nil, fmt.Errorf("gas schedule %s: negative gas for %s", s.Name, name)
+		}
+		s.gas[op] = gas
+	}
+	dyn := []struct {
+		name string
+		src  *int
+		dst  *int
+	}{
+		{"exp_byte", f.Dynamic.ExpByte, &s.ExpByte},
+		{"hash_word", f.Dynamic.HashWord, &s.HashWord},
+		{"copy_word", f.Dynamic.CopyWord, &s.CopyWord},
+		{"mem_word", f.Dynamic.MemWord, &s.MemWord},
+		{"sstore_min", f.Dynamic.SStoreMin, &s.SStoreMin},
+		{"sstore_max", f.Dynamic.SStoreMax, &s.SStoreMax},
+	}
+	for _, d := range dyn {
+		if d.src == nil {
+			continue
+		}
+		if *d.src < 0 {
+			return nil, fmt.Errorf("gas schedule %s: negative %s", s.Name, d.name)
+		}
+		*d.dst = *d.src
+	}
+	if s.SStoreMin > s.SStoreMax {
+		return nil, fmt.Errorf("gas schedule %s: sstore_min > sstore_max", s.Name)
+	}
+	return s, nil
+}
+
+// Cost retourne le coût statique d'un opcode dans ce barème.
+func (s *GasSchedule) Cost(op Opcode) int {
+	if s == nil {
+		s = defaultSchedule
+	}
+	return s.gas[op]
+}
+
+// Set modifie le coût statique d'un opcode.
+func (s *GasSchedule) Set(op Opcode, gas int) {
+	s.gas[op] = gas
+}
+
+// MarshalJSON sérialise le barème au format de LoadGasSchedule.
+func (s *GasSchedule) MarshalJSON() ([]byte, error) {
+	f := gasScheduleFile{Name: s.Name, Gas: make(map[string]int, len(s.gas))}
+	for op, gas := range s.gas {
+		f.Gas[op.String()] = gas
+	}
+	f.Dynamic.ExpByte = &s.ExpByte
+	f.Dynamic.HashWord = &s.HashWord
+	f.Dynamic.CopyWord = &s.CopyWord
+	f.Dynamic.MemWord = &s.MemWord
+	f.Dynamic.SStoreMin = &s.SStoreMin
+	f.Dynamic.SStoreMax = &s.SStoreMax
+	return json.Marshal(f)
+}
+
+var defaultSchedule = DefaultGasSchedule()
@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseGasSchedule(t *testing.T) {
+	s, err := ParseGasSchedule([]byte(`{"name": "testnet", "gas": {"SLOAD": 200, "BALANCE": 0}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "testnet" {
+		t.Errorf("name = %q, want testnet", s.Name)
+	}
+	if got := s.Cost(OP_SLOAD); got != 200 {
+		t.Errorf("SLOAD = %d, want 200", got)
+	}
+	if got := s.Cost(OP_BALANCE); got != 0 {
+		t.Errorf("BALANCE = %d, want 0", got)
+	}
+	// Les opcodes absents gardent leur coût par défaut.
+	if got, want := s.Cost(OP_ADD), opcodeInfo[OP_ADD].Gas; got != want {
+		t.Errorf("ADD = %d, want %d", got, want)
+	}
+	if got, want := DefaultGasSchedule().Cost(OP_SLOAD), opcodeInfo[OP_SLOAD].Gas; got != want {
+		t.Errorf("default schedule changed: SLOAD = %d, want %d", got, want)
+	}
+}
+
+func TestParseGasScheduleErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"gas": {"SLOADX": 1}}`, `unknown opcode "SLOADX"`},
+		{`{"gas": {"SLOAD": -1}}`, "negative gas for SLOAD"},
+		{`{"gas": [1]}`, "gas schedule:"},
+	}
+	for _, tt := range tests {
+		_, err := ParseGasSchedule([]byte(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestGasScheduleRoundTrip(t *testing.T) {
+	s := DefaultGasSchedule()
+	s.Name = "custom"
+	s.Set(OP_EXP, 77)
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := ParseGasSchedule(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Name != "custom" || back.Cost(OP_EXP) != 77 {
+		t.Errorf("round trip: name %q, EXP %d", back.Name, back.Cost(OP_EXP))
+	}
+	if got := (Instruction{Op: OP_EXP}).GasIn(back); got != 77 {
+		t.Errorf("GasIn = %d, want 77", got)
+	}
+}
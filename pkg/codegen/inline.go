@@ -0,0 +1,895 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// InlineDecision résume l'inlining des appels d'une fonction.
+type InlineDecision struct {
+	Func    string
+	Inlined int    // appels remplacés par le corps
+	Reason  string // pourquoi des appels restent (vide : aucun ne reste)
+}
+
+// maxInlineGrowth borne, sous le modèle gas, la croissance du bytecode
+// (octets) qu'un inlining peut coûter.
+const maxInlineGrowth = 128
+
+// callSequence est la séquence qu'un appel non inliné coûterait : PUSH2
+// adresse de retour, PUSH2 fonction, JUMP, JUMPDEST de retour côté
+// appelant ; JUMPDEST d'entrée, SWAP1 de l'adresse de retour et JUMP de
+// retour côté appelé.
+var callSequence = []Instruction{
+	{Op: OP_PUSH2}, {Op: OP_PUSH2}, {Op: OP_JUMP}, {Op: OP_JUMPDEST},
+	{Op: OP_JUMPDEST}, {Op: OP_SWAP1}, {Op: OP_JUMP},
+}
+
+// inliner porte l'état de InlineFunctions.
+type inliner struct {
+	cg        *CodeGen
+	funcs     map[string]*parser.FuncDecl
+	calls     map[string]int    // appels de chaque fonction dans le source
+	verdicts  map[string]string // "" : inlinable ; sinon la raison du refus
+	reasons   map[string]string // raison du dernier appel laissé en place
+	scopes    []map[string]bool // noms déclarés au point courant ; scopes[0] : globales
+	expanding map[string]bool   // fonctions en cours d'expansion
+	sites     int               // numéro du dernier appel inliné (noms frais)
+	// hoistable associe à chaque appel qui peut être avancé devant son
+	// instruction les sous-expressions évaluées avec lui (voir markHoistable) ;
+	// hoisted reçoit les déclarations à placer devant l'instruction en cours.
+	hoistable map[*parser.CallExpr][]parser.Node
+	hoisted   []parser.Node
+}
+
+// InlineFunctions remplace en place les appels de fonctions utilisateur par
+// leur corps (parser.InlineExpr) : les paramètres deviennent des variables
+// initialisées par les arguments, évalués de gauche à droite, et toutes les
+// variables du corps sont renommées (Fonction.nom.N) pour ne capturer aucun
+// nom de l'appelant. Un return en fin de corps donne la valeur de l'appel ;
+// les autres sont réécrits en affectations d'une variable de résultat (voir
+// tailReturns). Une fonction est inlinée si elle porte [[inline]], ou si le
+// modèle de coût (Passes.Cost) préfère son corps ainsi réécrit, à chaque
+// appel, au corps d'origine appelé par la séquence d'appel (callSequence),
+// même pour un seul appel ; jamais si elle porte [[noinline]], est
+// récursive, a un return dans une boucle, ou lit une globale qu'une
+// variable de l'appelant masque. Un corps contenant des branchements
+// s'inline en position d'instruction (F(x); x = F(x); I64 y = F(x);
+// return F(x);) ; ailleurs, il est avancé dans une variable déclarée devant
+// l'instruction si rien de ce qui est évalué avec lui ne peut le voir
+// (voir unhoistable), et jamais depuis une condition de boucle ou
+// l'opérande droit de && ou ||. Un appel d'une fonction [[inline]] laissé
+// en place est signalé par un avertissement not-inlined, avec la raison.
+// Les déclarations des fonctions restent en place.
+func (cg *CodeGen) InlineFunctions(prog *parser.Program) []InlineDecision {
+	in := &inliner{
+		cg:        cg,
+		funcs:     make(map[string]*parser.FuncDecl),
+		calls:     make(map[string]int),
+		verdicts:  make(map[string]string),
+		reasons:   make(map[string]string),
+		scopes:    []map[string]bool{{}},
+		expanding: make(map[string]bool),
+		hoistable: make(map[*parser.CallExpr][]parser.Node),
+	}
+	var order []string
+	for _, d := range prog.Decls {
+		if fn, ok := d.(*parser.FuncDecl); ok {
+			if _, dup := in.funcs[fn.Name]; !dup {
+				order = append(order, fn.Name)
+			}
+			in.funcs[fn.Name] = fn
+		}
+	}
+	walk(prog, func(n parser.Node) {
+		if call, ok := n.(*parser.CallExpr); ok {
+			in.calls[call.Func]++
+		}
+	})
+	for _, name := range order {
+		in.verdicts[name] = in.verdict(in.funcs[name])
+	}
+
+	prog.Decls = in.stmts(prog.Decls)
+	cg.inlinedBranches = inlinedBranches(prog)
+
+	inlined := make(map[string]int)
+	walk(prog, func(n parser.Node) {
+		if e, ok := n.(*parser.InlineExpr); ok {
+			inlined[e.Func]++
+		}
+	})
+	var decisions []InlineDecision
+	for _, name := range order {
+		if in.calls[name] == 0 {
+			continue
+		}
+		decisions = append(decisions, InlineDecision{Func: name, Inlined: inlined[name], Reason: in.reasons[name]})
+	}
+	return decisions
+}
+
+// verdict décide si fn peut être inlinée, indépendamment des appels.
+func (in *inliner) verdict(fn *parser.FuncDecl) string {
+	switch {
+	case fn.Body == nil:
+		return "no body"
+	case fn.Inline == parser.InlineNever:
+		return "noinline"
+	}
+	for _, p := range fn.Params {
+		if p.Name == "" {
+			return "unnamed parameter"
+		}
+	}
+	// Le corps tel qu'il serait inliné : renommé, ses return réécrits.
+	r := &renamer{prefix: fn.Name, free: make(map[string]bool)}
+	r.pushScope()
+	for _, p := range fn.Params {
+		r.declare(p.Name)
+	}
+	result := fn.Name + ".0"
+	expanded, value, ok := tailReturns(r.stmts(fn.Body.Stmts), result, result+".done")
+	if !ok {
+		return "return inside a loop"
+	}
+	if in.reaches(fn.Name, fn.Name, map[string]bool{}) {
+		return "recursive"
+	}
+	if fn.Inline == parser.InlineAlways {
+		return ""
+	}
+	if value != nil {
+		expanded = append(expanded, &parser.ExprStmt{Expr: value})
+	}
+
+	body := in.cg.costOf(expanded...)
+	call := in.cg.cost(callSequence)
+	own := in.cg.costOf(fn)
+
+	n := in.calls[fn.Name]
+	inlined := seqCost{gas: body.gas, size: n * body.size}
+	called := seqCost{gas: own.gas + call.gas, size: own.size + n*call.size}
+	if in.cg.less(called, inlined) ||
+		in.cg.Passes.Cost == CostGas && inlined.size-called.size > maxInlineGrowth {
+		return "too large"
+	}
+	return ""
+}
+
+// reaches indique si le corps de from appelle to, directement ou non.
+func (in *inliner) reaches(from, to string, seen map[string]bool) bool {
+	fn, ok := in.funcs[from]
+	if !ok || seen[from] || fn.Body == nil {
+		return false
+	}
+	seen[from] = true
+	found := false
+	walk(fn.Body, func(n parser.Node) {
+		if call, ok := n.(*parser.CallExpr); ok && !found {
+			found = call.Func == to || in.reaches(call.Func, to, seen)
+		}
+	})
+	return found
+}
+
+// ---- Parcours ----
+
+func (in *inliner) pushScope() { in.scopes = append(in.scopes, map[string]bool{}) }
+func (in *inliner) popScope()  { in.scopes = in.scopes[:len(in.scopes)-1] }
+
+func (in *inliner) declare(name string) { in.scopes[len(in.scopes)-1][name] = true }
+
+// shadowed indique si name est déclaré hors de la portée globale.
+func (in *inliner) shadowed(name string) bool {
+	for _, scope := range in.scopes[1:] {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// declared indique si name est une variable déclarée au point courant.
+func (in *inliner) declared(name string) bool {
+	return in.scopes[0][name] || in.shadowed(name)
+}
+
+// stmts traite une liste d'instructions et retourne la liste à garder,
+// les déclarations avancées (hoisted) devant leur instruction.
+func (in *inliner) stmts(stmts []parser.Node) []parser.Node {
+	out := make([]parser.Node, 0, len(stmts))
+	for _, s := range stmts {
+		out = append(out, in.lowered(s)...)
+	}
+	return out
+}
+
+// lowered traite une instruction et retourne les déclarations avancées
+// devant elle, suivies de l'instruction.
+func (in *inliner) lowered(node parser.Node) []parser.Node {
+	saved := in.hoisted
+	in.hoisted = nil
+	s := in.stmt(node)
+	out := append(in.hoisted, s)
+	in.hoisted = saved
+	return out
+}
+
+// body traite le corps d'un if ou d'une boucle, mis dans un bloc s'il
+// reçoit des déclarations avancées.
+func (in *inliner) body(node parser.Node) parser.Node {
+	stmts := in.lowered(node)
+	if len(stmts) == 1 {
+		return stmts[0]
+	}
+	return situate(&parser.Block{Stmts: stmts}, node.Span())
+}
+
+// stmt traite une instruction ; un appel qui en forme la valeur entière est
+// en position d'instruction.
+func (in *inliner) stmt(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case *parser.ExprStmt:
+		in.markHoistable(n.Expr, nil)
+		if a, ok := n.Expr.(*parser.AssignExpr); ok && a.Op == lexer.TOK_ASSIGN {
+			if _, ok := a.Target.(*parser.Identifier); ok {
+				a.Value = in.value(a.Value)
+				return n
+			}
+		}
+		n.Expr = in.value(n.Expr)
+	case *parser.VarDecl:
+		if n.Init != nil {
+			in.markHoistable(n.Init, nil)
+			n.Init = in.value(n.Init)
+		}
+		in.declare(n.Name)
+	case *parser.ReturnStmt:
+		if n.Value != nil {
+			in.markHoistable(n.Value, nil)
+			n.Value = in.value(n.Value)
+		}
+	case *parser.FuncDecl:
+		in.pushScope()
+		for _, p := range n.Params {
+			in.declare(p.Name)
+		}
+		if n.Body != nil {
+			n.Body.Stmts = in.stmts(n.Body.Stmts)
+		}
+		in.popScope()
+	case *parser.Block:
+		in.pushScope()
+		n.Stmts = in.stmts(n.Stmts)
+		in.popScope()
+	case *parser.IfStmt:
+		in.markHoistable(n.Cond, nil)
+		n.Cond = in.expr(n.Cond)
+		n.Body = in.body(n.Body)
+		if n.Else != nil {
+			n.Else = in.body(n.Else)
+		}
+	case *parser.WhileStmt:
+		// La condition est réévaluée à chaque tour : rien n'en est avancé.
+		n.Cond = in.expr(n.Cond)
+		n.Body = in.body(n.Body)
+	case *parser.ForStmt:
+		in.pushScope()
+		if n.Init != nil {
+			n.Init = in.stmt(n.Init)
+		}
+		if n.Cond != nil {
+			n.Cond = in.expr(n.Cond)
+		}
+		if n.Post != nil {
+			n.Post = in.expr(n.Post)
+		}
+		n.Body = in.body(n.Body)
+		in.popScope()
+	default:
+		return in.expr(node)
+	}
+	return node
+}
+
+// value traite une expression en position d'instruction.
+func (in *inliner) value(node parser.Node) parser.Node {
+	if call, ok := node.(*parser.CallExpr); ok {
+		return in.call(call, true)
+	}
+	return in.expr(node)
+}
+
+// expr traite les sous-expressions de node et retourne le nœud à garder.
+func (in *inliner) expr(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case *parser.CallExpr:
+		return in.call(n, false)
+	case *parser.BinaryExpr:
+		n.Left, n.Right = in.expr(n.Left), in.expr(n.Right)
+	case *parser.UnaryExpr:
+		n.Operand = in.expr(n.Operand)
+	case *parser.AssignExpr:
+		n.Target, n.Value = in.expr(n.Target), in.expr(n.Value)
+	case *parser.PostfixExpr:
+		n.Operand = in.expr(n.Operand)
+	case *parser.IndexExpr:
+		n.Array, n.Index = in.expr(n.Array), in.expr(n.Index)
+	case *parser.MemberExpr:
+		n.Object = in.expr(n.Object)
+	case *parser.CastExpr:
+		n.Expr = in.expr(n.Expr)
+	}
+	return node
+}
+
+// call remplace un appel par le corps de la fonction appelée si elle est
+// inlinable ; sinon l'appel reste, sa raison notée.
+func (in *inliner) call(n *parser.CallExpr, stmtPos bool) parser.Node {
+	for i := range n.Args {
+		n.Args[i] = in.expr(n.Args[i])
+	}
+	fn, ok := in.funcs[n.Func]
+	if !ok {
+		return n
+	}
+	keep := func(reason string) parser.Node {
+		in.reasons[n.Func] = reason
+		if fn.Inline == parser.InlineAlways {
+			in.cg.warnAt("not-inlined", n.Span(), "call to '%s' not inlined despite [[inline]]: %s", n.Func, reason)
+		}
+		return n
+	}
+	if v := in.verdicts[n.Func]; v != "" {
+		return keep(v)
+	}
+	if in.expanding[n.Func] {
+		return keep("recursive")
+	}
+	if len(n.Args) > len(fn.Params) {
+		return keep("too many arguments")
+	}
+
+	in.sites++
+	r := &renamer{prefix: fn.Name, site: in.sites, free: make(map[string]bool)}
+	r.pushScope()
+	e := &parser.InlineExpr{Func: fn.Name}
+	e.SetSpan(n.Span()) // le corps inliné prend la place de l'appel
+	for i, p := range fn.Params {
+		arg := p.Default
+		if i < len(n.Args) {
+			arg = n.Args[i]
+		} else if arg == nil {
+			return keep("missing argument")
+		} else {
+			arg = r.node(arg)
+		}
+		e.Stmts = append(e.Stmts, situate(&parser.VarDecl{
+			TypeName: p.TypeName, Name: r.declare(p.Name), Init: arg, IsPtr: strings.HasSuffix(p.TypeName, "*"),
+		}, n.Span()))
+	}
+	params := len(e.Stmts)
+	body := r.stmts(fn.Body.Stmts)
+	for name := range r.free {
+		if in.shadowed(name) {
+			return keep(fmt.Sprintf("'%s' is shadowed at the call site", name))
+		}
+	}
+
+	result := fmt.Sprintf("%s.%d", fn.Name, in.sites)
+	stmts, value, _ := tailReturns(body, result, result+".done")
+	if value == nil && usesResult(stmts, result) {
+		e.Stmts = append(e.Stmts, situate(&parser.VarDecl{TypeName: fn.ReturnType, Name: result}, n.Span()))
+		value = situate(&parser.Identifier{Name: result}, n.Span())
+	}
+	e.Stmts = append(e.Stmts, stmts...)
+	e.Value = value
+	if !stmtPos && hasBranches(e) {
+		if why := in.unhoistable(n, e); why != "" {
+			return keep(why)
+		}
+	}
+
+	in.expanding[n.Func] = true
+	in.pushScope()
+	for _, p := range e.Stmts[:params] {
+		in.declare(p.(*parser.VarDecl).Name)
+	}
+	e.Stmts = append(e.Stmts[:params:params], in.stmts(e.Stmts[params:])...)
+	if e.Value != nil {
+		// Ce que la valeur avance se place en fin de corps.
+		saved := in.hoisted
+		in.hoisted = nil
+		in.markHoistable(e.Value, nil)
+		e.Value = in.expr(e.Value)
+		e.Stmts = append(e.Stmts, in.hoisted...)
+		in.hoisted = saved
+	}
+	in.popScope()
+	delete(in.expanding, n.Func)
+	if !stmtPos && hasBranches(e) {
+		if why := in.unhoistable(n, e); why != "" {
+			return keep(why)
+		}
+		// Le corps à branchements est avancé dans une variable devant
+		// l'instruction ; l'appel devient sa lecture.
+		temp := result + ".value"
+		in.hoisted = append(in.hoisted, situate(&parser.VarDecl{
+			TypeName: fn.ReturnType, Name: temp, Init: e, IsPtr: strings.HasSuffix(fn.ReturnType, "*"),
+		}, n.Span()))
+		return situate(&parser.Identifier{Name: temp}, n.Span())
+	}
+	return e
+}
+
+// markHoistable note, pour chaque appel de node qui peut être avancé devant
+// son instruction, les sous-expressions évaluées avec lui : siblings pour
+// node, plus les opérandes voisins de chaque niveau. Un opérand droit de &&
+// ou de || n'est pas toujours évalué : ses appels ne sont pas notés.
+func (in *inliner) markHoistable(node parser.Node, siblings []parser.Node) {
+	with := func(others ...parser.Node) []parser.Node {
+		return append(append([]parser.Node(nil), siblings...), others...)
+	}
+	switch n := node.(type) {
+	case *parser.CallExpr:
+		in.hoistable[n] = siblings
+		for i, a := range n.Args {
+			others := append(append([]parser.Node(nil), n.Args[:i]...), n.Args[i+1:]...)
+			in.markHoistable(a, with(others...))
+		}
+	case *parser.BinaryExpr:
+		in.markHoistable(n.Left, with(n.Right))
+		if n.Op != lexer.TOK_AND_AND && n.Op != lexer.TOK_OR_OR {
+			in.markHoistable(n.Right, with(n.Left))
+		}
+	case *parser.UnaryExpr:
+		in.markHoistable(n.Operand, siblings)
+	case *parser.CastExpr:
+		in.markHoistable(n.Expr, siblings)
+	case *parser.AssignExpr:
+		in.markHoistable(n.Value, with(n.Target))
+	case *parser.IndexExpr:
+		in.markHoistable(n.Array, with(n.Index))
+		in.markHoistable(n.Index, with(n.Array))
+	case *parser.MemberExpr:
+		in.markHoistable(n.Object, siblings)
+	}
+}
+
+// unhoistable retourne pourquoi le corps inliné e de l'appel n ne peut pas
+// être avancé devant son instruction, ou "" : l'appel doit être noté par
+// markHoistable, et rien de ce qui est évalué avec lui ne doit avoir
+// d'effet ni lire ce que e écrit ou la mémoire.
+func (in *inliner) unhoistable(n *parser.CallExpr, e *parser.InlineExpr) string {
+	siblings, ok := in.hoistable[n]
+	if !ok {
+		return "control flow in a loop condition or after && or ||"
+	}
+	if e.Value == nil {
+		return "control flow in an expression"
+	}
+	written := make(map[string]bool)
+	walk(e, func(node parser.Node) {
+		var target parser.Node
+		switch node := node.(type) {
+		case *parser.AssignExpr:
+			target = node.Target
+		case *parser.PostfixExpr:
+			target = node.Operand
+		case *parser.UnaryExpr:
+			// Une variable dont l'adresse est prise peut être écrite.
+			switch node.Op {
+			case lexer.TOK_PLUS_PLUS, lexer.TOK_MINUS_MINUS, lexer.TOK_AMP:
+				target = node.Operand
+			}
+		}
+		if id, ok := target.(*parser.Identifier); ok {
+			written[id.Name] = true
+		}
+	})
+	for _, sib := range siblings {
+		if !in.stable(sib, written) {
+			return "control flow in an expression with other effects"
+		}
+	}
+	return ""
+}
+
+// stable indique si node s'évalue sans effet et sans lire ce que peut
+// changer un corps qui écrit les variables written : littéraux, variables
+// déclarées non écrites, opérateurs et builtins purs.
+func (in *inliner) stable(node parser.Node, written map[string]bool) bool {
+	switch n := node.(type) {
+	case *parser.IntLiteral, *parser.FloatLiteral, *parser.StringLiteral, *parser.SizeofExpr:
+		return true
+	case *parser.Identifier:
+		return !written[n.Name] && in.declared(n.Name)
+	case *parser.BinaryExpr:
+		return in.stable(n.Left, written) && in.stable(n.Right, written)
+	case *parser.UnaryExpr:
+		switch n.Op {
+		case lexer.TOK_STAR, lexer.TOK_PLUS_PLUS, lexer.TOK_MINUS_MINUS:
+			return false
+		}
+		return in.stable(n.Operand, written)
+	case *parser.CastExpr:
+		return in.stable(n.Expr, written)
+	case *parser.CallExpr:
+		op, _, ok := Builtin(n.Func)
+		if !ok || op.Purity() != Pure {
+			return false
+		}
+		for _, a := range n.Args {
+			if !in.stable(a, written) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// inlinedBranches retourne les if et while des corps inlinés de prog, et
+// ceux dont la condition contient un corps inliné.
+func inlinedBranches(prog *parser.Program) map[parser.Node]bool {
+	marked := make(map[parser.Node]bool)
+	branch := func(n parser.Node) {
+		switch n.(type) {
+		case *parser.IfStmt, *parser.WhileStmt:
+			marked[n] = true
+		}
+	}
+	walk(prog, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.InlineExpr:
+			walk(&parser.Block{Stmts: n.Stmts}, branch)
+		case *parser.IfStmt:
+			if containsInline(n.Cond) {
+				marked[n] = true
+			}
+		case *parser.WhileStmt:
+			if containsInline(n.Cond) {
+				marked[n] = true
+			}
+		}
+	})
+	return marked
+}
+
+func containsInline(node parser.Node) bool {
+	found := false
+	walk(node, func(n parser.Node) {
+		if _, ok := n.(*parser.InlineExpr); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// hasBranches indique si node contient un if ou une boucle.
+func hasBranches(node parser.Node) bool {
+	found := false
+	walk(node, func(n parser.Node) {
+		switch n.(type) {
+		case *parser.IfStmt, *parser.WhileStmt, *parser.ForStmt:
+			found = true
+		}
+	})
+	return found
+}
+
+func usesResult(stmts []parser.Node, result string) bool {
+	found := false
+	walk(&parser.Block{Stmts: stmts}, func(n parser.Node) {
+		if id, ok := n.(*parser.Identifier); ok && id.Name == result {
+			found = true
+		}
+	})
+	return found
+}
+
+// tailReturns réécrit les return d'un corps de fonction pour qu'il s'exécute
+// jusqu'au bout : un return final, s'il est le seul, donne la valeur
+// (value) ; sinon les return deviennent result = valeur (voir
+// rewriteReturns), done nommant le drapeau des gardes. ok est faux si un
+// return est dans une boucle.
+func tailReturns(stmts []parser.Node, result, done string) (out []parser.Node, value parser.Node, ok bool) {
+	if len(stmts) > 0 {
+		if ret, isRet := stmts[len(stmts)-1].(*parser.ReturnStmt); isRet && !containsReturn(stmts[:len(stmts)-1]...) {
+			return stmts[:len(stmts)-1], ret.Value, true
+		}
+	}
+	out, ok = rewriteReturns(stmts, result, done)
+	return out, nil, ok
+}
+
+// rewriteReturns réécrit les return de stmts en affectations de result, sans
+// recopier d'instruction : ce qui suit un if dont une branche retourne
+// toujours passe dans l'autre branche, et ce qui suit une instruction qui
+// ne retourne que parfois est gardé par if (!done), done valant 1 après
+// chaque return. La déclaration de done (initialisée à 0) est alors en tête
+// du résultat. ok est faux si un return est dans une boucle. Les nœuds
+// réécrits sont neufs et situés comme ceux qu'ils remplacent.
+func rewriteReturns(stmts []parser.Node, result, done string) (out []parser.Node, ok bool) {
+	r := &returnRewriter{result: result}
+	out, ok = r.list(stmts)
+	if !ok || !r.guarded {
+		return out, ok
+	}
+	r = &returnRewriter{result: result, done: done}
+	out, ok = r.list(stmts)
+	flag := &parser.VarDecl{TypeName: "I64", Name: done, Init: &parser.IntLiteral{Value: 0}}
+	if len(stmts) > 0 {
+		situate(flag, stmts[0].Span())
+	}
+	return append([]parser.Node{flag}, out...), ok
+}
+
+// returnRewriter porte l'état de rewriteReturns.
+type returnRewriter struct {
+	result  string
+	done    string // drapeau des gardes ; "" tant qu'aucune garde n'est émise
+	guarded bool   // une garde a été nécessaire
+}
+
+// list réécrit une liste d'instructions.
+func (r *returnRewriter) list(stmts []parser.Node) (out []parser.Node, ok bool) {
+	for i, s := range stmts {
+		if !containsReturn(s) {
+			out = append(out, s)
+			continue
+		}
+		rest := stmts[i+1:]
+		switch s := s.(type) {
+		case *parser.ReturnStmt:
+			return append(out, r.ret(s)...), true
+		case *parser.Block:
+			// Les noms sont uniques après renommage : le bloc peut s'ouvrir.
+			tail, ok := r.list(append(append([]parser.Node(nil), s.Stmts...), rest...))
+			return append(out, tail...), ok
+		case *parser.IfStmt:
+			body, els := stmtList(s.Body), stmtList(s.Else)
+			switch thenReturns, elseReturns := alwaysReturns(body), alwaysReturns(els); {
+			case thenReturns && elseReturns:
+				rest = nil
+			case thenReturns:
+				els, rest = append(append([]parser.Node(nil), els...), rest...), nil
+			case elseReturns:
+				body, rest = append(append([]parser.Node(nil), body...), rest...), nil
+			}
+			body, ok1 := r.list(body)
+			els, ok2 := r.list(els)
+			out = append(out, situate(&parser.IfStmt{
+				Cond: s.Cond,
+				Body: situate(&parser.Block{Stmts: body}, branchSpan(s.Body, s)),
+				Else: situate(&parser.Block{Stmts: els}, branchSpan(s.Else, s)),
+			}, s.Span()))
+			tail, ok3 := r.guard(rest)
+			return append(out, tail...), ok1 && ok2 && ok3
+		default:
+			return nil, false // return dans une boucle
+		}
+	}
+	return out, true
+}
+
+// ret réécrit un return : result = valeur, puis done = 1 si des gardes
+// testent done.
+func (r *returnRewriter) ret(s *parser.ReturnStmt) []parser.Node {
+	var out []parser.Node
+	if s.Value != nil {
+		out = append(out, r.assign(r.result, s.Value, s.Span()))
+	}
+	if r.done != "" {
+		out = append(out, r.assign(r.done, &parser.IntLiteral{Value: 1}, s.Span()))
+	}
+	return out
+}
+
+func (r *returnRewriter) assign(name string, value parser.Node, span lexer.Span) parser.Node {
+	target := situate(&parser.Identifier{Name: name}, span)
+	if value.Span().IsZero() {
+		situate(value, span)
+	}
+	return situate(&parser.ExprStmt{Expr: situate(&parser.AssignExpr{
+		Op: lexer.TOK_ASSIGN, Target: target, Value: value,
+	}, span)}, span)
+}
+
+// guard réécrit rest, qui ne s'exécute que si rien n'a retourné avant :
+// if (!done) { rest }.
+func (r *returnRewriter) guard(rest []parser.Node) ([]parser.Node, bool) {
+	if len(rest) == 0 {
+		return nil, true
+	}
+	r.guarded = true
+	body, ok := r.list(rest)
+	if r.done == "" {
+		return body, ok // premier passage : seule compte la garde notée
+	}
+	span := rest[0].Span()
+	cond := situate(&parser.UnaryExpr{Op: lexer.TOK_BANG, Operand: situate(&parser.Identifier{Name: r.done}, span)}, span)
+	return []parser.Node{situate(&parser.IfStmt{Cond: cond, Body: situate(&parser.Block{Stmts: body}, span)}, span)}, ok
+}
+
+// alwaysReturns indique si toute exécution de stmts finit par un return.
+func alwaysReturns(stmts []parser.Node) bool {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *parser.ReturnStmt:
+			return true
+		case *parser.Block:
+			if alwaysReturns(s.Stmts) {
+				return true
+			}
+		case *parser.IfStmt:
+			if s.Else != nil && alwaysReturns(stmtList(s.Body)) && alwaysReturns(stmtList(s.Else)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// situate donne à node l'étendue span et retourne node.
+func situate[N parser.Node](node N, span lexer.Span) N {
+	node.SetSpan(span)
+	return node
+}
+
+// branchSpan retourne l'étendue d'une branche de s, celle de s si elle est
+// absente.
+func branchSpan(branch parser.Node, s parser.Node) lexer.Span {
+	if branch == nil {
+		return s.Span()
+	}
+	return branch.Span()
+}
+
+func stmtList(node parser.Node) []parser.Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *parser.Block:
+		return n.Stmts
+	}
+	return []parser.Node{node}
+}
+
+func containsReturn(nodes ...parser.Node) bool {
+	found := false
+	for _, node := range nodes {
+		walk(node, func(n parser.Node) {
+			if _, ok := n.(*parser.ReturnStmt); ok {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+// ---- Renommage ----
+
+// renamer copie un corps de fonction en renommant ses variables
+// (prefix.nom.site) ; free relève les noms qui n'y sont pas déclarés.
+type renamer struct {
+	prefix string
+	site   int
+	scopes []map[string]string
+	free   map[string]bool
+}
+
+func (r *renamer) pushScope() { r.scopes = append(r.scopes, map[string]string{}) }
+func (r *renamer) popScope()  { r.scopes = r.scopes[:len(r.scopes)-1] }
+
+func (r *renamer) declare(name string) string {
+	fresh := fmt.Sprintf("%s.%s.%d", r.prefix, name, r.site)
+	r.scopes[len(r.scopes)-1][name] = fresh
+	return fresh
+}
+
+func (r *renamer) lookup(name string) string {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if fresh, ok := r.scopes[i][name]; ok {
+			return fresh
+		}
+	}
+	r.free[name] = true
+	return name
+}
+
+func (r *renamer) stmts(stmts []parser.Node) []parser.Node {
+	out := make([]parser.Node, len(stmts))
+	for i, s := range stmts {
+		out[i] = r.node(s)
+	}
+	return out
+}
+
+// node retourne une copie de node.
+func (r *renamer) node(node parser.Node) parser.Node {
+	c := r.copy(node)
+	if c != nil {
+		c.SetSpan(node.Span()) // la copie reste située dans le corps d'origine
+	}
+	return c
+}
+
+// copy construit la copie renommée de node, sans son étendue.
+func (r *renamer) copy(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *parser.Identifier:
+		return &parser.Identifier{Name: r.lookup(n.Name)}
+	case *parser.IntLiteral:
+		c := *n
+		return &c
+	case *parser.FloatLiteral:
+		c := *n
+		return &c
+	case *parser.StringLiteral:
+		c := *n
+		return &c
+	case *parser.SizeofExpr:
+		c := *n
+		return &c
+	case *parser.BinaryExpr:
+		return &parser.BinaryExpr{Op: n.Op, Left: r.node(n.Left), Right: r.node(n.Right)}
+	case *parser.UnaryExpr:
+		return &parser.UnaryExpr{Op: n.Op, Operand: r.node(n.Operand)}
+	case *parser.CallExpr:
+		args := make([]parser.Node, len(n.Args))
+		for i, a := range n.Args {
+			args[i] = r.node(a)
+		}
+		return &parser.CallExpr{Func: n.Func, Args: args}
+	case *parser.IndexExpr:
+		return &parser.IndexExpr{Array: r.node(n.Array), Index: r.node(n.Index)}
+	case *parser.MemberExpr:
+		return &parser.MemberExpr{Object: r.node(n.Object), Member: n.Member, Arrow: n.Arrow}
+	case *parser.AssignExpr:
+		return &parser.AssignExpr{Op: n.Op, Target: r.node(n.Target), Value: r.node(n.Value)}
+	case *parser.PostfixExpr:
+		return &parser.PostfixExpr{Op: n.Op, Operand: r.node(n.Operand)}
+	case *parser.CastExpr:
+		c := *n
+		c.Expr = r.node(n.Expr)
+		return &c
+	case *parser.InlineExpr:
+		r.pushScope()
+		defer r.popScope()
+		return &parser.InlineExpr{Func: n.Func, Stmts: r.stmts(n.Stmts), Value: r.node(n.Value)}
+	case *parser.VarDecl:
+		c := *n
+		c.Init = r.node(n.Init) // avant la déclaration : I64 x = x lit l'ancien x
+		c.Name = r.declare(n.Name)
+		return &c
+	case *parser.ExprStmt:
+		return &parser.ExprStmt{Expr: r.node(n.Expr)}
+	case *parser.ReturnStmt:
+		return &parser.ReturnStmt{Value: r.node(n.Value)}
+	case *parser.Block:
+		r.pushScope()
+		defer r.popScope()
+		return &parser.Block{Stmts: r.stmts(n.Stmts)}
+	case *parser.IfStmt:
+		return &parser.IfStmt{Cond: r.node(n.Cond), Body: r.node(n.Body), Else: r.node(n.Else)}
+	case *parser.WhileStmt:
+		c := *n
+		c.Cond, c.Body = r.node(n.Cond), r.node(n.Body)
+		return &c
+	case *parser.ForStmt:
+		r.pushScope()
+		defer r.popScope()
+		c := *n
+		c.Init = r.node(n.Init)
+		c.Cond, c.Post, c.Body = r.node(n.Cond), r.node(n.Post), r.node(n.Body)
+		return &c
+	}
+	return node
+}
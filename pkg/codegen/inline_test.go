@@ -0,0 +1,276 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// inlineAt compile src au niveau l sans faire échouer le test sur une
+// erreur de génération : les appels laissés en place en produisent une.
+func inlineAt(t *testing.T, src string, l OptLevel) (*CodeGen, []Instruction) {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	cg := NewCodeGen()
+	cg.Passes = l.Passes()
+	return cg, cg.Generate(prog)
+}
+
+func decisions(cg *CodeGen) string {
+	parts := make([]string, len(cg.Inlined))
+	for i, d := range cg.Inlined {
+		parts[i] = fmt.Sprintf("%s x%d", d.Func, d.Inlined)
+		if d.Reason != "" {
+			parts[i] += " (" + d.Reason + ")"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestInlineDecisions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`I64 Sq(I64 x) { return x * x; }
+SStore(1, Sq(2) + Sq(SLoad(0)));`, "Sq x2"},
+		{`[[noinline]] I64 Id(I64 x) { return x; }
+SStore(1, Id(2));`, "Id x0 (noinline)"},
+		{`I64 Fact(I64 n) { if (n < 2) return 1; return n * Fact(n - 1); }
+SStore(1, Fact(5));`, "Fact x0 (recursive)"},
+		{`I64 Find(I64 n) { for (I64 i = 0; i < n; i++) if (SLoad(i) == 0) return i; return n; }
+SStore(1, Find(5));`, "Find x0 (return inside a loop)"},
+		{`I64 g = 1;
+I64 AddG(I64 x) { return x + g; }
+I64 F() { I64 g = 5; return AddG(g); }
+F();`, "AddG x1 ('g' is shadowed at the call site), F x1"},
+		// Un corps à branchements est avancé devant l'instruction...
+		{`I64 Abs(I64 x) { if (x < 0) return -x; return x; }
+I64 y = Abs(SLoad(0));
+SStore(1, 1 + Abs(SLoad(1)));`, "Abs x2"},
+		// ...sauf dans une condition de boucle, après && ou ||, ou à côté
+		// d'une expression qui a un effet ou lit l'état.
+		{`I64 Abs(I64 x) { if (x < 0) return -x; return x; }
+while (Abs(SLoad(0)) > 1) SStore(0, 1);`, "Abs x0 (control flow in a loop condition or after && or ||)"},
+		{`I64 Abs(I64 x) { if (x < 0) return -x; return x; }
+SStore(1, SLoad(2) && Abs(SLoad(1)));`, "Abs x0 (control flow in a loop condition or after && or ||)"},
+		{`I64 Abs(I64 x) { if (x < 0) return -x; return x; }
+SStore(SLoad(2), Abs(SLoad(1)));`, "Abs x0 (control flow in an expression with other effects)"},
+		{`I64 n = 0;
+I64 Bump(I64 x) { if (x) n++; return x; }
+SStore(1, n + Bump(SLoad(0)));`, "Bump x0 (control flow in an expression with other effects)"},
+	}
+	for _, tt := range tests {
+		cg, _ := inlineAt(t, tt.src, O2)
+		if got := decisions(cg); got != tt.want {
+			t.Errorf("%s\ndecisions: %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestInlineCostModel(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("I64 Mix(I64 a, I64 b) {\n  I64 c = a * 3 + b;\n  c = c ^ (c >> 7);\n  return c * 5 + a - b;\n}\n")
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&b, "SStore(%d, Mix(SLoad(%d), %d));\n", i, i, i)
+	}
+	src := b.String()
+	if cg, _ := inlineAt(t, src, O2); decisions(cg) != "Mix x4" {
+		t.Errorf("-O2: %s, want Mix x4", decisions(cg))
+	}
+	if cg, _ := inlineAt(t, src, Os); decisions(cg) != "Mix x0 (too large)" {
+		t.Errorf("-Os: %s, want Mix x0 (too large)", decisions(cg))
+	}
+	if cg, _ := inlineAt(t, "[[inline]] "+src, Os); decisions(cg) != "Mix x4" {
+		t.Errorf("-Os with [[inline]]: %s, want Mix x4", decisions(cg))
+	}
+}
+
+// TestInlinedCallsRun vérifie le résultat des appels inlinés, retours
+// anticipés et renommage compris. Les corps des fonctions restent émis en
+// ligne, après le code qui les appelle : l'exécution y entre par chute,
+// seul le stockage compte.
+func TestInlinedCallsRun(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`I64 r = Clamp(SLoad(0) - 4, 2, 9);
+SStore(1, r);
+SStore(2, r * 5);
+I64 Clamp(I64 x, I64 lo, I64 hi) {
+  if (x < lo) return lo;
+  if (x > hi) return hi;
+  return x;
+}
+`, "storage={1:2 2:10}"},
+		{`I64 x = 10;
+I64 y = Twice(x + 1);
+SStore(1, y);
+SStore(2, x);
+[[inline]] I64 Twice(I64 x) { I64 y = x * 2; return y; }
+`, "storage={1:22 2:10}"},
+		{`SStore(1, Outer(6));
+I64 Outer(I64 a) { return Inner(a) + 1; }
+I64 Inner(I64 a) { return a * a; }
+`, "storage={1:37}"},
+		// Corps à branchements en argument, en condition et dans une
+		// valeur de retour.
+		{`SStore(7, 0 - 3);
+I64 k = 2;
+SStore(1, Pick(SLoad(7)) * k);
+if (Pick(SLoad(7)) > 2) SStore(2, 10 + Pick(k));
+SStore(3, Outer(SLoad(7)));
+[[inline]] I64 Pick(I64 x) {
+  if (x < 0) return 0 - x;
+  return x + 100;
+}
+[[inline]] I64 Outer(I64 a) { return 1 + Pick(a); }
+`, "storage={1:6 2:112 3:4 7:-3}"},
+	}
+	for _, tt := range tests {
+		for _, l := range []OptLevel{O2, Os} {
+			cg, code := inlineAt(t, tt.src, l)
+			if diag.HasErrors(cg.Diagnostics) {
+				t.Errorf("%s: %v", l, messages(cg, diag.Error))
+				continue
+			}
+			got := run(code).String()
+			if got = got[strings.Index(got, "storage="):]; got != tt.want {
+				t.Errorf("%s: got %s, want %s\n%s", l, got, tt.want, tt.src)
+			}
+		}
+	}
+}
+
+func TestInlineKeepsSpans(t *testing.T) {
+	p := parser.NewParser(lexer.NewLexer("I64 y = Sq(SLoad(0));\nI64 Sq(I64 x) {\n  return x * x;\n}\n", "test.HC"))
+	prog := p.Parse()
+	cg := NewCodeGen()
+	cg.Passes = O2.Passes()
+	cg.InlineFunctions(prog)
+	e, ok := prog.Decls[0].(*parser.VarDecl).Init.(*parser.InlineExpr)
+	if !ok {
+		t.Fatalf("init = %#v, want an inlined call", prog.Decls[0].(*parser.VarDecl).Init)
+	}
+	if s := e.Span(); s.Start.Line != 1 || s.Start.Col != 9 {
+		t.Errorf("inlined call at %v, want 1:9", s)
+	}
+	if s := e.Value.Span(); s.Start.Line != 3 || s.Start.Col != 10 {
+		t.Errorf("inlined return value at %v, want 3:10", s)
+	}
+}
+
+// TestEarlyReturnsGuarded vérifie que les retours anticipés passent par un
+// drapeau plutôt que par la recopie de la suite du corps : chaque
+// instruction n'est émise qu'une fois par appel.
+func TestEarlyReturnsGuarded(t *testing.T) {
+	body := `[[inline]] I64 Step(I64 x) {
+  if (x < 10) {
+    if (x == 3) return 30;
+    TStore(6, x);
+  }
+  TStore(7, x);
+  if (x > 100) return 100;
+  TStore(8, x);
+  if (x == 50) return 1;
+  return x * 2;
+}
+`
+	for _, tt := range []struct {
+		in   int
+		want string
+	}{
+		{3, "storage={0:3 1:30}"},
+		{4, "storage={0:4 1:8}"},
+		{50, "storage={0:50 1:1}"},
+		{200, "storage={0:200 1:100}"},
+	} {
+		src := fmt.Sprintf("SStore(0, %d);\nI64 r = Step(SLoad(0));\nSStore(1, r);\n%s", tt.in, body)
+		cg, code := inlineAt(t, src, O2)
+		if decisions(cg) != "Step x1" {
+			t.Fatalf("decisions: %s", decisions(cg))
+		}
+		counts := map[Opcode]int{}
+		for _, inst := range code {
+			counts[inst.Op]++
+		}
+		if counts[OP_TSTORE] != 3 {
+			t.Errorf("%d TSTORE, want 3", counts[OP_TSTORE])
+		}
+		got := run(code).String()
+		if got = got[strings.Index(got, "storage="):]; got != tt.want {
+			t.Errorf("Step(%d): %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestInlinedBranchesNotReported vérifie que les branches d'un corps inliné
+// éliminées grâce à un argument constant ne sont pas signalées, ni la
+// fonction inlinée à chaque appel.
+func TestInlinedBranchesNotReported(t *testing.T) {
+	src := "I64 Abs(I64 x) { if (x < 0) return -x; return x; }\nI64 y = Abs(5);\nSStore(1, y);\n"
+	cg, code := inlineAt(t, src, O2)
+	if w := messages(cg, diag.Warning); len(w) != 0 {
+		t.Errorf("warnings %q", w)
+	}
+	got := run(code).String()
+	if got = got[strings.Index(got, "storage="):]; got != "storage={1:5}" {
+		t.Errorf("got %s", got)
+	}
+}
+
+// TestInlinedNodesSituated vérifie que les nœuds ajoutés par la réécriture
+// des return sont situés.
+func TestInlinedNodesSituated(t *testing.T) {
+	src := "I64 y = Pick(SLoad(0));\nI64 Pick(I64 x) {\n  if (x) { SStore(3, x); return 1; }\n  SStore(4, x);\n  return 2;\n}\n"
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	cg := NewCodeGen()
+	cg.Passes = O2.Passes()
+	cg.InlineFunctions(prog)
+	walk(prog.Decls[0], func(n parser.Node) {
+		if n.Span().IsZero() {
+			t.Errorf("unsituated %T", n)
+		}
+	})
+}
+
+// TestRefusedInlineHintWarns vérifie qu'un appel d'une fonction [[inline]]
+// laissé en place est signalé à l'appel, avec la raison.
+func TestRefusedInlineHintWarns(t *testing.T) {
+	src := `[[inline]] I64 Fact(I64 n) { if (n < 2) return 1; return n * Fact(n - 1); }
+SStore(1, Fact(5));
+`
+	cg, _ := inlineAt(t, src, O2)
+	var got []string
+	for _, d := range cg.Diagnostics {
+		if d.Code == "not-inlined" {
+			got = append(got, d.String())
+		}
+	}
+	// Un avertissement par appel, récursif compris.
+	want := []string{
+		"test.HC:1:62: warning: call to 'Fact' not inlined despite [[inline]]: recursive [not-inlined]",
+		"test.HC:2:11: warning: call to 'Fact' not inlined despite [[inline]]: recursive [not-inlined]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Sans l'attribut, le refus ne figure que dans les décisions.
+	cg, _ = inlineAt(t, src[len("[[inline]] "):], O2)
+	for _, d := range cg.Diagnostics {
+		if d.Code == "not-inlined" {
+			t.Errorf("without [[inline]]: %s", d)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package codegen_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/ir"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// compile compile src au niveau l, directement ou par l'IR ; une erreur
+// fait échouer le test.
+func compile(t *testing.T, src string, l codegen.OptLevel, viaIR bool) []codegen.Instruction {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	cg := codegen.NewCodeGen()
+	cg.Passes = l.Passes()
+	var code []codegen.Instruction
+	if viaIR {
+		cg.OptimizeAST(prog)
+		lowered, errs := ir.Lower(prog)
+		if len(errs) > 0 {
+			t.Fatalf("%s --ir: %v", l, errs)
+		}
+		ir.Optimize(lowered, cg.Passes, cg.Schedule)
+		out, err := ir.Schedule(lowered, cg.Passes, cg.Schedule)
+		if err != nil {
+			t.Fatalf("%s --ir: %v", l, err)
+		}
+		code = cg.Assemble(out.Code, out.Funcs, out.LoopBounds)
+	} else {
+		code = cg.Generate(prog)
+	}
+	if diag.HasErrors(cg.Diagnostics) {
+		t.Fatalf("%s: %v", l, cg.Diagnostics)
+	}
+	return code
+}
+
+// sameThroughIR vérifie qu'à chaque niveau, src se comporte par l'IR
+// comme par le backend direct.
+func sameThroughIR(t *testing.T, src string) {
+	t.Helper()
+	for _, l := range []codegen.OptLevel{codegen.O0, codegen.O2, codegen.Os} {
+		want := codegen.Run(compile(t, src, l, false)).String()
+		if got := codegen.Run(compile(t, src, l, true)).String(); got != want {
+			t.Errorf("%s --ir: got %s, want %s", l, got, want)
+		}
+	}
+}
+
+func TestIRAgreesOnTestFiles(t *testing.T) {
+	files, err := filepath.Glob("../../tests/*.HC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			sameThroughIR(t, string(src))
+		})
+	}
+}
+
+func TestIRAgreesOnPrograms(t *testing.T) {
+	for name, src := range codegen.Programs {
+		t.Run(name, func(t *testing.T) {
+			sameThroughIR(t, src)
+		})
+	}
+}
+
+// TestStackLocalsAgree compare des fonctions dont les variables restent
+// sur la pile à -O2 --ir, ou y sont en partie renvoyées en mémoire, au
+// backend direct à -O0. Les corps sont exécutés par chute.
+func TestStackLocalsAgree(t *testing.T) {
+	var spill strings.Builder
+	spill.WriteString("I64 G() {\n")
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&spill, "  I64 v%d = SLoad(%d) + %d;\n", i, i, i)
+	}
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&spill, "  v%d = v%d * 3 - v%d;\n  SStore(%d, v%d);\n", i, i, (i+5)%12, 20+i, i)
+	}
+	spill.WriteString("}\n")
+	srcs := map[string]string{
+		"loop": `I64 F(I64 n) {
+  I64 s = 0;
+  for (I64 i = 0; i < n + 5; i++) {
+    if (i % 3 == 0) s += i;
+    else s = s * 2;
+  }
+  SStore(1, s);
+  SStore(2, n);
+}
+`,
+		"spill": spill.String(),
+	}
+	for name, src := range srcs {
+		t.Run(name, func(t *testing.T) {
+			want := codegen.Run(compile(t, src, codegen.O0, false)).String()
+			if got := codegen.Run(compile(t, src, codegen.O2, true)).String(); got != want {
+				t.Errorf("-O2 --ir: got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestIRAgreesOnInlinedCalls(t *testing.T) {
+	src := `I64 r = Clamp(SLoad(0) - 4, 2, 9);
+SStore(1, r + Sq(r));
+SStore(2, Sq(SLoad(1)) - Sq(3));
+I64 Clamp(I64 x, I64 lo, I64 hi) {
+  if (x < lo) return lo;
+  if (x > hi) return hi;
+  return x;
+}
+[[inline]] I64 Sq(I64 x) { return x * x; }
+`
+	for _, l := range []codegen.OptLevel{codegen.O2, codegen.Os} {
+		want := codegen.Run(compile(t, src, l, false)).String()
+		if got := codegen.Run(compile(t, src, l, true)).String(); got != want {
+			t.Errorf("%s --ir: got %s, want %s", l, got, want)
+		}
+	}
+}
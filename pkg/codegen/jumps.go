@@ -0,0 +1,149 @@
+package codegen
+
+import "fmt"
+
+// JumpDestBitmap analyse le bytecode brut : valid[off] vaut true si l'octet
+// off est un opcode JUMPDEST, et non un octet d'immédiat d'un PUSH.
+func JumpDestBitmap(data []byte) []bool {
+	valid := make([]bool, len(data))
+	for pc := 0; pc < len(data); pc++ {
+		op := Opcode(data[pc])
+		if op == OP_JUMPDEST {
+			valid[pc] = true
+		}
+		pc += op.PushSize()
+	}
+	return valid
+}
+
+// JumpKind classe la destination d'un JUMP/JUMPI.
+type JumpKind int
+
+const (
+	JumpValid      JumpKind = iota // constante désignant un JUMPDEST
+	JumpInvalid                    // constante ne désignant pas un JUMPDEST (opcode ou immédiat de PUSH)
+	JumpOutOfRange                 // constante au-delà de la fin du code : destination inatteignable
+	JumpDynamic                    // destination calculée à l'exécution
+)
+
+func (k JumpKind) String() string {
+	switch k {
+	case JumpValid:
+		return "valid"
+	case JumpInvalid:
+		return "invalid"
+	case JumpOutOfRange:
+		return "out of range"
+	}
+	return "dynamic"
+}
+
+// Jump décrit un JUMP/JUMPI et sa destination résolue statiquement.
+type Jump struct {
+	Index     int // instruction JUMP/JUMPI
+	Offset    int
+	Op        Opcode
+	Kind      JumpKind
+	Target    uint64 // offset visé, sauf si Kind == JumpDynamic
+	Reachable bool   // le saut lui-même est atteignable depuis l'offset 0
+}
+
+func (j Jump) String() string {
+	if j.Kind == JumpDynamic {
+		return fmt.Sprintf("%04d (0x%04X): %s to a dynamic destination", j.Index, j.Offset, j.Op)
+	}
+	return fmt.Sprintf("%04d (0x%04X): %s to 0x%04X: %s", j.Index, j.Offset, j.Op, j.Target, j.Kind)
+}
+
+// JumpAnalysis est le résultat de AnalyzeJumps.
+type JumpAnalysis struct {
+	Dests       []bool // bitmap des JUMPDEST valides, indexée par offset
+	Jumps       []Jump
+	Unreachable [][2]int // plages d'instructions [début, fin) inatteignables
+}
+
+// Bad retourne les sauts atteignables dont la destination n'est pas un
+// JUMPDEST valide connu statiquement.
+func (a *JumpAnalysis) Bad() []Jump {
+	var bad []Jump
+	for _, j := range a.Jumps {
+		if j.Reachable && j.Kind != JumpValid {
+			bad = append(bad, j)
+		}
+	}
+	return bad
+}
+
+// AnalyzeJumps construit la bitmap des JUMPDEST, résout les destinations
+// constantes (voir jumpConstants) et classe chaque saut ; le code qu'aucun
+// chemin depuis l'offset 0 n'atteint est listé dans Unreachable.
+func AnalyzeJumps(code []Instruction) *JumpAnalysis {
+	a := &JumpAnalysis{Dests: JumpDestBitmap(Encode(code))}
+	g := BuildCFG(code)
+	offsets := Offsets(code)
+	consts := jumpConstants(code)
+
+	reached := make([]bool, len(g.Blocks))
+	if len(g.Blocks) > 0 {
+		reached[0] = true
+		work := []int{0}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, s := range g.Blocks[b].Succs {
+				if !reached[s] {
+					reached[s] = true
+					work = append(work, s)
+				}
+			}
+		}
+	}
+	for _, b := range g.Blocks {
+		if reached[b.Index] {
+			continue
+		}
+		if n := len(a.Unreachable); n > 0 && a.Unreachable[n-1][1] == b.Start {
+			a.Unreachable[n-1][1] = b.End
+		} else {
+			a.Unreachable = append(a.Unreachable, [2]int{b.Start, b.End})
+		}
+	}
+
+	for i, inst := range code {
+		if inst.Op != OP_JUMP && inst.Op != OP_JUMPI {
+			continue
+		}
+		j := Jump{Index: i, Offset: offsets[i], Op: inst.Op, Reachable: reached[g.blockOf[i]]}
+		target, ok := consts[i]
+		switch {
+		case !ok:
+			j.Kind = JumpDynamic
+		case target >= uint64(len(a.Dests)):
+			j.Kind, j.Target = JumpOutOfRange, target
+		case !a.Dests[target]:
+			j.Kind, j.Target = JumpInvalid, target
+		default:
+			j.Kind, j.Target = JumpValid, target
+		}
+		a.Jumps = append(a.Jumps, j)
+	}
+	return a
+}
+
+// jumpConstants retourne, pour chaque JUMP/JUMPI dont la destination est
+// une constante, la valeur de celle-ci. Les constantes sont propagées en
+// ligne droite (PUSH, DUP, SWAP, opcodes purs via Eval) et oubliées à
+// chaque JUMPDEST, où plusieurs chemins peuvent se rejoindre.
+func jumpConstants(code []Instruction) map[int]uint64 {
+	consts := make(map[int]uint64)
+	var stack absStack
+	for i, inst := range code {
+		if inst.Op == OP_JUMP || inst.Op == OP_JUMPI {
+			if dest := stack.peek(0); dest.known {
+				consts[i] = dest.v
+			}
+		}
+		stack.step(inst)
+	}
+	return consts
+}
@@ -0,0 +1,75 @@
+package codegen
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJumpDestBitmap(t *testing.T) {
+	// Le 0x5B immédiat du PUSH1 n'est pas un JUMPDEST.
+	data := []byte{byte(OP_PUSH1), byte(OP_JUMPDEST), byte(OP_JUMPDEST)}
+	if got := fmt.Sprint(JumpDestBitmap(data)); got != "[false false true]" {
+		t.Errorf("bitmap = %s, want [false false true]", got)
+	}
+}
+
+func TestAnalyzeJumps(t *testing.T) {
+	code := []Instruction{
+		{Op: OP_PUSH1, Operand: int64(OP_JUMPDEST)}, {Op: OP_POP}, // 0x00, 0x02
+		{Op: OP_CALLER}, {Op: OP_PUSH1, Operand: 1}, {Op: OP_JUMPI}, // vers l'immédiat en 0x01
+		{Op: OP_CALLER}, {Op: OP_PUSH1, Operand: 0xF0}, {Op: OP_JUMPI}, // au-delà du code
+		{Op: OP_CALLER}, {Op: OP_CALLER}, {Op: OP_JUMPI}, // destination inconnue
+		{Op: OP_PUSH1, Operand: 7}, {Op: OP_PUSH1, Operand: 3}, {Op: OP_MUL}, {Op: OP_JUMP}, // 3*7 = 0x15
+		{Op: OP_PUSH0},                   // 0x14, inatteignable
+		{Op: OP_JUMPDEST}, {Op: OP_STOP}, // 0x15
+	}
+	a := AnalyzeJumps(code)
+	want := []string{
+		"0004 (0x0006): JUMPI to 0x0001: invalid",
+		"0007 (0x000A): JUMPI to 0x00F0: out of range",
+		"0010 (0x000D): JUMPI to a dynamic destination",
+		"0014 (0x0013): JUMP to 0x0015: valid",
+	}
+	if len(a.Jumps) != len(want) {
+		t.Fatalf("jumps = %v", a.Jumps)
+	}
+	for i, j := range a.Jumps {
+		if j.String() != want[i] || !j.Reachable {
+			t.Errorf("jump %d = %s (reachable %v), want %s", i, j, j.Reachable, want[i])
+		}
+	}
+	if len(a.Bad()) != 3 {
+		t.Errorf("bad jumps = %v, want the first three", a.Bad())
+	}
+	if got := fmt.Sprint(a.Unreachable); got != "[[15 16]]" {
+		t.Errorf("unreachable = %s, want [[15 16]]", got)
+	}
+}
+
+func TestAnalyzeJumpsOnGeneratedCode(t *testing.T) {
+	_, code := generate(t, `I64 x = SLoad(0);
+while (x < 10) { if (x % 3 == 0) x += 2; else x++; }
+SStore(1, x);
+`)
+	a := AnalyzeJumps(code)
+	if len(a.Jumps) == 0 || len(a.Bad()) != 0 || len(a.Unreachable) != 0 {
+		t.Errorf("jumps %v, bad %v, unreachable %v", a.Jumps, a.Bad(), a.Unreachable)
+	}
+}
+
+// TestAnalyzeJumpsUnreachableLoop vérifie qu'une boucle sautée reste
+// inatteignable même si elle s'atteint elle-même.
+func TestAnalyzeJumpsUnreachableLoop(t *testing.T) {
+	code := []Instruction{
+		{Op: OP_PUSH1, Operand: 7}, {Op: OP_JUMP}, // 0x00 : saute la boucle
+		{Op: OP_JUMPDEST}, {Op: OP_PUSH1, Operand: 3}, {Op: OP_JUMP}, // 0x03 : boucle
+		{Op: OP_JUMPDEST}, {Op: OP_STOP}, // 0x07
+	}
+	a := AnalyzeJumps(code)
+	if got := fmt.Sprint(a.Unreachable); got != "[[2 5]]" {
+		t.Errorf("unreachable = %s, want [[2 5]]", got)
+	}
+	if len(a.Jumps) != 2 || !a.Jumps[0].Reachable || a.Jumps[1].Reachable {
+		t.Errorf("jumps %+v", a.Jumps)
+	}
+}
@@ -0,0 +1,40 @@
+package codegen
+
+import "fmt"
+
+// Offsets retourne l'offset en octets de chaque instruction ; le dernier
+// élément (len(code)) est la taille totale du bytecode.
+func Offsets(code []Instruction) []int {
+	offsets := make([]int, len(code)+1)
+	for i, inst := range code {
+		offsets[i+1] = offsets[i] + inst.Size()
+	}
+	return offsets
+}
+
+// ResolveLabels remplace l'opérande des PUSH étiquetés par l'offset du
+// JUMPDEST portant la même étiquette.
+func ResolveLabels(code []Instruction) error {
+	offsets := Offsets(code)
+	dest := make(map[int]int)
+	for i, inst := range code {
+		if inst.Op == OP_JUMPDEST && inst.Label != 0 {
+			dest[inst.Label] = offsets[i]
+		}
+	}
+	for i := range code {
+		inst := &code[i]
+		if inst.Label == 0 || !inst.Op.IsPush() {
+			continue
+		}
+		off, ok := dest[inst.Label]
+		if !ok {
+			return fmt.Errorf("undefined label L%d", inst.Label)
+		}
+		if off >= 1<<(8*inst.Op.PushSize()) {
+			return fmt.Errorf("jump target 0x%X does not fit in %s", off, inst.Op)
+		}
+		inst.Operand = int64(off)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// generate compile src sans optimisation ; une erreur d'analyse fait
+// échouer le test.
+func generate(t *testing.T, src string) (*CodeGen, []Instruction) {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	cg := NewCodeGen()
+	return cg, cg.Generate(prog)
+}
+
+func TestOffsets(t *testing.T) {
+	code := []Instruction{{Op: OP_PUSH2, Operand: 1}, {Op: OP_JUMP}, {Op: OP_PUSH0}, {Op: OP_PUSH8, Operand: 1}, {Op: OP_STOP}}
+	got := Offsets(code)
+	want := []int{0, 3, 4, 5, 14, 15}
+	if len(got) != len(want) {
+		t.Fatalf("Offsets = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Offsets = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestResolveLabels(t *testing.T) {
+	code := []Instruction{
+		{Op: OP_PUSH2, Label: 1}, {Op: OP_JUMP},
+		{Op: OP_PUSH1, Operand: 7},
+		{Op: OP_JUMPDEST, Label: 1},
+	}
+	if err := ResolveLabels(code); err != nil {
+		t.Fatal(err)
+	}
+	if code[0].Operand != 6 {
+		t.Errorf("jump resolved to 0x%X, want 0x6", code[0].Operand)
+	}
+
+	if err := ResolveLabels([]Instruction{{Op: OP_PUSH2, Label: 3}, {Op: OP_JUMP}}); err == nil || !strings.Contains(err.Error(), "undefined label L3") {
+		t.Errorf("undefined label: error %v", err)
+	}
+
+	far := []Instruction{{Op: OP_PUSH1, Label: 1}, {Op: OP_JUMP}}
+	for i := 0; i < 130; i++ {
+		far = append(far, Instruction{Op: OP_PUSH1, Operand: 1})
+	}
+	far = append(far, Instruction{Op: OP_JUMPDEST, Label: 1})
+	if err := ResolveLabels(far); err == nil || !strings.Contains(err.Error(), "does not fit in PUSH1") {
+		t.Errorf("far label: error %v", err)
+	}
+}
+
+func TestControlFlowJumpsToItsLabels(t *testing.T) {
+	_, code := generate(t, `I64 x = 0;
+if (x) x = 1; else x = 2;
+while (x < 10) x = x + 1;
+for (I64 i = 0; i < 3; i++) { if (i == 1) x = 5; }
+`)
+	offsets := Offsets(code)
+	dest := make(map[int]int) // étiquette → offset
+	for i, inst := range code {
+		if inst.Op == OP_JUMPDEST {
+			dest[inst.Label] = offsets[i]
+		}
+	}
+	jumps := 0
+	for i, inst := range code {
+		if inst.Op != OP_JUMP && inst.Op != OP_JUMPI {
+			continue
+		}
+		jumps++
+		push := code[i-1]
+		if push.Label == 0 {
+			t.Fatalf("%04d %s: not preceded by a label push", i, inst)
+		}
+		if off, ok := dest[push.Label]; !ok || int64(off) != push.Operand {
+			t.Errorf("%04d %s: target 0x%X, label L%d at 0x%X", i, inst, push.Operand, push.Label, off)
+		}
+	}
+	// if/else : JUMPI + JUMP ; while et for : JUMPI + JUMP ; if : JUMPI.
+	if jumps != 7 {
+		t.Errorf("%d jumps, want 7", jumps)
+	}
+}
@@ -0,0 +1,675 @@
+package codegen
+
+import (
+	"fmt"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// maxUnroll borne le nombre de tours d'une boucle que le modèle de coût
+// peut dérouler ; maxUnrollForced, celui d'une boucle marquée [[unroll]].
+// maxUnrollGrowth borne, sous le modèle gas, la croissance du bytecode
+// (octets) qu'un déroulage peut coûter. defaultTrips est le nombre de tours
+// supposé d'une boucle sans borne annoncée.
+const (
+	maxUnroll       = 8
+	maxUnrollForced = 64
+	maxUnrollGrowth = 128
+	defaultTrips    = 8
+)
+
+// loopEffects résume ce qu'une boucle change à chaque tour.
+type loopEffects struct {
+	assigned map[string]int // affectations (ou déclarations) de chaque variable
+	state    bool           // SSTORE, TSTORE ou appel de fonction
+	memory   bool           // écriture en mémoire hors variables (MSTORE, copies...) ou appel
+	calls    bool           // appel de fonction utilisateur, qui peut tout changer
+}
+
+// loopOpt porte l'état de OptimizeLoops.
+type loopOpt struct {
+	cg        *CodeGen
+	defines   map[string]bool
+	addressed map[string]bool // variables dont l'adresse est prise
+	hoists    map[string]bool // variables LICM.n, sorties d'une boucle intérieure
+	vars      int             // dernier numéro de variable créée (LICM.n, IV.n)
+	sites     int             // dernier corps copié par un déroulage (Unroll.nom.n)
+
+	hoisted, reduced, unrolled int
+}
+
+// OptimizeLoops optimise en place les boucles du programme, des plus
+// intérieures aux plus extérieures, et retourne le nombre d'expressions
+// invariantes sorties des boucles, de variables d'induction dérivées et de
+// boucles déroulées :
+//   - un for dont le nombre de tours est constant (I64 i = constante, une
+//     condition et un pas qui ne dépendent que de i, i non modifiée par le
+//     corps) est remplacé par autant de copies du corps, où i est une
+//     constante repliée, si le modèle de coût y gagne (au plus maxUnroll
+//     tours) ou si la boucle porte [[unroll]] ou #pragma unroll (au plus
+//     maxUnrollForced tours ; sinon un avertissement dit pourquoi) ;
+//   - dans un for dont la variable i avance d'un pas constant, i * k et
+//     i << k (k constant) deviennent une variable initialisée avant la
+//     boucle et augmentée du pas * k à la fin de chaque tour ;
+//   - une sous-expression invariante (voir invariant) devient une variable
+//     LICM.n calculée une fois avant la boucle.
+//
+// Les deux dernières transformations ne sont faites que si le modèle de
+// coût y gagne, pour Bound tours (defaultTrips si la boucle n'est pas
+// bornée) : lire une variable en mémoire coûte plus cher que ADDRESS ou
+// CALLER, et, sous le modèle taille, seule une expression répétée gagne à
+// être sortie. Une expression sortie est évaluée même si la boucle ne fait
+// aucun tour.
+func (cg *CodeGen) OptimizeLoops(prog *parser.Program) (hoisted, reduced, unrolled int) {
+	l := &loopOpt{cg: cg, defines: make(map[string]bool), addressed: make(map[string]bool), hoists: make(map[string]bool)}
+	walk(prog, func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.DefineDecl:
+			l.defines[n.Name] = true
+		case *parser.UnaryExpr:
+			if id, ok := n.Operand.(*parser.Identifier); ok && n.Op == lexer.TOK_AMP {
+				l.addressed[id.Name] = true
+			}
+		}
+	})
+	transform(prog, l.loop)
+	return l.hoisted, l.reduced, l.unrolled
+}
+
+// loop optimise une boucle dont les boucles intérieures le sont déjà.
+func (l *loopOpt) loop(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case *parser.ForStmt:
+		if out := l.unroll(n); out != nil {
+			return out
+		}
+		pre := l.reduce(n)
+		pre = append(pre, l.hoist(n.Bound, &n.Cond, &n.Post, &n.Body)...)
+		if len(pre) == 0 {
+			return n
+		}
+		// L'initialisation passe avant les variables, qui peuvent la lire.
+		var stmts []parser.Node
+		switch init := n.Init.(type) {
+		case nil:
+		case *parser.VarDecl:
+			stmts = append(stmts, init)
+		default:
+			stmts = append(stmts, &parser.ExprStmt{Expr: init})
+		}
+		n.Init = nil
+		return &parser.Block{Stmts: append(append(stmts, pre...), n)}
+	case *parser.WhileStmt:
+		if pre := l.hoist(n.Bound, &n.Cond, &n.Body); len(pre) > 0 {
+			return &parser.Block{Stmts: append(pre, n)}
+		}
+	}
+	return node
+}
+
+// trips retourne le nombre de tours pour lequel une boucle de borne bound
+// est estimée.
+func trips(bound int64) int {
+	if bound > 0 && bound < 1<<20 {
+		return int(bound)
+	}
+	return defaultTrips
+}
+
+// newVar retourne une variable prefix.n initialisée par init.
+func (l *loopOpt) newVar(prefix string, init parser.Node) *parser.VarDecl {
+	l.vars++
+	return &parser.VarDecl{TypeName: "I64", Name: fmt.Sprintf("%s.%d", prefix, l.vars), Init: init}
+}
+
+// ---- Déroulage ----
+
+// unroll retourne les copies du corps qui remplacent n, ou nil si n reste
+// une boucle.
+func (l *loopOpt) unroll(n *parser.ForStmt) parser.Node {
+	limit := maxUnroll
+	if n.Unroll {
+		limit = maxUnrollForced
+	}
+	name, values, why := l.tripValues(n, limit)
+	if why != "" {
+		if n.Unroll {
+			l.cg.warnAt("not-unrolled", n.Span(), "loop not unrolled: %s", why)
+		}
+		return nil
+	}
+	out := &parser.Block{}
+	for _, v := range values {
+		l.sites++
+		r := &renamer{prefix: "Unroll", site: l.sites, free: make(map[string]bool)}
+		r.pushScope()
+		body := transform(r.node(n.Body), func(m parser.Node) parser.Node {
+			if id, ok := m.(*parser.Identifier); ok && id.Name == name {
+				return &parser.IntLiteral{Value: v}
+			}
+			return m
+		})
+		if body = l.fold(body); body != nil {
+			out.Stmts = append(out.Stmts, body)
+		}
+	}
+	// Les copies repliées laissent des if constants et des variables
+	// inutiles ; leurs noms contiennent un point, rien n'est signalé.
+	d := &eliminator{}
+	out.Stmts = d.list(out.Stmts, "unrolled loop")
+	for d.variables(&parser.Program{Decls: out.Stmts}) {
+	}
+
+	if !n.Unroll {
+		looped, unrolled := l.cg.costOf(n), l.cg.costOf(out)
+		looped.gas *= max(len(values), 1)
+		if l.cg.less(looped, unrolled) ||
+			l.cg.Passes.Cost == CostGas && unrolled.size-looped.size > maxUnrollGrowth {
+			return nil
+		}
+	}
+	l.unrolled++
+	return out
+}
+
+// tripValues retourne la variable de n et ses valeurs successives au début
+// de chaque tour, ou la raison pour laquelle n n'a pas un nombre constant
+// de tours, au plus limit.
+func (l *loopOpt) tripValues(n *parser.ForStmt, limit int) (string, []int64, string) {
+	decl, ok := n.Init.(*parser.VarDecl)
+	if !ok || decl.IsPtr {
+		return "", nil, "no 'I64 i = constant' initialization"
+	}
+	start, ok := constOf(decl.Init)
+	if !ok {
+		return "", nil, fmt.Sprintf("'%s' does not start at a constant", decl.Name)
+	}
+	next := stepValue(n.Post, decl.Name)
+	if n.Cond == nil || next == nil {
+		return "", nil, fmt.Sprintf("no condition or no step on '%s'", decl.Name)
+	}
+	if l.effects(n.Cond, n.Body).assigned[decl.Name] > 0 || l.addressed[decl.Name] {
+		return "", nil, fmt.Sprintf("'%s' is modified by the loop body", decl.Name)
+	}
+	var values []int64
+	for v := start; ; {
+		c, ok := l.evalWith(n.Cond, decl.Name, v)
+		if !ok {
+			return "", nil, "condition is not constant"
+		}
+		if c == 0 {
+			return decl.Name, values, ""
+		}
+		if len(values) == limit {
+			return "", nil, fmt.Sprintf("more than %d iterations", limit)
+		}
+		values = append(values, int64(v))
+		if v, ok = l.evalWith(next, decl.Name, v); !ok {
+			return "", nil, "step is not constant"
+		}
+	}
+}
+
+// stepValue retourne l'expression de la nouvelle valeur de name après
+// post (i++, i += 2, i = i * 2...), ou nil si post fait autre chose.
+func stepValue(post parser.Node, name string) parser.Node {
+	is := func(n parser.Node) bool {
+		id, ok := n.(*parser.Identifier)
+		return ok && id.Name == name
+	}
+	incDec := func(op lexer.TokenType, operand parser.Node) parser.Node {
+		if !is(operand) {
+			return nil
+		}
+		bin := lexer.TOK_PLUS
+		if op == lexer.TOK_MINUS_MINUS {
+			bin = lexer.TOK_MINUS
+		}
+		return &parser.BinaryExpr{Op: bin, Left: &parser.Identifier{Name: name}, Right: &parser.IntLiteral{Value: 1}}
+	}
+	switch n := post.(type) {
+	case *parser.PostfixExpr:
+		return incDec(n.Op, n.Operand)
+	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			return incDec(n.Op, n.Operand)
+		}
+	case *parser.AssignExpr:
+		if !is(n.Target) || hasEffects(n.Value) {
+			return nil
+		}
+		if op, ok := compoundOps[n.Op]; ok {
+			return &parser.BinaryExpr{Op: op, Left: &parser.Identifier{Name: name}, Right: n.Value}
+		}
+		if n.Op == lexer.TOK_ASSIGN {
+			return n.Value
+		}
+	}
+	return nil
+}
+
+// evalWith retourne la valeur de expr quand name vaut v, si elle ne
+// dépend de rien d'autre.
+func (l *loopOpt) evalWith(expr parser.Node, name string, v uint64) (uint64, bool) {
+	r := &renamer{prefix: "Unroll", free: make(map[string]bool)}
+	r.pushScope()
+	copied := transform(r.node(expr), func(m parser.Node) parser.Node {
+		if id, ok := m.(*parser.Identifier); ok && id.Name == name {
+			return &parser.IntLiteral{Value: int64(v)}
+		}
+		return m
+	})
+	return constOf(l.fold(copied))
+}
+
+// fold replie node, une copie où aucune variable du programme n'est
+// déclarée.
+func (l *loopOpt) fold(node parser.Node) parser.Node {
+	f := &folder{
+		cg:      l.cg,
+		defines: make(map[string]parser.Node),
+		consts:  make(map[string]int64),
+		decls:   make(map[string]int),
+		written: make(map[string]bool),
+	}
+	f.scan(node)
+	return f.node(node)
+}
+
+// ---- Variables d'induction ----
+
+// reduce remplace dans le corps et la condition de n les multiples
+// constants de la variable d'induction, et retourne les déclarations des
+// variables qui les remplacent, à placer avant la boucle.
+func (l *loopOpt) reduce(n *parser.ForStmt) []parser.Node {
+	decl, ok := n.Init.(*parser.VarDecl)
+	if !ok || decl.IsPtr || l.addressed[decl.Name] {
+		return nil
+	}
+	name := decl.Name
+	next, ok := stepValue(n.Post, name).(*parser.BinaryExpr)
+	if !ok || l.effects(n.Cond, n.Body).assigned[name] > 0 {
+		return nil
+	}
+	var step uint64
+	switch id, _ := next.Left.(*parser.Identifier); {
+	case id == nil || id.Name != name:
+		return nil
+	case next.Op == lexer.TOK_PLUS:
+		if step, ok = constOf(next.Right); !ok {
+			return nil
+		}
+	case next.Op == lexer.TOK_MINUS:
+		if step, ok = constOf(next.Right); !ok {
+			return nil
+		}
+		step = -step
+	default:
+		return nil
+	}
+
+	// multiple retourne k si node vaut name * k.
+	multiple := func(node parser.Node) (uint64, bool) {
+		bin, ok := node.(*parser.BinaryExpr)
+		if !ok {
+			return 0, false
+		}
+		left, lok := bin.Left.(*parser.Identifier)
+		right, rok := bin.Right.(*parser.Identifier)
+		switch {
+		case bin.Op == lexer.TOK_STAR && lok && left.Name == name:
+			return constOf(bin.Right)
+		case bin.Op == lexer.TOK_STAR && rok && right.Name == name:
+			return constOf(bin.Left)
+		case bin.Op == lexer.TOK_SHL && lok && left.Name == name:
+			if s, ok := constOf(bin.Right); ok && s < 64 {
+				return 1 << s, true
+			}
+		}
+		return 0, false
+	}
+	uses := make(map[uint64]int)
+	for _, part := range []parser.Node{n.Cond, n.Body} {
+		walk(part, func(m parser.Node) {
+			if k, ok := multiple(m); ok {
+				uses[k]++
+			}
+		})
+	}
+
+	derived := make(map[uint64]*parser.VarDecl)
+	var pre, updates []parser.Node
+	replace := func(m parser.Node) parser.Node {
+		k, ok := multiple(m)
+		if !ok {
+			return m
+		}
+		v, ok := derived[k]
+		if !ok {
+			if !l.worthReducing(name, k, step*k, uses[k], trips(n.Bound)) {
+				return m
+			}
+			v = l.newVar("IV", &parser.BinaryExpr{Op: lexer.TOK_STAR, Left: &parser.Identifier{Name: name}, Right: &parser.IntLiteral{Value: int64(k)}})
+			derived[k] = v
+			pre = append(pre, v)
+			updates = append(updates, &parser.ExprStmt{Expr: &parser.AssignExpr{
+				Op:     lexer.TOK_ASSIGN,
+				Target: &parser.Identifier{Name: v.Name},
+				Value:  &parser.BinaryExpr{Op: lexer.TOK_PLUS, Left: &parser.Identifier{Name: v.Name}, Right: &parser.IntLiteral{Value: int64(step * k)}},
+			}})
+			l.reduced++
+		}
+		return &parser.Identifier{Name: v.Name}
+	}
+	n.Cond = transform(n.Cond, replace)
+	n.Body = transform(n.Body, replace)
+	if len(updates) > 0 {
+		// Mises à jour en fin de tour, juste avant le pas de i.
+		if b, ok := n.Body.(*parser.Block); ok {
+			b.Stmts = append(b.Stmts, updates...)
+		} else {
+			n.Body = &parser.Block{Stmts: append([]parser.Node{n.Body}, updates...)}
+		}
+	}
+	return pre
+}
+
+// worthReducing compare, pour tours tours, uses calculs de name * k à
+// chaque tour à autant de lectures d'une variable augmentée de delta.
+func (l *loopOpt) worthReducing(name string, k, delta uint64, uses, tours int) bool {
+	cg := l.cg
+	product := &parser.BinaryExpr{Op: lexer.TOK_STAR, Left: &parser.Identifier{Name: name}, Right: &parser.IntLiteral{Value: int64(k)}}
+	init := cg.costOf(&parser.VarDecl{TypeName: "I64", Name: "IV", Init: product})
+	update := cg.costOf(&parser.ExprStmt{Expr: &parser.AssignExpr{
+		Op:     lexer.TOK_ASSIGN,
+		Target: &parser.Identifier{Name: "IV"},
+		Value:  &parser.BinaryExpr{Op: lexer.TOK_PLUS, Left: &parser.Identifier{Name: "IV"}, Right: &parser.IntLiteral{Value: int64(delta)}},
+	}})
+	value := l.valueCost(product)
+	read := cg.cost([]Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}})
+	keep := seqCost{
+		gas:  init.gas + tours*(uses*read.gas+update.gas),
+		size: init.size + uses*read.size + update.size,
+	}
+	again := seqCost{gas: tours * uses * value.gas, size: uses * value.size}
+	return cg.less(keep, again)
+}
+
+// valueCost retourne le coût du calcul de node.
+func (l *loopOpt) valueCost(node parser.Node) seqCost {
+	c := l.cg.costOf(&parser.VarDecl{TypeName: "I64", Name: "v", Init: node})
+	store := l.cg.cost([]Instruction{PushInstr(LocalsBase), {Op: OP_MSTORE}})
+	return seqCost{gas: c.gas - store.gas, size: c.size - store.size}
+}
+
+// ---- Expressions invariantes ----
+
+// hoist remplace dans les parties d'une boucle de borne bound les
+// sous-expressions invariantes les plus grandes par des variables, et
+// retourne leurs déclarations, à placer avant la boucle. Les variables
+// qu'une boucle intérieure a déjà sorties, si leur valeur est encore
+// invariante, sont sorties avec leur déclaration.
+func (l *loopOpt) hoist(bound int64, parts ...*parser.Node) []parser.Node {
+	var nodes []parser.Node
+	for _, p := range parts {
+		nodes = append(nodes, *p)
+	}
+	eff := l.effects(nodes...)
+	movable := func(n parser.Node) (*parser.VarDecl, bool) {
+		d, ok := n.(*parser.VarDecl)
+		if !ok || !l.hoists[d.Name] || eff.assigned[d.Name] != 1 {
+			return nil, false
+		}
+		_, ok = l.invariant(d.Init, eff)
+		return d, ok
+	}
+	candidate := func(n parser.Node) (string, bool) {
+		switch n.(type) {
+		case *parser.BinaryExpr, *parser.UnaryExpr, *parser.CallExpr:
+			return l.invariant(n, eff)
+		}
+		return "", false
+	}
+
+	uses := make(map[string]int)
+	for _, p := range parts {
+		rewrite(*p, func(n parser.Node) (parser.Node, bool) {
+			if _, ok := movable(n); ok {
+				return n, true
+			}
+			key, ok := candidate(n)
+			if ok {
+				uses[key]++
+			}
+			return n, ok
+		})
+	}
+
+	var pre []parser.Node
+	vars := make(map[string]*parser.VarDecl)
+	worth := make(map[string]bool)
+	for _, p := range parts {
+		*p = rewrite(*p, func(n parser.Node) (parser.Node, bool) {
+			if d, ok := movable(n); ok {
+				pre = append(pre, d)
+				return nil, true
+			}
+			key, ok := candidate(n)
+			if !ok {
+				return n, false
+			}
+			if _, seen := worth[key]; !seen {
+				worth[key] = l.worthHoisting(n, max(uses[key], 1), trips(bound))
+			}
+			if !worth[key] {
+				return n, false
+			}
+			v, ok := vars[key]
+			if !ok {
+				v = l.newVar("LICM", n)
+				vars[key] = v
+				l.hoists[v.Name] = true
+				pre = append(pre, v)
+				l.hoisted++
+			}
+			return &parser.Identifier{Name: v.Name}, true
+		})
+	}
+	return pre
+}
+
+// worthHoisting compare, pour tours tours, uses calculs de node à chaque
+// tour à un calcul avant la boucle suivi d'autant de lectures.
+func (l *loopOpt) worthHoisting(node parser.Node, uses, tours int) bool {
+	cg := l.cg
+	value := l.valueCost(node)
+	store := cg.cost([]Instruction{PushInstr(LocalsBase), {Op: OP_MSTORE}})
+	read := cg.cost([]Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}})
+	keep := seqCost{
+		gas:  value.gas + store.gas + tours*uses*read.gas,
+		size: value.size + store.size + uses*read.size,
+	}
+	again := seqCost{gas: tours * uses * value.gas, size: uses * value.size}
+	return cg.less(keep, again)
+}
+
+// invariant retourne la forme canonique de node si sa valeur ne change pas
+// d'un tour à l'autre d'une boucle d'effets eff : opérateurs et builtins
+// Pure ou Context sur des constantes et des variables que la boucle
+// n'affecte pas ; StateRead si la boucle n'écrit pas le stockage ; HASH et
+// MLOAD d'une zone constante sous LocalsBase si elle n'écrit pas la
+// mémoire.
+func (l *loopOpt) invariant(node parser.Node, eff loopEffects) (string, bool) {
+	switch n := node.(type) {
+	case *parser.IntLiteral:
+		return fmt.Sprint(n.Value), true
+	case *parser.Identifier:
+		return n.Name, !l.defines[n.Name] && eff.assigned[n.Name] == 0 && !eff.calls && !(eff.memory && l.addressed[n.Name])
+	case *parser.BinaryExpr:
+		if _, _, ok := BinaryOp(n.Op); !ok {
+			return "", false
+		}
+		left, lok := l.invariant(n.Left, eff)
+		right, rok := l.invariant(n.Right, eff)
+		return fmt.Sprintf("(%d %s %s)", n.Op, left, right), lok && rok
+	case *parser.UnaryExpr:
+		if _, ok := UnaryOp(n.Op); !ok {
+			return "", false
+		}
+		k, ok := l.invariant(n.Operand, eff)
+		return fmt.Sprintf("(%d %s)", n.Op, k), ok
+	case *parser.CallExpr:
+		op, args, ok := Builtin(n.Func)
+		if !ok || len(n.Args) != args || opcodeInfo[op].Results != 1 {
+			return "", false
+		}
+		switch op.Purity() {
+		case Pure, Context:
+		case StateRead:
+			if eff.state {
+				return "", false
+			}
+		case MemoryRead:
+			if eff.memory || !scratchRead(op, n.Args) {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+		key := "(" + n.Func
+		for _, a := range n.Args {
+			k, ok := l.invariant(a, eff)
+			if !ok {
+				return "", false
+			}
+			key += " " + k
+		}
+		return key + ")", true
+	}
+	return "", false
+}
+
+// scratchRead indique si op lit, avec les arguments args, une zone
+// constante de la mémoire sous LocalsBase, où ne vit aucune variable.
+func scratchRead(op Opcode, args []parser.Node) bool {
+	var consts []uint64
+	for _, a := range args {
+		v, ok := constOf(a)
+		if !ok {
+			return false
+		}
+		consts = append(consts, v)
+	}
+	var size uint64
+	switch op {
+	case OP_HASH:
+		size = consts[1]
+	case OP_MLOAD:
+		size = 8
+	case OP_MLOAD16, OP_MLOAD16S:
+		size = 2
+	case OP_MLOAD32, OP_MLOAD32S:
+		size = 4
+	default:
+		return false
+	}
+	return consts[0] <= LocalsBase && size <= LocalsBase-consts[0]
+}
+
+// effects résume nodes (voir loopEffects).
+func (l *loopOpt) effects(nodes ...parser.Node) loopEffects {
+	eff := loopEffects{assigned: make(map[string]int)}
+	target := func(n parser.Node) {
+		if id, ok := n.(*parser.Identifier); ok {
+			eff.assigned[id.Name]++
+		} else {
+			eff.memory = true
+		}
+	}
+	visit := func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.VarDecl:
+			eff.assigned[n.Name]++
+		case *parser.AssignExpr:
+			target(n.Target)
+		case *parser.PostfixExpr:
+			target(n.Operand)
+		case *parser.UnaryExpr:
+			if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+				target(n.Operand)
+			}
+		case *parser.CallExpr:
+			op, _, builtin := Builtin(n.Func)
+			stores := op == OP_SSTORE || op == OP_TSTORE
+			eff.state = eff.state || !builtin || stores
+			eff.memory = eff.memory || !builtin || op.Purity() == Effect && !stores
+			eff.calls = eff.calls || !builtin
+		}
+	}
+	for _, node := range nodes {
+		walk(node, visit)
+	}
+	return eff
+}
+
+// rewrite remplace chaque nœud de node, parents d'abord, par f(nœud) si f
+// l'accepte (ok), sans descendre dans le remplaçant ; sinon rewrite descend
+// dans ses enfants. Une instruction remplacée par nil est retirée de sa
+// liste.
+func rewrite(node parser.Node, f func(parser.Node) (parser.Node, bool)) parser.Node {
+	if node == nil {
+		return nil
+	}
+	if r, ok := f(node); ok {
+		return r
+	}
+	list := func(nodes []parser.Node) []parser.Node {
+		out := nodes[:0]
+		for _, s := range nodes {
+			if s = rewrite(s, f); s != nil {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	switch n := node.(type) {
+	case *parser.Block:
+		n.Stmts = list(n.Stmts)
+	case *parser.InlineExpr:
+		n.Stmts = list(n.Stmts)
+		n.Value = rewrite(n.Value, f)
+	case *parser.VarDecl:
+		n.Init = rewrite(n.Init, f)
+	case *parser.ExprStmt:
+		n.Expr = rewrite(n.Expr, f)
+	case *parser.ReturnStmt:
+		n.Value = rewrite(n.Value, f)
+	case *parser.IfStmt:
+		n.Cond, n.Body, n.Else = rewrite(n.Cond, f), rewrite(n.Body, f), rewrite(n.Else, f)
+	case *parser.WhileStmt:
+		n.Cond, n.Body = rewrite(n.Cond, f), rewrite(n.Body, f)
+	case *parser.ForStmt:
+		n.Init, n.Cond = rewrite(n.Init, f), rewrite(n.Cond, f)
+		n.Post, n.Body = rewrite(n.Post, f), rewrite(n.Body, f)
+	case *parser.BinaryExpr:
+		n.Left, n.Right = rewrite(n.Left, f), rewrite(n.Right, f)
+	case *parser.UnaryExpr:
+		n.Operand = rewrite(n.Operand, f)
+	case *parser.AssignExpr:
+		n.Target, n.Value = rewrite(n.Target, f), rewrite(n.Value, f)
+	case *parser.PostfixExpr:
+		n.Operand = rewrite(n.Operand, f)
+	case *parser.CallExpr:
+		for i, a := range n.Args {
+			n.Args[i] = rewrite(a, f)
+		}
+	case *parser.IndexExpr:
+		n.Array, n.Index = rewrite(n.Array, f), rewrite(n.Index, f)
+	case *parser.MemberExpr:
+		n.Object = rewrite(n.Object, f)
+	case *parser.CastExpr:
+		n.Expr = rewrite(n.Expr, f)
+	}
+	return node
+}
@@ -0,0 +1,70 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+func TestOptimizeLoops(t *testing.T) {
+	tests := []struct {
+		name                       string
+		src                        string
+		hoisted, reduced, unrolled int
+	}{
+		{"unroll", `I64 s = SLoad(0);
+for (I64 k = 0; k < 4; k++)
+  s = s * 2 + SLoad(k);
+SStore(1, s);
+`, 0, 0, 1},
+		{"forced", `I64 s = 1;
+#pragma unroll
+for (I64 k = 0; k < 20; k += 2)
+  s = s * 3 + k;
+SStore(2, s);
+`, 0, 0, 1},
+		{"induction", `I64 n = SLoad(0) + 6;
+for (I64 i = 0; i < n; i++)
+  SStore(i * 24, i * 24 + SLoad(i * 24 + 8) * (i * 24));
+`, 0, 1, 0},
+		{"invariant", `I64 base = SLoad(0) + 1;
+I64 n = SLoad(1) + 5;
+for (I64 i = 0; i < n; i++)
+  SStore(i, MulMod(base, base, 1000003) + i);
+`, 1, 0, 0},
+		// La boucle écrit le stockage : SLoad(5) peut changer.
+		{"storage-written", `I64 n = SLoad(1) + 5;
+for (I64 i = 0; i < n; i++)
+  SStore(5, SLoad(5) + i);
+`, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg, code := inlineAt(t, tt.src, O2)
+			if diag.HasErrors(cg.Diagnostics) {
+				t.Fatal(messages(cg, diag.Error))
+			}
+			if cg.Hoisted != tt.hoisted || cg.Reduced != tt.reduced || cg.Unrolled != tt.unrolled {
+				t.Errorf("%d hoisted, %d reduced, %d unrolled, want %d, %d, %d",
+					cg.Hoisted, cg.Reduced, cg.Unrolled, tt.hoisted, tt.reduced, tt.unrolled)
+			}
+			if got, want := run(code).String(), run(compileAt(t, tt.src, O1)).String(); got != want {
+				t.Errorf("-O2: %s, -O1: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestForcedUnrollWarning(t *testing.T) {
+	src := `I64 n = SLoad(0);
+[[unroll]]
+for (I64 k = 0; k < n; k++)
+  SStore(k, 1);
+`
+	cg, _ := inlineAt(t, src, O2)
+	warns := messages(cg, diag.Warning)
+	if cg.Unrolled != 0 || len(warns) != 1 || !strings.HasPrefix(warns[0], "loop not unrolled: ") {
+		t.Errorf("%d unrolled, warnings %q", cg.Unrolled, warns)
+	}
+}
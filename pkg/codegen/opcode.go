@@ -1,6 +1,10 @@
 package codegen
 
-import "fmt"
+import (
+	"fmt"
+
+	"holyc-compiler/pkg/lexer"
+)
 
 type Opcode byte
 
@@ -124,141 +128,186 @@ const (
 	// DUP et SWAP (1-8 seulement dans HolyCVM)
 	OP_DUP1  Opcode = 0x80
 	OP_DUP2  Opcode = 0x81
+	OP_DUP3  Opcode = 0x82
+	OP_DUP4  Opcode = 0x83
+	OP_DUP5  Opcode = 0x84
+	OP_DUP6  Opcode = 0x85
+	OP_DUP7  Opcode = 0x86
+	OP_DUP8  Opcode = 0x87
 	OP_SWAP1 Opcode = 0x90
 	OP_SWAP2 Opcode = 0x91
+	OP_SWAP3 Opcode = 0x92
+	OP_SWAP4 Opcode = 0x93
+	OP_SWAP5 Opcode = 0x94
+	OP_SWAP6 Opcode = 0x95
+	OP_SWAP7 Opcode = 0x96
+	OP_SWAP8 Opcode = 0x97
 
 	// Contrôle
-	OP_RETURN Opcode = 0xF3 // offset, size → retourne données
-	OP_REVERT Opcode = 0xFD // offset, size → revert
+	OP_RETURN  Opcode = 0xF3 // offset, size → retourne données
+	OP_REVERT  Opcode = 0xFD // offset, size → revert
+	OP_INVALID Opcode = 0xFE // Instruction invalide (abort)
+)
+
+// Purity classe un opcode selon ce dont dépend son résultat et ce qu'il
+// modifie. Les opcodes de pile (PUSH, DUP, SWAP, POP) sont purs.
+type Purity int
+
+const (
+	Effect     Purity = iota // modifie la mémoire, le stockage ou le flot d'exécution
+	Pure                     // ne dépend que de ses opérandes
+	Context                  // lit le contexte de l'exécution, fixe tant qu'elle dure
+	StateRead                // lit le stockage (SLOAD, TLOAD) : stable entre deux écritures
+	MemoryRead               // lit la mémoire, où vivent aussi les variables
+	Volatile                 // change à chaque instruction (GAS, PC)
 )
 
+// opcodeInfo décrit chaque opcode : mnémonique, gas, effet de pile et
+// pureté. La VM n'a ni CALL ni CREATE : aucun transfert de valeur ne change
+// BALANCE ou SELFBALANCE, ni aucun appel RETURNDATASIZE, pendant
+// l'exécution ; ils sont donc Context.
 var opcodeInfo = map[Opcode]struct {
 	Name    string
 	Gas     int
 	Args    int // nombre d'opérandes consommées sur la pile
 	Results int // nombre de résultats poussés sur la pile
+	Purity  Purity
 }{
-	OP_STOP:       {"STOP", 0, 0, 0},
-	OP_ADD:        {"ADD", 3, 2, 1},
-	OP_MUL:        {"MUL", 5, 2, 1},
-	OP_SUB:        {"SUB", 3, 2, 1},
-	OP_DIV:        {"DIV", 5, 2, 1},
-	OP_SDIV:       {"SDIV", 5, 2, 1},
-	OP_MOD:        {"MOD", 5, 2, 1},
-	OP_SMOD:       {"SMOD", 5, 2, 1},
-	OP_ADDMOD:     {"ADDMOD", 8, 3, 1},
-	OP_MULMOD:     {"MULMOD", 8, 3, 1},
-	OP_EXP:        {"EXP", 8, 2, 1},
-	OP_SIGNEXTEND: {"SIGNEXTEND", 5, 2, 1},
-	OP_MULHI:      {"MULHI", 5, 2, 1},
-	OP_MODEXP:     {"MODEXP", 20, 3, 1},
-	OP_ADDCARRY:   {"ADDCARRY", 5, 3, 2},
-	OP_FIXMUL18:   {"FIXMUL18", 5, 2, 1},
+	OP_STOP:       {"STOP", 0, 0, 0, Effect},
+	OP_ADD:        {"ADD", 3, 2, 1, Pure},
+	OP_MUL:        {"MUL", 5, 2, 1, Pure},
+	OP_SUB:        {"SUB", 3, 2, 1, Pure},
+	OP_DIV:        {"DIV", 5, 2, 1, Pure},
+	OP_SDIV:       {"SDIV", 5, 2, 1, Pure},
+	OP_MOD:        {"MOD", 5, 2, 1, Pure},
+	OP_SMOD:       {"SMOD", 5, 2, 1, Pure},
+	OP_ADDMOD:     {"ADDMOD", 8, 3, 1, Pure},
+	OP_MULMOD:     {"MULMOD", 8, 3, 1, Pure},
+	OP_EXP:        {"EXP", 8, 2, 1, Pure},
+	OP_SIGNEXTEND: {"SIGNEXTEND", 5, 2, 1, Pure},
+	OP_MULHI:      {"MULHI", 5, 2, 1, Pure},
+	OP_MODEXP:     {"MODEXP", 20, 3, 1, Pure},
+	OP_ADDCARRY:   {"ADDCARRY", 5, 3, 2, Pure},
+	OP_FIXMUL18:   {"FIXMUL18", 5, 2, 1, Pure},
 	// Comparaison et logique
-	OP_LT:     {"LT", 3, 2, 1},
-	OP_GT:     {"GT", 3, 2, 1},
-	OP_SLT:    {"SLT", 3, 2, 1},
-	OP_SGT:    {"SGT", 3, 2, 1},
-	OP_EQ:     {"EQ", 3, 2, 1},
-	OP_ISZERO: {"ISZERO", 3, 1, 1},
-	OP_AND:    {"AND", 3, 2, 1},
-	OP_OR:     {"OR", 3, 2, 1},
-	OP_XOR:    {"XOR", 3, 2, 1},
-	OP_NOT:    {"NOT", 3, 1, 1},
-	OP_SHL:    {"SHL", 3, 2, 1},
-	OP_SHR:    {"SHR", 3, 2, 1},
-	OP_SAR:      {"SAR", 3, 2, 1},
-	OP_CLZ:      {"CLZ", 3, 1, 1},
-	OP_FIXDIV18: {"FIXDIV18", 5, 2, 1},
+	OP_LT:     {"LT", 3, 2, 1, Pure},
+	OP_GT:     {"GT", 3, 2, 1, Pure},
+	OP_SLT:    {"SLT", 3, 2, 1, Pure},
+	OP_SGT:    {"SGT", 3, 2, 1, Pure},
+	OP_EQ:     {"EQ", 3, 2, 1, Pure},
+	OP_ISZERO: {"ISZERO", 3, 1, 1, Pure},
+	OP_AND:    {"AND", 3, 2, 1, Pure},
+	OP_OR:     {"OR", 3, 2, 1, Pure},
+	OP_XOR:    {"XOR", 3, 2, 1, Pure},
+	OP_NOT:    {"NOT", 3, 1, 1, Pure},
+	OP_BYTE:   {"BYTE", 3, 2, 1, Pure},
+	OP_SHL:    {"SHL", 3, 2, 1, Pure},
+	OP_SHR:    {"SHR", 3, 2, 1, Pure},
+	OP_SAR:      {"SAR", 3, 2, 1, Pure},
+	OP_CLZ:      {"CLZ", 3, 1, 1, Pure},
+	OP_FIXDIV18: {"FIXDIV18", 5, 2, 1, Pure},
 	// Hash et bits
-	OP_HASH:   {"HASH", 30, 2, 1},
-	OP_ROL:    {"ROL", 3, 2, 1},
-	OP_ROR:    {"ROR", 3, 2, 1},
-	OP_POPCNT: {"POPCNT", 3, 1, 1},
-	OP_BSWAP:  {"BSWAP", 3, 1, 1},
+	OP_HASH:   {"HASH", 30, 2, 1, MemoryRead},
+	OP_ROL:    {"ROL", 3, 2, 1, Pure},
+	OP_ROR:    {"ROR", 3, 2, 1, Pure},
+	OP_POPCNT: {"POPCNT", 3, 1, 1, Pure},
+	OP_BSWAP:  {"BSWAP", 3, 1, 1, Pure},
 
 	// État du contrat
-	OP_ADDRESS:        {"ADDRESS", 2, 0, 1},
-	OP_BALANCE:        {"BALANCE", 700, 1, 1},
-	OP_ORIGIN:         {"ORIGIN", 2, 0, 1},
-	OP_CALLER:         {"CALLER", 2, 0, 1},
-	OP_CALLVALUE:      {"CALLVALUE", 2, 0, 1},
-	OP_CALLDATALOAD:   {"CALLDATALOAD", 3, 1, 1},
-	OP_CALLDATASIZE:   {"CALLDATASIZE", 2, 0, 1},
-	OP_CALLDATACOPY:   {"CALLDATACOPY", 3, 3, 0},
-	OP_CODESIZE:       {"CODESIZE", 2, 0, 1},
-	OP_CODECOPY:       {"CODECOPY", 3, 3, 0},
-	OP_GASPRICE:       {"GASPRICE", 2, 0, 1},
-	OP_EXTCODESIZE:    {"EXTCODESIZE", 700, 1, 1},
-	OP_EXTCODECOPY:    {"EXTCODECOPY", 700, 4, 0},
-	OP_RETURNDATASIZE: {"RETURNDATASIZE", 2, 0, 1},
-	OP_RETURNDATACOPY: {"RETURNDATACOPY", 3, 3, 0},
-	OP_EXTCODEHASH:    {"EXTCODEHASH", 700, 1, 1},
+	OP_ADDRESS:        {"ADDRESS", 2, 0, 1, Context},
+	OP_BALANCE:        {"BALANCE", 700, 1, 1, Context},
+	OP_ORIGIN:         {"ORIGIN", 2, 0, 1, Context},
+	OP_CALLER:         {"CALLER", 2, 0, 1, Context},
+	OP_CALLVALUE:      {"CALLVALUE", 2, 0, 1, Context},
+	OP_CALLDATALOAD:   {"CALLDATALOAD", 3, 1, 1, Context},
+	OP_CALLDATASIZE:   {"CALLDATASIZE", 2, 0, 1, Context},
+	OP_CALLDATACOPY:   {"CALLDATACOPY", 3, 3, 0, Effect},
+	OP_CODESIZE:       {"CODESIZE", 2, 0, 1, Context},
+	OP_CODECOPY:       {"CODECOPY", 3, 3, 0, Effect},
+	OP_GASPRICE:       {"GASPRICE", 2, 0, 1, Context},
+	OP_EXTCODESIZE:    {"EXTCODESIZE", 700, 1, 1, Context},
+	OP_EXTCODECOPY:    {"EXTCODECOPY", 700, 4, 0, Effect},
+	OP_RETURNDATASIZE: {"RETURNDATASIZE", 2, 0, 1, Context},
+	OP_RETURNDATACOPY: {"RETURNDATACOPY", 3, 3, 0, Effect},
+	OP_EXTCODEHASH:    {"EXTCODEHASH", 700, 1, 1, Context},
 
 	// Contexte de bloc
-	OP_BLOCKHASH:   {"BLOCKHASH", 20, 1, 1},
-	OP_COINBASE:    {"COINBASE", 2, 0, 1},
-	OP_TIMESTAMP:   {"TIMESTAMP", 2, 0, 1},
-	OP_NUMBER:      {"NUMBER", 2, 0, 1},
-	OP_PREVRANDAO:  {"PREVRANDAO", 2, 0, 1},
-	OP_GASLIMIT:    {"GASLIMIT", 2, 0, 1},
-	OP_CHAINID:     {"CHAINID", 2, 0, 1},
-	OP_SELFBALANCE: {"SELFBALANCE", 5, 0, 1},
-	OP_BASEFEE:     {"BASEFEE", 2, 0, 1},
+	OP_BLOCKHASH:   {"BLOCKHASH", 20, 1, 1, Context},
+	OP_COINBASE:    {"COINBASE", 2, 0, 1, Context},
+	OP_TIMESTAMP:   {"TIMESTAMP", 2, 0, 1, Context},
+	OP_NUMBER:      {"NUMBER", 2, 0, 1, Context},
+	OP_PREVRANDAO:  {"PREVRANDAO", 2, 0, 1, Context},
+	OP_GASLIMIT:    {"GASLIMIT", 2, 0, 1, Context},
+	OP_CHAINID:     {"CHAINID", 2, 0, 1, Context},
+	OP_SELFBALANCE: {"SELFBALANCE", 5, 0, 1, Context},
+	OP_BASEFEE:     {"BASEFEE", 2, 0, 1, Context},
 
 	// Pile et mémoire
-	OP_POP:      {"POP", 2, 1, 0},
-	OP_MLOAD:    {"MLOAD", 3, 1, 1},
-	OP_MSTORE:   {"MSTORE", 3, 2, 0},
-	OP_MSTORE8:  {"MSTORE8", 3, 2, 0},
-	OP_SLOAD:    {"SLOAD", 800, 1, 1},
-	OP_SSTORE:   {"SSTORE", 0, 2, 0}, // gas dynamique (cold/warm/refund)
-	OP_JUMP:     {"JUMP", 8, 1, 0},
-	OP_JUMPI:    {"JUMPI", 10, 2, 0},
-	OP_PC:       {"PC", 2, 0, 1},
-	OP_MSIZE:    {"MSIZE", 2, 0, 1},
-	OP_GAS:      {"GAS", 2, 0, 1},
-	OP_JUMPDEST: {"JUMPDEST", 1, 0, 0},
-	OP_TLOAD:    {"TLOAD", 100, 1, 1},
-	OP_TSTORE:   {"TSTORE", 100, 2, 0},
-	OP_MCOPY:    {"MCOPY", 3, 3, 0},
-	OP_PUSH0:    {"PUSH0", 2, 0, 1},
+	OP_POP:      {"POP", 2, 1, 0, Pure},
+	OP_MLOAD:    {"MLOAD", 3, 1, 1, MemoryRead},
+	OP_MSTORE:   {"MSTORE", 3, 2, 0, Effect},
+	OP_MSTORE8:  {"MSTORE8", 3, 2, 0, Effect},
+	OP_SLOAD:    {"SLOAD", 800, 1, 1, StateRead},
+	OP_SSTORE:   {"SSTORE", 0, 2, 0, Effect}, // gas dynamique (cold/warm/refund)
+	OP_JUMP:     {"JUMP", 8, 1, 0, Effect},
+	OP_JUMPI:    {"JUMPI", 10, 2, 0, Effect},
+	OP_PC:       {"PC", 2, 0, 1, Volatile},
+	OP_MSIZE:    {"MSIZE", 2, 0, 1, MemoryRead},
+	OP_GAS:      {"GAS", 2, 0, 1, Volatile},
+	OP_JUMPDEST: {"JUMPDEST", 1, 0, 0, Effect},
+	OP_TLOAD:    {"TLOAD", 100, 1, 1, StateRead},
+	OP_TSTORE:   {"TSTORE", 100, 2, 0, Effect},
+	OP_MCOPY:    {"MCOPY", 3, 3, 0, Effect},
+	OP_PUSH0:    {"PUSH0", 2, 0, 1, Pure},
 
 	// Mémoire sous-64 bits
-	OP_MLOAD16:  {"MLOAD16", 3, 1, 1},
-	OP_MLOAD16S: {"MLOAD16S", 3, 1, 1},
-	OP_MLOAD32:  {"MLOAD32", 3, 1, 1},
-	OP_MLOAD32S: {"MLOAD32S", 3, 1, 1},
-	OP_MSTORE16: {"MSTORE16", 3, 2, 0},
-	OP_MSTORE32: {"MSTORE32", 3, 2, 0},
-	OP_SEXT8:    {"SEXT8", 3, 1, 1},
-	OP_SEXT16:   {"SEXT16", 3, 1, 1},
+	OP_MLOAD16:  {"MLOAD16", 3, 1, 1, MemoryRead},
+	OP_MLOAD16S: {"MLOAD16S", 3, 1, 1, MemoryRead},
+	OP_MLOAD32:  {"MLOAD32", 3, 1, 1, MemoryRead},
+	OP_MLOAD32S: {"MLOAD32S", 3, 1, 1, MemoryRead},
+	OP_MSTORE16: {"MSTORE16", 3, 2, 0, Effect},
+	OP_MSTORE32: {"MSTORE32", 3, 2, 0, Effect},
+	OP_SEXT8:    {"SEXT8", 3, 1, 1, Pure},
+	OP_SEXT16:   {"SEXT16", 3, 1, 1, Pure},
 
 	// Sign-extension et troncature
-	OP_SEXT32:  {"SEXT32", 3, 1, 1},
-	OP_TRUNC8:  {"TRUNC8", 3, 1, 1},
-	OP_TRUNC16: {"TRUNC16", 3, 1, 1},
-	OP_TRUNC32: {"TRUNC32", 3, 1, 1},
+	OP_SEXT32:  {"SEXT32", 3, 1, 1, Pure},
+	OP_TRUNC8:  {"TRUNC8", 3, 1, 1, Pure},
+	OP_TRUNC16: {"TRUNC16", 3, 1, 1, Pure},
+	OP_TRUNC32: {"TRUNC32", 3, 1, 1, Pure},
 
 	// PUSH1-PUSH8 : gas=3 (N octets LE, zero-étendus à 64 bits)
-	OP_PUSH1: {"PUSH1", 3, 0, 1},
-	OP_PUSH2: {"PUSH2", 3, 0, 1},
-	OP_PUSH3: {"PUSH3", 3, 0, 1},
-	OP_PUSH4: {"PUSH4", 3, 0, 1},
-	OP_PUSH5: {"PUSH5", 3, 0, 1},
-	OP_PUSH6: {"PUSH6", 3, 0, 1},
-	OP_PUSH7: {"PUSH7", 3, 0, 1},
-	OP_PUSH8: {"PUSH8", 3, 0, 1},
-
-	// DUP/SWAP
-	OP_DUP1:  {"DUP1", 3, 1, 2},
-	OP_DUP2:  {"DUP2", 3, 2, 3},
-	OP_SWAP1: {"SWAP1", 3, 2, 2},
-	OP_SWAP2: {"SWAP2", 3, 3, 3},
+	OP_PUSH1: {"PUSH1", 3, 0, 1, Pure},
+	OP_PUSH2: {"PUSH2", 3, 0, 1, Pure},
+	OP_PUSH3: {"PUSH3", 3, 0, 1, Pure},
+	OP_PUSH4: {"PUSH4", 3, 0, 1, Pure},
+	OP_PUSH5: {"PUSH5", 3, 0, 1, Pure},
+	OP_PUSH6: {"PUSH6", 3, 0, 1, Pure},
+	OP_PUSH7: {"PUSH7", 3, 0, 1, Pure},
+	OP_PUSH8: {"PUSH8", 3, 0, 1, Pure},
+
+	// DUP/SWAP : Args est la profondeur lue, DUPn pousse une copie de plus
+	OP_DUP1:  {"DUP1", 3, 1, 2, Pure},
+	OP_DUP2:  {"DUP2", 3, 2, 3, Pure},
+	OP_DUP3:  {"DUP3", 3, 3, 4, Pure},
+	OP_DUP4:  {"DUP4", 3, 4, 5, Pure},
+	OP_DUP5:  {"DUP5", 3, 5, 6, Pure},
+	OP_DUP6:  {"DUP6", 3, 6, 7, Pure},
+	OP_DUP7:  {"DUP7", 3, 7, 8, Pure},
+	OP_DUP8:  {"DUP8", 3, 8, 9, Pure},
+	OP_SWAP1: {"SWAP1", 3, 2, 2, Pure},
+	OP_SWAP2: {"SWAP2", 3, 3, 3, Pure},
+	OP_SWAP3: {"SWAP3", 3, 4, 4, Pure},
+	OP_SWAP4: {"SWAP4", 3, 5, 5, Pure},
+	OP_SWAP5: {"SWAP5", 3, 6, 6, Pure},
+	OP_SWAP6: {"SWAP6", 3, 7, 7, Pure},
+	OP_SWAP7: {"SWAP7", 3, 8, 8, Pure},
+	OP_SWAP8: {"SWAP8", 3, 9, 9, Pure},
 
 	// Contrôle
-	OP_RETURN: {"RETURN", 0, 2, 0},
-	OP_REVERT: {"REVERT", 0, 2, 0},
+	OP_RETURN:  {"RETURN", 0, 2, 0, Effect},
+	OP_REVERT:  {"REVERT", 0, 2, 0, Effect},
+	OP_INVALID: {"INVALID", 0, 0, 0, Effect},
 }
 
 func (op Opcode) String() string {
@@ -268,6 +317,16 @@ func (op Opcode) String() string {
 	return fmt.Sprintf("UNKNOWN(0x%02X)", byte(op))
 }
 
+// OpcodeByName retrouve un opcode à partir de son mnémonique ("SLOAD", "PUSH1"...).
+func OpcodeByName(name string) (Opcode, bool) {
+	for op, info := range opcodeInfo {
+		if info.Name == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
 // IsPush retourne true si l'opcode est un PUSH1-PUSH8.
 func (op Opcode) IsPush() bool {
 	return op >= OP_PUSH1 && op <= OP_PUSH8
@@ -281,18 +340,46 @@ func (op Opcode) PushSize() int {
 	return 0
 }
 
+// StackEffect retourne le nombre d'éléments que op lit sur la pile et le
+// nombre qu'il y laisse (voir opcodeInfo).
+func (op Opcode) StackEffect() (args, results int) {
+	info := opcodeInfo[op]
+	return info.Args, info.Results
+}
+
+// Purity retourne la classe de pureté de op ; un opcode inconnu est un Effect.
+func (op Opcode) Purity() Purity {
+	if info, ok := opcodeInfo[op]; ok {
+		return info.Purity
+	}
+	return Effect
+}
+
 // Instruction représente une instruction bytecode avec opérande optionnel.
+// Label relie un JUMPDEST aux PUSH qui le visent : tant que le code est
+// réordonné, l'opérande de ces PUSH est recalculé par ResolveLabels.
+// Span situe dans le source le nœud qui a produit l'instruction (nulle si
+// elle ne vient d'aucun, ou si elle a été relue d'un .hcb) ; voir SourceMap.
 type Instruction struct {
 	Op      Opcode
 	Operand int64 // utilisé uniquement par PUSH
+	Label   int   // 0 = aucune étiquette
+	Span    lexer.Span
 }
 
-// Gas retourne le coût en gas de l'instruction.
+// Size retourne la taille encodée de l'instruction en octets.
+func (inst Instruction) Size() int {
+	return 1 + inst.Op.PushSize()
+}
+
+// Gas retourne le coût en gas de l'instruction selon le barème par défaut.
 func (inst Instruction) Gas() int {
-	if info, ok := opcodeInfo[inst.Op]; ok {
-		return info.Gas
-	}
-	return 0
+	return defaultSchedule.Cost(inst.Op)
+}
+
+// GasIn retourne le coût statique de l'instruction selon le barème s.
+func (inst Instruction) GasIn(s *GasSchedule) int {
+	return s.Cost(inst.Op)
 }
 
 func (inst Instruction) String() string {
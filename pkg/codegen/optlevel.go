@@ -0,0 +1,71 @@
+package codegen
+
+import "fmt"
+
+// OptLevel est un niveau d'optimisation prédéfini : il fixe les passes
+// exécutées et l'objectif des décisions de coût.
+type OptLevel int
+
+const (
+	O0 OptLevel = iota // aucune passe : traduction directe de l'AST
+	O1                 // repliement des constantes et optimiseur à lucarne
+	O2                 // toutes les passes, pour le gas à l'exécution
+	Os                 // toutes les passes, pour la taille du bytecode
+)
+
+func (l OptLevel) String() string {
+	switch l {
+	case O1:
+		return "-O1"
+	case O2:
+		return "-O2"
+	case Os:
+		return "-Os"
+	}
+	return "-O0"
+}
+
+// ParseOptLevel lit un niveau d'optimisation : "-O0", "-O1", "-O2", "-Os",
+// ou "-O" pour "-O2".
+func ParseOptLevel(s string) (OptLevel, error) {
+	switch s {
+	case "-O0":
+		return O0, nil
+	case "-O1":
+		return O1, nil
+	case "-O", "-O2":
+		return O2, nil
+	case "-Os":
+		return Os, nil
+	}
+	return O0, fmt.Errorf("unknown optimization level %q (want -O0, -O1, -O2 or -Os)", s)
+}
+
+// Passes désigne les passes d'optimisation exécutées par Generate, ou par
+// la compilation par l'IR (ir.Optimize, ir.Schedule).
+type Passes struct {
+	Fold        bool      // FoldConstants sur l'AST avant la génération
+	Strength    bool      // réduction de force et matérialisation des constantes
+	Peephole    bool      // PeepholeRules sur le code généré
+	TailCalls   bool      // appels récursifs terminaux changés en boucles (EliminateTailCalls)
+	Inline      bool      // inlining des appels de fonctions utilisateur
+	DeadCode    bool      // EliminateDeadCode sur l'AST, et rien d'émis après un saut ou une fin d'exécution
+	Loops       bool      // LICM, variables d'induction et déroulage (OptimizeLoops)
+	Storage     bool      // cache des SLOAD et fusion des SSTORE (OptimizeStorage)
+	CSE         bool      // sous-expressions communes (EliminateCommonSubexpressions)
+	StackLocals bool      // variables sur la pile, par ir.Schedule seulement (--ir) : Generate les garde en mémoire
+	Cost        CostModel // objectif des décisions de coût
+}
+
+// Passes retourne les passes du niveau l.
+func (l OptLevel) Passes() Passes {
+	switch l {
+	case O1:
+		return Passes{Fold: true, Peephole: true}
+	case O2:
+		return Passes{Fold: true, Strength: true, Peephole: true, TailCalls: true, Inline: true, DeadCode: true, Loops: true, Storage: true, CSE: true, StackLocals: true, Cost: CostGas}
+	case Os:
+		return Passes{Fold: true, Strength: true, Peephole: true, TailCalls: true, Inline: true, DeadCode: true, Loops: true, Storage: true, CSE: true, StackLocals: true, Cost: CostSize}
+	}
+	return Passes{}
+}
@@ -0,0 +1,143 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+var levels = []OptLevel{O0, O1, O2, Os}
+
+func TestParseOptLevel(t *testing.T) {
+	for _, l := range levels {
+		if got, err := ParseOptLevel(l.String()); err != nil || got != l {
+			t.Errorf("ParseOptLevel(%q) = %v, %v", l, got, err)
+		}
+	}
+	if got, err := ParseOptLevel("-O"); err != nil || got != O2 {
+		t.Errorf("ParseOptLevel(\"-O\") = %v, %v, want -O2", got, err)
+	}
+	if _, err := ParseOptLevel("-O3"); err == nil {
+		t.Error("ParseOptLevel(\"-O3\"): no error")
+	}
+	if O0.Passes() != (Passes{}) {
+		t.Errorf("-O0 runs passes: %+v", O0.Passes())
+	}
+	if O2.Passes().Cost != CostGas || Os.Passes().Cost != CostSize {
+		t.Error("-O2 and -Os cost models")
+	}
+}
+
+// compileAt compile src au niveau l ; une erreur fait échouer le test.
+func compileAt(t *testing.T, src string, l OptLevel) []Instruction {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	cg := NewCodeGen()
+	cg.Passes = l.Passes()
+	code := cg.Generate(prog)
+	if diag.HasErrors(cg.Diagnostics) {
+		t.Fatalf("%s: %s", l, strings.Join(messages(cg, diag.Error), "; "))
+	}
+	return code
+}
+
+// agree vérifie que src se comporte à chaque niveau comme à -O0.
+func agree(t *testing.T, src string) outcome {
+	t.Helper()
+	want := run(compileAt(t, src, O0))
+	for _, l := range levels[1:] {
+		if got := run(compileAt(t, src, l)); got.String() != want.String() {
+			t.Errorf("%s: got %s, want %s (as at -O0)", l, got, want)
+		}
+	}
+	return want
+}
+
+func TestLevelsAgreeOnTestFiles(t *testing.T) {
+	files, err := filepath.Glob("../../tests/*.HC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test files in tests/")
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			agree(t, string(src))
+		})
+	}
+}
+
+// Programmes dont le résultat est dans le stockage, pour que la
+// comparaison entre niveaux porte sur des valeurs calculées.
+var programs = map[string]string{
+	"loop": `I64 s = 0;
+for (I64 i = 0; i < 10; i++)
+  s = s * 3 + SLoad(i) + i;
+SStore(1, s);
+`,
+	"unroll": `I64 s = 1;
+#pragma unroll
+for (I64 k = 0; k < 4; k++)
+  s = s * 2 + k;
+SStore(2, s);
+`,
+	"storage": `I64 a = SLoad(1);
+I64 b = SLoad(1) + SLoad(2);
+SStore(3, a);
+SStore(3, b * 2);
+SStore(4, a ^ b);
+`,
+	"branches": `I64 x = SLoad(0) - 3;
+I64 y = 0;
+while (x < 4) {
+  if (x % 2 == 0) y += x;
+  else y -= 1;
+  x++;
+}
+SStore(1, y);
+SStore(2, x);
+`,
+	"constants": `#define K 0x1000000000000000
+I64 x = SLoad(0) + 3;
+SStore(1, x * K);
+SStore(2, x / 8 + ~0xFF);
+SStore(3, Mod(x - 100, 64) - 1);
+SStore(4, x ` + "`" + ` 3);
+`,
+}
+
+func TestLevelsAgreeOnPrograms(t *testing.T) {
+	for name, src := range programs {
+		t.Run(name, func(t *testing.T) {
+			agree(t, src)
+		})
+	}
+}
+
+func TestDeadCodeRemoval(t *testing.T) {
+	src := `SStore(1, 2);
+return;
+SStore(3, 4);
+`
+	o1, o2 := compileAt(t, src, O1), compileAt(t, src, O2)
+	if got, want := listing(o2), "PUSH1 0x2; PUSH1 0x1; SSTORE; PUSH0; PUSH0; RETURN"; got != want {
+		t.Errorf("-O2: %s, want %s", got, want)
+	}
+	if got, want := run(o2).String(), run(o1).String(); got != want {
+		t.Errorf("-O2: %s, -O1: %s", got, want)
+	}
+}
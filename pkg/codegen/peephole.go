@@ -0,0 +1,187 @@
+package codegen
+
+// PeepholeRule réécrit une fenêtre d'instructions consécutives : chaque
+// élément de Pattern doit accepter l'instruction correspondante, puis
+// Rewrite fournit le remplacement (ok = false laisse la fenêtre intacte).
+// Les motifs ne contiennent jamais de JUMPDEST qu'ils ne recopient pas :
+// une fenêtre ne franchit donc pas une destination de saut.
+type PeepholeRule struct {
+	Name    string
+	Pattern []func(Instruction) bool
+	Rewrite func(m []Instruction) (repl []Instruction, ok bool)
+}
+
+func is(op Opcode) func(Instruction) bool {
+	return func(inst Instruction) bool { return inst.Op == op }
+}
+
+func oneOf(ops ...Opcode) func(Instruction) bool {
+	return func(inst Instruction) bool {
+		for _, op := range ops {
+			if inst.Op == op {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func anyPush(inst Instruction) bool { return inst.Op == OP_PUSH0 || inst.Op.IsPush() }
+
+// constPush accepte un PUSH de constante (pas une adresse de saut).
+func constPush(inst Instruction) bool { return anyPush(inst) && inst.Label == 0 }
+
+func pushOf(v int64) func(Instruction) bool {
+	return func(inst Instruction) bool { return constPush(inst) && inst.Operand == v }
+}
+
+func anyDup(inst Instruction) bool { return inst.Op >= OP_DUP1 && inst.Op <= OP_DUP8 }
+
+// commutative : opérateurs dont les deux opérandes du sommet peuvent être échangés.
+var commutative = oneOf(OP_ADD, OP_MUL, OP_AND, OP_OR, OP_XOR, OP_EQ, OP_ADDMOD, OP_MULMOD)
+
+// boolean : opcodes dont le résultat vaut toujours 0 ou 1.
+var boolean = oneOf(OP_EQ, OP_LT, OP_GT, OP_SLT, OP_SGT, OP_ISZERO)
+
+// pureOp accepte les opcodes purs à n opérandes et un résultat que Eval
+// sait calculer.
+func pureOp(n int) func(Instruction) bool {
+	return func(inst Instruction) bool {
+		info, ok := opcodeInfo[inst.Op]
+		if !ok || info.Purity != Pure || info.Args != n || info.Results != 1 {
+			return false
+		}
+		_, ok = Eval(inst.Op, make([]uint64, n)...)
+		return ok
+	}
+}
+
+func drop(m []Instruction) ([]Instruction, bool) { return nil, true }
+
+func keep(idx ...int) func(m []Instruction) ([]Instruction, bool) {
+	return func(m []Instruction) ([]Instruction, bool) {
+		out := make([]Instruction, len(idx))
+		for i, k := range idx {
+			out[i] = m[k]
+		}
+		return out, true
+	}
+}
+
+// fold remplace les PUSH de m[:len(m)-1] et l'opcode pur final par le PUSH
+// de son résultat, sauf si ce PUSH est plus long que la fenêtre : une
+// constante matérialisée pour la taille (PUSH1 0xFF NOT) reste en l'état.
+func fold(m []Instruction) ([]Instruction, bool) {
+	n := len(m) - 1
+	args := make([]uint64, n)
+	for i := range args {
+		args[i] = uint64(m[n-1-i].Operand) // args[0] = sommet
+	}
+	res, ok := Eval(m[n].Op, args...)
+	if !ok || len(res) != 1 {
+		return nil, false
+	}
+	push := PushInstr(int64(res[0]))
+	size := 0
+	for _, inst := range m {
+		size += inst.Size()
+	}
+	return []Instruction{push}, push.Size() <= size
+}
+
+// PeepholeRules est la table des règles appliquées dès -O1, dans l'ordre où
+// elles sont essayées à chaque position.
+var PeepholeRules = []PeepholeRule{
+	{"swap1-swap1", []func(Instruction) bool{is(OP_SWAP1), is(OP_SWAP1)}, drop},
+	{"not-not", []func(Instruction) bool{is(OP_NOT), is(OP_NOT)}, drop},
+	{"push-pop", []func(Instruction) bool{anyPush, is(OP_POP)}, drop},
+	{"dup-pop", []func(Instruction) bool{anyDup, is(OP_POP)}, drop},
+	{"iszero-iszero-jumpi", []func(Instruction) bool{is(OP_ISZERO), is(OP_ISZERO), anyPush, is(OP_JUMPI)}, keep(2, 3)},
+	{"bool-iszero-iszero", []func(Instruction) bool{boolean, is(OP_ISZERO), is(OP_ISZERO)}, keep(0)},
+	{"swap1-commutative", []func(Instruction) bool{is(OP_SWAP1), commutative}, keep(1)},
+	{"dup1-swap1", []func(Instruction) bool{is(OP_DUP1), is(OP_SWAP1)}, keep(0)},
+	{"push-push-swap1", []func(Instruction) bool{anyPush, anyPush, is(OP_SWAP1)}, keep(1, 0)},
+	{"add-zero", []func(Instruction) bool{pushOf(0), oneOf(OP_ADD, OP_OR, OP_XOR, OP_SHL, OP_SHR, OP_SAR)}, drop},
+	{"mul-one", []func(Instruction) bool{pushOf(1), is(OP_MUL)}, drop},
+	{"and-ones", []func(Instruction) bool{pushOf(-1), is(OP_AND)}, drop},
+	{"fold-unary", []func(Instruction) bool{constPush, pureOp(1)}, fold},
+	{"fold-binary", []func(Instruction) bool{constPush, constPush, pureOp(2)}, fold},
+	{"fold-ternary", []func(Instruction) bool{constPush, constPush, constPush, pureOp(3)}, fold},
+	{"jump-to-next", []func(Instruction) bool{anyPush, is(OP_JUMP), is(OP_JUMPDEST)}, func(m []Instruction) ([]Instruction, bool) {
+		return m[2:], m[0].Label != 0 && m[0].Label == m[2].Label
+	}},
+}
+
+// PeepholeResult est le résultat de Peephole.
+type PeepholeResult struct {
+	Code    []Instruction
+	Applied map[string]int // nombre d'applications de chaque règle
+	// Origin[i] est l'index dans le code d'entrée de l'instruction i (pour
+	// un remplacement, celui de la première instruction de la fenêtre).
+	Origin []int
+}
+
+// Peephole applique les règles jusqu'à ce qu'aucune ne s'applique plus.
+// Chaque règle raccourcit le code ou retire un SWAP1, ce qui garantit la
+// terminaison. Les étiquettes restent symboliques : ResolveLabels doit
+// être rappelé sur le résultat.
+func Peephole(code []Instruction, rules []PeepholeRule) *PeepholeResult {
+	res := &PeepholeResult{
+		Code:    append([]Instruction(nil), code...),
+		Applied: make(map[string]int),
+		Origin:  make([]int, len(code)),
+	}
+	window := 0
+	for i := range res.Origin {
+		res.Origin[i] = i
+	}
+	for _, r := range rules {
+		window = max(window, len(r.Pattern))
+	}
+
+	for i := 0; i < len(res.Code); i++ {
+		for _, r := range rules {
+			n := len(r.Pattern)
+			if i+n > len(res.Code) || !matches(r.Pattern, res.Code[i:i+n]) {
+				continue
+			}
+			repl, ok := r.Rewrite(res.Code[i : i+n])
+			if !ok {
+				continue
+			}
+			origin := make([]int, len(repl))
+			for k := range origin {
+				origin[k] = res.Origin[i]
+				if repl[k].Span.IsZero() {
+					// Instruction nouvelle (constante repliée) : située comme
+					// l'opération qui termine la fenêtre.
+					repl[k].Span = res.Code[i+n-1].Span
+				}
+			}
+			res.Code = splice(res.Code, i, n, repl)
+			res.Origin = splice(res.Origin, i, n, origin)
+			res.Applied[r.Name]++
+			// Une réécriture peut compléter un motif qui commence plus tôt.
+			i = max(i-window, -1)
+			break
+		}
+	}
+	return res
+}
+
+func matches(pattern []func(Instruction) bool, code []Instruction) bool {
+	for k, p := range pattern {
+		if !p(code[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splice remplace s[i:i+n] par repl.
+func splice[T any](s []T, i, n int, repl []T) []T {
+	out := make([]T, 0, len(s)-n+len(repl))
+	out = append(out, s[:i]...)
+	out = append(out, repl...)
+	return append(out, s[i+n:]...)
+}
@@ -0,0 +1,125 @@
+package codegen
+
+import "testing"
+
+func ops(code ...Opcode) []Instruction {
+	out := make([]Instruction, len(code))
+	for i, op := range code {
+		out[i] = Instruction{Op: op}
+	}
+	return out
+}
+
+func seq(parts ...[]Instruction) []Instruction {
+	var out []Instruction
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func push(v int64) []Instruction { return []Instruction{PushInstr(v)} }
+
+func TestPeepholeRules(t *testing.T) {
+	jumpTo := []Instruction{{Op: OP_PUSH1, Label: 1}, {Op: OP_JUMP}}
+	dest := []Instruction{{Op: OP_JUMPDEST, Label: 1}}
+	otherDest := []Instruction{{Op: OP_JUMPDEST, Label: 2}}
+	tests := []struct {
+		rule string
+		in   []Instruction
+		want []Instruction // nil : aucune règle ne s'applique, le code reste
+	}{
+		{"swap1-swap1", ops(OP_CALLER, OP_CALLVALUE, OP_SWAP1, OP_SWAP1, OP_SUB), ops(OP_CALLER, OP_CALLVALUE, OP_SUB)},
+		{"not-not", ops(OP_CALLER, OP_NOT, OP_NOT), ops(OP_CALLER)},
+		{"push-pop", seq(ops(OP_CALLER), push(7), ops(OP_POP)), ops(OP_CALLER)},
+		{"dup-pop", ops(OP_CALLER, OP_DUP1, OP_POP), ops(OP_CALLER)},
+		{"iszero-iszero-jumpi", seq(ops(OP_CALLER, OP_ISZERO, OP_ISZERO), jumpTo[:1], ops(OP_JUMPI), dest), seq(ops(OP_CALLER), jumpTo[:1], ops(OP_JUMPI), dest)},
+		{"bool-iszero-iszero", ops(OP_CALLER, OP_CALLVALUE, OP_LT, OP_ISZERO, OP_ISZERO), ops(OP_CALLER, OP_CALLVALUE, OP_LT)},
+		// ISZERO est booléen : bool-iszero-iszero réduit aussi trois ISZERO
+		// à un.
+		{"bool-iszero-iszero", ops(OP_CALLER, OP_ISZERO, OP_ISZERO, OP_ISZERO), ops(OP_CALLER, OP_ISZERO)},
+		{"swap1-commutative", ops(OP_CALLER, OP_CALLVALUE, OP_SWAP1, OP_ADD), ops(OP_CALLER, OP_CALLVALUE, OP_ADD)},
+		{"dup1-swap1", ops(OP_CALLER, OP_DUP1, OP_SWAP1), ops(OP_CALLER, OP_DUP1)},
+		{"push-push-swap1", seq(push(1), ops(OP_CALLER), push(2), push(3), ops(OP_SWAP1)), seq(push(1), ops(OP_CALLER), push(3), push(2))},
+		{"add-zero", seq(ops(OP_CALLER), push(0), ops(OP_ADD)), ops(OP_CALLER)},
+		{"add-zero", seq(ops(OP_CALLER), push(0), ops(OP_SHR)), ops(OP_CALLER)},
+		{"mul-one", seq(ops(OP_CALLER), push(1), ops(OP_MUL)), ops(OP_CALLER)},
+		{"and-ones", seq(ops(OP_CALLER), push(-1), ops(OP_AND)), ops(OP_CALLER)},
+		{"fold-unary", seq(push(0x1FF), ops(OP_TRUNC8)), push(0xFF)},
+		{"fold-unary", seq(push(5), ops(OP_ISZERO)), push(0)},
+		// PUSH1 0xFF NOT tient en 3 octets, le PUSH8 de son résultat en 9.
+		{"fold-unary", seq(push(0xFF), ops(OP_NOT)), nil},
+		// 7 - 2 : le premier opérande (7) est au sommet.
+		{"fold-binary", seq(push(2), push(7), ops(OP_SUB)), push(5)},
+		{"fold-binary", seq(push(0), push(9), ops(OP_SDIV)), push(0)},
+		{"fold-ternary", seq(push(5), push(4), push(3), ops(OP_MULMOD)), push(2)},
+		{"jump-to-next", seq(jumpTo, dest, ops(OP_CALLER)), seq(dest, ops(OP_CALLER))},
+
+		// Ce qui ne doit pas être réécrit.
+		{"jump-to-next", seq(jumpTo, otherDest, dest), nil},
+		{"swap1-commutative", ops(OP_CALLER, OP_CALLVALUE, OP_SWAP1, OP_SUB), nil},
+		{"mul-one", seq(ops(OP_CALLER), push(1), ops(OP_SDIV)), nil},
+		// Une adresse de saut n'est pas une constante.
+		{"fold-binary", seq(jumpTo[:1], push(1), ops(OP_ADD), ops(OP_JUMP), dest), nil},
+	}
+	fired := make(map[string]bool)
+	for _, tt := range tests {
+		res := Peephole(tt.in, PeepholeRules)
+		if tt.want != nil {
+			fired[tt.rule] = true
+		}
+		want := tt.want
+		if want == nil {
+			want = tt.in
+		}
+		if got := listing(res.Code); got != listing(want) {
+			t.Errorf("%s: %s\n  got  %s\n  want %s", tt.rule, listing(tt.in), got, listing(want))
+		}
+		if applied := res.Applied[tt.rule] > 0; applied != (tt.want != nil) {
+			t.Errorf("%s: %s: applied = %v, want %v (applied: %v)", tt.rule, listing(tt.in), applied, tt.want != nil, res.Applied)
+		}
+		if len(res.Origin) != len(res.Code) {
+			t.Errorf("%s: %d origins for %d instructions", tt.rule, len(res.Origin), len(res.Code))
+		}
+	}
+	// Une règle qu'aucun cas ne déclenche est masquée par celles d'avant.
+	for _, r := range PeepholeRules {
+		if !fired[r.Name] {
+			t.Errorf("rule %s never fires", r.Name)
+		}
+	}
+}
+
+func TestPeepholeRuleNamesAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, r := range PeepholeRules {
+		if seen[r.Name] {
+			t.Errorf("duplicate rule %q", r.Name)
+		}
+		seen[r.Name] = true
+	}
+}
+
+func TestPeepholePreservesBehavior(t *testing.T) {
+	_, code := generate(t, `I64 x = SLoad(0) + 3;
+I64 y = 0;
+while (!(x >= 9)) {
+  if (!!(x % 2 == 0)) y += x * 1;
+  else y = y ^ 0;
+  x++;
+}
+SStore(1, y);
+SStore(2, -(2 - 7) * 4);
+`)
+	want := run(code).String()
+	res := Peephole(code, PeepholeRules)
+	if err := ResolveLabels(res.Code); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Code) >= len(code) {
+		t.Errorf("no instruction removed (%d → %d)", len(code), len(res.Code))
+	}
+	if got := run(res.Code).String(); got != want {
+		t.Errorf("after peephole: %s, before: %s", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package codegen
+
+import (
+	"sort"
+
+	"holyc-compiler/pkg/lexer"
+)
+
+// StorageAccess est une instruction SLOAD, SSTORE, TLOAD ou TSTORE du code.
+type StorageAccess struct {
+	Index  int // instruction
+	Offset int // son offset en octets
+	Op     Opcode
+	Key    uint64 // valable seulement si Known
+	Known  bool   // la clé est une constante
+	Func   string // "" hors de toute fonction
+	Span   lexer.Span
+}
+
+// Transient indique si l'accès vise le stockage transitoire (TLOAD, TSTORE).
+func (a StorageAccess) Transient() bool { return a.Op == OP_TLOAD || a.Op == OP_TSTORE }
+
+// Write indique si l'accès écrit (SSTORE, TSTORE).
+func (a StorageAccess) Write() bool { return a.Op == OP_SSTORE || a.Op == OP_TSTORE }
+
+// StorageSlot résume les accès à une clé constante.
+type StorageSlot struct {
+	Key       uint64
+	Transient bool
+	Reads     int
+	Writes    int
+	Funcs     []string // fonctions qui y accèdent, triées ; "" hors de toute fonction
+}
+
+// StorageLayout décrit les emplacements de stockage qu'utilise le code.
+type StorageLayout struct {
+	// Slots liste les clés constantes, le stockage persistant d'abord, par
+	// clé croissante.
+	Slots []StorageSlot
+	// Accesses liste tous les accès dans l'ordre du code, y compris ceux
+	// dont la clé n'est connue qu'à l'exécution.
+	Accesses []StorageAccess
+}
+
+// Dynamic retourne les accès dont la clé n'est pas une constante.
+func (l *StorageLayout) Dynamic() []StorageAccess {
+	var out []StorageAccess
+	for _, a := range l.Accesses {
+		if !a.Known {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// AnalyzeStorage retrouve la clé de chaque accès au stockage de code, dont
+// les étiquettes sont résolues. La clé est le premier opérande, au sommet
+// de la pile ; elle est connue si elle se propage en constante depuis les
+// instructions précédentes (comme les destinations de saut, voir
+// jumpConstants), ce qui suppose à -O0 qu'elle soit écrite en littéral ou
+// en #define.
+func AnalyzeStorage(code []Instruction, funcs []FuncInfo) *StorageLayout {
+	names := make([]string, len(code))
+	for _, fn := range funcs {
+		for i := fn.Start; i < fn.End && i < len(code); i++ {
+			names[i] = fn.Name
+		}
+	}
+	offsets := Offsets(code)
+	layout := &StorageLayout{}
+	type slotKey struct {
+		key       uint64
+		transient bool
+	}
+	slots := make(map[slotKey]*StorageSlot)
+	seen := make(map[slotKey]map[string]bool)
+	var stack absStack
+	for i, inst := range code {
+		switch inst.Op {
+		case OP_SLOAD, OP_SSTORE, OP_TLOAD, OP_TSTORE:
+			key := stack.peek(0)
+			a := StorageAccess{Index: i, Offset: offsets[i], Op: inst.Op, Key: key.v, Known: key.known, Func: names[i], Span: inst.Span}
+			layout.Accesses = append(layout.Accesses, a)
+			if !a.Known {
+				break
+			}
+			k := slotKey{a.Key, a.Transient()}
+			slot := slots[k]
+			if slot == nil {
+				slot = &StorageSlot{Key: a.Key, Transient: a.Transient()}
+				slots[k] = slot
+				seen[k] = make(map[string]bool)
+			}
+			if a.Write() {
+				slot.Writes++
+			} else {
+				slot.Reads++
+			}
+			if !seen[k][a.Func] {
+				seen[k][a.Func] = true
+				slot.Funcs = append(slot.Funcs, a.Func)
+			}
+		}
+		stack.step(inst)
+	}
+	for _, slot := range slots {
+		sort.Strings(slot.Funcs)
+		layout.Slots = append(layout.Slots, *slot)
+	}
+	sort.Slice(layout.Slots, func(i, j int) bool {
+		a, b := layout.Slots[i], layout.Slots[j]
+		if a.Transient != b.Transient {
+			return !a.Transient
+		}
+		return a.Key < b.Key
+	})
+	return layout
+}
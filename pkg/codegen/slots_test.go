@@ -0,0 +1,46 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeStorage(t *testing.T) {
+	src := `#define OWNER 7
+I64 x = SLoad(1);
+SStore(2, x);
+TStore(2, SLoad(OWNER));
+I64 Bump(I64 k) {
+  SStore(2, SLoad(2) + k);
+  return TLoad(2);
+}
+SStore(SLoad(5), 1);
+`
+	cg, code := generate(t, src)
+	layout := AnalyzeStorage(code, cg.Funcs)
+	var slots []string
+	for _, s := range layout.Slots {
+		slots = append(slots, fmt.Sprintf("%d transient=%v r=%d w=%d %q", s.Key, s.Transient, s.Reads, s.Writes, s.Funcs))
+	}
+	want := []string{
+		`1 transient=false r=1 w=0 [""]`,
+		`2 transient=false r=1 w=2 ["" "Bump"]`,
+		`5 transient=false r=1 w=0 [""]`,
+		`7 transient=false r=1 w=0 [""]`,
+		`2 transient=true r=1 w=1 ["" "Bump"]`,
+	}
+	if got := strings.Join(slots, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("slots:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	dyn := layout.Dynamic()
+	if len(dyn) != 1 || dyn[0].Op != OP_SSTORE || dyn[0].Span.Start.Line != 9 {
+		t.Errorf("dynamic accesses %+v", dyn)
+	}
+	offsets := Offsets(code)
+	for _, a := range layout.Accesses {
+		if code[a.Index].Op != a.Op || offsets[a.Index] != a.Offset {
+			t.Errorf("access %+v does not match instruction %d (%s at %d)", a, a.Index, code[a.Index].Op, offsets[a.Index])
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"holyc-compiler/pkg/lexer"
+)
+
+// SourceMapEntry associe une plage du bytecode au nœud du source qui l'a
+// produite et à la fonction dont elle fait partie.
+type SourceMapEntry struct {
+	Offset int // premier octet
+	Length int // en octets
+	Span   lexer.Span
+	Func   string // "" hors de toute fonction
+}
+
+// SourceMap associe chaque octet du bytecode à son origine dans le source.
+// Entries couvre le code dans l'ordre et sans trou ; une entrée dont Span
+// est nulle ne vient d'aucun nœud (STOP final, prologue d'une fonction).
+//
+// Elle s'écrit dans deux formats, que ParseSourceMap relit tous deux. Le
+// format compact (Compact) tient sur trois lignes :
+//
+//	holyc-sourcemap 1
+//	main.HC
+//	3:0:2:5:2:12:Add;1;2::3:1:3:9;1:-:::::-
+//
+// la version, les fichiers séparés par « ; », puis les entrées séparées par
+// « ; », chacune length:file:line:col:endLine:endCol:function, file étant
+// l'index du fichier. Un champ vide (ou omis en fin d'entrée) reprend la
+// valeur de l'entrée précédente ; file vaut « - » pour une entrée sans
+// origine et function « - » hors de toute fonction. Offset est la somme des
+// longueurs des entrées précédentes.
+//
+// Le format JSON (json.Marshal) donne chaque champ explicitement :
+//
+//	{"version": 1, "entries": [{"offset": 0, "length": 3, "file": "main.HC",
+//	  "line": 2, "col": 5, "endLine": 2, "endCol": 12, "function": "Add"}]}
+type SourceMap struct {
+	Entries []SourceMapEntry
+}
+
+const sourceMapVersion = 1
+
+// NewSourceMap construit la source map de code, dont les étiquettes sont
+// résolues, d'après le Span de chaque instruction et les régions funcs.
+// Les instructions consécutives de même origine forment une seule entrée.
+func NewSourceMap(code []Instruction, funcs []FuncInfo) *SourceMap {
+	names := make([]string, len(code))
+	for _, fn := range funcs {
+		for i := fn.Start; i < fn.End && i < len(code); i++ {
+			names[i] = fn.Name
+		}
+	}
+	offsets := Offsets(code)
+	m := &SourceMap{}
+	for i, inst := range code {
+		if n := len(m.Entries); n > 0 && m.Entries[n-1].Span == inst.Span && m.Entries[n-1].Func == names[i] {
+			m.Entries[n-1].Length += inst.Size()
+			continue
+		}
+		m.Entries = append(m.Entries, SourceMapEntry{Offset: offsets[i], Length: inst.Size(), Span: inst.Span, Func: names[i]})
+	}
+	return m
+}
+
+// Lookup retourne l'entrée qui contient l'octet offset (la valeur du
+// compteur de programme à un revert, par exemple).
+func (m *SourceMap) Lookup(offset int) (SourceMapEntry, bool) {
+	i := sort.Search(len(m.Entries), func(i int) bool { return m.Entries[i].Offset+m.Entries[i].Length > offset })
+	if i == len(m.Entries) || offset < m.Entries[i].Offset {
+		return SourceMapEntry{}, false
+	}
+	return m.Entries[i], true
+}
+
+// Compact retourne la source map au format compact.
+func (m *SourceMap) Compact() string {
+	var files []string
+	index := make(map[string]int)
+	entries := make([]string, len(m.Entries))
+	var prev [7]string
+	for i, e := range m.Entries {
+		cur := [7]string{strconv.Itoa(e.Length), "-", prev[2], prev[3], prev[4], prev[5], "-"}
+		if !e.Span.IsZero() {
+			file := e.Span.Start.File
+			if _, ok := index[file]; !ok {
+				index[file] = len(files)
+				files = append(files, file)
+			}
+			cur[1] = strconv.Itoa(index[file])
+			cur[2], cur[3] = strconv.Itoa(e.Span.Start.Line), strconv.Itoa(e.Span.Start.Col)
+			cur[4], cur[5] = strconv.Itoa(e.Span.End.Line), strconv.Itoa(e.Span.End.Col)
+		}
+		if e.Func != "" {
+			cur[6] = e.Func
+		}
+		fields := make([]string, len(cur))
+		for k := range cur {
+			if i == 0 || cur[k] != prev[k] || k == 0 {
+				fields[k] = cur[k]
+			}
+		}
+		// Sans origine, les positions ne sont pas lues : elles restent celles
+		// de l'entrée précédente.
+		if e.Span.IsZero() {
+			fields[2], fields[3], fields[4], fields[5] = "", "", "", ""
+		}
+		entries[i] = strings.TrimRight(strings.Join(fields, ":"), ":")
+		prev = cur
+	}
+	return fmt.Sprintf("holyc-sourcemap %d\n%s\n%s\n", sourceMapVersion, strings.Join(files, ";"), strings.Join(entries, ";"))
+}
+
+// sourceMapJSON est le format JSON d'une entrée.
+type sourceMapJSON struct {
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Col      int    `json:"col,omitempty"`
+	EndLine  int    `json:"endLine,omitempty"`
+	EndCol   int    `json:"endCol,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// MarshalJSON écrit la source map au format JSON.
+func (m *SourceMap) MarshalJSON() ([]byte, error) {
+	f := struct {
+		Version int             `json:"version"`
+		Entries []sourceMapJSON `json:"entries"`
+	}{Version: sourceMapVersion, Entries: make([]sourceMapJSON, len(m.Entries))}
+	for i, e := range m.Entries {
+		f.Entries[i] = sourceMapJSON{
+			Offset: e.Offset, Length: e.Length, File: e.Span.Start.File,
+			Line: e.Span.Start.Line, Col: e.Span.Start.Col,
+			EndLine: e.Span.End.Line, EndCol: e.Span.End.Col, Function: e.Func,
+		}
+	}
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON relit une source map au format JSON.
+func (m *SourceMap) UnmarshalJSON(data []byte) error {
+	var f struct {
+		Version int             `json:"version"`
+		Entries []sourceMapJSON `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.Version != sourceMapVersion {
+		return fmt.Errorf("unsupported source map version %d", f.Version)
+	}
+	m.Entries = make([]SourceMapEntry, len(f.Entries))
+	for i, e := range f.Entries {
+		m.Entries[i] = SourceMapEntry{Offset: e.Offset, Length: e.Length, Func: e.Function}
+		if e.Line != 0 {
+			m.Entries[i].Span = lexer.Span{
+				Start: lexer.Pos{File: e.File, Line: e.Line, Col: e.Col},
+				End:   lexer.Pos{File: e.File, Line: e.EndLine, Col: e.EndCol},
+			}
+		}
+	}
+	return nil
+}
+
+// ParseSourceMap relit une source map, au format compact ou JSON.
+func ParseSourceMap(data []byte) (*SourceMap, error) {
+	m := &SourceMap{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, m); err != nil {
+			return nil, fmt.Errorf("source map: %v", err)
+		}
+		return m, nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if lines[0] != fmt.Sprintf("holyc-sourcemap %d", sourceMapVersion) {
+		return nil, fmt.Errorf("source map: missing 'holyc-sourcemap %d' header", sourceMapVersion)
+	}
+	for len(lines) < 3 {
+		lines = append(lines, "")
+	}
+	var files []string
+	if lines[1] != "" {
+		files = strings.Split(lines[1], ";")
+	}
+	if lines[2] == "" {
+		return m, nil
+	}
+	var prev [7]string
+	offset := 0
+	for i, entry := range strings.Split(lines[2], ";") {
+		fields := strings.Split(entry, ":")
+		if len(fields) > len(prev) {
+			return nil, fmt.Errorf("source map: entry %d: too many fields", i)
+		}
+		cur := prev
+		for k, f := range fields {
+			if f != "" {
+				cur[k] = f
+			}
+		}
+		e := SourceMapEntry{Offset: offset}
+		var err error
+		if e.Length, err = strconv.Atoi(cur[0]); err != nil || e.Length <= 0 {
+			return nil, fmt.Errorf("source map: entry %d: invalid length '%s'", i, cur[0])
+		}
+		if cur[1] != "-" {
+			var pos [5]int
+			for k := range pos {
+				if pos[k], err = strconv.Atoi(cur[1+k]); err != nil {
+					return nil, fmt.Errorf("source map: entry %d: invalid field '%s'", i, cur[1+k])
+				}
+			}
+			if pos[0] < 0 || pos[0] >= len(files) {
+				return nil, fmt.Errorf("source map: entry %d: no file %d", i, pos[0])
+			}
+			file := files[pos[0]]
+			e.Span = lexer.Span{
+				Start: lexer.Pos{File: file, Line: pos[1], Col: pos[2]},
+				End:   lexer.Pos{File: file, Line: pos[3], Col: pos[4]},
+			}
+		}
+		if cur[6] != "-" {
+			e.Func = cur[6]
+		}
+		m.Entries = append(m.Entries, e)
+		offset += e.Length
+		prev = cur
+	}
+	return m, nil
+}
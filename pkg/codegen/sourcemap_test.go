@@ -0,0 +1,118 @@
+package codegen
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mapped = `I64 x = SLoad(0);
+I64 Add(I64 a, I64 b) {
+  return a + b * 2;
+}
+SStore(1, x + 1);
+`
+
+// storeLines retourne la ligne d'origine de chaque SSTORE de code.
+func storeLines(code []Instruction) []int {
+	var lines []int
+	for _, inst := range code {
+		if inst.Op == OP_SSTORE {
+			lines = append(lines, inst.Span.Start.Line)
+		}
+	}
+	return lines
+}
+
+func TestSourceMap(t *testing.T) {
+	cg, code := generate(t, mapped)
+	m := NewSourceMap(code, cg.Funcs)
+	size := 0
+	for i, e := range m.Entries {
+		if e.Offset != size || e.Length <= 0 {
+			t.Fatalf("entry %d: %+v at offset %d", i, e, size)
+		}
+		size += e.Length
+	}
+	if want := Offsets(code)[len(code)]; size != want {
+		t.Errorf("entries cover %d bytes, want %d", size, want)
+	}
+
+	offsets := Offsets(code)
+	for i, inst := range code {
+		e, ok := m.Lookup(offsets[i])
+		if !ok || e.Span != inst.Span {
+			t.Errorf("%04d %s: lookup %+v, want span %v", i, inst.Op, e, inst.Span)
+		}
+		inFunc := i >= cg.Funcs[0].Start && i < cg.Funcs[0].End
+		if (e.Func == "Add") != inFunc {
+			t.Errorf("%04d %s: function %q", i, inst.Op, e.Func)
+		}
+		if inst.Op == OP_MUL && (inst.Span.Start.Line != 3 || inst.Span.Start.File != "test.HC") {
+			t.Errorf("MUL at %v, want test.HC:3", inst.Span)
+		}
+	}
+	if _, ok := m.Lookup(size); ok {
+		t.Error("lookup past the end succeeds")
+	}
+	if got := storeLines(code); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("SSTORE lines %v, want [5]", got)
+	}
+}
+
+func TestSourceMapFormats(t *testing.T) {
+	cg, code := generate(t, mapped)
+	m := NewSourceMap(code, cg.Funcs)
+	compact := m.Compact()
+	if !strings.HasPrefix(compact, "holyc-sourcemap 1\ntest.HC\n") {
+		t.Errorf("compact map:\n%s", compact)
+	}
+	js, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{compact, string(js)} {
+		back, err := ParseSourceMap([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(back, m) {
+			t.Errorf("read back:\n%+v\nwant:\n%+v", back.Entries, m.Entries)
+		}
+	}
+}
+
+func TestParseSourceMapErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"sourcemap 1\n", "missing 'holyc-sourcemap 1' header"},
+		{"holyc-sourcemap 1\na.HC\n0:0:1:1:1:2:-", "entry 0: invalid length '0'"},
+		{"holyc-sourcemap 1\na.HC\n3:1:1:1:1:2:-", "entry 0: no file 1"},
+		{"holyc-sourcemap 1\na.HC\n3:0:x:1:1:2:-", "entry 0: invalid field 'x'"},
+		{"holyc-sourcemap 1\na.HC\n3:0:1:1:1:2:-:8", "entry 0: too many fields"},
+		{`{"version": 1, "entries": [`, "source map: "},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSourceMap([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+// TestSpansSurviveOptimization vérifie que le code réécrit par les passes
+// garde l'origine du source qu'il remplace.
+func TestSpansSurviveOptimization(t *testing.T) {
+	src := `I64 k = 2;
+SStore(k, Sq(SLoad(0)));
+SStore(k + 1, 8 * 4);
+I64 Sq(I64 x) { return x * x; }
+`
+	for _, l := range []OptLevel{O2, Os} {
+		if got := storeLines(compileAt(t, src, l)); !reflect.DeepEqual(got, []int{2, 3}) {
+			t.Errorf("%s: SSTORE lines %v, want [2 3]", l, got)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package codegen
+
+import (
+	"fmt"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// storageKey identifie un emplacement de stockage persistant : une clé
+// constante, ou la valeur courante d'une variable.
+type storageKey struct {
+	name  string // variable ; "" pour une constante
+	value uint64
+}
+
+// mayAlias indique si deux clés peuvent désigner le même emplacement : une
+// variable peut valoir n'importe quelle constante.
+func (k storageKey) mayAlias(o storageKey) bool {
+	return k == o || k.name != "" || o.name != ""
+}
+
+// cacheEntry est la valeur connue d'un emplacement : celle d'une variable
+// de cache (affectée par assign lors d'un SLOAD ou d'un SSTORE), ou une
+// constante écrite par SSTORE.
+type cacheEntry struct {
+	key    storageKey
+	decl   *parser.VarDecl    // variable de cache (nil : constante)
+	assign *parser.AssignExpr // SLOAD ou valeur écrite, affectés à decl
+	lit    int64
+	hits   []parser.Node // lectures remplacées
+	forced bool          // un SSTORE supprimé compte sur cette valeur
+}
+
+// pendingStore est un SSTORE d'une liste d'instructions qu'aucune lecture
+// n'a encore observé : un SSTORE suivant sur la même clé le rend inutile.
+type pendingStore struct {
+	index int // dans la liste en construction
+	value parser.Node
+	entry *cacheEntry
+}
+
+// storageEffects résume ce qu'un fragment fait au stockage et aux clés.
+type storageEffects struct {
+	writes   []storageKey    // SSTORE de clé connue
+	clobber  bool            // SSTORE de clé inconnue, ou appel de fonction
+	exits    bool            // fin d'exécution possible
+	assigned map[string]bool // variables affectées ou déclarées
+}
+
+// storageOpt porte l'état de OptimizeStorage.
+type storageOpt struct {
+	cg      *CodeGen
+	defines map[string]bool
+	entries []*cacheEntry
+	origin  map[parser.Node]parser.Node // lecture remplacée → SLoad d'origine
+	vars    int
+	merged  int
+}
+
+// OptimizeStorage réduit en place les accès au stockage persistant de
+// chaque liste d'instructions, et retourne le nombre de SLOAD remplacés par
+// une valeur connue et de SSTORE supprimés :
+//   - la valeur lue par un SLOAD, ou écrite par un SSTORE, est gardée dans
+//     une variable (en mémoire, ou sur la pile avec StackLocals) ; les
+//     SLOAD suivants de la même clé la relisent ;
+//   - un SSTORE suivi, dans la même liste, d'un SSTORE de la même clé est
+//     supprimé si rien entre les deux ne peut lire le stockage ni terminer
+//     l'exécution.
+//
+// La VM n'a ni CALL ni CREATE : les seuls appels qui peuvent lire ou écrire
+// le stockage dans le dos de la fonction, réentrance comprise, sont ceux de
+// fonctions utilisateur non inlinées. Un tel appel vide le cache et fixe
+// les SSTORE qui le précèdent. Une boucle oublie les clés qu'elle écrit, un
+// if ou un bloc ceux qu'écrivent ses branches, et un SSTORE de clé variable
+// toutes les clés. Une valeur n'est gardée que si le modèle de coût y
+// gagne. À lancer après FoldConstants, qui rend les clés constantes.
+func (cg *CodeGen) OptimizeStorage(prog *parser.Program) (cached, merged int) {
+	s := &storageOpt{cg: cg, defines: make(map[string]bool), origin: make(map[parser.Node]parser.Node)}
+	walk(prog, func(n parser.Node) {
+		if d, ok := n.(*parser.DefineDecl); ok {
+			s.defines[d.Name] = true
+		}
+	})
+	prog.Decls = s.list(prog.Decls, map[storageKey]*cacheEntry{})
+
+	revert := make(map[parser.Node]parser.Node)
+	unassign := make(map[*parser.AssignExpr]bool)
+	unused := make(map[*parser.VarDecl]bool)
+	for _, e := range s.entries {
+		if e.forced || s.worth(e) {
+			cached += len(e.hits)
+			continue
+		}
+		for _, h := range e.hits {
+			revert[h] = s.origin[h]
+		}
+		if e.decl != nil {
+			unassign[e.assign] = true
+			unused[e.decl] = true
+		}
+	}
+	transform(prog, func(n parser.Node) parser.Node {
+		switch n := n.(type) {
+		case *parser.AssignExpr:
+			if unassign[n] {
+				return n.Value
+			}
+		case *parser.VarDecl:
+			if unused[n] {
+				return nil
+			}
+		}
+		if orig, ok := revert[n]; ok {
+			return orig
+		}
+		return n
+	})
+	return cached, s.merged
+}
+
+// worth compare le coût des lectures remplacées par e à celui de garder la
+// valeur.
+func (s *storageOpt) worth(e *cacheEntry) bool {
+	cg := s.cg
+	var keep, reload []Instruction
+	read := []Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}}
+	if e.decl != nil {
+		keep = append(keep, Instruction{Op: OP_DUP1}, PushInstr(LocalsBase), Instruction{Op: OP_MSTORE})
+	} else {
+		read = cg.materialize(uint64(e.lit))
+	}
+	var key []Instruction
+	if e.key.name != "" {
+		key = []Instruction{PushInstr(LocalsBase), {Op: OP_MLOAD}}
+	} else {
+		key = cg.materialize(e.key.value)
+	}
+	for range e.hits {
+		keep = append(keep, read...)
+		reload = append(append(reload, key...), Instruction{Op: OP_SLOAD})
+	}
+	return cg.less(cg.cost(keep), cg.cost(reload))
+}
+
+// keyOf retourne la clé d'un argument de SLoad ou SStore, si elle est
+// connue : une constante ou une variable.
+func (s *storageOpt) keyOf(node parser.Node) (storageKey, bool) {
+	if v, ok := constOf(node); ok {
+		return storageKey{value: v}, true
+	}
+	if id, ok := node.(*parser.Identifier); ok && !s.defines[id.Name] {
+		return storageKey{name: id.Name}, true
+	}
+	return storageKey{}, false
+}
+
+// newVar déclare une variable de cache, à insérer avant l'instruction
+// courante.
+func (s *storageOpt) newVar() *parser.VarDecl {
+	s.vars++
+	return &parser.VarDecl{TypeName: "I64", Name: fmt.Sprintf("SLoad.%d", s.vars)}
+}
+
+// ---- Listes d'instructions ----
+
+// list optimise une liste d'instructions exécutée avec le cache cache.
+func (s *storageOpt) list(stmts []parser.Node, cache map[storageKey]*cacheEntry) []parser.Node {
+	var out []parser.Node
+	pending := make(map[storageKey]*pendingStore)
+	flush := func() { clear(pending) }
+	for _, stmt := range stmts {
+		switch n := stmt.(type) {
+		case *parser.FuncDecl:
+			// Le corps est émis en ligne : on y entre par appel ou par chute.
+			if n.Body != nil {
+				n.Body.Stmts = s.list(n.Body.Stmts, map[storageKey]*cacheEntry{})
+			}
+			clear(cache)
+			flush()
+		case *parser.ExprStmt:
+			if call, ok := n.Expr.(*parser.CallExpr); ok && call.Func == "SStore" && len(call.Args) == 2 {
+				out = s.store(call, out, cache, pending)
+				out = append(out, stmt)
+				continue
+			}
+			if a, ok := n.Expr.(*parser.AssignExpr); ok && a.Op == lexer.TOK_ASSIGN {
+				if id, ok := a.Target.(*parser.Identifier); ok {
+					a.Value, out = s.value(a.Value, out, cache, pending)
+					s.invalidate(cache, storageEffects{assigned: map[string]bool{id.Name: true}})
+					forgetKey(pending, id.Name)
+					break
+				}
+			}
+			n.Expr, out = s.value(n.Expr, out, cache, pending)
+		case *parser.VarDecl:
+			n.Init, out = s.value(n.Init, out, cache, pending)
+			s.invalidate(cache, storageEffects{assigned: map[string]bool{n.Name: true}})
+			forgetKey(pending, n.Name)
+		case *parser.ReturnStmt:
+			n.Value, out = s.value(n.Value, out, cache, pending)
+			flush()
+		case *parser.IfStmt:
+			n.Cond, out = s.expr(n.Cond, out, cache, pending, true)
+			n.Body = s.nested(n.Body, cache)
+			n.Else = s.nested(n.Else, cache)
+			flush()
+		case *parser.WhileStmt:
+			s.invalidate(cache, s.effects(n))
+			n.Cond, out = s.expr(n.Cond, out, cache, pending, false)
+			n.Body = s.nested(n.Body, cache)
+			flush()
+		case *parser.ForStmt:
+			s.invalidate(cache, s.effects(n))
+			n.Body = s.nested(n.Body, cache)
+			flush()
+		case *parser.Block:
+			s.nested(n, cache)
+			flush()
+		}
+		out = append(out, stmt)
+	}
+	compact := out[:0]
+	for _, stmt := range out {
+		if stmt != nil {
+			compact = append(compact, stmt)
+		}
+	}
+	return compact
+}
+
+// nested optimise le corps d'un if, d'une boucle ou d'un bloc avec une copie
+// du cache, puis retire du cache les clés que le corps peut écrire.
+func (s *storageOpt) nested(node parser.Node, cache map[storageKey]*cacheEntry) parser.Node {
+	if node == nil {
+		return nil
+	}
+	inner := make(map[storageKey]*cacheEntry, len(cache))
+	for k, e := range cache {
+		inner[k] = e
+	}
+	if b, ok := node.(*parser.Block); ok {
+		b.Stmts = s.list(b.Stmts, inner)
+	} else if stmts := s.list([]parser.Node{node}, inner); len(stmts) == 1 {
+		node = stmts[0]
+	} else {
+		node = &parser.Block{Stmts: stmts}
+	}
+	s.invalidate(cache, s.effects(node))
+	return node
+}
+
+// value traite la valeur entière d'une instruction. Un corps inliné y est
+// exécuté d'un seul tenant, avant le reste de l'instruction : ses
+// instructions partagent le cache de la liste, comme si elles y figuraient,
+// mais les variables qu'elles déclarent n'existent que dans le corps.
+func (s *storageOpt) value(node parser.Node, out []parser.Node, cache map[storageKey]*cacheEntry, pending map[storageKey]*pendingStore) (parser.Node, []parser.Node) {
+	in, ok := node.(*parser.InlineExpr)
+	if !ok {
+		return s.expr(node, out, cache, pending, true)
+	}
+	inner := make(map[storageKey]*cacheEntry, len(cache))
+	for k, e := range cache {
+		inner[k] = e
+	}
+	in.Stmts = s.list(in.Stmts, inner)
+	in.Value, in.Stmts = s.expr(in.Value, in.Stmts, inner, make(map[storageKey]*pendingStore), true)
+	s.invalidate(cache, s.effects(in))
+	clear(pending)
+	return in, out
+}
+
+// store traite SStore(key, value) : la valeur est réutilisée par les
+// lectures suivantes de la clé, et le SSTORE précédent de la même clé est
+// supprimé s'il n'a pas été observé.
+func (s *storageOpt) store(call *parser.CallExpr, out []parser.Node, cache map[storageKey]*cacheEntry, pending map[storageKey]*pendingStore) []parser.Node {
+	out = s.exprs(call.Args, out, cache, pending, true)
+	key, ok := s.keyOf(call.Args[0])
+	if !ok {
+		clear(cache)
+		return out
+	}
+	s.invalidate(cache, storageEffects{writes: []storageKey{key}})
+
+	e := &cacheEntry{key: key}
+	if v, ok := constOf(call.Args[1]); ok {
+		e.lit = int64(v)
+	} else {
+		e.decl = s.newVar()
+		e.assign = &parser.AssignExpr{Op: lexer.TOK_ASSIGN, Target: &parser.Identifier{Name: e.decl.Name}, Value: call.Args[1]}
+		call.Args[1] = e.assign
+		out = append(out, e.decl)
+	}
+	s.entries = append(s.entries, e)
+	cache[key] = e
+
+	if p, ok := pending[key]; ok {
+		s.merged++
+		out[p.index] = nil
+		if hasEffects(p.value) {
+			out[p.index] = &parser.ExprStmt{Expr: p.value}
+		}
+		p.entry.forced = true
+	}
+	pending[key] = &pendingStore{index: len(out), value: call.Args[1], entry: e}
+	return out
+}
+
+// ---- Expressions ----
+
+// expr traite l'expression d'une instruction (voir exprs).
+func (s *storageOpt) expr(node parser.Node, out []parser.Node, cache map[storageKey]*cacheEntry, pending map[storageKey]*pendingStore, create bool) (parser.Node, []parser.Node) {
+	if node == nil {
+		return nil, out
+	}
+	nodes := []parser.Node{node}
+	out = s.exprs(nodes, out, cache, pending, create)
+	return nodes[0], out
+}
+
+// exprs remplace dans les expressions d'une même instruction les SLOAD
+// dont la valeur est dans le cache, et, si create, garde la valeur des
+// autres dans une variable déclarée avant l'instruction. Une valeur gardée
+// ne sert qu'aux instructions suivantes : l'ordre d'évaluation au sein de
+// l'instruction n'entre pas en compte. Une instruction qui écrit le
+// stockage, appelle une fonction ou peut terminer l'exécution est laissée
+// telle quelle.
+func (s *storageOpt) exprs(nodes []parser.Node, out []parser.Node, cache map[storageKey]*cacheEntry, pending map[storageKey]*pendingStore, create bool) []parser.Node {
+	eff := s.effects(nodes...)
+	s.invalidate(cache, eff)
+	for name := range eff.assigned {
+		forgetKey(pending, name)
+	}
+	if eff.clobber || eff.exits || len(eff.writes) > 0 {
+		clear(pending)
+		return out
+	}
+	// Un corps inliné peut contenir des if et des boucles : une lecture qui
+	// s'y trouve n'est pas forcément exécutée et ne crée pas de valeur.
+	guarded := make(map[parser.Node]bool)
+	for _, node := range nodes {
+		walk(node, func(n parser.Node) {
+			if in, ok := n.(*parser.InlineExpr); ok {
+				walk(in, func(m parser.Node) { guarded[m] = true })
+			}
+		})
+	}
+	var fresh []*cacheEntry
+	load := func(n parser.Node) parser.Node {
+		call, ok := n.(*parser.CallExpr)
+		if !ok || call.Func != "SLoad" || len(call.Args) != 1 {
+			return n
+		}
+		key, ok := s.keyOf(call.Args[0])
+		if !ok {
+			clear(pending)
+			return n
+		}
+		if e, ok := cache[key]; ok {
+			var hit parser.Node = &parser.IntLiteral{Value: e.lit}
+			if e.decl != nil {
+				hit = &parser.Identifier{Name: e.decl.Name}
+			}
+			e.hits = append(e.hits, hit)
+			s.origin[hit] = call
+			return hit
+		}
+		for k := range pending {
+			if k.mayAlias(key) {
+				delete(pending, k)
+			}
+		}
+		if !create || guarded[call] {
+			return n
+		}
+		for _, e := range fresh {
+			if e.key == key {
+				return n
+			}
+		}
+		e := &cacheEntry{key: key, decl: s.newVar()}
+		e.assign = &parser.AssignExpr{Op: lexer.TOK_ASSIGN, Target: &parser.Identifier{Name: e.decl.Name}, Value: call}
+		fresh = append(fresh, e)
+		out = append(out, e.decl)
+		return e.assign
+	}
+	for i, node := range nodes {
+		nodes[i] = transform(node, load)
+	}
+	for _, e := range fresh {
+		s.entries = append(s.entries, e)
+		cache[e.key] = e
+	}
+	s.invalidate(cache, eff)
+	return out
+}
+
+// effects résume nodes (voir storageEffects).
+func (s *storageOpt) effects(nodes ...parser.Node) storageEffects {
+	eff := storageEffects{assigned: make(map[string]bool)}
+	target := func(n parser.Node) {
+		if id, ok := n.(*parser.Identifier); ok {
+			eff.assigned[id.Name] = true
+		}
+	}
+	visit := func(n parser.Node) {
+		switch n := n.(type) {
+		case *parser.ReturnStmt:
+			eff.exits = true
+		case *parser.VarDecl:
+			eff.assigned[n.Name] = true
+		case *parser.AssignExpr:
+			target(n.Target)
+		case *parser.PostfixExpr:
+			target(n.Operand)
+		case *parser.UnaryExpr:
+			if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+				target(n.Operand)
+			}
+		case *parser.CallExpr:
+			op, _, builtin := Builtin(n.Func)
+			switch {
+			case !builtin:
+				eff.clobber = true
+			case isTerminator(op):
+				eff.exits = true
+			case op == OP_SSTORE && len(n.Args) == 2:
+				if key, ok := s.keyOf(n.Args[0]); ok {
+					eff.writes = append(eff.writes, key)
+				} else {
+					eff.clobber = true
+				}
+			}
+		}
+	}
+	for _, node := range nodes {
+		walk(node, visit)
+	}
+	return eff
+}
+
+// invalidate retire du cache les valeurs que eff peut rendre fausses.
+func (s *storageOpt) invalidate(cache map[storageKey]*cacheEntry, eff storageEffects) {
+	for k := range cache {
+		stale := eff.clobber || eff.assigned[k.name]
+		for _, w := range eff.writes {
+			stale = stale || k.mayAlias(w)
+		}
+		if stale {
+			delete(cache, k)
+		}
+	}
+}
+
+// forgetKey retire les SSTORE en attente dont la clé est la variable name :
+// sa nouvelle valeur désigne un autre emplacement.
+func forgetKey(pending map[storageKey]*pendingStore, name string) {
+	for k := range pending {
+		if k.name == name {
+			delete(pending, k)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package codegen
+
+import (
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+func TestOptimizeStorage(t *testing.T) {
+	tests := []struct {
+		name           string
+		src            string
+		cached, merged int
+	}{
+		{"readme", `SStore(1, 10);
+SStore(2, SLoad(0) + 7);
+I64 a = SLoad(1) + SLoad(2);
+I64 b = SLoad(2) * 3;
+SStore(2, a);
+SStore(2, a + b);
+`, 3, 2},
+		// Entre deux SSTORE de la même clé, un SLOAD de cette clé lit la
+		// valeur en cache : le premier SSTORE est quand même supprimé.
+		{"read-between", `SStore(1, SLoad(0) + 1);
+SStore(2, SLoad(1));
+SStore(1, 5);
+`, 1, 1},
+		// Une clé variable peut désigner la clé 1.
+		{"variable-key", `I64 k = SLoad(0);
+SStore(1, 4);
+SStore(k, 9);
+SStore(3, SLoad(1));
+SStore(1, 6);
+`, 0, 0},
+		// La boucle écrit la clé 1 : la valeur d'avant n'est plus sûre.
+		{"loop", `SStore(1, SLoad(0) + 2);
+for (I64 i = 0; i < SLoad(9) + 3; i++)
+  SStore(1, SLoad(1) * 2);
+SStore(2, SLoad(1));
+`, 0, 0},
+		// Un if interrompt la fusion des SSTORE.
+		{"branch", `SStore(1, 5);
+if (SLoad(0)) SStore(2, 1);
+SStore(1, 6);
+`, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg, code := inlineAt(t, tt.src, O2)
+			if diag.HasErrors(cg.Diagnostics) {
+				t.Fatal(messages(cg, diag.Error))
+			}
+			if cg.CachedLoads != tt.cached || cg.MergedStores != tt.merged {
+				t.Errorf("%d SLOAD(s) cached, %d SSTORE(s) merged, want %d and %d", cg.CachedLoads, cg.MergedStores, tt.cached, tt.merged)
+			}
+			if got, want := run(code).String(), run(compileAt(t, tt.src, O1)).String(); got != want {
+				t.Errorf("-O2: %s, -O1: %s", got, want)
+			}
+		})
+	}
+}
+
+// TestStorageCallInvalidates vérifie qu'un appel de fonction non inlinée
+// vide le cache : la fonction peut écrire n'importe quelle clé.
+func TestStorageCallInvalidates(t *testing.T) {
+	src := `I64 x = SLoad(1);
+Touch();
+SStore(2, SLoad(1) + x);
+[[noinline]] U0 Touch() { SStore(1, 3); }
+`
+	p := folded(t, src)
+	cg := NewCodeGen()
+	cg.Passes = O2.Passes()
+	if cached, _ := cg.OptimizeStorage(p); cached != 0 {
+		t.Errorf("%d SLOAD(s) cached across a call", cached)
+	}
+}
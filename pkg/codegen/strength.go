@@ -0,0 +1,182 @@
+package codegen
+
+import (
+	"math/bits"
+
+	"holyc-compiler/pkg/parser"
+)
+
+// genReduced génère « a op b » par une séquence moins coûteuse quand b est
+// une constante (a pour MUL, commutatif) : voir reduction. Retourne false,
+// sans rien émettre, si aucune réduction n'est plus avantageuse.
+func (cg *CodeGen) genReduced(op Opcode, a, b parser.Node) bool {
+	c, ok := constOf(b)
+	x := a
+	if !ok && op == OP_MUL {
+		c, ok = constOf(a)
+		x = b
+	}
+	if !ok {
+		return false
+	}
+	seq, ok := cg.reduce(op, c)
+	if !ok {
+		return false
+	}
+	cg.genValue(x)
+	for _, inst := range seq {
+		if constPush(inst) {
+			cg.emitPush(inst.Operand)
+		} else {
+			cg.add(inst)
+		}
+	}
+	return true
+}
+
+// reduce retourne la séquence de reduction pour « x op c » si elle coûte
+// moins, selon Passes.Cost, que c matérialisée suivie de op.
+func (cg *CodeGen) reduce(op Opcode, c uint64) ([]Instruction, bool) {
+	seq, ok := reduction(op, c)
+	if !ok {
+		return nil, false
+	}
+	orig := cg.cost(append(cg.materialize(c), Instruction{Op: op}))
+	if op == OP_EXP {
+		orig.gas += expBytes(c) * cg.schedule().ExpByte
+	}
+	if !cg.less(cg.cost(cg.expand(seq)), orig) {
+		return nil, false
+	}
+	return seq, true
+}
+
+// Reduce est reduce pour un code produit hors de CodeGen (les passes de
+// l'IR) : la séquence qui calcule « x op c » à partir de x au sommet de la
+// pile, si elle est plus avantageuse avec passes et le barème s (nil :
+// barème par défaut). Ses PUSH de constantes sont à matérialiser
+// (Materialize).
+func Reduce(op Opcode, c uint64, passes Passes, s *GasSchedule) ([]Instruction, bool) {
+	cg := &CodeGen{Passes: passes, Schedule: s}
+	return cg.reduce(op, c)
+}
+
+// reduction retourne une séquence calculant « x op c » à partir de x au
+// sommet de la pile, sans MUL/DIV/MOD ni EXP dynamique :
+//
+//	x * 2^k    → PUSH k SHL          x / 2^k (DIV)  → PUSH k SHR
+//	x % 2^k    → PUSH 2^k-1 AND      x / 2^k (SDIV) → arrondi vers zéro par SAR
+//	x % 2^k (SMOD) → x - ((x + biais) & -2^k)
+//	x ` e      → élévations au carré et multiplications (DUP, MUL)
+//
+// La sémantique est exactement celle de l'opcode remplacé, division par
+// zéro comprise (résultat 0).
+func reduction(op Opcode, c uint64) ([]Instruction, bool) {
+	pow2 := c != 0 && c&(c-1) == 0
+	k := int64(bits.TrailingZeros64(c))
+	zero := []Instruction{{Op: OP_POP}, {Op: OP_PUSH0}}
+
+	switch op {
+	case OP_MUL:
+		switch {
+		case c == 0:
+			return zero, true
+		case c == 1:
+			return nil, true
+		case pow2:
+			return []Instruction{PushInstr(k), {Op: OP_SHL}}, true
+		}
+	case OP_DIV:
+		switch {
+		case c == 0:
+			return zero, true
+		case c == 1:
+			return nil, true
+		case pow2:
+			return []Instruction{PushInstr(k), {Op: OP_SHR}}, true
+		}
+	case OP_MOD:
+		switch {
+		case c <= 1:
+			return zero, true
+		case pow2:
+			return []Instruction{PushInstr(int64(c - 1)), {Op: OP_AND}}, true
+		}
+	case OP_SDIV:
+		switch {
+		case c == 0:
+			return zero, true
+		case c == 1:
+			return nil, true
+		case int64(c) == -1:
+			return []Instruction{{Op: OP_PUSH0}, {Op: OP_SUB}}, true
+		case pow2 && k < 63:
+			// x < 0 : on ajoute 2^k-1 avant le décalage pour arrondir vers zéro.
+			return append([]Instruction{{Op: OP_DUP1}}, append(signBias(k), []Instruction{
+				{Op: OP_ADD}, PushInstr(k), {Op: OP_SAR},
+			}...)...), true
+		}
+	case OP_SMOD:
+		switch {
+		case c <= 1 || int64(c) == -1:
+			return zero, true
+		case pow2 && k < 63:
+			seq := []Instruction{{Op: OP_DUP1}, {Op: OP_DUP1}}
+			seq = append(seq, signBias(k)...)
+			return append(seq, []Instruction{
+				{Op: OP_ADD}, PushInstr(-int64(c)), {Op: OP_AND}, {Op: OP_SWAP1}, {Op: OP_SUB},
+			}...), true
+		}
+	case OP_EXP:
+		if c >= 1<<16 {
+			return nil, false
+		}
+		return expChain(c), true
+	}
+	return nil, false
+}
+
+// signBias remplace le sommet x par 2^k-1 si x < 0, 0 sinon.
+func signBias(k int64) []Instruction {
+	return []Instruction{PushInstr(63), {Op: OP_SAR}, PushInstr(64 - k), {Op: OP_SHR}}
+}
+
+// expChain calcule x^e par élévations au carré successives, de l'octet de
+// poids fort vers le poids faible ; x reste sous l'accumulateur tant qu'un
+// bit à 1 doit encore le multiplier.
+func expChain(e uint64) []Instruction {
+	switch e {
+	case 0:
+		return []Instruction{{Op: OP_POP}, PushInstr(1)}
+	case 1:
+		return nil
+	}
+	n := bits.Len64(e)
+	keepX := e != 1<<(n-1)
+	var seq []Instruction
+	if keepX {
+		seq = append(seq, Instruction{Op: OP_DUP1})
+	}
+	for i := n - 2; i >= 0; i-- {
+		seq = append(seq, Instruction{Op: OP_DUP1}, Instruction{Op: OP_MUL})
+		if e>>i&1 == 1 {
+			seq = append(seq, Instruction{Op: OP_DUP2}, Instruction{Op: OP_MUL})
+		}
+	}
+	if keepX {
+		seq = append(seq, Instruction{Op: OP_SWAP1}, Instruction{Op: OP_POP})
+	}
+	return seq
+}
+
+// expBytes retourne le nombre d'octets non nuls d'un exposant, facturés
+// chacun ExpByte par EXP.
+func expBytes(e uint64) int {
+	n := 0
+	for ; e != 0; e >>= 8 {
+		if e&0xFF != 0 {
+			n++
+		}
+	}
+	return n
+}
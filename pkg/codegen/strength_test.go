@@ -0,0 +1,67 @@
+package codegen
+
+import "testing"
+
+// TestReductionMatchesOpcode exécute chaque séquence de réduction sur la VM
+// de test et compare au résultat de l'opcode qu'elle remplace.
+func TestReductionMatchesOpcode(t *testing.T) {
+	xs := []int64{0, 1, -1, 7, -7, 8, -8, 1000, -1000, 1 << 62, -1 << 63, 1<<63 - 1}
+	cs := []int64{0, 1, -1, 2, 8, 1 << 20, 1 << 62, 3, 5, 10, 255}
+	ops := []Opcode{OP_MUL, OP_DIV, OP_MOD, OP_SDIV, OP_SMOD, OP_EXP}
+	reduced := 0
+	for _, op := range ops {
+		for _, c := range cs {
+			seq, ok := reduction(op, uint64(c))
+			if !ok {
+				continue
+			}
+			reduced++
+			for _, inst := range seq {
+				switch inst.Op {
+				case OP_DIV, OP_SDIV, OP_MOD, OP_SMOD, OP_EXP:
+					t.Errorf("%s by %d: reduction uses %s", op, c, inst.Op)
+				}
+			}
+			for _, x := range xs {
+				want, _ := Eval(op, uint64(x), uint64(c))
+				code := append([]Instruction{PushInstr(x)}, seq...)
+				code = append(code, PushInstr(1), Instruction{Op: OP_SSTORE})
+				got := run(code).storage[1]
+				if got != want[0] {
+					t.Errorf("%s(%d, %d): reduction gives %d, want %d (%s)", op, x, c, int64(got), int64(want[0]), listing(seq))
+				}
+			}
+		}
+	}
+	if reduced < 20 {
+		t.Errorf("only %d reductions", reduced)
+	}
+}
+
+func TestStrengthReductionInCode(t *testing.T) {
+	src := `I64 x = SLoad(0) - 5;
+SStore(1, x * 8);
+SStore(2, 16 * x);
+SStore(3, x / 4);
+SStore(4, x % 4);
+SStore(5, Div(x, 32));
+SStore(6, Mod(x, 32));
+SStore(7, x ` + "`" + ` 5);
+`
+	_, plain := generate(t, src)
+	cg := NewCodeGen()
+	cg.Passes = Passes{Strength: true, Peephole: true}
+	p := folded(t, src)
+	code := cg.Generate(p)
+	// SDIV et SMOD peuvent rester : leur réduction n'est pas toujours moins
+	// chère que l'opcode au barème par défaut.
+	for _, inst := range code {
+		switch inst.Op {
+		case OP_DIV, OP_MOD, OP_EXP:
+			t.Errorf("%s left in reduced code", inst.Op)
+		}
+	}
+	if got, want := run(code).String(), run(plain).String(); got != want {
+		t.Errorf("reduced: %s, plain: %s", got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// tailCaller porte l'état de EliminateTailCalls.
+type tailCaller struct {
+	cg    *CodeGen
+	funcs map[string]*parser.FuncDecl
+	calls map[string]map[string]bool // fonctions appelées par chaque corps
+	sites int                        // dernier corps copié (Tail.Fonction.nom.n)
+	temps int                        // dernier argument copié (Tail.arg.n)
+	jumps int
+	check bool // CheckTailCalls : rien n'est réécrit
+}
+
+// tailMachine est la fonction réécrite en cours de construction : les
+// noms, dans son corps, des paramètres de chaque fonction du groupe.
+type tailMachine struct {
+	group  []*parser.FuncDecl
+	params map[string][]string
+	state  map[string]int // numéro de chaque fonction du groupe
+}
+
+// EliminateTailCalls réécrit en place les fonctions récursives, seules ou
+// mutuellement, dont tous les appels à leur groupe (les fonctions qui
+// s'appellent l'une l'autre) sont terminaux — return F(x); ou, pour une
+// fonction U0, F(x); en fin de corps — et retourne le nombre d'appels
+// remplacés. Chaque fonction du groupe devient une boucle sur les corps du
+// groupe (renommés, Tail.Fonction.nom.N, sauf le sien) : un appel terminal
+// affecte les paramètres de l'appelée, par des temporaires évalués de
+// gauche à droite, choisit son corps (Tail.state) et repart au début de la
+// boucle au lieu d'empiler un retour ; un return ordinaire affecte
+// Tail.result et termine la boucle. La fonction n'est alors plus récursive
+// et InlineFunctions peut l'inliner. Une fonction marquée [[tailcall]] dont
+// le groupe ne peut pas être réécrit (appel non terminal, return dans une
+// boucle...) est une erreur.
+func (cg *CodeGen) EliminateTailCalls(prog *parser.Program) int {
+	return cg.tailCalls(prog, false)
+}
+
+// CheckTailCalls signale, comme EliminateTailCalls mais sans rien
+// réécrire, les fonctions marquées [[tailcall]] dont le groupe ne pourrait
+// pas l'être : OptimizeAST l'appelle quand Passes.TailCalls est désactivé,
+// pour que l'attribut soit vérifié à tous les niveaux.
+func (cg *CodeGen) CheckTailCalls(prog *parser.Program) {
+	cg.tailCalls(prog, true)
+}
+
+func (cg *CodeGen) tailCalls(prog *parser.Program, check bool) int {
+	t := &tailCaller{cg: cg, funcs: make(map[string]*parser.FuncDecl), calls: make(map[string]map[string]bool), check: check}
+	var order []*parser.FuncDecl
+	for _, d := range prog.Decls {
+		if fn, ok := d.(*parser.FuncDecl); ok && fn.Body != nil {
+			if _, dup := t.funcs[fn.Name]; !dup {
+				order = append(order, fn)
+			}
+			t.funcs[fn.Name] = fn
+		}
+	}
+	for _, fn := range order {
+		t.calls[fn.Name] = make(map[string]bool)
+		walk(fn.Body, func(n parser.Node) {
+			if call, ok := n.(*parser.CallExpr); ok && t.funcs[call.Func] != nil {
+				t.calls[fn.Name][call.Func] = true
+			}
+		})
+	}
+
+	done := make(map[string]bool)
+	for _, fn := range order {
+		if done[fn.Name] || !t.reaches(fn.Name, fn.Name, map[string]bool{}) {
+			continue
+		}
+		var group []*parser.FuncDecl
+		for _, g := range order {
+			if t.reaches(fn.Name, g.Name, map[string]bool{}) && t.reaches(g.Name, fn.Name, map[string]bool{}) {
+				group = append(group, g)
+				done[g.Name] = true
+			}
+		}
+		if why := t.rewrite(group); why != "" {
+			for _, g := range group {
+				if g.TailCall {
+					cg.errorAt("tail-call", g.Span(), "function '%s' requires tail calls: %s", g.Name, why)
+				}
+			}
+		}
+	}
+	return t.jumps
+}
+
+// reaches indique si le corps de from appelle to, directement ou non.
+func (t *tailCaller) reaches(from, to string, seen map[string]bool) bool {
+	if seen[from] {
+		return false
+	}
+	seen[from] = true
+	for callee := range t.calls[from] {
+		if callee == to || t.reaches(callee, to, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite réécrit les fonctions de group, ou retourne pourquoi elles
+// restent récursives.
+func (t *tailCaller) rewrite(group []*parser.FuncDecl) string {
+	for _, fn := range group {
+		for _, p := range fn.Params {
+			if p.Name == "" {
+				return fmt.Sprintf("'%s' has an unnamed parameter", fn.Name)
+			}
+		}
+	}
+
+	bodies := make(map[string]*parser.Block)
+	jumps := 0
+	for _, fn := range group {
+		m := &tailMachine{group: group, params: make(map[string][]string), state: make(map[string]int)}
+		body, n, why := t.machine(fn, m)
+		if why != "" {
+			return why
+		}
+		bodies[fn.Name] = body
+		jumps += n
+	}
+	if t.check {
+		return ""
+	}
+	// Tout le groupe est réécrit, ou rien.
+	for _, fn := range group {
+		fn.Body = bodies[fn.Name]
+	}
+	t.jumps += jumps
+	return ""
+}
+
+// machine construit le corps réécrit de fn et retourne le nombre d'appels
+// terminaux de son propre corps.
+func (t *tailCaller) machine(fn *parser.FuncDecl, m *tailMachine) (*parser.Block, int, string) {
+	var decls []parser.Node
+	for i, g := range m.group {
+		m.state[g.Name] = i
+		names := make([]string, len(g.Params))
+		for j, p := range g.Params {
+			names[j] = p.Name
+			if g != fn {
+				t.sites++
+				names[j] = fmt.Sprintf("Tail.%s.%s.%d", g.Name, p.Name, t.sites)
+				decls = append(decls, &parser.VarDecl{TypeName: p.TypeName, Name: names[j], IsPtr: strings.HasSuffix(p.TypeName, "*")})
+			}
+		}
+		m.params[g.Name] = names
+	}
+
+	void := fn.ReturnType == "U0" || fn.ReturnType == "I0"
+	var cases []parser.Node
+	own := 0
+	for _, g := range m.group {
+		t.sites++
+		r := &renamer{prefix: "Tail." + g.Name, site: t.sites, free: make(map[string]bool)}
+		r.pushScope()
+		for j, p := range g.Params {
+			r.scopes[0][p.Name] = m.params[g.Name][j]
+		}
+		stmts := r.stmts(g.Body.Stmts)
+		if g != fn {
+			for _, p := range fn.Params {
+				if r.free[p.Name] {
+					return nil, 0, fmt.Sprintf("'%s' reads '%s', which a parameter of '%s' shadows", g.Name, p.Name, fn.Name)
+				}
+			}
+		}
+
+		jumps := 0
+		stmts = t.tails(stmts, true, g.ReturnType == "U0" || g.ReturnType == "I0", m, &jumps)
+		stmts, ok := rewriteReturns(stmts, "Tail.result", fmt.Sprintf("Tail.done.%d", t.sites))
+		if !ok {
+			return nil, 0, fmt.Sprintf("'%s' returns inside a loop", g.Name)
+		}
+		var stray string
+		walk(&parser.Block{Stmts: stmts}, func(n parser.Node) {
+			if call, ok := n.(*parser.CallExpr); ok && stray == "" {
+				if _, member := m.params[call.Func]; member {
+					stray = fmt.Sprintf("call to '%s' in '%s' is not in tail position", call.Func, g.Name)
+				}
+			}
+		})
+		if stray != "" {
+			return nil, 0, stray
+		}
+		if g == fn {
+			own = jumps
+		}
+		cases = append(cases, &parser.Block{Stmts: stmts})
+	}
+
+	// Corps de la boucle : le corps choisi par Tail.state, du dernier au premier.
+	loop := cases[len(cases)-1]
+	for i := len(cases) - 2; i >= 0; i-- {
+		loop = &parser.IfStmt{
+			Cond: &parser.BinaryExpr{Op: lexer.TOK_EQ, Left: &parser.Identifier{Name: "Tail.state"}, Right: &parser.IntLiteral{Value: int64(i)}},
+			Body: cases[i],
+			Else: loop,
+		}
+	}
+	body := &parser.Block{Stmts: []parser.Node{
+		&parser.VarDecl{TypeName: "I64", Name: "Tail.again", Init: &parser.IntLiteral{Value: 1}},
+	}}
+	if len(m.group) > 1 {
+		body.Stmts = append(body.Stmts, &parser.VarDecl{TypeName: "I64", Name: "Tail.state", Init: &parser.IntLiteral{Value: int64(m.state[fn.Name])}})
+	}
+	if !void {
+		body.Stmts = append(body.Stmts, &parser.VarDecl{TypeName: fn.ReturnType, Name: "Tail.result"})
+	}
+	body.Stmts = append(body.Stmts, decls...)
+	body.Stmts = append(body.Stmts, &parser.WhileStmt{
+		Cond: &parser.Identifier{Name: "Tail.again"},
+		Body: &parser.Block{Stmts: []parser.Node{assign("Tail.again", &parser.IntLiteral{Value: 0}), loop}},
+	})
+	if !void {
+		body.Stmts = append(body.Stmts, &parser.ReturnStmt{Value: &parser.Identifier{Name: "Tail.result"}})
+	}
+	return body, own, ""
+}
+
+// tails remplace les appels terminaux de stmts (en position terminale si
+// tail) par un saut au début de la boucle (voir jump).
+func (t *tailCaller) tails(stmts []parser.Node, tail, void bool, m *tailMachine, jumps *int) []parser.Node {
+	for i, s := range stmts {
+		last := tail && i == len(stmts)-1
+		if void && i+1 < len(stmts) {
+			if ret, ok := stmts[i+1].(*parser.ReturnStmt); ok && ret.Value == nil {
+				last = true
+			}
+		}
+		switch n := s.(type) {
+		case *parser.ReturnStmt:
+			if call := t.member(n.Value, m); call != nil {
+				stmts[i] = t.jump(call, m, jumps)
+			}
+		case *parser.ExprStmt:
+			if call := t.member(n.Expr, m); call != nil && void && last {
+				stmts[i] = t.jump(call, m, jumps)
+			}
+		case *parser.Block:
+			n.Stmts = t.tails(n.Stmts, last, void, m, jumps)
+		case *parser.IfStmt:
+			n.Body = &parser.Block{Stmts: t.tails(stmtList(n.Body), last, void, m, jumps)}
+			if n.Else != nil {
+				n.Else = &parser.Block{Stmts: t.tails(stmtList(n.Else), last, void, m, jumps)}
+			}
+		}
+	}
+	return stmts
+}
+
+// member retourne node si c'est un appel à une fonction du groupe.
+func (t *tailCaller) member(node parser.Node, m *tailMachine) *parser.CallExpr {
+	call, ok := node.(*parser.CallExpr)
+	if !ok {
+		return nil
+	}
+	if _, member := m.params[call.Func]; !member || len(call.Args) > len(t.funcs[call.Func].Params) {
+		return nil
+	}
+	for _, p := range t.funcs[call.Func].Params[len(call.Args):] {
+		if p.Default == nil {
+			return nil // argument manquant : l'appel reste
+		}
+	}
+	return call
+}
+
+// jump retourne ce qui remplace l'appel terminal call : les arguments dans
+// des temporaires, puis dans les paramètres de l'appelée, le choix de son
+// corps et un return (sans valeur) qui termine le tour.
+func (t *tailCaller) jump(call *parser.CallExpr, m *tailMachine, jumps *int) parser.Node {
+	callee := t.funcs[call.Func]
+	var temps, assigns []parser.Node
+	for i, p := range callee.Params {
+		arg := p.Default
+		if i < len(call.Args) {
+			arg = call.Args[i]
+		} else {
+			r := &renamer{prefix: "Tail", free: make(map[string]bool)}
+			r.pushScope()
+			arg = r.node(arg)
+		}
+		t.temps++
+		temp := fmt.Sprintf("Tail.arg.%d", t.temps)
+		temps = append(temps, &parser.VarDecl{TypeName: p.TypeName, Name: temp, Init: arg, IsPtr: strings.HasSuffix(p.TypeName, "*")})
+		assigns = append(assigns, assign(m.params[callee.Name][i], &parser.Identifier{Name: temp}))
+	}
+	stmts := append(temps, assigns...)
+	if len(m.group) > 1 {
+		stmts = append(stmts, assign("Tail.state", &parser.IntLiteral{Value: int64(m.state[callee.Name])}))
+	}
+	stmts = append(stmts, assign("Tail.again", &parser.IntLiteral{Value: 1}), &parser.ReturnStmt{})
+	*jumps++
+	return &parser.Block{Stmts: stmts}
+}
+
+// assign retourne l'instruction name = value.
+func assign(name string, value parser.Node) parser.Node {
+	return &parser.ExprStmt{Expr: &parser.AssignExpr{Op: lexer.TOK_ASSIGN, Target: &parser.Identifier{Name: name}, Value: value}}
+}
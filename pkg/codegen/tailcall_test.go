@@ -0,0 +1,98 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/diag"
+)
+
+func TestEliminateTailCalls(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		jumps int
+		want  string
+	}{
+		{"gcd", `I64 g = Gcd(SLoad(5) + 1071, 462);
+SStore(1, g);
+[[tailcall]] I64 Gcd(I64 a, I64 b) {
+  if (b == 0) return a;
+  return Gcd(b, a % b);
+}
+`, 1, "storage={1:21}"},
+		{"mutual", `I64 e = IsEven(SLoad(0) + 7);
+I64 o = IsOdd(SLoad(0) + 7);
+SStore(1, e);
+SStore(2, o);
+I64 IsEven(I64 n) {
+  if (n == 0) return 1;
+  return IsOdd(n - 1);
+}
+I64 IsOdd(I64 n) {
+  if (n == 0) return 0;
+  return IsEven(n - 1);
+}
+`, 2, "storage={1:0 2:1}"},
+		{"u0", `Count(SLoad(0) + 3, 10);
+U0 Count(I64 n, I64 key) {
+  if (n == 0) return;
+  SStore(key, n);
+  Count(n - 1, key + 1);
+}
+`, 1, "storage={10:3 11:2 12:1}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, l := range []OptLevel{O2, Os} {
+				cg, code := inlineAt(t, tt.src, l)
+				if diag.HasErrors(cg.Diagnostics) {
+					t.Fatalf("%s: %v", l, messages(cg, diag.Error))
+				}
+				if cg.TailCalls != tt.jumps {
+					t.Errorf("%s: %d call(s) turned into jumps, want %d", l, cg.TailCalls, tt.jumps)
+				}
+				// Les corps des fonctions suivent le code qui les appelle :
+				// l'exécution y entre par chute, seul le stockage compte.
+				got := run(code).String()
+				if got = got[strings.Index(got, "storage="):]; got != tt.want {
+					t.Errorf("%s: got %s, want %s", l, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTailCallRequired(t *testing.T) {
+	src := `SStore(1, Fact(5));
+[[tailcall]] I64 Fact(I64 n) {
+  if (n < 2) return 1;
+  return n * Fact(n - 1);
+}
+`
+	// L'attribut est vérifié à tous les niveaux, réécrit ou non.
+	for _, l := range []OptLevel{O0, O1, O2, Os} {
+		cg, _ := inlineAt(t, src, l)
+		errs := messages(cg, diag.Error)
+		if cg.TailCalls != 0 || len(errs) == 0 || !strings.HasPrefix(errs[0], "function 'Fact' requires tail calls: ") {
+			t.Errorf("%s: %d tail call(s), errors %q", l, cg.TailCalls, errs)
+		}
+	}
+
+	// Sans la passe, un groupe valide n'est ni réécrit ni signalé (l'appel
+	// resté en place est l'erreur habituelle).
+	src = `[[tailcall]] I64 Sum(I64 n, I64 acc) {
+  if (n == 0) return acc;
+  return Sum(n - 1, acc + n);
+}
+`
+	cg, _ := inlineAt(t, src, O1)
+	for _, e := range messages(cg, diag.Error) {
+		if strings.Contains(e, "requires tail calls") {
+			t.Errorf("-O1: %s", e)
+		}
+	}
+	if cg.TailCalls != 0 {
+		t.Errorf("-O1: %d tail call(s)", cg.TailCalls)
+	}
+}
@@ -0,0 +1,109 @@
+package codegen
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MaxStackDepth est le nombre maximal d'éléments sur la pile de la VM,
+// comme dans l'EVM ; au-delà l'exécution échoue.
+const MaxStackDepth = 1024
+
+// StackError est une violation de pile détectée par VerifyStack.
+type StackError struct {
+	Index  int // instruction fautive
+	Offset int // son offset en octets
+	Msg    string
+}
+
+func (e StackError) Error() string {
+	return fmt.Sprintf("%04d (0x%04X): %s", e.Index, e.Offset, e.Msg)
+}
+
+// StackCheck est le résultat de VerifyStack.
+type StackCheck struct {
+	Heights   []int // hauteur de pile avant chaque instruction, -1 si inatteignable
+	MaxHeight int
+	Errors    []StackError
+}
+
+// OK indique qu'aucune violation n'a été trouvée.
+func (c *StackCheck) OK() bool { return len(c.Errors) == 0 }
+
+func (c *StackCheck) errorf(offsets []int, i int, format string, args ...any) {
+	c.Errors = append(c.Errors, StackError{Index: i, Offset: offsets[i], Msg: fmt.Sprintf(format, args...)})
+}
+
+// VerifyStack calcule la hauteur de pile le long de chaque chemin du CFG,
+// à partir d'une pile vide à l'offset 0, d'après les Args/Results de
+// opcodeInfo. Sont rejetés : les opcodes inconnus, le manque d'opérandes,
+// les DUP/SWAP qui lisent au-delà de la pile, les hauteurs différentes à
+// l'entrée d'un même bloc et le dépassement de MaxStackDepth. Les sauts
+// dynamiques ne sont pas suivis.
+func VerifyStack(code []Instruction) *StackCheck {
+	chk := &StackCheck{Heights: make([]int, len(code))}
+	for i := range chk.Heights {
+		chk.Heights[i] = -1
+	}
+	if len(code) == 0 {
+		return chk
+	}
+	g := BuildCFG(code)
+	offsets := Offsets(code)
+
+	entry := make([]int, len(g.Blocks))
+	for i := range entry {
+		entry[i] = -1
+	}
+	entry[0] = 0
+	work := []int{0}
+	for len(work) > 0 {
+		b := g.Blocks[work[len(work)-1]]
+		work = work[:len(work)-1]
+
+		h, aborted := entry[b.Index], false
+		for i := b.Start; i < b.End; i++ {
+			chk.Heights[i] = h
+			op := code[i].Op
+			info, ok := opcodeInfo[op]
+			if !ok {
+				chk.errorf(offsets, i, "unknown opcode 0x%02X", byte(op))
+				aborted = true
+				break
+			}
+			if h < info.Args {
+				switch {
+				case op >= OP_DUP1 && op <= OP_DUP8, op >= OP_SWAP1 && op <= OP_SWAP8:
+					chk.errorf(offsets, i, "%s reaches stack[%d] but the stack holds %d item(s)", op, info.Args-1, h)
+				default:
+					chk.errorf(offsets, i, "stack underflow: %s needs %d operand(s), %d available", op, info.Args, h)
+				}
+				h = info.Args // on poursuit comme si les opérandes étaient là
+			}
+			h += info.Results - info.Args
+			if h > MaxStackDepth {
+				chk.errorf(offsets, i, "stack overflow: height %d exceeds %d", h, MaxStackDepth)
+			}
+			chk.MaxHeight = max(chk.MaxHeight, h)
+		}
+		if aborted {
+			continue
+		}
+
+		for _, s := range b.Succs {
+			switch entry[s] {
+			case -1:
+				entry[s] = h
+				work = append(work, s)
+			case h:
+			default:
+				start := g.Blocks[s].Start
+				chk.errorf(offsets, start, "inconsistent stack height at merge: %d from %04d, %d on another path",
+					h, b.End-1, entry[s])
+			}
+		}
+	}
+
+	sort.SliceStable(chk.Errors, func(i, j int) bool { return chk.Errors[i].Index < chk.Errors[j].Index })
+	return chk
+}
@@ -0,0 +1,93 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// listing rend le code sur une ligne, étiquettes comprises.
+func listing(code []Instruction) string {
+	lines := make([]string, len(code))
+	for i, inst := range code {
+		lines[i] = inst.String()
+		if inst.Label != 0 {
+			lines[i] += fmt.Sprintf(" @%d", inst.Label)
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+func TestVerifyStackAcceptsGeneratedCode(t *testing.T) {
+	_, code := generate(t, `I64 s = 0;
+for (I64 i = 0; i < 4; i++) {
+  if (i % 2) s += AddCarry(i, s, 1);
+  else s -= 1;
+}
+SStore(1, s);
+`)
+	chk := VerifyStack(code)
+	if !chk.OK() {
+		t.Fatalf("errors: %v", chk.Errors)
+	}
+	if chk.MaxHeight < 3 || chk.Heights[0] != 0 {
+		t.Errorf("max height %d, first height %d", chk.MaxHeight, chk.Heights[0])
+	}
+}
+
+func TestVerifyStackErrors(t *testing.T) {
+	merge := []Instruction{
+		{Op: OP_CALLER}, {Op: OP_PUSH1, Label: 1}, {Op: OP_JUMPI},
+		{Op: OP_PUSH0}, // une valeur de plus par chute
+		{Op: OP_JUMPDEST, Label: 1}, {Op: OP_STOP},
+	}
+	if err := ResolveLabels(merge); err != nil {
+		t.Fatal(err)
+	}
+	deep := make([]Instruction, MaxStackDepth+1)
+	for i := range deep {
+		deep[i] = Instruction{Op: OP_PUSH0}
+	}
+	tests := []struct {
+		name string
+		code []Instruction
+		want string
+	}{
+		{"underflow", []Instruction{{Op: OP_PUSH0}, {Op: OP_ADD}}, "0001 (0x0001): stack underflow: ADD needs 2 operand(s), 1 available"},
+		{"dup", []Instruction{{Op: OP_PUSH0}, {Op: OP_DUP2}}, "DUP2 reaches stack[1] but the stack holds 1 item(s)"},
+		{"swap", []Instruction{{Op: OP_PUSH0}, {Op: OP_SWAP1}}, "SWAP1 reaches stack[1] but the stack holds 1 item(s)"},
+		{"unknown", []Instruction{{Op: 0xEE}}, "unknown opcode 0xEE"},
+		{"merge", merge, "0004 (0x0005): inconsistent stack height at merge"},
+		{"overflow", deep, "stack overflow: height 1025 exceeds 1024"},
+	}
+	for _, tt := range tests {
+		chk := VerifyStack(tt.code)
+		if chk.OK() || !strings.Contains(chk.Errors[0].Error(), tt.want) {
+			t.Errorf("%s: errors %v, want %q", tt.name, chk.Errors, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	code := []Instruction{
+		{Op: OP_PUSH0}, {Op: OP_PUSH1, Operand: 0x7F}, {Op: OP_PUSH2, Operand: 0x1234},
+		{Op: OP_PUSH8, Operand: 0x0102030405060708}, {Op: OP_ADD}, {Op: OP_STOP},
+	}
+	data := Encode(code)
+	if got, want := len(data), Offsets(code)[len(code)]; got != want {
+		t.Fatalf("%d bytes, want %d", got, want)
+	}
+	if data[4] != 0x34 || data[5] != 0x12 {
+		t.Errorf("PUSH2 immediate % X, want little-endian 34 12", data[4:6])
+	}
+	back, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing(back) != listing(code) {
+		t.Errorf("decoded %s, want %s", listing(back), listing(code))
+	}
+	if _, err := Decode([]byte{byte(OP_PUSH2), 0x01}); err == nil || !strings.Contains(err.Error(), "truncated (1 of 2 immediate bytes)") {
+		t.Errorf("truncated PUSH2: error %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSteps borne l'exécution d'un programme de test.
+const maxSteps = 1 << 20
+
+// outcome est ce qu'un programme laisse d'observable : comment il s'est
+// terminé, ce qu'il retourne et le stockage. La mémoire n'en fait pas
+// partie : les niveaux n'y placent pas les variables aux mêmes adresses.
+// stack est la pile à la fin de l'exécution, hors de String.
+type outcome struct {
+	status  string
+	ret     []byte
+	storage map[uint64]uint64
+	stack   []uint64
+}
+
+func (o outcome) String() string {
+	keys := make([]uint64, 0, len(o.storage))
+	for k := range o.storage {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s ret=%x storage={", o.status, o.ret)
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%d:%d", k, int64(o.storage[k]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// run exécute code sur une VM réduite : pile, mémoire, stockage et
+// stockage transitoire. Les opcodes purs passent par Eval ; les opcodes de
+// contexte rendent une valeur fixe tirée de l'opcode et de ses arguments.
+func run(code []Instruction) outcome {
+	offsets := Offsets(code)
+	at := make(map[int]int) // offset d'un JUMPDEST → index
+	for i, inst := range code {
+		if inst.Op == OP_JUMPDEST {
+			at[offsets[i]] = i
+		}
+	}
+	var stack []uint64
+	var mem []byte
+	storage := make(map[uint64]uint64)
+	transient := make(map[uint64]uint64)
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	grow := func(n int) {
+		for len(mem) < n {
+			mem = append(mem, 0)
+		}
+	}
+	end := func(status string) outcome {
+		return outcome{status: status, storage: storage, stack: stack}
+	}
+
+	for pc, steps := 0, 0; ; steps++ {
+		if steps > maxSteps {
+			return end("timeout")
+		}
+		if pc >= len(code) {
+			return end("stop")
+		}
+		inst := code[pc]
+		op := inst.Op
+		pc++
+		if op == OP_PUSH0 || op.IsPush() {
+			stack = append(stack, uint64(inst.Operand))
+			continue
+		}
+		info, ok := opcodeInfo[op]
+		if !ok {
+			return end(fmt.Sprintf("unknown opcode %s at %d", op, pc-1))
+		}
+		if len(stack) < info.Args {
+			return end(fmt.Sprintf("stack underflow at %d (%s)", pc-1, op))
+		}
+		switch name := info.Name; {
+		case op == OP_STOP:
+			return end("stop")
+		case op == OP_INVALID:
+			return end("invalid")
+		case op == OP_JUMPDEST:
+		case strings.HasPrefix(name, "DUP"):
+			n, _ := strconv.Atoi(name[3:])
+			stack = append(stack, stack[len(stack)-n])
+		case strings.HasPrefix(name, "SWAP"):
+			n, _ := strconv.Atoi(name[4:])
+			top := len(stack) - 1
+			stack[top], stack[top-n] = stack[top-n], stack[top]
+		case op == OP_POP:
+			pop()
+		case op == OP_MLOAD, op == OP_MLOAD16, op == OP_MLOAD32, op == OP_MLOAD16S, op == OP_MLOAD32S:
+			a := int(pop())
+			grow(a + 8)
+			v := binary.LittleEndian.Uint64(mem[a:])
+			switch op {
+			case OP_MLOAD16:
+				v &= 0xFFFF
+			case OP_MLOAD32:
+				v &= 0xFFFFFFFF
+			case OP_MLOAD16S:
+				v = uint64(int64(int16(v)))
+			case OP_MLOAD32S:
+				v = uint64(int64(int32(v)))
+			}
+			stack = append(stack, v)
+		case op == OP_MSTORE, op == OP_MSTORE8, op == OP_MSTORE16, op == OP_MSTORE32:
+			a, v := int(pop()), pop()
+			n := map[Opcode]int{OP_MSTORE: 8, OP_MSTORE8: 1, OP_MSTORE16: 2, OP_MSTORE32: 4}[op]
+			grow(a + 8)
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], v)
+			copy(mem[a:a+n], buf[:n])
+		case op == OP_SLOAD:
+			stack = append(stack, storage[pop()])
+		case op == OP_SSTORE:
+			k, v := pop(), pop()
+			storage[k] = v
+		case op == OP_TLOAD:
+			stack = append(stack, transient[pop()])
+		case op == OP_TSTORE:
+			k, v := pop(), pop()
+			transient[k] = v
+		case op == OP_JUMP, op == OP_JUMPI:
+			dest := int(pop())
+			if op == OP_JUMPI && pop() == 0 {
+				break
+			}
+			target, ok := at[dest]
+			if !ok {
+				return end(fmt.Sprintf("bad jump to 0x%X", dest))
+			}
+			pc = target
+		case op == OP_RETURN, op == OP_REVERT:
+			off, size := int(pop()), int(pop())
+			grow(off + size)
+			o := end("return")
+			if op == OP_REVERT {
+				o.status = "revert"
+			}
+			o.ret = append([]byte(nil), mem[off:off+size]...)
+			return o
+		case op == OP_HASH:
+			off, size := int(pop()), int(pop())
+			grow(off + size)
+			h := uint64(14695981039346656037) // FNV-1a
+			for _, c := range mem[off : off+size] {
+				h = (h ^ uint64(c)) * 1099511628211
+			}
+			stack = append(stack, h)
+		case op == OP_MSIZE, op == OP_GAS, op == OP_PC:
+			stack = append(stack, 12345)
+		default:
+			in := make([]uint64, info.Args)
+			for i := range in {
+				in[i] = pop()
+			}
+			out, ok := Eval(op, in...)
+			if !ok {
+				// Contexte (CALLER...), copies, journaux : une valeur fixe
+				// par opcode et arguments, ou rien.
+				out = nil
+				if info.Results > 0 {
+					v := uint64(op)*1000 + 7
+					for _, x := range in {
+						v = v*31 + x
+					}
+					out = make([]uint64, info.Results)
+					for i := range out {
+						out[i] = v + uint64(i)
+					}
+				}
+			}
+			for i := len(out) - 1; i >= 0; i-- {
+				stack = append(stack, out[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package codegen
+
+import (
+	"math"
+	"sort"
+)
+
+// ProgramEntry est le nom donné par AnalyzeWorstCase au programme entier,
+// exécuté depuis l'offset 0.
+const ProgramEntry = "<program>"
+
+// WorstCase est le pire cas en gas d'une fonction, calculé sur le CFG.
+type WorstCase struct {
+	Name   string
+	Public bool
+	Gas    int // valable seulement si !Unbounded
+
+	// Unbounded indique qu'aucun pire cas fini n'a pu être établi ; les
+	// champs suivants en donnent la raison.
+	Unbounded      bool
+	UnboundedLoops []int // instruction d'en-tête (JUMPDEST) des boucles sans borne
+	DynamicCosts   []int // instructions dont le coût dynamique n'est pas borné
+	DynamicJumps   []int // JUMP/JUMPI de destination inconnue
+	Recursive      bool  // la fonction appartient à un cycle d'appels
+	Irreducible    bool  // cycle sans en-tête dominant : boucle non structurée
+}
+
+// AnalyzeWorstCase calcule le pire cas en gas du programme entier puis de
+// chaque fonction de funcs. Les boucles sont bornées par bounds (étiquette
+// de l'en-tête → nombre maximal d'itérations, voir CodeGen.LoopBounds) ; une
+// boucle sans borne, une récursion ou un coût dynamique non borné rendent
+// le résultat Unbounded.
+func AnalyzeWorstCase(code []Instruction, funcs []FuncInfo, bounds map[int]int64, s *GasSchedule) []WorstCase {
+	g := BuildCFG(code)
+	costs := EstimateGas(code, s).Instrs
+	recursive := recursiveFuncs(funcs)
+
+	var results []WorstCase
+	if len(code) > 0 {
+		wc := WorstCase{Name: ProgramEntry}
+		worstCaseRegion(g, costs, bounds, 0, nil, &wc)
+		results = append(results, wc)
+	}
+	for _, fn := range funcs {
+		wc := WorstCase{Name: fn.Name, Public: fn.Public, Recursive: recursive[fn.Name]}
+		if fn.End > fn.Start {
+			region := make(map[int]bool)
+			for i := fn.Start; i < fn.End; i++ {
+				region[g.blockOf[i]] = true
+			}
+			worstCaseRegion(g, costs, bounds, g.blockOf[fn.Start], region, &wc)
+		}
+		if wc.Recursive {
+			wc.Unbounded = true
+		}
+		results = append(results, wc)
+	}
+	return results
+}
+
+// recursiveFuncs retourne les fonctions appartenant à un cycle d'appels.
+func recursiveFuncs(funcs []FuncInfo) map[string]bool {
+	calls := make(map[string][]string, len(funcs))
+	for _, fn := range funcs {
+		calls[fn.Name] = append(calls[fn.Name], fn.Calls...)
+	}
+	rec := make(map[string]bool)
+	for _, fn := range funcs {
+		seen := map[string]bool{}
+		stack := append([]string(nil), calls[fn.Name]...)
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if f == fn.Name {
+				rec[fn.Name] = true
+				break
+			}
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			stack = append(stack, calls[f]...)
+		}
+	}
+	return rec
+}
+
+// loopInfo est une boucle naturelle : son en-tête, ses blocs, ses blocs de retour.
+type loopInfo struct {
+	header  int
+	body    map[int]bool
+	latches []int
+}
+
+// worstCaseRegion calcule le plus long chemin depuis entry dans la région,
+// chaque boucle étant réduite à un nœud de coût bound×itération + sortie.
+func worstCaseRegion(g *CFG, costs []GasCost, bounds map[int]int64, entry int, region map[int]bool, wc *WorstCase) {
+	dom := g.Dominators(entry, region)
+	reach := func(b int) bool { _, ok := dom[b]; return ok }
+	succs := func(b int) []int {
+		var out []int
+		for _, s := range g.Blocks[b].Succs {
+			if reach(s) {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	nodeCost := make(map[int]int, len(dom))
+	blocks := make([]int, 0, len(dom))
+	for b := range dom {
+		blocks = append(blocks, b)
+	}
+	sort.Ints(blocks)
+	for _, b := range blocks {
+		blk := g.Blocks[b]
+		for i := blk.Start; i < blk.End; i++ {
+			c := costs[i]
+			if c.Unbounded {
+				wc.DynamicCosts = append(wc.DynamicCosts, i)
+			}
+			nodeCost[b] = satAdd(nodeCost[b], c.Max)
+		}
+		if blk.DynamicJump {
+			wc.DynamicJumps = append(wc.DynamicJumps, blk.End-1)
+		}
+	}
+
+	// Boucles naturelles : un arc b → h est un arc retour si h domine b.
+	loops := make(map[int]*loopInfo)
+	for _, b := range blocks {
+		for _, h := range succs(b) {
+			if !dom[b][h] {
+				continue
+			}
+			l := loops[h]
+			if l == nil {
+				l = &loopInfo{header: h, body: map[int]bool{h: true}}
+				loops[h] = l
+			}
+			l.latches = append(l.latches, b)
+			stack := []int{b}
+			for len(stack) > 0 {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if l.body[n] {
+					continue
+				}
+				l.body[n] = true
+				for _, p := range g.Blocks[n].Preds {
+					if reach(p) {
+						stack = append(stack, p)
+					}
+				}
+			}
+		}
+	}
+	ordered := make([]*loopInfo, 0, len(loops))
+	for _, l := range loops {
+		ordered = append(ordered, l)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if len(ordered[i].body) != len(ordered[j].body) {
+			return len(ordered[i].body) < len(ordered[j].body)
+		}
+		return ordered[i].header < ordered[j].header
+	})
+
+	// Réduction des boucles, des plus internes aux plus externes.
+	rep := make(map[int]int, len(blocks))
+	for _, b := range blocks {
+		rep[b] = b
+	}
+	for _, l := range ordered {
+		h := l.header
+		edges := make(map[int][]int)
+		latch := make(map[int]bool)
+		exiting := make(map[int]bool)
+		for b := range l.body {
+			rb := rep[b]
+			if g.Blocks[b].Exit || g.Blocks[b].DynamicJump {
+				exiting[rb] = true
+			}
+			for _, s := range succs(b) {
+				rs := rep[s]
+				switch {
+				case rs == h:
+					latch[rb] = true
+				case !l.body[s]:
+					exiting[rb] = true
+				case rs != rb:
+					edges[rb] = append(edges[rb], rs)
+				}
+			}
+		}
+		dist, cyclic := longestPaths(h, edges, nodeCost)
+		if cyclic {
+			wc.Irreducible = true
+		}
+		iter, exit := 0, 0
+		for n := range latch {
+			iter = max(iter, dist[n])
+		}
+		for n := range exiting {
+			exit = max(exit, dist[n])
+		}
+
+		header := g.Code[g.Blocks[h].Start]
+		bound, ok := bounds[header.Label]
+		if header.Op != OP_JUMPDEST || header.Label == 0 || !ok {
+			wc.UnboundedLoops = append(wc.UnboundedLoops, g.Blocks[h].Start)
+			bound = 1
+		}
+		nodeCost[h] = satAdd(satMul(int(bound), iter), exit)
+		for _, b := range blocks {
+			if l.body[rep[b]] {
+				rep[b] = h
+			}
+		}
+	}
+
+	// Plus long chemin dans le graphe acyclique des blocs et boucles réduites.
+	edges := make(map[int][]int)
+	for _, b := range blocks {
+		for _, s := range succs(b) {
+			if rep[b] != rep[s] {
+				edges[rep[b]] = append(edges[rep[b]], rep[s])
+			}
+		}
+	}
+	dist, cyclic := longestPaths(rep[entry], edges, nodeCost)
+	if cyclic {
+		wc.Irreducible = true
+	}
+	for _, d := range dist {
+		wc.Gas = max(wc.Gas, d)
+	}
+
+	sort.Ints(wc.UnboundedLoops)
+	wc.Unbounded = wc.Unbounded || len(wc.UnboundedLoops) > 0 || len(wc.DynamicCosts) > 0 ||
+		len(wc.DynamicJumps) > 0 || wc.Irreducible || wc.Gas == math.MaxInt
+}
+
+// longestPaths calcule, pour chaque nœud atteignable depuis from, le coût
+// du plus long chemin from → nœud (coûts des nœuds inclus). cyclic indique
+// que le graphe contient un cycle (les distances restent alors partielles).
+func longestPaths(from int, edges map[int][]int, cost map[int]int) (dist map[int]int, cyclic bool) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[int]int)
+	var order []int
+	var visit func(n int)
+	visit = func(n int) {
+		state[n] = visiting
+		for _, s := range edges[n] {
+			switch state[s] {
+			case unvisited:
+				visit(s)
+			case visiting:
+				cyclic = true
+			}
+		}
+		state[n] = done
+		order = append(order, n)
+	}
+	visit(from)
+
+	dist = map[int]int{from: cost[from]}
+	for i := len(order) - 1; i >= 0; i-- {
+		n := order[i]
+		d, ok := dist[n]
+		if !ok {
+			continue
+		}
+		for _, s := range edges[n] {
+			if nd := satAdd(d, cost[s]); nd > dist[s] {
+				dist[s] = nd
+			}
+		}
+	}
+	return dist, cyclic
+}
+
+func satAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+func satMul(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
@@ -0,0 +1,126 @@
+package codegen
+
+import (
+	"fmt"
+	"testing"
+)
+
+// branch : un JUMPI vers L1, avec un STOP de chaque côté.
+func branch() []Instruction {
+	code := []Instruction{
+		{Op: OP_CALLER},
+		{Op: OP_PUSH1, Label: 1}, {Op: OP_JUMPI},
+		{Op: OP_PUSH1, Operand: 1}, {Op: OP_STOP},
+		{Op: OP_JUMPDEST, Label: 1}, {Op: OP_STOP},
+	}
+	if err := ResolveLabels(code); err != nil {
+		panic(err)
+	}
+	return code
+}
+
+func TestBuildCFG(t *testing.T) {
+	g := BuildCFG(branch())
+	want := []struct {
+		start, end int
+		succs      string
+		exit       bool
+	}{
+		{0, 3, "[2 1]", false},
+		{3, 5, "[]", true},
+		{5, 7, "[]", true},
+	}
+	if len(g.Blocks) != len(want) {
+		t.Fatalf("%d blocks, want %d", len(g.Blocks), len(want))
+	}
+	for i, w := range want {
+		b := g.Blocks[i]
+		if b.Start != w.start || b.End != w.end || fmt.Sprint(b.Succs) != w.succs || b.Exit != w.exit || b.DynamicJump {
+			t.Errorf("block %d: [%d,%d) succs %v exit %v dynamic %v, want [%d,%d) succs %s exit %v", i, b.Start, b.End, b.Succs, b.Exit, b.DynamicJump, w.start, w.end, w.succs, w.exit)
+		}
+	}
+	dom := g.Dominators(0, nil)
+	for b, want := range []string{"map[0:true]", "map[0:true 1:true]", "map[0:true 2:true]"} {
+		if got := fmt.Sprint(dom[b]); got != want {
+			t.Errorf("dom[%d] = %s, want %s", b, got, want)
+		}
+	}
+}
+
+func TestWorstCaseTakesCostliestPath(t *testing.T) {
+	code := branch()
+	s := DefaultGasSchedule()
+	cost := func(is ...int) int {
+		n := 0
+		for _, i := range is {
+			n += s.Cost(code[i].Op)
+		}
+		return n
+	}
+	// Le pire cas est le plus cher des deux chemins.
+	want := max(cost(0, 1, 2, 3, 4), cost(0, 1, 2, 5, 6))
+	wc := AnalyzeWorstCase(code, nil, nil, s)
+	if len(wc) != 1 || wc[0].Name != ProgramEntry || wc[0].Unbounded || wc[0].Gas != want {
+		t.Errorf("worst case = %+v, want %s with gas %d", wc, ProgramEntry, want)
+	}
+}
+
+func TestWorstCaseDynamicCost(t *testing.T) {
+	code := []Instruction{{Op: OP_CALLER}, {Op: OP_PUSH0}, {Op: OP_HASH}, {Op: OP_STOP}}
+	wc := AnalyzeWorstCase(code, nil, nil, nil)
+	if !wc[0].Unbounded || fmt.Sprint(wc[0].DynamicCosts) != "[2]" {
+		t.Errorf("worst case = %+v, want unbounded by the HASH at 2", wc[0])
+	}
+}
+
+func TestWorstCaseLoopBounds(t *testing.T) {
+	gas := func(bound int) int {
+		cg, code := generate(t, fmt.Sprintf(`public I64 F() {
+  I64 s = 0;
+  [[bound(%d)]]
+  while (s < 100) s = s + SLoad(s);
+  return s;
+}`, bound))
+		for _, wc := range AnalyzeWorstCase(code, cg.Funcs, cg.LoopBounds, nil) {
+			if wc.Name == "F" {
+				if wc.Unbounded || !wc.Public {
+					t.Fatalf("F: %+v, want a bounded public function", wc)
+				}
+				return wc.Gas
+			}
+		}
+		t.Fatal("no worst case for F")
+		return 0
+	}
+	// Chaque itération de plus coûte le même prix.
+	g4, g5, g8 := gas(4), gas(5), gas(8)
+	if iter := g5 - g4; iter <= 0 || g8-g4 != 4*iter {
+		t.Errorf("gas for bounds 4, 5, 8 = %d, %d, %d: not linear in the bound", g4, g5, g8)
+	}
+}
+
+func TestWorstCaseUnbounded(t *testing.T) {
+	cg, code := generate(t, `I64 Spin() {
+  I64 s = 0;
+  while (s < 100) s = s + 1;
+  return s;
+}
+I64 Ping() { return Pong(); }
+I64 Pong() { return Ping(); }
+`)
+	got := make(map[string]WorstCase)
+	for _, wc := range AnalyzeWorstCase(code, cg.Funcs, cg.LoopBounds, nil) {
+		got[wc.Name] = wc
+	}
+	if wc := got["Spin"]; !wc.Unbounded || len(wc.UnboundedLoops) != 1 || wc.Recursive {
+		t.Errorf("Spin: %+v, want one unbounded loop", wc)
+	}
+	for _, name := range []string{"Ping", "Pong"} {
+		if wc := got[name]; !wc.Unbounded || !wc.Recursive {
+			t.Errorf("%s: %+v, want recursive", name, wc)
+		}
+	}
+	if wc := got[ProgramEntry]; !wc.Unbounded {
+		t.Errorf("%s: %+v, want unbounded", ProgramEntry, wc)
+	}
+}
@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// ABIParam est un paramètre d'une fonction de l'ABI.
+type ABIParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Optional indique que le paramètre a une valeur par défaut.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ABIFunction décrit un point d'entrée du contrat : une fonction publique,
+// ou chaque fonction si aucune n'est déclarée public (comme pour le budget
+// de gas).
+type ABIFunction struct {
+	Name   string     `json:"name"`
+	Inputs []ABIParam `json:"inputs"`
+	Output string     `json:"output"`
+	Span   lexer.Span `json:"span"`
+}
+
+// abiOf extrait l'ABI des déclarations de prog, telles qu'écrites dans le
+// source : à appeler avant les passes sur l'AST, qui retirent les fonctions
+// inlinées ou mortes.
+func abiOf(prog *parser.Program) []ABIFunction {
+	var funcs []*parser.FuncDecl
+	anyPublic := false
+	for _, decl := range prog.Decls {
+		if fn, ok := decl.(*parser.FuncDecl); ok {
+			funcs = append(funcs, fn)
+			anyPublic = anyPublic || fn.Public
+		}
+	}
+	abi := []ABIFunction{}
+	for _, fn := range funcs {
+		if anyPublic && !fn.Public {
+			continue
+		}
+		entry := ABIFunction{Name: fn.Name, Inputs: []ABIParam{}, Output: fn.ReturnType, Span: fn.Span()}
+		for _, p := range fn.Params {
+			entry.Inputs = append(entry.Inputs, ABIParam{Name: p.Name, Type: p.TypeName, Optional: p.Default != nil})
+		}
+		abi = append(abi, entry)
+	}
+	return abi
+}
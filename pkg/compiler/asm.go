@@ -0,0 +1,142 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/lexer"
+)
+
+// formatAsm produit le listing de art : chaque instruction avec son gas,
+// les totaux, le résumé des passes exécutées et, au-dessus de -O0, la
+// comparaison avec le code -O0. Avec withSource, chaque ligne du source qui
+// produit du code est rappelée en commentaire avant la première de ses
+// instructions.
+func formatAsm(art *Artifact, withSource bool) string {
+	var b strings.Builder
+	lines := make(map[string][]string)
+	if withSource {
+		for _, src := range art.Sources {
+			lines[src.Name] = strings.Split(src.Text, "\n")
+		}
+	}
+	est := art.Gas
+	var shown lexer.Pos
+	for i, inst := range art.Instructions {
+		start := inst.Span.Start
+		if src := lines[start.File]; src != nil && (start.File != shown.File || start.Line != shown.Line) && start.Line <= len(src) {
+			fmt.Fprintf(&b, "; %4d | %s\n", start.Line, strings.TrimRight(src[start.Line-1], " \t\r"))
+			shown = start
+		}
+		fmt.Fprintf(&b, "  %04d  %-20s  ; 0x%02X  gas=%s\n", i, inst.String(), byte(inst.Op), est.Instrs[i])
+	}
+	fmt.Fprintf(&b, "\n; Total: %d instructions, estimated gas: %s\n", len(art.Instructions), est.Total)
+	if est.MemUnbounded {
+		fmt.Fprintf(&b, "; Memory high-water: >= %d bytes (unknown offsets)\n", est.MemHighWater)
+	} else {
+		fmt.Fprintf(&b, "; Memory high-water: %d bytes\n", est.MemHighWater)
+	}
+
+	passes, stats := art.Passes, art.Stats
+	if passes.TailCalls {
+		fmt.Fprintf(&b, "; Tail calls: %d call(s) turned into jumps\n", stats.TailCalls)
+	}
+	if passes.Inline {
+		writeInlining(&b, stats.Inlined)
+	}
+	if passes.Fold {
+		fmt.Fprintf(&b, "; Constant folding: %d expression(s) folded\n", stats.Folded)
+	}
+	if passes.DeadCode {
+		fmt.Fprintf(&b, "; Dead code: %d item(s) eliminated\n", len(stats.Eliminated))
+	}
+	if passes.Loops {
+		fmt.Fprintf(&b, "; Loops: %d invariant(s) hoisted, %d induction variable(s) reduced, %d loop(s) unrolled\n", stats.Hoisted, stats.Reduced, stats.Unrolled)
+	}
+	if passes.Storage {
+		fmt.Fprintf(&b, "; Storage: %d SLOAD(s) cached, %d SSTORE(s) merged\n", stats.CachedLoads, stats.MergedStores)
+	}
+	if passes.CSE {
+		fmt.Fprintf(&b, "; CSE: %d subexpression(s) reused\n", stats.Reused)
+	}
+	if passes.Peephole {
+		writeRewrites(&b, "Peephole", stats.Peepholed)
+	}
+	if art.ViaIR {
+		writeRewrites(&b, "IR passes", stats.IR)
+	}
+	switch {
+	case passes.StackLocals:
+		fmt.Fprintf(&b, "; Stack locals: %d resident in %d stack slot(s), %d spilled to memory\n", stats.Resident, stats.Slots, stats.Spilled)
+	case art.Level.Passes().StackLocals:
+		b.WriteString("; Stack locals: skipped (needs --ir)\n")
+	}
+	if art.Level != codegen.O0 {
+		sizeAfter := len(art.Bytecode)
+		fmt.Fprintf(&b, "; %s (%s) vs -O0 layout: size %d -> %d bytes (%s), %s\n", art.Level, passes.Cost,
+			stats.BaselineSize, sizeAfter, percent(stats.BaselineSize, sizeAfter), gasChange(art))
+		if stats.BaselineCalls > 0 {
+			fmt.Fprintf(&b, "; -O0 layout: %d call(s) left without code (no CALL opcode), not counted above\n", stats.BaselineCalls)
+		}
+	}
+	return b.String()
+}
+
+// gasChange compare le gas du code à celui du code -O0, tel quel : les
+// appels qu'il laisse sans code (Stats.BaselineCalls) n'y coûtent rien.
+// Compare le pire cas du programme, qui compte chaque boucle autant de
+// fois que sa borne, quand il est borné des deux côtés ; sinon
+// l'estimation en ligne droite, qui ne compte qu'une fois le corps de
+// chaque boucle et pénalise donc le déroulage et la sortie des invariants.
+func gasChange(art *Artifact) string {
+	before, after := art.Stats.BaselineWorstCase, programWorstCase(art.WorstCase)
+	if !before.Unbounded && !after.Unbounded {
+		return fmt.Sprintf("worst-case gas %d -> %d (%s)", before.Gas, after.Gas, percent(before.Gas, after.Gas))
+	}
+	gasBefore, gasAfter := art.Stats.BaselineGas, art.Gas.Total
+	return fmt.Sprintf("straight-line gas %s -> %s (%s, loop bodies counted once)",
+		gasBefore, gasAfter, percent(gasBefore.Min, gasAfter.Min))
+}
+
+// percent formate la variation relative de before à after.
+func percent(before, after int) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*float64(after-before)/float64(before))
+}
+
+// writeInlining résume les décisions de l'inliner.
+func writeInlining(b *strings.Builder, decisions []codegen.InlineDecision) {
+	if len(decisions) == 0 {
+		b.WriteString("; Inlining: no calls\n")
+		return
+	}
+	parts := make([]string, len(decisions))
+	for i, d := range decisions {
+		parts[i] = fmt.Sprintf("%s x%d", d.Func, d.Inlined)
+		if d.Reason != "" {
+			parts[i] += fmt.Sprintf(" (not inlined: %s)", d.Reason)
+		}
+	}
+	fmt.Fprintf(b, "; Inlining: %s\n", strings.Join(parts, ", "))
+}
+
+// writeRewrites résume les réécritures d'un optimiseur, par nom de règle
+// (lucarne, passes sur l'IR).
+func writeRewrites(b *strings.Builder, title string, applied map[string]int) {
+	names := make([]string, 0, len(applied))
+	total := 0
+	for name, n := range applied {
+		names = append(names, fmt.Sprintf("%s x%d", name, n))
+		total += n
+	}
+	sort.Strings(names)
+	if total == 0 {
+		fmt.Fprintf(b, "; %s: no rewrites\n", title)
+		return
+	}
+	fmt.Fprintf(b, "; %s: %d rewrite(s): %s\n", title, total, strings.Join(names, ", "))
+}
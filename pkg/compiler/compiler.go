@@ -0,0 +1,275 @@
+// Package compiler enchaîne les étapes de la compilation (lexer, parser,
+// génération directe ou par l'IR, vérifications) et rassemble leurs
+// résultats dans un Artifact. La commande holyc n'en est qu'une interface.
+package compiler
+
+import (
+	"path/filepath"
+	"strings"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/ir"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// Source est un fichier source : son nom, qui situe les diagnostics et la
+// source map, et son contenu.
+type Source struct {
+	Name string
+	Text string
+}
+
+// Options règle la compilation. La valeur zéro compile en -O0, avec le
+// barème de gas par défaut.
+type Options struct {
+	Level    codegen.OptLevel
+	Cost     *codegen.CostModel   // remplace l'objectif du niveau (nil : celui du niveau)
+	Schedule *codegen.GasSchedule // barème de gas (nil : DefaultGasSchedule)
+	// ViaIR génère le code par l'IR (ir.Lower, ir.Schedule) plutôt que
+	// directement depuis l'AST.
+	ViaIR bool
+	// AsmSource rappelle dans Artifact.Asm chaque ligne du source avant la
+	// première de ses instructions.
+	AsmSource bool
+}
+
+// passes retourne les passes qu'exécute la compilation. StackLocals n'en
+// fait partie qu'avec ViaIR : Generate garde les variables en mémoire.
+func (o Options) passes() codegen.Passes {
+	passes := o.Level.Passes()
+	if o.Cost != nil {
+		passes.Cost = *o.Cost
+	}
+	passes.StackLocals = passes.StackLocals && o.ViaIR
+	return passes
+}
+
+func (o Options) schedule() *codegen.GasSchedule {
+	if o.Schedule == nil {
+		return codegen.DefaultGasSchedule()
+	}
+	return o.Schedule
+}
+
+// Stats rend compte des optimisations ; seuls comptent les champs des
+// passes exécutées (Artifact.Passes).
+type Stats struct {
+	TailCalls    int
+	Inlined      []codegen.InlineDecision
+	Folded       int
+	Eliminated   []string
+	Hoisted      int
+	Reduced      int
+	Unrolled     int
+	CachedLoads  int
+	MergedStores int
+	Reused       int
+	Peepholed    map[string]int
+	// IR compte les réécritures des passes sur l'IR (Artifact.ViaIR, voir
+	// ir.Optimize).
+	IR map[string]int
+	// Resident et Spilled comptent les variables gardées sur la pile ou
+	// renvoyées en mémoire, Slots les emplacements de pile que partagent
+	// les résidentes (Artifact.ViaIR avec StackLocals).
+	Resident int
+	Spilled  int
+	Slots    int
+	// BaselineSize, BaselineGas et BaselineWorstCase sont la taille, le gas
+	// estimé en ligne droite et le pire cas du programme pour le même source
+	// compilé en -O0, pour comparaison (au-dessus de -O0). BaselineCalls
+	// compte les appels que -O0 laisse sans code, faute d'opcode CALL : ni
+	// la taille ni le gas de référence ne les comptent.
+	BaselineSize      int
+	BaselineGas       codegen.GasCost
+	BaselineWorstCase codegen.WorstCase
+	BaselineCalls     int
+}
+
+// Artifact est le résultat d'une compilation : un contrat.
+type Artifact struct {
+	// Name est le nom du contrat : celui du premier source, sans
+	// répertoire ni extension.
+	Name    string
+	Sources []Source
+	Level   codegen.OptLevel
+	Passes  codegen.Passes
+	ViaIR   bool
+
+	Instructions []codegen.Instruction // étiquettes résolues
+	Bytecode     []byte                // Instructions encodées (.hcb)
+	Funcs        []codegen.FuncInfo
+	LoopBounds   map[int]int64
+	// Asm est le listing commenté : gas de chaque instruction, totaux et
+	// résumé des optimisations.
+	Asm string
+
+	Schedule  *codegen.GasSchedule
+	Gas       *codegen.GasEstimate
+	WorstCase []codegen.WorstCase
+
+	ABI       []ABIFunction
+	SourceMap *codegen.SourceMap
+	Storage   *codegen.StorageLayout
+	Stats     Stats
+}
+
+// Compile compile sources, analysées dans l'ordre comme un seul programme.
+// Les diagnostics de toutes les étapes sont retournés triés par position.
+// L'artefact est nil si une erreur empêche de produire du code (erreur de
+// syntaxe, IR impossible à ordonnancer) ; les erreurs de génération, comme
+// un appel qui n'est pas un builtin, laissent un artefact.
+func Compile(sources []Source, opts Options) (*Artifact, []diag.Diagnostic) {
+	prog, diags := parse(sources)
+	if diag.HasErrors(diags) {
+		diag.Sort(diags)
+		return nil, diags
+	}
+	art := &Artifact{
+		Name:     contractName(sources),
+		Sources:  sources,
+		Level:    opts.Level,
+		Passes:   opts.passes(),
+		ViaIR:    opts.ViaIR,
+		Schedule: opts.schedule(),
+		ABI:      abiOf(prog),
+	}
+
+	cg := codegen.NewCodeGen()
+	cg.Passes = art.Passes
+	cg.Schedule = art.Schedule
+	if opts.ViaIR {
+		cg.OptimizeAST(prog)
+		lowered, lowerDiags := ir.Lower(prog)
+		diags = append(append(diags, cg.Diagnostics...), lowerDiags...)
+		art.Stats.IR = ir.Optimize(lowered, art.Passes, art.Schedule)
+		out, err := ir.Schedule(lowered, art.Passes, art.Schedule)
+		if err != nil {
+			diags = append(diags, diag.Errorf("schedule", lexer.Span{}, "%v", err))
+			diag.Sort(diags)
+			return nil, diags
+		}
+		// Les diagnostics de l'AST sont déjà dans diags.
+		cg.Diagnostics = nil
+		art.Instructions = cg.Assemble(out.Code, out.Funcs, out.LoopBounds)
+		art.Stats.Resident, art.Stats.Spilled, art.Stats.Slots = out.Resident, out.Spilled, out.Slots
+	} else {
+		art.Instructions = cg.Generate(prog)
+	}
+	diags = append(diags, cg.Diagnostics...)
+
+	art.Funcs, art.LoopBounds = cg.Funcs, cg.LoopBounds
+	art.Bytecode = codegen.Encode(art.Instructions)
+	art.Gas = codegen.EstimateGas(art.Instructions, art.Schedule)
+	art.WorstCase = codegen.AnalyzeWorstCase(art.Instructions, art.Funcs, art.LoopBounds, art.Schedule)
+	art.SourceMap = codegen.NewSourceMap(art.Instructions, art.Funcs)
+	art.Storage = codegen.AnalyzeStorage(art.Instructions, art.Funcs)
+	art.Stats.TailCalls, art.Stats.Inlined, art.Stats.Folded = cg.TailCalls, cg.Inlined, cg.Folded
+	art.Stats.Eliminated = cg.Eliminated
+	art.Stats.Hoisted, art.Stats.Reduced, art.Stats.Unrolled = cg.Hoisted, cg.Reduced, cg.Unrolled
+	art.Stats.CachedLoads, art.Stats.MergedStores = cg.CachedLoads, cg.MergedStores
+	art.Stats.Reused, art.Stats.Peepholed = cg.Reused, cg.Peepholed
+	if opts.Level != codegen.O0 {
+		baseline(sources, art.Schedule, &art.Stats)
+	}
+	art.Asm = formatAsm(art, opts.AsmSource)
+
+	diag.Sort(diags)
+	return art, diags
+}
+
+// LowerIR analyse sources et les traduit en IR après les passes sur l'AST
+// et sur l'IR de opts, sans ordonnancer le code ; le programme est nil si
+// une erreur de syntaxe l'empêche.
+func LowerIR(sources []Source, opts Options) (*ir.Program, []diag.Diagnostic) {
+	prog, diags := parse(sources)
+	if diag.HasErrors(diags) {
+		diag.Sort(diags)
+		return nil, diags
+	}
+	cg := codegen.NewCodeGen()
+	cg.Passes = opts.passes()
+	cg.Schedule = opts.schedule()
+	cg.OptimizeAST(prog)
+	lowered, lowerDiags := ir.Lower(prog)
+	ir.Optimize(lowered, cg.Passes, cg.Schedule)
+	diags = append(append(diags, cg.Diagnostics...), lowerDiags...)
+	diag.Sort(diags)
+	return lowered, diags
+}
+
+// parse analyse chaque source et réunit leurs déclarations en un
+// programme, avec les diagnostics des lexers et des parsers.
+func parse(sources []Source) (*parser.Program, []diag.Diagnostic) {
+	prog := &parser.Program{}
+	var diags []diag.Diagnostic
+	for i, src := range sources {
+		l := lexer.NewLexer(src.Text, src.Name)
+		p := parser.NewParser(l)
+		part := p.Parse()
+		diags = append(append(diags, l.Diagnostics...), p.Diagnostics...)
+		prog.Decls = append(prog.Decls, part.Decls...)
+		if i == 0 {
+			prog.SetSpan(part.Span())
+		}
+	}
+	return prog, diags
+}
+
+// baseline remplit les champs Baseline de stats avec sources compilées en
+// -O0.
+func baseline(sources []Source, schedule *codegen.GasSchedule, stats *Stats) {
+	prog, _ := parse(sources)
+	cg := codegen.NewCodeGen()
+	cg.Schedule = schedule
+	code := cg.Generate(prog)
+	stats.BaselineSize = len(codegen.Encode(code))
+	stats.BaselineGas = codegen.EstimateGas(code, schedule).Total
+	stats.BaselineWorstCase = programWorstCase(codegen.AnalyzeWorstCase(code, cg.Funcs, cg.LoopBounds, schedule))
+	stats.BaselineCalls = 0
+	for _, d := range cg.Diagnostics {
+		if d.Code == "not-builtin" {
+			stats.BaselineCalls++
+		}
+	}
+}
+
+// programWorstCase retourne le pire cas du programme entier dans report
+// (non borné si le code est vide).
+func programWorstCase(report []codegen.WorstCase) codegen.WorstCase {
+	for _, wc := range report {
+		if wc.Name == codegen.ProgramEntry {
+			return wc
+		}
+	}
+	return codegen.WorstCase{Name: codegen.ProgramEntry, Unbounded: true}
+}
+
+// contractName retourne le nom du premier source sans répertoire ni
+// extension.
+func contractName(sources []Source) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	base := filepath.Base(sources[0].Name)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// OverBudget retourne les points d'entrée dont le pire cas dépasse budget :
+// le programme et les fonctions publiques (toutes les fonctions si aucune
+// n'est déclarée public).
+func (a *Artifact) OverBudget(budget int) []string {
+	anyPublic := false
+	for _, wc := range a.WorstCase {
+		anyPublic = anyPublic || wc.Public
+	}
+	var over []string
+	for _, wc := range a.WorstCase {
+		entry := wc.Name == codegen.ProgramEntry || wc.Public || !anyPublic
+		if entry && (wc.Unbounded || wc.Gas > budget) {
+			over = append(over, wc.Name)
+		}
+	}
+	return over
+}
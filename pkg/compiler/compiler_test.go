@@ -0,0 +1,234 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/diag"
+)
+
+const token = `public I64 Balance(I64 who) {
+  return SLoad(who);
+}
+public U0 Mint(I64 who, I64 amount = 1) {
+  SStore(who, SLoad(who) + amount);
+}
+I64 Helper() { return 3; }
+SStore(0, 42);
+`
+
+// build compile sources et fait échouer le test sur une erreur.
+func build(t *testing.T, opts Options, sources ...Source) *Artifact {
+	t.Helper()
+	art, diags := Compile(sources, opts)
+	if art == nil || diag.HasErrors(diags) {
+		t.Fatalf("compilation failed: %v", diags)
+	}
+	return art
+}
+
+func TestCompile(t *testing.T) {
+	art := build(t, Options{}, Source{Name: "contracts/Token.HC", Text: token})
+	if art.Name != "Token" {
+		t.Errorf("name %q", art.Name)
+	}
+	if !bytes.Equal(art.Bytecode, codegen.Encode(art.Instructions)) {
+		t.Error("bytecode does not encode the instructions")
+	}
+	if art.Schedule == nil || art.Gas == nil || len(art.Gas.Instrs) != len(art.Instructions) {
+		t.Errorf("gas estimate %+v", art.Gas)
+	}
+	if e, ok := art.SourceMap.Lookup(len(art.Bytecode) - 1); !ok || e.Offset+e.Length != len(art.Bytecode) {
+		t.Errorf("source map does not cover the bytecode: %+v", art.SourceMap.Entries)
+	}
+	var names []string
+	for _, f := range art.Funcs {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "Balance Mint Helper" {
+		t.Errorf("funcs %q", got)
+	}
+	if len(art.Storage.Slots) != 1 || art.Storage.Slots[0].Key != 0 || len(art.Storage.Dynamic()) != 3 {
+		t.Errorf("storage %+v", art.Storage)
+	}
+	if art.Stats.BaselineSize != 0 {
+		t.Errorf("baseline at -O0: %d bytes", art.Stats.BaselineSize)
+	}
+	for _, want := range []string{"  0000  ", "; Total: ", "; Memory high-water: "} {
+		if !strings.Contains(art.Asm, want) {
+			t.Errorf("asm lacks %q:\n%s", want, art.Asm)
+		}
+	}
+	if strings.Contains(art.Asm, "; Constant folding") || strings.Contains(art.Asm, "-> ") {
+		t.Errorf("-O0 asm reports optimizations:\n%s", art.Asm)
+	}
+}
+
+func TestABI(t *testing.T) {
+	art := build(t, Options{Level: codegen.O2}, Source{Name: "Token.HC", Text: token})
+	var got []string
+	for _, fn := range art.ABI {
+		var params []string
+		for _, p := range fn.Inputs {
+			param := p.Type + " " + p.Name
+			if p.Optional {
+				param += "?"
+			}
+			params = append(params, param)
+		}
+		got = append(got, fn.Output+" "+fn.Name+"("+strings.Join(params, ", ")+")")
+	}
+	want := "I64 Balance(I64 who); U0 Mint(I64 who, I64 amount?)"
+	if strings.Join(got, "; ") != want {
+		t.Errorf("ABI %q, want %q", strings.Join(got, "; "), want)
+	}
+	if art.ABI[1].Span.Start.Line != 4 {
+		t.Errorf("Mint declared at %v", art.ABI[1].Span)
+	}
+
+	// Sans fonction publique, toutes sont des points d'entrée.
+	art = build(t, Options{}, Source{Name: "a.HC", Text: "I64 F() { return 1; }\nI64 G() { return 2; }\n"})
+	if len(art.ABI) != 2 || art.ABI[0].Name != "F" || art.ABI[1].Name != "G" {
+		t.Errorf("ABI without public functions: %+v", art.ABI)
+	}
+}
+
+func TestCompileOptimized(t *testing.T) {
+	src := "I64 x = SLoad(0) * (2 * 3);\nI64 unused = SLoad(9);\nSStore(1, x + 4);\n"
+	plain := build(t, Options{}, Source{Name: "a.HC", Text: src})
+	for _, viaIR := range []bool{false, true} {
+		art := build(t, Options{Level: codegen.O2, ViaIR: viaIR}, Source{Name: "a.HC", Text: src})
+		if art.Stats.Folded == 0 || len(art.Stats.Eliminated) != 1 {
+			t.Errorf("ir=%v: folded %d, eliminated %q", viaIR, art.Stats.Folded, art.Stats.Eliminated)
+		}
+		if art.Stats.BaselineSize != len(plain.Bytecode) || art.Stats.BaselineGas != plain.Gas.Total {
+			t.Errorf("ir=%v: baseline %d bytes %s, -O0 gives %d bytes %s", viaIR,
+				art.Stats.BaselineSize, art.Stats.BaselineGas, len(plain.Bytecode), plain.Gas.Total)
+		}
+		if len(art.Bytecode) >= len(plain.Bytecode) {
+			t.Errorf("ir=%v: %d bytes at -O2, %d at -O0", viaIR, len(art.Bytecode), len(plain.Bytecode))
+		}
+		for _, want := range []string{"; Constant folding: ", "; Dead code: 1 item(s) eliminated", "; -O2 (gas) vs -O0 layout: "} {
+			if !strings.Contains(art.Asm, want) {
+				t.Errorf("ir=%v: asm lacks %q:\n%s", viaIR, want, art.Asm)
+			}
+		}
+		for _, line := range []string{"; IR passes: ", " resident in "} {
+			if strings.Contains(art.Asm, line) != viaIR {
+				t.Errorf("ir=%v: %q line:\n%s", viaIR, line, art.Asm)
+			}
+		}
+		// Sans --ir, les variables restent en mémoire, et le résumé le dit.
+		if art.Passes.StackLocals != viaIR || strings.Contains(art.Asm, "; Stack locals: skipped (needs --ir)\n") == viaIR {
+			t.Errorf("ir=%v: StackLocals %v:\n%s", viaIR, art.Passes.StackLocals, art.Asm)
+		}
+	}
+}
+
+func TestAsmSource(t *testing.T) {
+	art := build(t, Options{AsmSource: true}, Source{Name: "a.HC", Text: "I64 x = 5;\n\nSStore(1, x);   \n"})
+	for _, want := range []string{";    1 | I64 x = 5;\n", ";    3 | SStore(1, x);\n"} {
+		if !strings.Contains(art.Asm, want) {
+			t.Errorf("asm lacks %q:\n%s", want, art.Asm)
+		}
+	}
+	if strings.Contains(art.Asm, ";    2 |") {
+		t.Errorf("asm shows a line without code:\n%s", art.Asm)
+	}
+}
+
+func TestCompileSources(t *testing.T) {
+	lib := Source{Name: "lib.HC", Text: "I64 Twice(I64 v) { return v * 2; }\n"}
+	main := Source{Name: "main.HC", Text: "SStore(1, Twice(SLoad(0)));\n"}
+	art := build(t, Options{Level: codegen.O2}, main, lib)
+	if art.Name != "main" || len(art.Stats.Inlined) != 1 || art.Stats.Inlined[0].Inlined != 1 {
+		t.Errorf("name %q, inlined %+v", art.Name, art.Stats.Inlined)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	// Les erreurs de syntaxe empêchent de produire du code ; les
+	// diagnostics sont triés par fichier et par position.
+	art, diags := Compile([]Source{
+		{Name: "b.HC", Text: "I64 x = );\n"},
+		{Name: "a.HC", Text: "I64 y = 1;\nI64 = 2;\n"},
+	}, Options{})
+	if art != nil {
+		t.Error("artifact despite syntax errors")
+	}
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.Range.Start.String()+" "+d.Code)
+	}
+	if want := "a.HC:2:5 expected-identifier; b.HC:1:9 unexpected-token"; strings.Join(got, "; ") != want {
+		t.Errorf("diagnostics %q, want %q", strings.Join(got, "; "), want)
+	}
+
+	// Une erreur de génération laisse un artefact.
+	art, diags = Compile([]Source{{Name: "a.HC", Text: "I64 y = 1;\nSStore(1, Sload(y));\n"}}, Options{})
+	if art == nil || len(art.Instructions) == 0 {
+		t.Fatal("no artifact after a code generation error")
+	}
+	if len(diags) != 1 || diags[0].Code != "not-builtin" {
+		t.Errorf("diagnostics %v", diags)
+	}
+}
+
+func TestLowerIR(t *testing.T) {
+	prog, diags := LowerIR([]Source{{Name: "a.HC", Text: "I64 x = 2 * 3;\nSStore(1, x);\n"}}, Options{Level: codegen.O2})
+	if prog == nil || diag.HasErrors(diags) {
+		t.Fatalf("lowering failed: %v", diags)
+	}
+	// L'AST est replié avant la traduction.
+	if got := prog.String(); !strings.Contains(got, "const 0x6") || strings.Contains(got, "MUL") {
+		t.Errorf("listing:\n%s", got)
+	}
+	if prog, _ := LowerIR([]Source{{Name: "a.HC", Text: "I64 = 1;\n"}}, Options{}); prog != nil {
+		t.Error("IR despite a syntax error")
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	src := "public I64 Cheap() { return 1; }\npublic U0 Spin() { while (SLoad(0)) SStore(1, 2); }\nI64 Internal() { while (1) {} return 0; }\n"
+	art := build(t, Options{}, Source{Name: "a.HC", Text: src})
+	// Internal n'est pas un point d'entrée : des fonctions sont publiques.
+	if got := strings.Join(art.OverBudget(1<<30), " "); got != "Spin" {
+		t.Errorf("over a large budget: %q", got)
+	}
+	if got := strings.Join(art.OverBudget(1), " "); got != codegen.ProgramEntry+" Cheap Spin" {
+		t.Errorf("over a budget of 1: %q", got)
+	}
+}
+
+func TestGasChange(t *testing.T) {
+	bounded := "I64 s = 0;\n#pragma bound 4\nfor (I64 i = 0; i < 4; i++) s += SLoad(i);\nSStore(1, s);\n"
+	art := build(t, Options{Level: codegen.O2}, Source{Name: "a.HC", Text: bounded})
+	before, after := art.Stats.BaselineWorstCase, programWorstCase(art.WorstCase)
+	if before.Unbounded || after.Unbounded || after.Gas >= before.Gas {
+		t.Fatalf("worst case %+v -> %+v", before, after)
+	}
+	want := fmt.Sprintf("worst-case gas %d -> %d (%s)", before.Gas, after.Gas, percent(before.Gas, after.Gas))
+	if !strings.Contains(art.Asm, want) {
+		t.Errorf("asm lacks %q:\n%s", want, art.Asm)
+	}
+
+	if art.Stats.BaselineCalls != 0 || strings.Contains(art.Asm, "; -O0 layout: ") {
+		t.Errorf("no calls: baseline calls %d:\n%s", art.Stats.BaselineCalls, art.Asm)
+	}
+
+	// -O0 n'a pas de code pour les appels que -O2 inline.
+	calls := "I64 Sq(I64 x) { return x * x; }\nSStore(1, Sq(SLoad(0)) + Sq(SLoad(1)));\n"
+	art = build(t, Options{Level: codegen.O2}, Source{Name: "a.HC", Text: calls})
+	if want := "; -O0 layout: 2 call(s) left without code (no CALL opcode), not counted above\n"; art.Stats.BaselineCalls != 2 || !strings.Contains(art.Asm, want) {
+		t.Errorf("baseline calls %d, asm lacks %q:\n%s", art.Stats.BaselineCalls, want, art.Asm)
+	}
+
+	unbounded := "I64 s = SLoad(0);\nwhile (s > 1) s = s / 2;\nSStore(1, s);\n"
+	art = build(t, Options{Level: codegen.O2}, Source{Name: "a.HC", Text: unbounded})
+	if !strings.Contains(art.Asm, "straight-line gas ") || !strings.Contains(art.Asm, ", loop bodies counted once)") {
+		t.Errorf("unbounded loop: asm does not label the straight-line estimate:\n%s", art.Asm)
+	}
+}
@@ -0,0 +1,183 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/lexer"
+)
+
+// Version est la version du compilateur, reportée dans les artefacts JSON.
+const Version = "0.1.0"
+
+// SourceHash retourne l'empreinte des sources de a, « sha256: » suivi de
+// l'empreinte en hexadécimal. Chaque source y entre dans l'ordre, son nom
+// puis son contenu, chacun précédé de sa longueur (« 4:a.HC10:U64 x = 1; »)
+// pour que deux découpages différents ne se confondent pas.
+func (a *Artifact) SourceHash() string {
+	h := sha256.New()
+	for _, src := range a.Sources {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(src.Name), src.Name, len(src.Text), src.Text)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// artifactJSON est le document JSON d'un artefact. Les clés de stockage et
+// les opérandes s'écrivent en hexadécimal, pour ne pas perdre de précision
+// au-delà de 2^53 dans les outils qui lisent les nombres en flottants.
+type artifactJSON struct {
+	Contract     string             `json:"contract"`
+	Compiler     string             `json:"compiler"`
+	Version      string             `json:"version"`
+	SourceHash   string             `json:"sourceHash"`
+	Sources      []string           `json:"sources"`
+	Level        string             `json:"level"`
+	Cost         string             `json:"cost"`
+	Bytecode     string             `json:"bytecode"`
+	Size         int                `json:"size"`
+	Instructions []instructionJSON  `json:"instructions"`
+	Gas          gasJSON            `json:"gas"`
+	ABI          []ABIFunction      `json:"abi"`
+	SourceMap    *codegen.SourceMap `json:"sourceMap"`
+	Storage      storageJSON        `json:"storage"`
+}
+
+type instructionJSON struct {
+	Index   int     `json:"index"`
+	Offset  int     `json:"offset"`
+	Opcode  string  `json:"opcode"` // octet de l'opcode, « 0x60 »
+	Op      string  `json:"op"`
+	Operand string  `json:"operand,omitempty"` // PUSH1..PUSH8 seulement
+	Gas     gasCost `json:"gas"`
+}
+
+type gasCost struct {
+	Min       int  `json:"min"`
+	Max       int  `json:"max"`
+	Unbounded bool `json:"unbounded,omitempty"`
+}
+
+type gasJSON struct {
+	Total        gasCost         `json:"total"`
+	MemHighWater int             `json:"memHighWater"`
+	MemUnbounded bool            `json:"memUnbounded,omitempty"`
+	WorstCase    []worstCaseJSON `json:"worstCase"`
+}
+
+// worstCaseJSON est le pire cas d'un point d'entrée ; Gas n'en est qu'une
+// borne basse si Unbounded.
+type worstCaseJSON struct {
+	Name      string `json:"name"`
+	Public    bool   `json:"public"`
+	Gas       int    `json:"gas"`
+	Unbounded bool   `json:"unbounded,omitempty"`
+}
+
+type storageJSON struct {
+	Slots   []slotJSON   `json:"slots"`
+	Dynamic []accessJSON `json:"dynamic"`
+}
+
+type slotJSON struct {
+	Key       string   `json:"key"`
+	Transient bool     `json:"transient"`
+	Reads     int      `json:"reads"`
+	Writes    int      `json:"writes"`
+	Functions []string `json:"functions"`
+}
+
+// accessJSON est un accès au stockage dont la clé n'est connue qu'à
+// l'exécution.
+type accessJSON struct {
+	Offset   int        `json:"offset"`
+	Op       string     `json:"op"`
+	Function string     `json:"function"`
+	Span     lexer.Span `json:"span"`
+}
+
+func toGasCost(c codegen.GasCost) gasCost {
+	return gasCost{Min: c.Min, Max: c.Max, Unbounded: c.Unbounded}
+}
+
+// MarshalJSON écrit a en un document autonome : bytecode en hexadécimal,
+// listing des instructions décodées du bytecode avec leur offset et leur
+// gas (leur origine est dans la source map), ABI, source map, stockage,
+// version du compilateur et empreinte des sources.
+func (a *Artifact) MarshalJSON() ([]byte, error) {
+	code, err := codegen.Decode(a.Bytecode)
+	if err != nil {
+		return nil, err
+	}
+	doc := artifactJSON{
+		Contract:     a.Name,
+		Compiler:     "holyc",
+		Version:      Version,
+		SourceHash:   a.SourceHash(),
+		Sources:      make([]string, len(a.Sources)),
+		Level:        a.Level.String(),
+		Cost:         a.Passes.Cost.String(),
+		Bytecode:     fmt.Sprintf("%X", a.Bytecode),
+		Size:         len(a.Bytecode),
+		Instructions: make([]instructionJSON, len(code)),
+		ABI:          a.ABI,
+		SourceMap:    a.SourceMap,
+		Storage:      storageJSON{Slots: []slotJSON{}, Dynamic: []accessJSON{}},
+	}
+	for i, src := range a.Sources {
+		doc.Sources[i] = src.Name
+	}
+	offsets := codegen.Offsets(code)
+	for i, inst := range code {
+		entry := instructionJSON{
+			Index:  i,
+			Offset: offsets[i],
+			Opcode: fmt.Sprintf("0x%02X", byte(inst.Op)),
+			Op:     inst.Op.String(),
+			Gas:    toGasCost(a.Gas.Instrs[i]),
+		}
+		if inst.Op.IsPush() {
+			entry.Operand = fmt.Sprintf("0x%X", uint64(inst.Operand))
+		}
+		doc.Instructions[i] = entry
+	}
+	doc.Gas = gasJSON{
+		Total:        toGasCost(a.Gas.Total),
+		MemHighWater: a.Gas.MemHighWater,
+		MemUnbounded: a.Gas.MemUnbounded,
+		WorstCase:    make([]worstCaseJSON, len(a.WorstCase)),
+	}
+	for i, wc := range a.WorstCase {
+		doc.Gas.WorstCase[i] = worstCaseJSON{Name: wc.Name, Public: wc.Public, Gas: wc.Gas, Unbounded: wc.Unbounded}
+	}
+	for _, slot := range a.Storage.Slots {
+		doc.Storage.Slots = append(doc.Storage.Slots, slotJSON{
+			Key:       fmt.Sprintf("0x%X", slot.Key),
+			Transient: slot.Transient,
+			Reads:     slot.Reads,
+			Writes:    slot.Writes,
+			Functions: slot.Funcs,
+		})
+	}
+	for _, access := range a.Storage.Dynamic() {
+		doc.Storage.Dynamic = append(doc.Storage.Dynamic, accessJSON{
+			Offset:   access.Offset,
+			Op:       access.Op.String(),
+			Function: access.Func,
+			Span:     access.Span,
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// WriteJSON écrit le document JSON de art (voir Artifact.MarshalJSON),
+// indenté et suivi d'un saut de ligne ; plusieurs artefacts écrits à la
+// suite forment un flux que json.Decoder relit un par un.
+func WriteJSON(w io.Writer, art *Artifact) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(art)
+}
@@ -0,0 +1,132 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/codegen"
+)
+
+func TestSourceHash(t *testing.T) {
+	a := &Artifact{Sources: []Source{{Name: "a.HC", Text: "U64 x = 1;"}}}
+	b := &Artifact{Sources: []Source{{Name: "a.HC", Text: "U64 x = 1;"}}}
+	if a.SourceHash() != b.SourceHash() || !strings.HasPrefix(a.SourceHash(), "sha256:") || len(a.SourceHash()) != len("sha256:")+64 {
+		t.Errorf("hash %q", a.SourceHash())
+	}
+	// Le découpage compte : mêmes octets, sources différentes.
+	c := &Artifact{Sources: []Source{{Name: "a.H", Text: "CU64 x = 1;"}}}
+	d := &Artifact{Sources: []Source{{Name: "a.HC", Text: "U64 x"}, {Name: "", Text: " = 1;"}}}
+	if a.SourceHash() == c.SourceHash() || a.SourceHash() == d.SourceHash() {
+		t.Error("different sources share a hash")
+	}
+}
+
+func TestArtifactJSON(t *testing.T) {
+	src := "I64 Get(I64 k) { return SLoad(k); }\nSStore(0x20000000000000, Get(3) + 1);\n"
+	art := build(t, Options{Level: codegen.O2}, Source{Name: "Vault.HC", Text: src})
+	var b bytes.Buffer
+	if err := WriteJSON(&b, art); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Contract     string
+		Compiler     string
+		Version      string
+		SourceHash   string
+		Sources      []string
+		Level        string
+		Bytecode     string
+		Size         int
+		Instructions []struct {
+			Index, Offset int
+			Opcode, Op    string
+			Operand       string
+		}
+		Gas struct {
+			Total     struct{ Min, Max int }
+			WorstCase []struct{ Name string }
+		}
+		ABI       []ABIFunction
+		SourceMap *codegen.SourceMap
+		Storage   struct {
+			Slots []struct {
+				Key       string
+				Functions []string
+			}
+			Dynamic []struct{ Op string }
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("%v:\n%s", err, b.String())
+	}
+	if doc.Contract != "Vault" || doc.Compiler != "holyc" || doc.Version != Version || doc.Level != "-O2" {
+		t.Errorf("header %q %q %q %q", doc.Contract, doc.Compiler, doc.Version, doc.Level)
+	}
+	if doc.SourceHash != art.SourceHash() || len(doc.Sources) != 1 || doc.Sources[0] != "Vault.HC" {
+		t.Errorf("sources %q, hash %q", doc.Sources, doc.SourceHash)
+	}
+	code, err := hex.DecodeString(doc.Bytecode)
+	if err != nil || !bytes.Equal(code, art.Bytecode) || doc.Size != len(code) {
+		t.Errorf("bytecode %q (%d bytes): %v", doc.Bytecode, doc.Size, err)
+	}
+	if len(doc.Instructions) != len(art.Instructions) {
+		t.Fatalf("%d instructions, want %d", len(doc.Instructions), len(art.Instructions))
+	}
+	offsets := codegen.Offsets(art.Instructions)
+	for i, inst := range doc.Instructions {
+		want := art.Instructions[i]
+		if inst.Index != i || inst.Offset != offsets[i] || inst.Op != want.Op.String() {
+			t.Errorf("instruction %d: %+v, want %s at %d", i, inst, want, offsets[i])
+		}
+		if (inst.Operand != "") != want.Op.IsPush() {
+			t.Errorf("instruction %d: operand %q for %s", i, inst.Operand, want.Op)
+		}
+	}
+	if doc.Gas.Total.Min != art.Gas.Total.Min || len(doc.Gas.WorstCase) != len(art.WorstCase) {
+		t.Errorf("gas %+v", doc.Gas)
+	}
+	if len(doc.ABI) != 1 || doc.ABI[0].Name != "Get" || doc.ABI[0].Span.Start.Line != 1 {
+		t.Errorf("ABI %+v", doc.ABI)
+	}
+	if doc.SourceMap == nil || len(doc.SourceMap.Entries) != len(art.SourceMap.Entries) {
+		t.Errorf("source map %+v", doc.SourceMap)
+	}
+	// Les clés au-delà de 2^53 restent exactes.
+	var keys []string
+	for _, s := range doc.Storage.Slots {
+		keys = append(keys, s.Key)
+	}
+	if got := strings.Join(keys, " "); got != "0x3 0x20000000000000" {
+		t.Errorf("storage keys %q", got)
+	}
+	if len(doc.Storage.Dynamic) != 0 {
+		t.Errorf("dynamic accesses %+v", doc.Storage.Dynamic)
+	}
+}
+
+// TestWriteJSONStream vérifie que des artefacts écrits à la suite se
+// relisent un par un.
+func TestWriteJSONStream(t *testing.T) {
+	var b bytes.Buffer
+	for _, name := range []string{"A.HC", "B.HC"} {
+		art := build(t, Options{}, Source{Name: name, Text: "SStore(1, 2);\n"})
+		if err := WriteJSON(&b, art); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := json.NewDecoder(&b)
+	var names []string
+	for dec.More() {
+		var doc struct{ Contract string }
+		if err := dec.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, doc.Contract)
+	}
+	if got := strings.Join(names, " "); got != "A B" {
+		t.Errorf("contracts %q", got)
+	}
+}
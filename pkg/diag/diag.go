@@ -0,0 +1,291 @@
+// Package diag définit les diagnostics communs à toutes les passes du
+// compilateur (lexer, parser, codegen, IR) et leur rendu.
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Pos est une position dans le source ; lignes et colonnes commencent à 1.
+type Pos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col) }
+
+// Span est l'étendue d'un nœud de l'AST : de son premier caractère (Start)
+// jusqu'à la position qui suit son dernier (End). Elle est nulle pour les
+// nœuds créés par le compilateur.
+type Span struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+// IsZero indique si s ne situe rien.
+func (s Span) IsZero() bool { return s.Start.Line == 0 }
+
+// Severity est la gravité d'un diagnostic.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+var severityNames = [...]string{Error: "error", Warning: "warning", Note: "note"}
+
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalJSON écrit la gravité sous son nom.
+func (s Severity) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// UnmarshalJSON relit une gravité écrite par MarshalJSON.
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, n := range severityNames {
+		if n == name {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity '%s'", name)
+}
+
+// FixIt propose de remplacer le texte de Range par Text (une insertion si
+// Range est vide, une suppression si Text l'est).
+type FixIt struct {
+	Range Span   `json:"range"`
+	Text  string `json:"text"`
+}
+
+// Diagnostic est une erreur, un avertissement ou une note situés dans le
+// source. Code identifie la famille du diagnostic (« expected-token »,
+// « dead-code »…) indépendamment du texte de Message. Range est nulle pour
+// un diagnostic qui ne vient d'aucun nœud.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Range    Span     `json:"range"`
+	Fixes    []FixIt  `json:"fixes,omitempty"`
+}
+
+// Errorf construit un diagnostic d'erreur.
+func Errorf(code string, rng Span, format string, args ...any) Diagnostic {
+	return Diagnostic{Severity: Error, Code: code, Message: fmt.Sprintf(format, args...), Range: rng}
+}
+
+// Warnf construit un avertissement.
+func Warnf(code string, rng Span, format string, args ...any) Diagnostic {
+	return Diagnostic{Severity: Warning, Code: code, Message: fmt.Sprintf(format, args...), Range: rng}
+}
+
+// String formate d sur une ligne : « file:line:col: error: message [code] ».
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if !d.Range.IsZero() {
+		b.WriteString(d.Range.Start.String())
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+	if d.Code != "" {
+		fmt.Fprintf(&b, " [%s]", d.Code)
+	}
+	return b.String()
+}
+
+// Error permet de retourner un diagnostic comme une error.
+func (d Diagnostic) Error() string { return d.String() }
+
+// HasErrors indique si ds contient au moins une erreur.
+func HasErrors(ds []Diagnostic) bool {
+	for _, d := range ds {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Count retourne le nombre d'erreurs et d'avertissements de ds.
+func Count(ds []Diagnostic) (errors, warnings int) {
+	for _, d := range ds {
+		switch d.Severity {
+		case Error:
+			errors++
+		case Warning:
+			warnings++
+		}
+	}
+	return errors, warnings
+}
+
+// Sort trie ds par fichier puis par position, les diagnostics sans
+// position en tête ; l'ordre d'émission départage les autres.
+func Sort(ds []Diagnostic) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		a, b := ds[i].Range.Start, ds[j].Range.Start
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+}
+
+// Render écrit ds sous forme lisible : chaque diagnostic sur une ligne,
+// suivi de la ligne du source concernée et d'un caret sous l'étendue,
+// puis de ses fix-its. sources associe à chaque nom de fichier son
+// contenu ; un fichier absent n'affiche que le message.
+func Render(w io.Writer, ds []Diagnostic, sources map[string]string) {
+	lines := make(map[string][]string)
+	for _, d := range ds {
+		fmt.Fprintln(w, d)
+		if d.Range.IsZero() {
+			continue
+		}
+		file := d.Range.Start.File
+		if _, ok := lines[file]; !ok {
+			if src, ok := sources[file]; ok {
+				lines[file] = strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+			} else {
+				lines[file] = nil
+			}
+		}
+		src := lines[file]
+		if d.Range.Start.Line > len(src) {
+			continue
+		}
+		line := src[d.Range.Start.Line-1]
+		fmt.Fprintf(w, "  %s\n  %s\n", expandTabs(line), caret(line, d.Range))
+		for _, fix := range d.Fixes {
+			switch {
+			case fix.Text == "":
+				fmt.Fprintf(w, "  fix: remove %s\n", fix.Range.Start)
+			case fix.Range.Start == fix.Range.End:
+				fmt.Fprintf(w, "  fix: insert '%s' at %s\n", fix.Text, fix.Range.Start)
+			default:
+				fmt.Fprintf(w, "  fix: replace %s with '%s'\n", fix.Range.Start, fix.Text)
+			}
+		}
+	}
+}
+
+// caret retourne la ligne « ^~~~ » qui souligne rng dans line ; une
+// étendue sur plusieurs lignes est soulignée jusqu'à la fin de la première.
+func caret(line string, rng Span) string {
+	start := rng.Start.Col - 1
+	end := len(line)
+	if rng.End.Line == rng.Start.Line && rng.End.Col-1 < end {
+		end = rng.End.Col - 1
+	}
+	if start > len(line) {
+		start = len(line)
+	}
+	if end <= start {
+		end = start + 1
+	}
+	var b strings.Builder
+	for i := 0; i < start; i++ {
+		if line[i] == '\t' {
+			b.WriteString("    ")
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	for i := start + 1; i < end; i++ {
+		if line[i] == '\t' {
+			b.WriteString("~~~~")
+		} else {
+			b.WriteByte('~')
+		}
+	}
+	return b.String()
+}
+
+// expandTabs remplace les tabulations par quatre espaces, comme caret.
+func expandTabs(line string) string { return strings.ReplaceAll(line, "\t", "    ") }
+
+// WriteJSON écrit ds en un tableau JSON.
+func WriteJSON(w io.Writer, ds []Diagnostic) error {
+	if ds == nil {
+		ds = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ds)
+}
+
+// Suggest retourne le candidat le plus proche de name, pour un message
+// « did you mean », ou "" si aucun n'est assez proche : au plus une faute
+// par tranche de quatre caractères. Une inversion de deux caractères
+// voisins ou une différence de casse comptent pour une demi-faute, seule
+// admise sous quatre caractères ; les égalités vont au premier candidat.
+func Suggest(name string, candidates []string) string {
+	best, bestDist := "", 0
+	limit := max(1, 2*(len(name)/4))
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		d := editDistance(strings.ToLower(name), strings.ToLower(c)) + caseDistance(name, c)
+		if d <= limit && (best == "" || d < bestDist) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance est la distance d'édition entre a et b, en demi-fautes :
+// 2 par insertion, suppression ou substitution, 1 par transposition de deux
+// caractères voisins.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = 2 * j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = 2 * i
+		for j := 1; j <= len(b); j++ {
+			cost := 2
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+2, cur[j-1]+2, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// caseDistance vaut 1 (une demi-faute) si a et b ne diffèrent que par la
+// casse.
+func caseDistance(a, b string) int {
+	if a != b && strings.EqualFold(a, b) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func span(file string, line, col, endCol int) Span {
+	return Span{Start: Pos{File: file, Line: line, Col: col}, End: Pos{File: file, Line: line, Col: endCol}}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Errorf("not-builtin", span("a.HC", 3, 7, 12), "function '%s' not a builtin", "Foo"), "a.HC:3:7: error: function 'Foo' not a builtin [not-builtin]"},
+		{Warnf("dead-code", Span{}, "eliminated dead code: %s", "unused variable 'x'"), "warning: eliminated dead code: unused variable 'x' [dead-code]"},
+		{Diagnostic{Severity: Note, Message: "declared here", Range: span("a.HC", 1, 1, 4)}, "a.HC:1:1: note: declared here"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+		if got := tt.d.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	ds := []Diagnostic{
+		Warnf("dead-code", Span{}, "w"),
+		{Severity: Note, Message: "n"},
+		Warnf("not-unrolled", Span{}, "w"),
+	}
+	if HasErrors(ds) {
+		t.Error("HasErrors without errors")
+	}
+	ds = append(ds, Errorf("expected-token", Span{}, "e"))
+	if !HasErrors(ds) {
+		t.Error("HasErrors missed an error")
+	}
+	if e, w := Count(ds); e != 1 || w != 2 {
+		t.Errorf("Count = %d, %d; want 1, 2", e, w)
+	}
+}
+
+func TestSort(t *testing.T) {
+	ds := []Diagnostic{
+		Errorf("a", span("b.HC", 1, 1, 2), "b1"),
+		Errorf("b", span("a.HC", 2, 5, 6), "a2-5"),
+		Errorf("c", Span{}, "none"),
+		Errorf("d", span("a.HC", 2, 1, 2), "a2-1"),
+		Errorf("e", span("a.HC", 10, 1, 2), "a10"),
+		Errorf("f", span("a.HC", 2, 1, 2), "a2-1 again"),
+	}
+	Sort(ds)
+	var got []string
+	for _, d := range ds {
+		got = append(got, d.Message)
+	}
+	want := []string{"none", "a2-1", "a2-1 again", "a2-5", "a10", "b1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := "I64 x;\n\n\t[[unroll(2)]] for (I64 i = 0; i < 3; i++) { x += i; }\n"
+	d := Errorf("invalid-attribute", span("contract.HC", 3, 4, 13), "[[unroll]] takes no arguments")
+	d.Fixes = []FixIt{{Range: d.Range, Text: "unroll"}}
+	del := Errorf("unexpected-character", span("contract.HC", 1, 6, 7), "unexpected character")
+	del.Fixes = []FixIt{{Range: del.Range}}
+	ds := []Diagnostic{
+		d,
+		Warnf("dead-code", Span{}, "no position"),
+		Errorf("x", span("other.HC", 1, 1, 2), "file without source"),
+		Errorf("x", Span{Start: Pos{File: "contract.HC", Line: 1, Col: 5}, End: Pos{File: "contract.HC", Line: 3, Col: 2}}, "multiline"),
+		del,
+	}
+	var b bytes.Buffer
+	Render(&b, ds, map[string]string{"contract.HC": src})
+	want := `contract.HC:3:4: error: [[unroll]] takes no arguments [invalid-attribute]
+      [[unroll(2)]] for (I64 i = 0; i < 3; i++) { x += i; }
+        ^~~~~~~~~
+  fix: replace contract.HC:3:4 with 'unroll'
+warning: no position [dead-code]
+other.HC:1:1: error: file without source [x]
+contract.HC:1:5: error: multiline [x]
+  I64 x;
+      ^~
+contract.HC:1:6: error: unexpected character [unexpected-character]
+  I64 x;
+       ^
+  fix: remove contract.HC:1:6
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteJSON(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(b.String()); got != "[]" {
+		t.Errorf("no diagnostics: %q", got)
+	}
+
+	d := Errorf("expected-token", span("a.HC", 2, 8, 9), "expected ';'")
+	d.Fixes = []FixIt{{Range: span("a.HC", 2, 8, 8), Text: ";"}}
+	ds := []Diagnostic{d, Warnf("dead-code", Span{}, "unused"), {Severity: Note, Message: "n"}}
+	b.Reset()
+	if err := WriteJSON(&b, ds); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"severity": "error"`) || !strings.Contains(b.String(), `"code": "expected-token"`) {
+		t.Errorf("JSON lacks severity or code:\n%s", b.String())
+	}
+	var back []Diagnostic
+	if err := json.Unmarshal(b.Bytes(), &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, ds) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", back, ds)
+	}
+	var s Severity
+	if err := json.Unmarshal([]byte(`"fatal"`), &s); err == nil || err.Error() != "unknown severity 'fatal'" {
+		t.Errorf("unknown severity: %v", err)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	keywords := []string{"if", "while", "for", "return", "public", "I64", "U64"}
+	tests := []struct {
+		name       string
+		candidates []string
+		want       string
+	}{
+		{"retrun", keywords, "return"},
+		{"whle", keywords, "while"},
+		{"fi", keywords, "if"},
+		{"fo", keywords, ""}, // une faute entière sous quatre caractères
+		{"i64", keywords, "I64"},
+		{"publik", keywords, "public"},
+		{"x", keywords, ""},
+		{"if", keywords, ""}, // le nom lui-même n'est pas une suggestion
+		{"Sload", []string{"MLoad", "SLoad", "SStore"}, "SLoad"},
+		{"SStroe", []string{"MLoad", "SLoad", "SStore"}, "SStore"},
+		{"cat", []string{"act", "cta"}, "act"},
+		{"Storage", []string{"SStore"}, ""},
+	}
+	for _, tt := range tests {
+		if got := Suggest(tt.name, tt.candidates); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderInsertion(t *testing.T) {
+	d := Errorf("expected-token", span("a.HC", 1, 10, 10), "expected ';', got identifier 'y'")
+	d.Fixes = []FixIt{{Range: d.Range, Text: ";"}}
+	var b bytes.Buffer
+	Render(&b, []Diagnostic{d}, map[string]string{"a.HC": "I64 x = 1\nI64 y;\n"})
+	want := `a.HC:1:10: error: expected ';', got identifier 'y' [expected-token]
+  I64 x = 1
+           ^
+  fix: insert ';' at a.HC:1:10
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
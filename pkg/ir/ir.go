@@ -0,0 +1,213 @@
+// Package ir est la représentation intermédiaire entre l'AST et le bytecode :
+// des blocs de base d'instructions sur des temporaires explicites, dont les
+// variables sont lues et écrites par Load et Store, et terminés par un
+// branchement explicite. Lower la construit depuis l'AST, Optimize
+// l'optimise et Schedule la ramène à une suite d'instructions de pile.
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/lexer"
+)
+
+// Type est le type d'un temporaire.
+type Type int
+
+const (
+	Word Type = iota // mot de 64 bits
+	Bool             // 0 ou 1 (comparaisons, ISZERO)
+)
+
+func (t Type) String() string {
+	if t == Bool {
+		return "bool"
+	}
+	return "word"
+}
+
+// Temp est un temporaire, défini par une seule instruction.
+type Temp struct {
+	ID   int
+	Type Type
+}
+
+func (t *Temp) String() string { return fmt.Sprintf("t%d", t.ID) }
+
+// Var est une variable du source, rangée dans un mot mémoire à Addr.
+type Var struct {
+	Name     string
+	TypeName string
+	Addr     int
+}
+
+func (v *Var) String() string { return v.Name }
+
+// Kind est la nature d'une instruction.
+type Kind int
+
+const (
+	Const Kind = iota // Dsts[0] = Value
+	Op                // Dsts = Op(Args...)
+	Load              // Dsts[0] = Var
+	Store             // Var = Args[0]
+)
+
+// Instr est une instruction de bloc. Pour Op, Args[0] est le premier
+// opérande de l'opcode, celui qu'il trouve au sommet de la pile, et Dsts[0]
+// son premier résultat. Span situe le nœud du source qui l'a produite.
+type Instr struct {
+	Kind  Kind
+	Op    codegen.Opcode
+	Value int64
+	Var   *Var
+	Args  []*Temp
+	Dsts  []*Temp
+	Span  lexer.Span
+}
+
+func (in *Instr) String() string {
+	switch in.Kind {
+	case Const:
+		return fmt.Sprintf("%s = const 0x%X", typed(in.Dsts[0]), uint64(in.Value))
+	case Load:
+		return fmt.Sprintf("%s = load %s", typed(in.Dsts[0]), in.Var)
+	case Store:
+		return fmt.Sprintf("store %s, %s", in.Var, in.Args[0])
+	}
+	var b strings.Builder
+	if len(in.Dsts) > 0 {
+		dsts := make([]string, len(in.Dsts))
+		for i, t := range in.Dsts {
+			dsts[i] = typed(t)
+		}
+		fmt.Fprintf(&b, "%s = ", strings.Join(dsts, ", "))
+	}
+	b.WriteString(in.Op.String())
+	for i, t := range in.Args {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+func typed(t *Temp) string { return fmt.Sprintf("%s:%s", t, t.Type) }
+
+// TermKind est la nature du branchement qui termine un bloc.
+type TermKind int
+
+const (
+	Jump   TermKind = iota // vers Then
+	Branch                 // vers Then si Cond est non nul, Else sinon
+	Return                 // fin d'exécution, renvoie Value (nil : aucune valeur)
+	Stop                   // fin d'exécution sans valeur de retour
+)
+
+// Term termine un bloc.
+type Term struct {
+	Kind  TermKind
+	Cond  *Temp
+	Value *Temp
+	Then  *Block
+	Else  *Block
+	Span  lexer.Span
+}
+
+func (t Term) String() string {
+	switch t.Kind {
+	case Jump:
+		return fmt.Sprintf("jmp %s", t.Then)
+	case Branch:
+		return fmt.Sprintf("br %s, %s, %s", t.Cond, t.Then, t.Else)
+	case Return:
+		if t.Value == nil {
+			return "ret"
+		}
+		return fmt.Sprintf("ret %s", t.Value)
+	}
+	return "stop"
+}
+
+// Block est un bloc de base.
+type Block struct {
+	ID     int
+	Instrs []*Instr
+	Term   Term
+	// Bound est le nombre maximal d'itérations annoncé pour la boucle dont
+	// le bloc est l'en-tête (0 = non annoté).
+	Bound int64
+}
+
+func (b *Block) String() string { return fmt.Sprintf("b%d", b.ID) }
+
+// Func est la région d'une fonction : les blocs Blocks[Start:End] du
+// programme, émis à l'endroit où la fonction est déclarée.
+type Func struct {
+	Name   string
+	Public bool
+	Start  int
+	End    int
+	Calls  []string // fonctions non builtin appelées depuis le corps
+	Params []*Var
+	Locals []*Var // variables déclarées dans le corps
+}
+
+// Program est un programme en IR. Les blocs sont dans l'ordre d'émission :
+// Blocks[0] est l'entrée, et un Jump vers le bloc suivant est une chute.
+type Program struct {
+	Blocks []*Block
+	Funcs  []Func
+	Vars   []*Var // dans l'ordre de déclaration
+
+	temps int
+}
+
+func (p *Program) newTemp(t Type) *Temp {
+	p.temps++
+	return &Temp{ID: p.temps - 1, Type: t}
+}
+
+// String retourne le listing du programme, tel qu'affiché par --dump-ir.
+func (p *Program) String() string {
+	var b strings.Builder
+	for _, v := range p.Vars {
+		fmt.Fprintf(&b, "var %s %s @0x%X\n", v.TypeName, v.Name, v.Addr)
+	}
+	starts := make(map[int]Func)
+	ends := make(map[int][]Func)
+	for _, fn := range p.Funcs {
+		starts[fn.Start] = fn
+		ends[fn.End] = append(ends[fn.End], fn)
+	}
+	for i, blk := range p.Blocks {
+		for _, fn := range ends[i] {
+			fmt.Fprintf(&b, "end %s\n", fn.Name)
+		}
+		if fn, ok := starts[i]; ok {
+			if fn.Public {
+				fmt.Fprintf(&b, "func %s (public)\n", fn.Name)
+			} else {
+				fmt.Fprintf(&b, "func %s\n", fn.Name)
+			}
+		}
+		fmt.Fprintf(&b, "%s:", blk)
+		if blk.Bound > 0 {
+			fmt.Fprintf(&b, "  ; loop bound %d", blk.Bound)
+		}
+		b.WriteString("\n")
+		for _, in := range blk.Instrs {
+			fmt.Fprintf(&b, "  %s\n", in)
+		}
+		fmt.Fprintf(&b, "  %s\n", blk.Term)
+	}
+	for _, fn := range ends[len(p.Blocks)] {
+		fmt.Fprintf(&b, "end %s\n", fn.Name)
+	}
+	return b.String()
+}
@@ -0,0 +1,361 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// lower analyse src et le traduit en IR ; une erreur d'analyse fait
+// échouer le test.
+func lower(t *testing.T, src string) (*Program, []diag.Diagnostic) {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src, "test.HC"))
+	prog := p.Parse()
+	if len(p.Diagnostics) > 0 {
+		t.Fatalf("parse errors: %v", p.Diagnostics)
+	}
+	return Lower(prog)
+}
+
+func TestLower(t *testing.T) {
+	prog, errs := lower(t, "I64 x = 3;\nif (x < 10) x = x * x;\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := `var I64 x @0x100
+b0:
+  t0:word = const 0x3
+  store x, t0
+  t1:word = const 0xA
+  t2:word = load x
+  t3:bool = SLT t2, t1
+  br t3, b1, b2
+b1:
+  t4:word = load x
+  t5:word = load x
+  t6:word = MUL t5, t4
+  store x, t6
+  jmp b2
+b2:
+  stop
+`
+	if got := prog.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLowerFunction(t *testing.T) {
+	prog, errs := lower(t, "I64 F(I64 a) { return a + 1; }\nSStore(1, Div(4, 2) == 2);\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := prog.String()
+	for _, want := range []string{"var I64 a @0x100\n", "func F\nb0:\n", "  ret t2\nend F\n", "t7:bool = EQ t6, t5", "  SSTORE t8, t7\n  stop\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("listing lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLowerErrors(t *testing.T) {
+	_, errs := lower(t, "I64 y = missing;\n")
+	if len(errs) != 1 || errs[0].Message != "undefined variable 'missing'" {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+// TestScheduleKeepsStackDiscipline vérifie que le code ordonnancé passe
+// les vérifications du backend : hauteurs de pile cohérentes et sauts
+// valides.
+func TestScheduleKeepsStackDiscipline(t *testing.T) {
+	src := `I64 s = 0;
+for (I64 i = 0; i < 10; i++) {
+  I64 d = SLoad(i);
+  if (d > s && d != 7) s = s + d * d;
+  else s -= 1;
+}
+SStore(1, s);
+`
+	prog, errs := lower(t, src)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	out, err := Schedule(prog, codegen.Passes{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codegen.ResolveLabels(out.Code); err != nil {
+		t.Fatal(err)
+	}
+	if errs := codegen.VerifyStack(out.Code).Errors; len(errs) > 0 {
+		t.Errorf("stack check: %v", errs)
+	}
+	if bad := codegen.AnalyzeJumps(out.Code).Bad(); len(bad) > 0 {
+		t.Errorf("jump check: %v", bad)
+	}
+}
+
+// schedule traduit src et l'ordonnance avec les variables de fonctions sur
+// la pile ; le code doit passer les vérifications du backend.
+func schedule(t *testing.T, src string) *Output {
+	t.Helper()
+	prog, errs := lower(t, src)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	out, err := Schedule(prog, codegen.Passes{StackLocals: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codegen.ResolveLabels(out.Code); err != nil {
+		t.Fatal(err)
+	}
+	if errs := codegen.VerifyStack(out.Code).Errors; len(errs) > 0 {
+		t.Errorf("stack check: %v", errs)
+	}
+	return out
+}
+
+func TestStackLocals(t *testing.T) {
+	out := schedule(t, `I64 F(I64 n) {
+  I64 s = 0;
+  I64 i = 0;
+  while (i < n) {
+    s += i;
+    i++;
+  }
+  SStore(1, s);
+}
+`)
+	if out.Resident != 3 || out.Spilled != 0 {
+		t.Errorf("%d resident, %d spilled, want 3 and 0", out.Resident, out.Spilled)
+	}
+	// Seul le prologue lit la mémoire : le paramètre n.
+	mem := 0
+	for _, inst := range out.Code {
+		if inst.Op == codegen.OP_MLOAD || inst.Op == codegen.OP_MSTORE {
+			mem++
+		}
+	}
+	if mem != 1 {
+		t.Errorf("%d memory accesses, want 1", mem)
+	}
+}
+
+func TestStackLocalsSpillPastDup8(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("I64 F() {\n")
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&b, "  I64 v%d = SLoad(%d);\n", i, i)
+	}
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&b, "  SStore(%d, v%d + v%d);\n", 20+i, i, (i+1)%12)
+	}
+	b.WriteString("}\n")
+	out := schedule(t, b.String())
+	if out.Spilled == 0 || out.Resident+out.Spilled != 12 {
+		t.Errorf("%d resident, %d spilled, want 12 in all with some spilled", out.Resident, out.Spilled)
+	}
+}
+
+func TestScheduledCodeKeepsSpans(t *testing.T) {
+	prog, errs := lower(t, "I64 x = SLoad(0);\nif (x)\n  SStore(1, x * 3);\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, stackLocals := range []bool{false, true} {
+		out, err := Schedule(prog, codegen.Passes{StackLocals: stackLocals}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, inst := range out.Code {
+			switch inst.Op {
+			case codegen.OP_SSTORE, codegen.OP_MUL:
+				if s := inst.Span; s.Start.File != "test.HC" || s.Start.Line != 3 {
+					t.Errorf("%s at %v, want test.HC:3", inst.Op, s)
+				}
+			case codegen.OP_SLOAD:
+				if s := inst.Span; s.Start.Line != 1 || s.Start.Col != 9 {
+					t.Errorf("SLOAD at %v, want 1:9", s)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		level   codegen.OptLevel
+		applied string
+		want    string
+	}{
+		{"fold, branch and strength", "I64 x = SLoad(0);\nSStore(1, x * 8);\nif (2 > 1) SStore(3, 4 + 5);\n", codegen.O2,
+			"map[branch:1 dead:4 fold:2 forward:1 strength:1]", `var I64 x @0x100
+b0:
+  t0:word = const 0x0
+  t1:word = SLOAD t0
+  store x, t1
+  t13:word = const 0x3
+  t14:word = SHL t13, t1
+  t5:word = const 0x1
+  SSTORE t5, t14
+  jmp b1
+b1:
+  t11:word = const 0x9
+  t12:word = const 0x3
+  SSTORE t12, t11
+  jmp b2
+b2:
+  stop
+`},
+		// CALLER est un opcode de contexte : il ne change pas dans le bloc.
+		{"forward and cse", "I64 x = SLoad(0);\nI64 y = x * x + (x * x);\nSStore(1, y + Caller());\nSStore(2, Caller());\n", codegen.O2,
+			"map[cse:2 forward:5]", `var I64 x @0x100
+var I64 y @0x108
+b0:
+  t0:word = const 0x0
+  t1:word = SLOAD t0
+  store x, t1
+  t4:word = MUL t1, t1
+  t8:word = ADD t4, t4
+  store y, t8
+  t10:word = CALLER
+  t11:word = ADD t10, t8
+  t12:word = const 0x1
+  SSTORE t12, t11
+  t14:word = const 0x2
+  SSTORE t14, t10
+  stop
+`},
+		// Un opcode à effet peut écrire la mémoire : x est relu.
+		{"effects forget variables", "I64 x = SLoad(0);\nSStore(1, x);\nSStore(2, x);\n", codegen.O2,
+			"map[forward:1]", `var I64 x @0x100
+b0:
+  t0:word = const 0x0
+  t1:word = SLOAD t0
+  store x, t1
+  t3:word = const 0x1
+  SSTORE t3, t1
+  t4:word = load x
+  t5:word = const 0x2
+  SSTORE t5, t4
+  stop
+`},
+		{"-O0", "SStore(1, 2 + 3);\n", codegen.O0, "map[]", `b0:
+  t0:word = const 0x2
+  t1:word = const 0x3
+  t2:word = ADD t1, t0
+  t3:word = const 0x1
+  SSTORE t3, t2
+  stop
+`},
+	}
+	for _, tt := range tests {
+		prog, errs := lower(t, tt.src)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if got := fmt.Sprint(Optimize(prog, tt.level.Passes(), nil)); got != tt.applied {
+			t.Errorf("%s: applied %s, want %s", tt.name, got, tt.applied)
+		}
+		if got := prog.String(); got != tt.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestScheduleMaterializesConstants vérifie que l'IR matérialise les
+// constantes comme le backend direct au même niveau.
+func TestScheduleMaterializesConstants(t *testing.T) {
+	prog, errs := lower(t, "SStore(1, 0xFFFFFFFFFFFFFFF6);\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, tt := range []struct {
+		level codegen.OptLevel
+		want  string
+	}{
+		{codegen.O0, "PUSH8 0xFFFFFFFFFFFFFFF6; PUSH1 0x1; SSTORE; STOP"},
+		{codegen.Os, "PUSH1 0x9; NOT; PUSH1 0x1; SSTORE; STOP"},
+	} {
+		out, err := Schedule(prog, tt.level.Passes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ops []string
+		for _, inst := range out.Code {
+			ops = append(ops, inst.String())
+		}
+		if got := strings.Join(ops, "; "); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+// TestStackSlotsShareByLiveness vérifie que des variables jamais vivantes
+// en même temps partagent un emplacement de pile.
+func TestStackSlotsShareByLiveness(t *testing.T) {
+	out := schedule(t, `I64 F() {
+  I64 a = SLoad(1);
+  SStore(2, a * a);
+  I64 b = SLoad(3);
+  SStore(4, b + 1);
+  I64 c = SLoad(5);
+  SStore(6, c + a);
+}
+`)
+	// b ne vit qu'entre deux lectures de a, pendant lesquelles a est vivante :
+	// b et c partagent un emplacement, a garde le sien.
+	if out.Resident != 3 || out.Slots != 2 || out.Spilled != 0 {
+		t.Errorf("%d resident in %d slot(s), %d spilled, want 3 in 2", out.Resident, out.Slots, out.Spilled)
+	}
+}
+
+// TestTopLevelVariablesOnStack vérifie que les variables globales
+// qu'aucune fonction n'utilise vivent sur la pile, et les autres en mémoire.
+func TestTopLevelVariablesOnStack(t *testing.T) {
+	out := schedule(t, `I64 g = SLoad(0);
+I64 x = SLoad(1);
+SStore(2, x + g);
+U0 Reset() { g = 0; }
+`)
+	mem := make(map[string]int)
+	for _, inst := range out.Code {
+		if inst.Op == codegen.OP_MLOAD || inst.Op == codegen.OP_MSTORE {
+			mem[inst.Op.String()]++
+		}
+	}
+	// g reste en mémoire : écrite au début et dans Reset, lue une fois.
+	if out.Resident != 1 || mem["MSTORE"] != 2 || mem["MLOAD"] != 1 {
+		t.Errorf("%d resident, memory accesses %v; want x resident and g in memory", out.Resident, mem)
+	}
+}
+
+func TestLiveIn(t *testing.T) {
+	prog, errs := lower(t, "I64 a = 1;\nI64 b = 2;\nwhile (a < 10) a = a + b;\nSStore(1, a);\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	live := liveIn(prog)
+	var got []string
+	for _, b := range prog.Blocks {
+		var names []string
+		for _, v := range prog.Vars {
+			if live[b.ID][v] {
+				names = append(names, v.Name)
+			}
+		}
+		got = append(got, fmt.Sprintf("b%d:%s", b.ID, strings.Join(names, ",")))
+	}
+	if want := "b0: b1:a,b b2:a,b b3:a"; strings.Join(got, " ") != want {
+		t.Errorf("live in %s, want %s\n%s", strings.Join(got, " "), want, prog)
+	}
+}
@@ -0,0 +1,153 @@
+package ir
+
+import "sort"
+
+// successors retourne les blocs auxquels b peut passer la main.
+func successors(b *Block) []*Block {
+	switch b.Term.Kind {
+	case Jump:
+		return []*Block{b.Term.Then}
+	case Branch:
+		return []*Block{b.Term.Then, b.Term.Else}
+	}
+	return nil
+}
+
+// liveIn retourne, pour chaque bloc de p (par ID), les variables vivantes à
+// son entrée : lues avant d'être écrites sur au moins un chemin qui en part.
+func liveIn(p *Program) []map[*Var]bool {
+	live := make([]map[*Var]bool, len(p.Blocks))
+	for i := range live {
+		live[i] = make(map[*Var]bool)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(p.Blocks) - 1; i >= 0; i-- {
+			b := p.Blocks[i]
+			in := liveOut(b, live)
+			for j := len(b.Instrs) - 1; j >= 0; j-- {
+				switch instr := b.Instrs[j]; instr.Kind {
+				case Store:
+					delete(in, instr.Var)
+				case Load:
+					in[instr.Var] = true
+				}
+			}
+			for v := range in {
+				if !live[i][v] {
+					live[i][v] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return live
+}
+
+// liveOut retourne les variables vivantes à la sortie de b d'après live
+// (voir liveIn).
+func liveOut(b *Block, live []map[*Var]bool) map[*Var]bool {
+	out := make(map[*Var]bool)
+	for _, succ := range successors(b) {
+		for v := range live[succ.ID] {
+			out[v] = true
+		}
+	}
+	return out
+}
+
+// stackSlot est un emplacement de la base de la pile, partagé par des
+// variables qui ne sont jamais vivantes en même temps.
+type stackSlot struct {
+	temp     *Temp
+	vars     []*Var
+	accesses int
+	init     *Var // variable vivante à l'entrée de la région, nil : aucune
+}
+
+// assignSlots répartit vars entre des emplacements de pile. Deux variables
+// interfèrent si l'une est écrite là où l'autre est vivante, ou si toutes
+// deux sont vivantes à l'entrée de la région (le prologue les définit
+// ensemble) ; les variables qui n'interfèrent pas partagent un emplacement.
+// Les plus utilisées sont placées d'abord ; les emplacements sont rendus du
+// fond vers le sommet, les moins utilisés d'abord.
+func assignSlots(blocks []*Block, entry *Block, vars []*Var, accesses map[*Var]int, live []map[*Var]bool) []*stackSlot {
+	candidate := make(map[*Var]bool)
+	for _, v := range vars {
+		candidate[v] = true
+	}
+	conflicts := make(map[*Var]map[*Var]bool)
+	conflict := func(a, b *Var) {
+		if a == b {
+			return
+		}
+		for _, pair := range [][2]*Var{{a, b}, {b, a}} {
+			if conflicts[pair[0]] == nil {
+				conflicts[pair[0]] = make(map[*Var]bool)
+			}
+			conflicts[pair[0]][pair[1]] = true
+		}
+	}
+	for _, b := range blocks {
+		current := make(map[*Var]bool)
+		for v := range liveOut(b, live) {
+			if candidate[v] {
+				current[v] = true
+			}
+		}
+		for j := len(b.Instrs) - 1; j >= 0; j-- {
+			in := b.Instrs[j]
+			if !candidate[in.Var] {
+				continue
+			}
+			switch in.Kind {
+			case Store:
+				for v := range current {
+					conflict(in.Var, v)
+				}
+				delete(current, in.Var)
+			case Load:
+				current[in.Var] = true
+			}
+		}
+	}
+	var atEntry []*Var
+	for _, v := range vars {
+		if live[entry.ID][v] {
+			atEntry = append(atEntry, v)
+		}
+	}
+	for i, a := range atEntry {
+		for _, b := range atEntry[i+1:] {
+			conflict(a, b)
+		}
+	}
+
+	order := append([]*Var(nil), vars...)
+	sort.SliceStable(order, func(i, j int) bool { return accesses[order[i]] > accesses[order[j]] })
+	var slots []*stackSlot
+	for _, v := range order {
+		var slot *stackSlot
+		for _, shared := range slots {
+			free := true
+			for _, w := range shared.vars {
+				free = free && !conflicts[v][w]
+			}
+			if free {
+				slot = shared
+				break
+			}
+		}
+		if slot == nil {
+			slot = &stackSlot{temp: &Temp{ID: -1}}
+			slots = append(slots, slot)
+		}
+		slot.vars = append(slot.vars, v)
+		slot.accesses += accesses[v]
+		if live[entry.ID][v] {
+			slot.init = v
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].accesses < slots[j].accesses })
+	return slots
+}
@@ -0,0 +1,497 @@
+package ir
+
+import (
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/diag"
+	"holyc-compiler/pkg/lexer"
+	"holyc-compiler/pkg/parser"
+)
+
+// lowerer porte l'état de Lower.
+type lowerer struct {
+	prog *Program
+	cur  *Block // bloc en cours ; nil après un terminateur
+	fn   *Func
+	span lexer.Span // nœud du source à l'origine des instructions émises
+
+	scopes   []map[string]*Var
+	nextAddr int
+
+	defines   map[string]parser.Node
+	expanding map[string]bool
+	declared  map[string]bool // fonctions déclarées (voir codegen.NotBuiltin)
+
+	diags []diag.Diagnostic
+}
+
+// Lower traduit l'AST en IR avec la sémantique de CodeGen.Generate : même
+// ordre d'évaluation, mêmes emplacements mémoire de variables (à partir de
+// codegen.LocalsBase), mêmes séquences d'opcodes pour les opérateurs, corps
+// de fonctions émis en ligne. Le programme se termine par Stop. Les erreurs
+// sont celles de CodeGen ; l'expression fautive vaut alors 0.
+func Lower(prog *parser.Program) (*Program, []diag.Diagnostic) {
+	l := &lowerer{
+		prog:      &Program{},
+		scopes:    []map[string]*Var{{}},
+		nextAddr:  codegen.LocalsBase,
+		defines:   make(map[string]parser.Node),
+		expanding: make(map[string]bool),
+		declared:  codegen.DeclaredFuncs(prog),
+	}
+	l.startBlock(&Block{})
+	for _, d := range prog.Decls {
+		l.stmt(d)
+	}
+	l.terminate(Term{Kind: Stop})
+	return l.prog, l.diags
+}
+
+// errorf signale une erreur au nœud en cours de traduction.
+func (l *lowerer) errorf(code, format string, args ...any) {
+	l.diags = append(l.diags, diag.Errorf(code, l.span, format, args...))
+}
+
+// block retourne le bloc en cours, en ouvrant un bloc inatteignable après
+// un terminateur (code qui suit un return).
+func (l *lowerer) block() *Block {
+	if l.cur == nil {
+		l.startBlock(&Block{})
+	}
+	return l.cur
+}
+
+// terminate termine le bloc en cours par t.
+func (l *lowerer) terminate(t Term) {
+	t.Span = l.span
+	l.block().Term = t
+	l.cur = nil
+}
+
+// startBlock termine le bloc en cours par une chute dans b, qui devient le
+// bloc en cours. Les blocs sont créés dès qu'un branchement les vise, mais
+// placés (numérotés et rangés dans Program.Blocks) seulement ici : l'ordre
+// d'émission est celui du source.
+func (l *lowerer) startBlock(b *Block) {
+	if l.cur != nil {
+		l.terminate(Term{Kind: Jump, Then: b})
+	}
+	b.ID = len(l.prog.Blocks)
+	l.prog.Blocks = append(l.prog.Blocks, b)
+	l.cur = b
+}
+
+func (l *lowerer) emit(in *Instr) *Instr {
+	in.Span = l.span
+	b := l.block()
+	b.Instrs = append(b.Instrs, in)
+	return in
+}
+
+func (l *lowerer) constant(v int64) *Temp {
+	t := l.prog.newTemp(Word)
+	l.emit(&Instr{Kind: Const, Value: v, Dsts: []*Temp{t}})
+	return t
+}
+
+// op émet op(args...) et retourne ses résultats.
+func (l *lowerer) op(op codegen.Opcode, args ...*Temp) []*Temp {
+	_, results := op.StackEffect()
+	dsts := make([]*Temp, results)
+	for i := range dsts {
+		dsts[i] = l.prog.newTemp(resultType(op))
+	}
+	l.emit(&Instr{Kind: Op, Op: op, Args: args, Dsts: dsts})
+	return dsts
+}
+
+// resultType retourne le type des résultats de op.
+func resultType(op codegen.Opcode) Type {
+	switch op {
+	case codegen.OP_LT, codegen.OP_GT, codegen.OP_SLT, codegen.OP_SGT, codegen.OP_EQ, codegen.OP_ISZERO:
+		return Bool
+	}
+	return Word
+}
+
+// ---- Variables ----
+
+func (l *lowerer) pushScope() { l.scopes = append(l.scopes, map[string]*Var{}) }
+func (l *lowerer) popScope()  { l.scopes = l.scopes[:len(l.scopes)-1] }
+
+func (l *lowerer) declare(name, typeName string) *Var {
+	scope := l.scopes[len(l.scopes)-1]
+	if _, ok := scope[name]; ok {
+		l.errorf("redeclared", "'%s' redeclared in this scope", name)
+	}
+	v := &Var{Name: name, TypeName: typeName, Addr: l.nextAddr}
+	l.nextAddr += 8
+	scope[name] = v
+	l.prog.Vars = append(l.prog.Vars, v)
+	return v
+}
+
+func (l *lowerer) lookup(name string) (*Var, bool) {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if v, ok := l.scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// situate fait de node, comme CodeGen.situate, l'origine des instructions
+// émises jusqu'à l'appel de la fonction retournée.
+func (l *lowerer) situate(node parser.Node) (restore func()) {
+	saved := l.span
+	if node != nil && len(l.expanding) == 0 && !node.Span().IsZero() {
+		l.span = node.Span()
+	}
+	return func() { l.span = saved }
+}
+
+// ---- Instructions ----
+
+func (l *lowerer) stmt(node parser.Node) {
+	defer l.situate(node)()
+	switch n := node.(type) {
+	case nil:
+	case *parser.Block:
+		l.pushScope()
+		for _, s := range n.Stmts {
+			l.stmt(s)
+		}
+		l.popScope()
+	case *parser.ExprStmt:
+		l.effect(n.Expr)
+	case *parser.VarDecl:
+		if n.Init != nil {
+			t := l.value(n.Init)
+			l.emit(&Instr{Kind: Store, Var: l.declare(n.Name, n.TypeName), Args: []*Temp{t}})
+		} else {
+			l.declare(n.Name, n.TypeName)
+		}
+	case *parser.ReturnStmt:
+		if n.Value != nil {
+			l.terminate(Term{Kind: Return, Value: l.value(n.Value)})
+		} else {
+			l.terminate(Term{Kind: Return})
+		}
+	case *parser.DefineDecl:
+		l.defines[n.Name] = n.Value
+	case *parser.FuncDecl:
+		l.function(n)
+	case *parser.IfStmt:
+		cond := l.value(n.Cond)
+		body, end := &Block{}, &Block{}
+		if n.Else == nil {
+			l.terminate(Term{Kind: Branch, Cond: cond, Then: body, Else: end})
+			l.startBlock(body)
+			l.stmt(n.Body)
+		} else {
+			els := &Block{}
+			l.terminate(Term{Kind: Branch, Cond: cond, Then: body, Else: els})
+			l.startBlock(body)
+			l.stmt(n.Body)
+			l.terminate(Term{Kind: Jump, Then: end})
+			l.startBlock(els)
+			l.stmt(n.Else)
+		}
+		l.startBlock(end)
+	case *parser.WhileStmt:
+		l.loop(nil, n.Cond, nil, n.Body, n.Bound)
+	case *parser.ForStmt:
+		l.pushScope()
+		l.loop(n.Init, n.Cond, n.Post, n.Body, n.Bound)
+		l.popScope()
+	default:
+		l.errorf("unsupported", "unhandled node type: %T", node)
+	}
+}
+
+// function traduit une fonction, dont le corps forme une région de blocs.
+func (l *lowerer) function(n *parser.FuncDecl) {
+	if l.cur == nil || len(l.cur.Instrs) > 0 {
+		l.startBlock(&Block{})
+	}
+	fn := Func{Name: n.Name, Public: n.Public, Start: len(l.prog.Blocks) - 1}
+	l.fn = &fn
+	l.pushScope()
+	for _, p := range n.Params {
+		if p.Name != "" {
+			fn.Params = append(fn.Params, l.declare(p.Name, p.TypeName))
+		}
+	}
+	params := len(l.prog.Vars)
+	if n.Body != nil {
+		l.stmt(n.Body)
+	}
+	l.popScope()
+	fn.Locals = append([]*Var(nil), l.prog.Vars[params:]...)
+	l.fn = nil
+	// Le corps est émis en ligne : l'exécution peut y entrer par chute et
+	// en sortir de même.
+	l.startBlock(&Block{})
+	fn.End = len(l.prog.Blocks) - 1
+	l.prog.Funcs = append(l.prog.Funcs, fn)
+}
+
+// loop traduit while (cond) body et for (init; cond; post) body : en-tête
+// testant cond, corps, saut vers l'en-tête.
+func (l *lowerer) loop(init, cond, post, body parser.Node, bound int64) {
+	if init != nil {
+		if _, ok := init.(*parser.VarDecl); ok {
+			l.stmt(init)
+		} else {
+			l.effect(init)
+		}
+	}
+	head := &Block{Bound: bound}
+	l.startBlock(head)
+	end := &Block{}
+	if cond != nil {
+		c := l.value(cond)
+		b := &Block{}
+		l.terminate(Term{Kind: Branch, Cond: c, Then: b, Else: end})
+		l.startBlock(b)
+	}
+	l.stmt(body)
+	if post != nil {
+		l.effect(post)
+	}
+	l.terminate(Term{Kind: Jump, Then: head})
+	l.startBlock(end)
+}
+
+// ---- Expressions ----
+
+// effect traduit une expression pour ses seuls effets.
+func (l *lowerer) effect(node parser.Node) {
+	defer l.situate(node)()
+	switch n := node.(type) {
+	case *parser.AssignExpr:
+		l.assign(n)
+		return
+	case *parser.PostfixExpr:
+		l.incDec(n.Operand, n.Op, false)
+		return
+	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			l.incDec(n.Operand, n.Op, true)
+			return
+		}
+	}
+	l.expr(node)
+}
+
+// value traduit une expression qui doit produire une valeur : la première
+// si elle en produit plusieurs (ADDCARRY : sum).
+func (l *lowerer) value(node parser.Node) *Temp {
+	reported := len(l.diags)
+	ts := l.expr(node)
+	if len(ts) == 0 {
+		if !diag.HasErrors(l.diags[reported:]) {
+			l.errorf("no-value", "expression %T has no value", node)
+		}
+		return l.constant(0)
+	}
+	return ts[0]
+}
+
+// expr traduit une expression et retourne ses valeurs.
+func (l *lowerer) expr(node parser.Node) []*Temp {
+	defer l.situate(node)()
+	switch n := node.(type) {
+	case *parser.IntLiteral:
+		return []*Temp{l.constant(n.Value)}
+	case *parser.FloatLiteral:
+		return []*Temp{l.constant(int64(n.Value))}
+	case *parser.StringLiteral:
+		return []*Temp{l.constant(0)}
+	case *parser.SizeofExpr:
+		return []*Temp{l.constant(codegen.TypeSizeOf(n.TypeName))}
+	case *parser.Identifier:
+		if v, ok := l.lookup(n.Name); ok {
+			return []*Temp{l.load(v)}
+		}
+		if value, ok := l.defines[n.Name]; ok {
+			return l.macro(n.Name, value)
+		}
+		l.errorf("undefined-variable", "undefined variable '%s'", n.Name)
+		return []*Temp{l.constant(0)}
+	case *parser.BinaryExpr:
+		return []*Temp{l.binary(n)}
+	case *parser.UnaryExpr:
+		if n.Op == lexer.TOK_PLUS_PLUS || n.Op == lexer.TOK_MINUS_MINUS {
+			return []*Temp{l.incDec(n.Operand, n.Op, true)}
+		}
+		ops, _ := codegen.UnaryOp(n.Op)
+		return []*Temp{l.apply(ops, []*Temp{l.value(n.Operand)})}
+	case *parser.CallExpr:
+		return l.call(n)
+	case *parser.InlineExpr:
+		l.pushScope()
+		defer l.popScope()
+		for _, s := range n.Stmts {
+			l.stmt(s)
+		}
+		if n.Value == nil {
+			return nil
+		}
+		return l.expr(n.Value)
+	case *parser.AssignExpr:
+		return []*Temp{l.assign(n)}
+	case *parser.PostfixExpr:
+		return []*Temp{l.incDec(n.Operand, n.Op, false)}
+	case *parser.CastExpr:
+		return []*Temp{l.value(n.Expr)}
+	case *parser.IndexExpr:
+		return l.op(codegen.OP_MLOAD, l.indexAddr(n))
+	case *parser.MemberExpr:
+		return []*Temp{l.value(n.Object)}
+	}
+	l.errorf("unsupported", "unhandled expression: %T", node)
+	return nil
+}
+
+func (l *lowerer) load(v *Var) *Temp {
+	t := l.prog.newTemp(Word)
+	l.emit(&Instr{Kind: Load, Var: v, Dsts: []*Temp{t}})
+	return t
+}
+
+// macro substitue la valeur d'un #define.
+func (l *lowerer) macro(name string, value parser.Node) []*Temp {
+	switch {
+	case value == nil:
+		l.errorf("macro", "macro '%s' has no expression value", name)
+	case l.expanding[name]:
+		l.errorf("macro", "macro '%s' expands to itself", name)
+	default:
+		l.expanding[name] = true
+		defer delete(l.expanding, name)
+		return l.expr(value)
+	}
+	return []*Temp{l.constant(0)}
+}
+
+// binary traduit un opérateur binaire : les opérandes sont évalués dans
+// l'ordre où CodeGen les pousse, puis la séquence d'opcodes est appliquée.
+func (l *lowerer) binary(n *parser.BinaryExpr) *Temp {
+	ops, leftOnTop, ok := codegen.BinaryOp(n.Op)
+	if !ok {
+		l.errorf("unsupported", "unknown binary op: %d", n.Op)
+		return l.constant(0)
+	}
+	if leftOnTop {
+		r := l.value(n.Right)
+		return l.apply(ops, []*Temp{r, l.value(n.Left)})
+	}
+	left := l.value(n.Left)
+	return l.apply(ops, []*Temp{left, l.value(n.Right)})
+}
+
+// apply exécute une séquence d'opcodes d'opérateur sur une pile symbolique
+// de temporaires (sommet = dernier élément) : PUSH0 y crée la constante 0,
+// SWAP1 échange les deux éléments du sommet, chaque autre opcode devient
+// une instruction Op. Retourne le sommet final.
+func (l *lowerer) apply(ops []codegen.Opcode, stack []*Temp) *Temp {
+	for _, op := range ops {
+		switch op {
+		case codegen.OP_PUSH0:
+			stack = append(stack, l.constant(0))
+			continue
+		case codegen.OP_SWAP1:
+			top := len(stack) - 1
+			stack[top], stack[top-1] = stack[top-1], stack[top]
+			continue
+		}
+		n, _ := op.StackEffect()
+		args := make([]*Temp, n)
+		for i := range args {
+			args[i] = stack[len(stack)-1-i]
+		}
+		stack = stack[:len(stack)-n]
+		dsts := l.op(op, args...)
+		for i := len(dsts) - 1; i >= 0; i-- {
+			stack = append(stack, dsts[i])
+		}
+	}
+	return stack[len(stack)-1]
+}
+
+// call traduit un appel de builtin ; les arguments sont évalués du dernier
+// au premier, comme CodeGen les pousse.
+func (l *lowerer) call(n *parser.CallExpr) []*Temp {
+	op, argc, ok := codegen.Builtin(n.Func)
+	if !ok {
+		if l.fn != nil {
+			l.fn.Calls = append(l.fn.Calls, n.Func)
+		}
+		l.diags = append(l.diags, codegen.NotBuiltin(n, l.span, l.declared))
+		return nil
+	}
+	if len(n.Args) != argc {
+		l.errorf("argument-count", "%s expects %d args, got %d", n.Func, argc, len(n.Args))
+		return nil
+	}
+	args := make([]*Temp, argc)
+	for i := argc - 1; i >= 0; i-- {
+		args[i] = l.value(n.Args[i])
+	}
+	return l.op(op, args...)
+}
+
+// assign traduit target op= value et retourne la valeur affectée.
+func (l *lowerer) assign(n *parser.AssignExpr) *Temp {
+	value := n.Value
+	if op, ok := codegen.CompoundOp(n.Op); ok {
+		value = &parser.BinaryExpr{Op: op, Left: n.Target, Right: n.Value}
+	}
+	t := l.value(value)
+	l.storeTo(n.Target, t)
+	return t
+}
+
+// incDec traduit ++x, --x (prefix) ou x++, x-- et retourne la valeur de
+// l'expression : la nouvelle pour prefix, l'ancienne sinon.
+func (l *lowerer) incDec(target parser.Node, op lexer.TokenType, prefix bool) *Temp {
+	var one, old, updated *Temp
+	if op == lexer.TOK_MINUS_MINUS {
+		one = l.constant(1)
+		old = l.value(target)
+		updated = l.op(codegen.OP_SUB, old, one)[0]
+	} else {
+		old = l.value(target)
+		one = l.constant(1)
+		updated = l.op(codegen.OP_ADD, one, old)[0]
+	}
+	l.storeTo(target, updated)
+	if prefix {
+		return updated
+	}
+	return old
+}
+
+// storeTo écrit t dans une lvalue (variable ou p[i]).
+func (l *lowerer) storeTo(target parser.Node, t *Temp) {
+	switch tg := target.(type) {
+	case *parser.Identifier:
+		v, ok := l.lookup(tg.Name)
+		if !ok {
+			l.errorf("undefined-variable", "undefined variable '%s'", tg.Name)
+			return
+		}
+		l.emit(&Instr{Kind: Store, Var: v, Args: []*Temp{t}})
+	case *parser.IndexExpr:
+		l.op(codegen.OP_MSTORE, l.indexAddr(tg), t)
+	default:
+		l.errorf("invalid-assignment", "cannot assign to %T", target)
+	}
+}
+
+// indexAddr retourne l'adresse de p[i] : p + 8*i (éléments de 8 octets).
+func (l *lowerer) indexAddr(n *parser.IndexExpr) *Temp {
+	index := l.value(n.Index)
+	scaled := l.op(codegen.OP_SHL, l.constant(3), index)[0]
+	return l.op(codegen.OP_ADD, l.value(n.Array), scaled)[0]
+}
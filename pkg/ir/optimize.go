@@ -0,0 +1,388 @@
+package ir
+
+import "holyc-compiler/pkg/codegen"
+
+// maxPressure est le nombre d'entrées que les temporaires d'un bloc peuvent
+// laisser sur la pile après une réécriture de Optimize : au-delà, un
+// opérande risquerait d'être hors de portée de DUP8/SWAP8 (voir Schedule).
+const maxPressure = 8
+
+// Optimize applique à p les passes de passes qui ont une forme sur l'IR,
+// bloc par bloc et jusqu'à ce qu'elles n'aient plus rien à réécrire (au
+// plus maxRounds tours), et retourne le nombre de réécritures de chacune :
+//
+//	fold      Passes.Fold : opcode pur d'opérandes constants → const (codegen.Eval)
+//	branch    Passes.Fold : branchement sur une constante → saut
+//	strength  Passes.Strength : MUL/DIV/MOD/SDIV/SMOD/EXP par une constante →
+//	          séquence de codegen.Reduce
+//	forward   Passes.CSE : load d'une variable dont le bloc connaît la valeur
+//	cse       Passes.CSE : opcode pur ou de contexte déjà calculé dans le bloc
+//	dead      Passes.DeadCode, ou après une autre réécriture : temporaire
+//	          inutilisé défini par un const, un load ou un opcode sans effet
+//
+// Les passes sur l'AST (CodeGen.OptimizeAST) ont déjà été exécutées ; ces
+// passes reprennent ce que la traduction en IR expose (adresses, opérateurs
+// décomposés, relectures de variables). forward et cse allongent la vie
+// d'un temporaire : un bloc dont elles porteraient la pile au-delà de
+// maxPressure est rendu tel quel.
+func Optimize(p *Program, passes codegen.Passes, s *codegen.GasSchedule) map[string]int {
+	o := &optimizer{prog: p, passes: passes, schedule: s, applied: make(map[string]int)}
+	for _, b := range p.Blocks {
+		rewritten := false
+		for round := 0; round < maxRounds; round++ {
+			changed := false
+			if passes.CSE {
+				changed = o.number(b) || changed
+			}
+			if passes.Fold {
+				changed = o.fold(b) || changed
+			}
+			if passes.Strength {
+				changed = o.strength(b) || changed
+			}
+			if !changed {
+				break
+			}
+			rewritten = true
+		}
+		if passes.DeadCode || rewritten {
+			o.dead(b)
+		}
+	}
+	return o.applied
+}
+
+// maxRounds borne le nombre de tours de Optimize sur un bloc : chaque passe
+// peut exposer du travail aux autres (une valeur propagée devient une
+// constante à replier, une réduction un calcul commun).
+const maxRounds = 4
+
+// optimizer porte l'état de Optimize.
+type optimizer struct {
+	prog     *Program
+	passes   codegen.Passes
+	schedule *codegen.GasSchedule
+	applied  map[string]int
+}
+
+// rename remplace dans les instructions et le terminateur de b chaque
+// temporaire de subst par sa valeur. Les temporaires ne vivent que dans
+// leur bloc : b suffit.
+func rename(b *Block, subst map[*Temp]*Temp) {
+	if len(subst) == 0 {
+		return
+	}
+	get := func(t *Temp) *Temp {
+		for {
+			r, ok := subst[t]
+			if !ok {
+				return t
+			}
+			t = r
+		}
+	}
+	for _, in := range b.Instrs {
+		for i, t := range in.Args {
+			in.Args[i] = get(t)
+		}
+	}
+	if b.Term.Cond != nil {
+		b.Term.Cond = get(b.Term.Cond)
+	}
+	if b.Term.Value != nil {
+		b.Term.Value = get(b.Term.Value)
+	}
+}
+
+// fold remplace par des constantes les opcodes purs dont tous les
+// opérandes sont constants, et par un saut un branchement sur une
+// constante.
+func (o *optimizer) fold(b *Block) bool {
+	consts := make(map[*Temp]uint64)
+	changed := false
+	for i, in := range b.Instrs {
+		switch in.Kind {
+		case Const:
+			consts[in.Dsts[0]] = uint64(in.Value)
+		case Op:
+			if in.Op.Purity() != codegen.Pure || len(in.Dsts) != 1 {
+				continue
+			}
+			args := make([]uint64, len(in.Args))
+			known := true
+			for j, t := range in.Args {
+				args[j], known = consts[t]
+				if !known {
+					break
+				}
+			}
+			if !known {
+				continue
+			}
+			res, ok := codegen.Eval(in.Op, args...)
+			if !ok {
+				continue
+			}
+			b.Instrs[i] = &Instr{Kind: Const, Value: int64(res[0]), Dsts: in.Dsts, Span: in.Span}
+			consts[in.Dsts[0]] = res[0]
+			o.applied["fold"]++
+			changed = true
+		}
+	}
+	if t := b.Term; t.Kind == Branch {
+		if v, ok := consts[t.Cond]; ok {
+			target := t.Else
+			if v != 0 {
+				target = t.Then
+			}
+			b.Term = Term{Kind: Jump, Then: target, Span: t.Span}
+			o.applied["branch"]++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// strength remplace « x op c » par la séquence de codegen.Reduce quand elle
+// est plus avantageuse, c étant une constante du bloc (l'un ou l'autre
+// opérande pour MUL).
+func (o *optimizer) strength(b *Block) bool {
+	consts := make(map[*Temp]uint64)
+	subst := make(map[*Temp]*Temp)
+	var out []*Instr
+	for _, in := range b.Instrs {
+		if in.Kind == Const {
+			consts[in.Dsts[0]] = uint64(in.Value)
+		}
+		if in.Kind != Op || len(in.Args) != 2 || !reducible(in.Op) {
+			out = append(out, in)
+			continue
+		}
+		x := in.Args[0]
+		c, ok := consts[in.Args[1]]
+		if !ok && in.Op == codegen.OP_MUL {
+			x = in.Args[1]
+			c, ok = consts[in.Args[0]]
+		}
+		var seq []codegen.Instruction
+		if ok {
+			seq, ok = codegen.Reduce(in.Op, c, o.passes, o.schedule)
+		}
+		if !ok {
+			out = append(out, in)
+			continue
+		}
+		var result *Temp
+		out, result = o.replay(out, seq, x, in)
+		subst[in.Dsts[0]] = result
+		o.applied["strength"]++
+	}
+	b.Instrs = out
+	rename(b, subst)
+	return len(subst) > 0
+}
+
+func reducible(op codegen.Opcode) bool {
+	switch op {
+	case codegen.OP_MUL, codegen.OP_DIV, codegen.OP_MOD, codegen.OP_SDIV, codegen.OP_SMOD, codegen.OP_EXP:
+		return true
+	}
+	return false
+}
+
+// replay traduit seq, exécutée à partir de x au sommet de la pile, en
+// instructions ajoutées à out, situées comme in, comme lowerer.apply
+// traduit les séquences d'opérateurs : PUSH, DUP, SWAP et POP ne
+// manipulent que la pile symbolique. Retourne le sommet final.
+func (o *optimizer) replay(out []*Instr, seq []codegen.Instruction, x *Temp, in *Instr) ([]*Instr, *Temp) {
+	stack := []*Temp{x}
+	at := func(d int) int { return len(stack) - 1 - d }
+	for _, inst := range seq {
+		switch op := inst.Op; {
+		case op.IsPush():
+			t := o.prog.newTemp(Word)
+			out = append(out, &Instr{Kind: Const, Value: inst.Operand, Dsts: []*Temp{t}, Span: in.Span})
+			stack = append(stack, t)
+		case op >= codegen.OP_DUP1 && op <= codegen.OP_DUP8:
+			stack = append(stack, stack[at(int(op-codegen.OP_DUP1))])
+		case op >= codegen.OP_SWAP1 && op <= codegen.OP_SWAP8:
+			top, d := at(0), at(int(op-codegen.OP_SWAP1)+1)
+			stack[top], stack[d] = stack[d], stack[top]
+		case op == codegen.OP_POP:
+			stack = stack[:len(stack)-1]
+		default:
+			n, _ := op.StackEffect()
+			args := make([]*Temp, n)
+			for i := range args {
+				args[i] = stack[at(i)]
+			}
+			stack = stack[:len(stack)-n]
+			t := o.prog.newTemp(resultType(op))
+			out = append(out, &Instr{Kind: Op, Op: op, Args: args, Dsts: []*Temp{t}, Span: in.Span})
+			stack = append(stack, t)
+		}
+	}
+	return out, stack[len(stack)-1]
+}
+
+// number numérote les valeurs du bloc : une constante déjà définie reprend
+// son temporaire, un load d'une variable dont le bloc vient de lire ou
+// d'écrire la valeur reprend ce temporaire, un opcode pur ou de contexte
+// déjà calculé sur les mêmes opérandes reprend ses résultats. Un opcode à
+// effet peut écrire n'importe où en mémoire : les valeurs connues des
+// variables sont alors oubliées.
+func (o *optimizer) number(b *Block) bool {
+	before := append([]*Instr(nil), b.Instrs...)
+	args := make([][]*Temp, len(before))
+	for i, in := range before {
+		args[i] = append([]*Temp(nil), in.Args...)
+	}
+	term := b.Term
+	peak := pressure(b)
+
+	type key struct {
+		op   codegen.Opcode
+		args [3]*Temp
+	}
+	consts := make(map[int64]*Temp)
+	vars := make(map[*Var]*Temp)
+	exprs := make(map[key][]*Temp)
+	subst := make(map[*Temp]*Temp)
+	applied := map[string]int{}
+	var out []*Instr
+	for _, in := range b.Instrs {
+		for i, t := range in.Args {
+			if r, ok := subst[t]; ok {
+				in.Args[i] = r
+			}
+		}
+		switch in.Kind {
+		case Const:
+			if t, ok := consts[in.Value]; ok {
+				subst[in.Dsts[0]] = t
+				continue
+			}
+			consts[in.Value] = in.Dsts[0]
+		case Load:
+			if t, ok := vars[in.Var]; ok {
+				subst[in.Dsts[0]] = t
+				applied["forward"]++
+				continue
+			}
+			vars[in.Var] = in.Dsts[0]
+		case Store:
+			vars[in.Var] = in.Args[0]
+		case Op:
+			if in.Op.Purity() == codegen.Effect {
+				vars = make(map[*Var]*Temp)
+				break
+			}
+			if purity := in.Op.Purity(); purity != codegen.Pure && purity != codegen.Context || len(in.Dsts) == 0 || len(in.Args) > 3 {
+				break
+			}
+			k := key{op: in.Op}
+			copy(k.args[:], in.Args)
+			if prev, ok := exprs[k]; ok {
+				for i, t := range in.Dsts {
+					subst[t] = prev[i]
+				}
+				applied["cse"]++
+				continue
+			}
+			exprs[k] = in.Dsts
+		}
+		out = append(out, in)
+	}
+	if len(subst) == 0 {
+		return false
+	}
+	b.Instrs = out
+	rename(b, subst)
+	if pressure(b) > max(peak, maxPressure) {
+		b.Instrs, b.Term = before, term
+		for i, in := range before {
+			in.Args = args[i]
+		}
+		return false
+	}
+	for name, n := range applied {
+		o.applied[name] += n
+	}
+	return true
+}
+
+// pressure retourne le plus grand nombre d'entrées que les temporaires de
+// b laissent sur la pile entre deux instructions : une par utilisation
+// encore attendue, comme Schedule les prépare.
+func pressure(b *Block) int {
+	uses := make(map[*Temp]int)
+	for _, in := range b.Instrs {
+		for _, t := range in.Args {
+			uses[t]++
+		}
+	}
+	for _, t := range []*Temp{b.Term.Cond, b.Term.Value} {
+		if t != nil {
+			uses[t]++
+		}
+	}
+	live, peak := 0, 0
+	for _, in := range b.Instrs {
+		live -= len(in.Args)
+		for _, t := range in.Dsts {
+			live += uses[t]
+		}
+		peak = max(peak, live)
+	}
+	return peak
+}
+
+// dead retire, du dernier au premier, les instructions sans effet dont
+// aucun résultat n'est utilisé.
+func (o *optimizer) dead(b *Block) {
+	uses := make(map[*Temp]int)
+	for _, in := range b.Instrs {
+		for _, t := range in.Args {
+			uses[t]++
+		}
+	}
+	for _, t := range []*Temp{b.Term.Cond, b.Term.Value} {
+		if t != nil {
+			uses[t]++
+		}
+	}
+	keep := make([]bool, len(b.Instrs))
+	for i := len(b.Instrs) - 1; i >= 0; i-- {
+		in := b.Instrs[i]
+		keep[i] = !removable(in)
+		for _, t := range in.Dsts {
+			keep[i] = keep[i] || uses[t] > 0
+		}
+		if keep[i] {
+			continue
+		}
+		for _, t := range in.Args {
+			uses[t]--
+		}
+		o.applied["dead"]++
+	}
+	var out []*Instr
+	for i, in := range b.Instrs {
+		if keep[i] {
+			out = append(out, in)
+		}
+	}
+	b.Instrs = out
+}
+
+// removable indique si in peut disparaître quand ses résultats sont
+// inutilisés : tout sauf un store et un opcode à effet.
+func removable(in *Instr) bool {
+	switch in.Kind {
+	case Const, Load:
+		return true
+	case Op:
+		return len(in.Dsts) > 0 && in.Op.Purity() != codegen.Effect
+	}
+	return false
+}
@@ -0,0 +1,537 @@
+package ir
+
+import (
+	"fmt"
+
+	"holyc-compiler/pkg/codegen"
+	"holyc-compiler/pkg/lexer"
+)
+
+// Output est le résultat de Schedule, prêt pour CodeGen.Assemble.
+type Output struct {
+	Code  []codegen.Instruction
+	Funcs []codegen.FuncInfo
+	// LoopBounds associe l'étiquette de l'en-tête de chaque boucle annotée
+	// à son nombre maximal d'itérations.
+	LoopBounds map[int]int64
+	// Resident et Spilled comptent les variables gardées sur la pile et
+	// celles renvoyées en mémoire faute d'être atteignables par DUP8/SWAP8 ;
+	// Slots compte les emplacements de pile que partagent les résidentes.
+	Resident int
+	Spilled  int
+	Slots    int
+}
+
+// scheduler porte l'état de Schedule.
+type scheduler struct {
+	prog    *Program
+	out     *Output
+	starts  []int           // position de chaque bloc dans out.Code
+	next    *Block          // bloc émis après le bloc courant
+	span    lexer.Span      // origine de l'instruction IR en cours d'émission
+	targets map[*Block]bool // blocs visés par un saut : ils commencent par un JUMPDEST
+	passes  codegen.Passes
+	gas     *codegen.GasSchedule
+
+	// Pile modélisée : une entrée par utilisation encore attendue d'un
+	// temporaire (sommet = dernier élément). Un temporaire utilisé n fois
+	// est dupliqué n-1 fois dès sa définition ; chaque utilisation consomme
+	// une copie.
+	stack []*Temp
+	uses  map[*Temp]int // nombre d'utilisations de chaque temporaire
+	err   error
+
+	// Variables résidentes de la région en cours (une fonction, ou le
+	// premier niveau si fn est nil) : chaque emplacement au fond de la pile
+	// (base) est représenté par un temporaire qu'aucune instruction ne
+	// consomme. Les emplacements du premier niveau, base[:outer], restent
+	// sous ceux d'une fonction.
+	live    []map[*Var]bool // variables vivantes à l'entrée de chaque bloc
+	fn      *Func
+	base    []*Temp
+	outer   int
+	slots   map[*Var]*Temp
+	tooDeep *Var // variable résidente hors de portée : la région est reprise sans elle
+}
+
+// Schedule ramène l'IR à des instructions de pile. Chaque bloc commence et
+// finit avec une pile vide ; dans un bloc, les temporaires vivent sur la
+// pile : les opérandes de chaque instruction y sont amenés dans l'ordre par
+// SWAP1-SWAP8, les utilisations multiples préparées par DUP, et les
+// résultats inutilisés retirés par POP. Les constantes et les adresses sont
+// matérialisées comme le fait CodeGen avec passes et le barème gas
+// (codegen.Materialize). Les variables sont en mémoire (PUSH adresse,
+// MLOAD/MSTORE), sauf avec passes.StackLocals. Les étiquettes sont
+// symboliques (étiquette du bloc b : b.ID+1) : ResolveLabels doit encore
+// être appelé.
+//
+// Avec passes.StackLocals, les paramètres et variables locales d'une
+// fonction vivent dans des emplacements au fond de la pile pendant la
+// région de la fonction, et les variables de premier niveau qu'aucune
+// fonction ne lit ni n'écrit pendant tout le programme. Deux variables qui
+// ne sont jamais vivantes en même temps partagent un emplacement (voir
+// assignSlots). Le prologue empile les emplacements (un paramètre vivant à
+// l'entrée depuis son emplacement mémoire, sinon 0), une lecture est un
+// DUP, une écriture un SWAP suivi d'un POP, et la sortie d'une fonction par
+// chute retire les siens. Les emplacements les plus utilisés sont les plus
+// proches du sommet ; une variable qu'une lecture ou une écriture
+// trouverait au-delà de DUP8/SWAP8 est renvoyée en mémoire et la région est
+// reprise sans elle.
+func Schedule(p *Program, passes codegen.Passes, gas *codegen.GasSchedule) (*Output, error) {
+	s := &scheduler{
+		prog:    p,
+		passes:  passes,
+		gas:     gas,
+		out:     &Output{LoopBounds: make(map[int]int64)},
+		starts:  make([]int, len(p.Blocks)+1),
+		targets: make(map[*Block]bool),
+		uses:    make(map[*Temp]int),
+	}
+	for i, b := range p.Blocks {
+		s.next = nil
+		if i+1 < len(p.Blocks) {
+			s.next = p.Blocks[i+1]
+		}
+		switch t := b.Term; t.Kind {
+		case Jump:
+			s.targets[t.Then] = s.targets[t.Then] || t.Then != s.next
+		case Branch:
+			s.targets[t.Then] = s.targets[t.Then] || t.Then != s.next
+			s.targets[t.Else] = s.targets[t.Else] || t.Then == s.next || t.Else != s.next
+		}
+		for _, in := range b.Instrs {
+			for _, t := range in.Args {
+				s.uses[t]++
+			}
+		}
+		for _, t := range []*Temp{b.Term.Cond, b.Term.Value} {
+			if t != nil {
+				s.uses[t]++
+			}
+		}
+	}
+
+	regions := make(map[int]*Func)
+	if passes.StackLocals {
+		s.live = liveIn(p)
+		for i := range p.Funcs {
+			regions[p.Funcs[i].Start] = &p.Funcs[i]
+		}
+	}
+	emit := func() {
+		for i := 0; i < len(p.Blocks) && s.err == nil && s.tooDeep == nil; {
+			if fn, ok := regions[i]; ok {
+				s.function(fn)
+				i = fn.End
+			} else {
+				s.blocks(i, i+1)
+				i++
+			}
+		}
+	}
+	if passes.StackLocals && len(p.Blocks) > 0 {
+		blocks, vars := s.topLevel()
+		s.reside(nil, blocks, p.Blocks[0], vars, emit)
+	} else {
+		emit()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.starts[len(p.Blocks)] = len(s.out.Code)
+
+	for _, fn := range p.Funcs {
+		s.out.Funcs = append(s.out.Funcs, codegen.FuncInfo{
+			Name: fn.Name, Public: fn.Public, Start: s.starts[fn.Start], End: s.starts[fn.End], Calls: fn.Calls,
+		})
+	}
+	return s.out, nil
+}
+
+// blocks émet les blocs Blocks[from:to].
+func (s *scheduler) blocks(from, to int) {
+	for i := from; i < to && s.err == nil && s.tooDeep == nil; i++ {
+		if i > from || s.fn == nil {
+			s.starts[i] = len(s.out.Code)
+		}
+		s.next = nil
+		if i+1 < len(s.prog.Blocks) {
+			s.next = s.prog.Blocks[i+1]
+		}
+		s.block(s.prog.Blocks[i])
+	}
+}
+
+// function émet la région de fn avec ses paramètres et variables locales
+// sur la pile.
+func (s *scheduler) function(fn *Func) {
+	vars := append(append([]*Var(nil), fn.Params...), fn.Locals...)
+	s.reside(fn, s.prog.Blocks[fn.Start:fn.End], s.prog.Blocks[fn.Start], vars, func() { s.blocks(fn.Start, fn.End) })
+}
+
+// topLevel retourne les blocs hors des régions de fonctions et les
+// variables que seuls ces blocs lisent ou écrivent.
+func (s *scheduler) topLevel() ([]*Block, []*Var) {
+	inFunc := make(map[int]bool)
+	for _, fn := range s.prog.Funcs {
+		for i := fn.Start; i < fn.End; i++ {
+			inFunc[i] = true
+		}
+	}
+	owned := make(map[*Var]bool)
+	for _, fn := range s.prog.Funcs {
+		for _, v := range append(append([]*Var(nil), fn.Params...), fn.Locals...) {
+			owned[v] = true
+		}
+	}
+	var blocks []*Block
+	for i, b := range s.prog.Blocks {
+		if !inFunc[i] {
+			blocks = append(blocks, b)
+			continue
+		}
+		for _, in := range b.Instrs {
+			if in.Kind == Load || in.Kind == Store {
+				owned[in.Var] = true
+			}
+		}
+	}
+	var vars []*Var
+	for _, v := range s.prog.Vars {
+		if !owned[v] {
+			vars = append(vars, v)
+		}
+	}
+	return blocks, vars
+}
+
+// reside émet par emit le code d'une région, une fonction ou le premier
+// niveau si fn est nil, avec les variables vars qu'elle lit ou écrit dans
+// des emplacements au fond de la pile, au-dessus de ceux de la région qui
+// l'englobe. blocks sont les blocs de la région et entry celui par lequel
+// l'exécution y entre. Une variable hors de portée est renvoyée en mémoire
+// et la région reprise sans elle.
+func (s *scheduler) reside(fn *Func, blocks []*Block, entry *Block, vars []*Var, emit func()) {
+	accesses := make(map[*Var]int)
+	for _, b := range blocks {
+		for _, in := range b.Instrs {
+			if in.Kind == Load || in.Kind == Store {
+				accesses[in.Var]++
+			}
+		}
+	}
+	spilled := make(map[*Var]bool)
+	mark, outer := len(s.out.Code), len(s.base)
+	resident, spills, slotCount := s.out.Resident, s.out.Spilled, s.out.Slots
+	enclosing, enclosingSlots, enclosingOuter := s.fn, s.slots, s.outer
+	for {
+		var candidates []*Var
+		for _, v := range vars {
+			if accesses[v] > 0 && !spilled[v] {
+				candidates = append(candidates, v)
+			}
+		}
+		slots := assignSlots(blocks, entry, candidates, accesses, s.live)
+
+		s.fn, s.outer = fn, outer
+		s.base = s.base[:outer]
+		s.slots = make(map[*Var]*Temp)
+		if fn != nil {
+			s.starts[fn.Start] = mark
+		}
+		s.span = lexer.Span{} // prologue : aucun nœud du source
+		for _, slot := range slots {
+			s.base = append(s.base, slot.temp)
+			for _, v := range slot.vars {
+				s.slots[v] = slot.temp
+			}
+			if fn != nil && slot.init != nil && isParam(fn, slot.init) {
+				s.push(int64(slot.init.Addr))
+				s.emitOp(codegen.OP_MLOAD)
+			} else {
+				s.push(0)
+			}
+		}
+		emit()
+		if s.tooDeep == nil || s.err != nil {
+			s.out.Resident += len(candidates)
+			s.out.Spilled += len(spilled)
+			s.out.Slots += len(slots)
+			break
+		}
+		spilled[s.tooDeep] = true
+		s.tooDeep = nil
+		s.out.Code = s.out.Code[:mark]
+		s.out.Resident, s.out.Spilled, s.out.Slots = resident, spills, slotCount
+	}
+	s.fn, s.slots, s.outer = enclosing, enclosingSlots, enclosingOuter
+	s.base = s.base[:outer]
+}
+
+func isParam(fn *Func, v *Var) bool {
+	for _, p := range fn.Params {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// inRegion indique si b appartient à la fonction en cours.
+func (s *scheduler) inRegion(b *Block) bool {
+	return s.fn == nil || b.ID >= s.fn.Start && b.ID < s.fn.End
+}
+
+func label(b *Block) int { return b.ID + 1 }
+
+func (s *scheduler) emit(inst codegen.Instruction) {
+	inst.Span = s.span
+	s.out.Code = append(s.out.Code, inst)
+}
+
+func (s *scheduler) emitOp(op codegen.Opcode) {
+	s.emit(codegen.Instruction{Op: op})
+}
+
+// push émet la matérialisation de v.
+func (s *scheduler) push(v int64) {
+	for _, inst := range codegen.Materialize(v, s.passes, s.gas) {
+		s.emit(inst)
+	}
+}
+
+func (s *scheduler) fail(format string, args ...any) {
+	if s.err == nil {
+		s.err = fmt.Errorf("ir: "+format, args...)
+	}
+}
+
+func (s *scheduler) block(b *Block) {
+	// Le JUMPDEST est situé comme la première instruction du bloc.
+	s.span = b.Term.Span
+	if len(b.Instrs) > 0 {
+		s.span = b.Instrs[0].Span
+	}
+	if s.targets[b] {
+		s.emit(codegen.Instruction{Op: codegen.OP_JUMPDEST, Label: label(b)})
+		if b.Bound > 0 {
+			s.out.LoopBounds[label(b)] = b.Bound
+		}
+	}
+
+	s.stack = append(s.stack[:0], s.base...)
+	for _, in := range b.Instrs {
+		s.instr(in)
+		if s.tooDeep != nil {
+			return
+		}
+	}
+	s.term(b.Term)
+	// Après la sortie d'une fonction, seuls restent les emplacements du
+	// premier niveau.
+	if s.tooDeep == nil && s.err == nil && !equal(s.stack, s.base) && !equal(s.stack, s.base[:s.outer]) {
+		s.fail("%s ends with %d value(s) on the stack", b, len(s.stack)-len(s.base))
+	}
+}
+
+func equal(a, b []*Temp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *scheduler) instr(in *Instr) {
+	s.span = in.Span
+	switch in.Kind {
+	case Const:
+		s.push(in.Value)
+	case Load:
+		if slot, ok := s.slots[in.Var]; ok {
+			d := s.depth(slot, 0)
+			if d >= 8 {
+				s.tooDeep = in.Var
+				return
+			}
+			s.emitOp(codegen.OP_DUP1 + codegen.Opcode(d))
+			break
+		}
+		s.push(int64(in.Var.Addr))
+		s.emitOp(codegen.OP_MLOAD)
+	case Store:
+		s.operands(in.Args)
+		if slot, ok := s.slots[in.Var]; ok {
+			d := s.depth(slot, 0)
+			if d > 8 {
+				s.tooDeep = in.Var
+				return
+			}
+			s.emitOp(codegen.OP_SWAP1 + codegen.Opcode(d-1))
+			s.emitOp(codegen.OP_POP)
+		} else {
+			s.push(int64(in.Var.Addr))
+			s.emitOp(codegen.OP_MSTORE)
+		}
+		s.consume(1)
+		return
+	case Op:
+		s.operands(in.Args)
+		s.emitOp(in.Op)
+		s.consume(len(in.Args))
+	}
+	s.define(in.Dsts)
+}
+
+func (s *scheduler) term(t Term) {
+	s.span = t.Span
+	switch t.Kind {
+	case Jump:
+		if !s.inRegion(t.Then) {
+			// Sortie de la fonction : ses variables quittent la pile.
+			for range s.base[s.outer:] {
+				s.emitOp(codegen.OP_POP)
+			}
+			s.stack = append(s.stack[:0], s.base[:s.outer]...)
+		}
+		if t.Then != s.next {
+			s.jump(codegen.OP_JUMP, t.Then)
+		}
+	case Branch:
+		if !s.inRegion(t.Then) || !s.inRegion(t.Else) {
+			s.fail("branch out of function %s", s.fn.Name)
+			return
+		}
+		s.operands([]*Temp{t.Cond})
+		s.consume(1)
+		switch {
+		case t.Then == s.next:
+			s.emitOp(codegen.OP_ISZERO)
+			s.jump(codegen.OP_JUMPI, t.Else)
+		case t.Else == s.next:
+			s.jump(codegen.OP_JUMPI, t.Then)
+		default:
+			s.jump(codegen.OP_JUMPI, t.Then)
+			s.jump(codegen.OP_JUMP, t.Else)
+		}
+	case Return:
+		if t.Value != nil {
+			s.operands([]*Temp{t.Value})
+			s.consume(1)
+			s.push(0)
+			s.emitOp(codegen.OP_MSTORE)
+			s.push(8)
+		} else {
+			s.push(0)
+		}
+		s.push(0)
+		s.emitOp(codegen.OP_RETURN)
+	case Stop:
+		s.emitOp(codegen.OP_STOP)
+	}
+}
+
+// jump émet PUSH2 étiquette, op.
+func (s *scheduler) jump(op codegen.Opcode, b *Block) {
+	s.emit(codegen.Instruction{Op: codegen.OP_PUSH2, Label: label(b)})
+	s.emitOp(op)
+}
+
+// define empile les résultats d'une instruction (Dsts[0] au sommet), puis
+// duplique ceux qui sont utilisés plusieurs fois et retire les inutilisés.
+func (s *scheduler) define(dsts []*Temp) {
+	for i := len(dsts) - 1; i >= 0; i-- {
+		s.stack = append(s.stack, dsts[i])
+	}
+	for _, t := range dsts {
+		d := s.depth(t, 0)
+		switch n := s.uses[t]; {
+		case n == 0 && d == 0:
+			s.emitOp(codegen.OP_POP)
+			s.stack = s.stack[:len(s.stack)-1]
+		case n == 0:
+			s.swap(d)
+			s.emitOp(codegen.OP_POP)
+			s.stack = s.stack[:len(s.stack)-1]
+		default:
+			for k := 1; k < n; k++ {
+				s.dup(s.depth(t, 0))
+			}
+		}
+	}
+}
+
+// operands amène une copie de chaque argument à sa place : args[i] à la
+// profondeur i. Les places sont fixées de la plus profonde au sommet ; une
+// copie mal placée est amenée au sommet puis échangée avec sa place.
+func (s *scheduler) operands(args []*Temp) {
+	n := len(args)
+	for i := n - 1; i >= 0; i-- {
+		if s.at(i) == args[i] {
+			continue
+		}
+		j := s.depth(args[i], 0)
+		if j > i && j < n {
+			j = s.depth(args[i], n) // les places i+1..n-1 sont déjà fixées
+		}
+		switch {
+		case j < 0:
+			s.fail("%s is not on the stack", args[i])
+			return
+		case i == 0:
+			s.swap(j)
+		case j == 0:
+			s.swap(i)
+		default:
+			s.swap(j)
+			s.swap(i)
+		}
+	}
+}
+
+// consume retire les n arguments consommés par l'instruction émise.
+func (s *scheduler) consume(n int) {
+	s.stack = s.stack[:len(s.stack)-n]
+}
+
+// at retourne l'entrée à la profondeur d (0 = sommet), nil au-delà du fond.
+func (s *scheduler) at(d int) *Temp {
+	if d >= len(s.stack) {
+		return nil
+	}
+	return s.stack[len(s.stack)-1-d]
+}
+
+// depth retourne la profondeur de la copie de t la plus proche du sommet,
+// à partir de la profondeur from, ou -1.
+func (s *scheduler) depth(t *Temp, from int) int {
+	for d := from; d < len(s.stack); d++ {
+		if s.at(d) == t {
+			return d
+		}
+	}
+	return -1
+}
+
+func (s *scheduler) dup(d int) {
+	if d >= 8 {
+		s.fail("%s is %d deep, beyond DUP8", s.at(d), d+1)
+		return
+	}
+	s.emitOp(codegen.OP_DUP1 + codegen.Opcode(d))
+	s.stack = append(s.stack, s.at(d))
+}
+
+func (s *scheduler) swap(d int) {
+	if d > 8 {
+		s.fail("%s is %d deep, beyond SWAP8", s.at(d), d+1)
+		return
+	}
+	s.emitOp(codegen.OP_SWAP1 + codegen.Opcode(d-1))
+	top := len(s.stack) - 1
+	s.stack[top], s.stack[top-d] = s.stack[top-d], s.stack[top]
+}
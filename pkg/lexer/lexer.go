@@ -2,29 +2,48 @@ package lexer
 
 import (
 	"fmt"
-	"os"
 	"strings"
+
+	"holyc-compiler/pkg/diag"
 )
 
 type Lexer struct {
-	src    string
-	pos    int
-	line   int
-	col    int
-	ch     byte
-	File   string
-	Errors []string
+	src      string
+	pos      int
+	line     int
+	col      int
+	ch       byte
+	lastLine int // position du dernier caractère consommé
+	lastCol  int
+	File     string
+	// Diagnostics reçoit les erreurs lexicales ; le caractère fautif est
+	// ignoré et l'analyse continue.
+	Diagnostics []diag.Diagnostic
 }
 
 func NewLexer(src, filename string) *Lexer {
-	l := &Lexer{src: src, line: 1, col: 0, File: filename}
+	return NewLexerAt(src, filename, 1, 1)
+}
+
+// NewLexerAt analyse src comme un extrait de filename commençant à la ligne
+// line, colonne col (la valeur d'un #define, par exemple).
+func NewLexerAt(src, filename string, line, col int) *Lexer {
+	l := &Lexer{src: src, line: line, col: col - 1, File: filename}
 	l.advance()
 	return l
 }
 
 func (l *Lexer) advance() {
+	l.lastLine, l.lastCol = l.line, l.col
 	if l.pos >= len(l.src) {
+		// l.pos-1 reste l'index du caractère courant : src[start:l.pos-1]
+		// couvre ainsi aussi le dernier lexème du fichier. La fin du
+		// fichier prend la colonne qui suit le dernier caractère.
+		if l.pos == len(l.src) {
+			l.col++
+		}
 		l.ch = 0
+		l.pos = len(l.src) + 1
 		return
 	}
 	l.ch = l.src[l.pos]
@@ -43,10 +62,13 @@ func (l *Lexer) peek() byte {
 	return l.src[l.pos]
 }
 
-func (l *Lexer) errorf(format string, args ...any) {
-	msg := fmt.Sprintf("%s:%d:%d: %s", l.File, l.line, l.col, fmt.Sprintf(format, args...))
-	l.Errors = append(l.Errors, msg)
-	fmt.Fprintln(os.Stderr, msg)
+// errorf signale une erreur sur les n caractères qui commencent à la
+// ligne line, colonne col, et retourne le diagnostic pour que l'appelant
+// le complète (fix-its).
+func (l *Lexer) errorf(code string, line, col, n int, format string, args ...any) *diag.Diagnostic {
+	rng := Span{Start: Pos{File: l.File, Line: line, Col: col}, End: Pos{File: l.File, Line: line, Col: col + n}}
+	l.Diagnostics = append(l.Diagnostics, diag.Errorf(code, rng, format, args...))
+	return &l.Diagnostics[len(l.Diagnostics)-1]
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -93,7 +115,7 @@ func (l *Lexer) readNumber() Token {
 			lit = l.src[start : l.pos-1]
 		}
 		val := parseHex(l.src[start+2 : l.pos-1])
-		return Token{TOK_INT, lit, val, 0, line, col}
+		return Token{TOK_INT, lit, val, 0, line, col, 0, 0}
 	}
 
 	if l.ch == '0' && (l.peek() == 'b' || l.peek() == 'B') {
@@ -104,7 +126,7 @@ func (l *Lexer) readNumber() Token {
 		}
 		lit := l.src[start : l.pos-1]
 		val := parseBin(l.src[start+2 : l.pos-1])
-		return Token{TOK_INT, lit, val, 0, line, col}
+		return Token{TOK_INT, lit, val, 0, line, col, 0, 0}
 	}
 
 	for isDigit(l.ch) {
@@ -138,10 +160,10 @@ func (l *Lexer) readNumber() Token {
 
 	if isFloat {
 		fval := parseFloat(lit)
-		return Token{TOK_FLOAT, lit, 0, fval, line, col}
+		return Token{TOK_FLOAT, lit, 0, fval, line, col, 0, 0}
 	}
 	ival := parseInt(lit)
-	return Token{TOK_INT, lit, ival, 0, line, col}
+	return Token{TOK_INT, lit, ival, 0, line, col, 0, 0}
 }
 
 func (l *Lexer) readIdent() Token {
@@ -155,7 +177,7 @@ func (l *Lexer) readIdent() Token {
 		end = l.pos - 1
 	}
 	lit := l.src[start:end]
-	return Token{LookupIdent(lit), lit, 0, 0, line, col}
+	return Token{LookupIdent(lit), lit, 0, 0, line, col, 0, 0}
 }
 
 func (l *Lexer) readString() Token {
@@ -188,7 +210,7 @@ func (l *Lexer) readString() Token {
 		l.advance()
 	}
 	l.advance()
-	return Token{TOK_STRING, sb.String(), 0, 0, line, col}
+	return Token{TOK_STRING, sb.String(), 0, 0, line, col, 0, 0}
 }
 
 func (l *Lexer) readCharConst() Token {
@@ -222,7 +244,7 @@ func (l *Lexer) readCharConst() Token {
 		l.advance()
 	}
 	l.advance()
-	return Token{TOK_CHAR, "", val, 0, line, col}
+	return Token{TOK_CHAR, "", val, 0, line, col, 0, 0}
 }
 
 func (l *Lexer) handlePreprocessor() Token {
@@ -241,7 +263,7 @@ func (l *Lexer) handlePreprocessor() Token {
 		l.skipWhitespace()
 		if l.ch == '"' {
 			tok := l.readString()
-			return Token{TOK_INCLUDE, tok.Literal, 0, 0, line, col}
+			return Token{TOK_INCLUDE, tok.Literal, 0, 0, line, col, 0, 0}
 		}
 	case "define":
 		l.skipWhitespace()
@@ -250,42 +272,68 @@ func (l *Lexer) handlePreprocessor() Token {
 			l.advance()
 		}
 		name := l.src[start : l.pos-1]
-		l.skipWhitespace()
-		valStart := l.pos - 1
+		if l.ch == '(' {
+			break // macro à paramètres : ignorée
+		}
+		for l.ch == ' ' || l.ch == '\t' {
+			l.advance()
+		}
+		valStart, valCol := l.pos-1, l.col
 		for l.ch != '\n' && l.ch != 0 {
 			l.advance()
 		}
 		valEnd := l.pos - 1
-		if l.ch != 0 {
-			valEnd = l.pos - 1
+		// Literal = "NOM valeur" ; la valeur est analysée par le parser, et
+		// le lexème finit avec elle : elle commence à EndCol - len(valeur).
+		value := strings.TrimSpace(l.src[valStart:valEnd])
+		tok := Token{TOK_DEFINE, strings.TrimSpace(name + " " + value), 0, 0, line, col, 0, 0}
+		if value != "" {
+			tok.EndLine, tok.EndCol = line, valCol+len(value)
+		}
+		return tok
+	case "pragma":
+		l.skipWhitespace()
+		start = l.pos - 1
+		for l.ch != '\n' && l.ch != 0 {
+			l.advance()
 		}
-		_ = strings.TrimSpace(l.src[valStart:valEnd])
-		return Token{TOK_DEFINE, name, 0, 0, line, col}
+		return Token{TOK_PRAGMA, strings.TrimSpace(l.src[start : l.pos-1]), 0, 0, line, col, 0, 0}
 	}
 	for l.ch != '\n' && l.ch != 0 {
 		l.advance()
 	}
-	return l.NextToken()
+	return l.nextToken()
 }
 
+// NextToken retourne le lexème suivant, sa fin comprise.
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	if tok.Type == TOK_EOF {
+		tok.EndLine, tok.EndCol = tok.Line, tok.Col
+	} else if tok.EndLine == 0 {
+		tok.EndLine, tok.EndCol = l.lastLine, l.lastCol+1
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() Token {
 	l.skipWhitespace()
 	line, col := l.line, l.col
 
 	if l.ch == 0 {
-		return Token{TOK_EOF, "", 0, 0, line, col}
+		return Token{TOK_EOF, "", 0, 0, line, col, 0, 0}
 	}
 
 	if l.ch == '/' {
 		if l.peek() == '/' {
 			l.skipLineComment()
-			return l.NextToken()
+			return l.nextToken()
 		}
 		if l.peek() == '*' {
 			l.advance()
 			l.advance()
 			l.skipBlockComment()
-			return l.NextToken()
+			return l.nextToken()
 		}
 	}
 
@@ -316,144 +364,145 @@ func (l *Lexer) NextToken() Token {
 	case '+':
 		if l.ch == '+' {
 			l.advance()
-			return Token{TOK_PLUS_PLUS, "++", 0, 0, line, col}
+			return Token{TOK_PLUS_PLUS, "++", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_PLUS_EQ, "+=", 0, 0, line, col}
+			return Token{TOK_PLUS_EQ, "+=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_PLUS, "+", 0, 0, line, col}
+		return Token{TOK_PLUS, "+", 0, 0, line, col, 0, 0}
 	case '-':
 		if l.ch == '-' {
 			l.advance()
-			return Token{TOK_MINUS_MINUS, "--", 0, 0, line, col}
+			return Token{TOK_MINUS_MINUS, "--", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_MINUS_EQ, "-=", 0, 0, line, col}
+			return Token{TOK_MINUS_EQ, "-=", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '>' {
 			l.advance()
-			return Token{TOK_ARROW, "->", 0, 0, line, col}
+			return Token{TOK_ARROW, "->", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_MINUS, "-", 0, 0, line, col}
+		return Token{TOK_MINUS, "-", 0, 0, line, col, 0, 0}
 	case '*':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_STAR_EQ, "*=", 0, 0, line, col}
+			return Token{TOK_STAR_EQ, "*=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_STAR, "*", 0, 0, line, col}
+		return Token{TOK_STAR, "*", 0, 0, line, col, 0, 0}
 	case '/':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_SLASH_EQ, "/=", 0, 0, line, col}
+			return Token{TOK_SLASH_EQ, "/=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_SLASH, "/", 0, 0, line, col}
+		return Token{TOK_SLASH, "/", 0, 0, line, col, 0, 0}
 	case '%':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_PERCENT_EQ, "%=", 0, 0, line, col}
+			return Token{TOK_PERCENT_EQ, "%=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_PERCENT, "%", 0, 0, line, col}
+		return Token{TOK_PERCENT, "%", 0, 0, line, col, 0, 0}
 	case '&':
 		if l.ch == '&' {
 			l.advance()
-			return Token{TOK_AND_AND, "&&", 0, 0, line, col}
+			return Token{TOK_AND_AND, "&&", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_AMP_EQ, "&=", 0, 0, line, col}
+			return Token{TOK_AMP_EQ, "&=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_AMP, "&", 0, 0, line, col}
+		return Token{TOK_AMP, "&", 0, 0, line, col, 0, 0}
 	case '|':
 		if l.ch == '|' {
 			l.advance()
-			return Token{TOK_OR_OR, "||", 0, 0, line, col}
+			return Token{TOK_OR_OR, "||", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_PIPE_EQ, "|=", 0, 0, line, col}
+			return Token{TOK_PIPE_EQ, "|=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_PIPE, "|", 0, 0, line, col}
+		return Token{TOK_PIPE, "|", 0, 0, line, col, 0, 0}
 	case '^':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_CARET_EQ, "^=", 0, 0, line, col}
+			return Token{TOK_CARET_EQ, "^=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_CARET, "^", 0, 0, line, col}
+		return Token{TOK_CARET, "^", 0, 0, line, col, 0, 0}
 	case '~':
-		return Token{TOK_TILDE, "~", 0, 0, line, col}
+		return Token{TOK_TILDE, "~", 0, 0, line, col, 0, 0}
 	case '!':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_NEQ, "!=", 0, 0, line, col}
+			return Token{TOK_NEQ, "!=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_BANG, "!", 0, 0, line, col}
+		return Token{TOK_BANG, "!", 0, 0, line, col, 0, 0}
 	case '<':
 		if l.ch == '<' {
 			l.advance()
 			if l.ch == '=' {
 				l.advance()
-				return Token{TOK_SHL_EQ, "<<=", 0, 0, line, col}
+				return Token{TOK_SHL_EQ, "<<=", 0, 0, line, col, 0, 0}
 			}
-			return Token{TOK_SHL, "<<", 0, 0, line, col}
+			return Token{TOK_SHL, "<<", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_LTE, "<=", 0, 0, line, col}
+			return Token{TOK_LTE, "<=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_LT, "<", 0, 0, line, col}
+		return Token{TOK_LT, "<", 0, 0, line, col, 0, 0}
 	case '>':
 		if l.ch == '>' {
 			l.advance()
 			if l.ch == '=' {
 				l.advance()
-				return Token{TOK_SHR_EQ, ">>=", 0, 0, line, col}
+				return Token{TOK_SHR_EQ, ">>=", 0, 0, line, col, 0, 0}
 			}
-			return Token{TOK_SHR, ">>", 0, 0, line, col}
+			return Token{TOK_SHR, ">>", 0, 0, line, col, 0, 0}
 		}
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_GTE, ">=", 0, 0, line, col}
+			return Token{TOK_GTE, ">=", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_GT, ">", 0, 0, line, col}
+		return Token{TOK_GT, ">", 0, 0, line, col, 0, 0}
 	case '=':
 		if l.ch == '=' {
 			l.advance()
-			return Token{TOK_EQ, "==", 0, 0, line, col}
+			return Token{TOK_EQ, "==", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_ASSIGN, "=", 0, 0, line, col}
+		return Token{TOK_ASSIGN, "=", 0, 0, line, col, 0, 0}
 	case '`':
-		return Token{TOK_BACKTICK, "`", 0, 0, line, col}
+		return Token{TOK_BACKTICK, "`", 0, 0, line, col, 0, 0}
 	case '.':
 		if l.ch == '.' && l.peek() == '.' {
 			l.advance()
 			l.advance()
-			return Token{TOK_ELLIPSIS, "...", 0, 0, line, col}
+			return Token{TOK_ELLIPSIS, "...", 0, 0, line, col, 0, 0}
 		}
-		return Token{TOK_DOT, ".", 0, 0, line, col}
+		return Token{TOK_DOT, ".", 0, 0, line, col, 0, 0}
 	case '(':
-		return Token{TOK_LPAREN, "(", 0, 0, line, col}
+		return Token{TOK_LPAREN, "(", 0, 0, line, col, 0, 0}
 	case ')':
-		return Token{TOK_RPAREN, ")", 0, 0, line, col}
+		return Token{TOK_RPAREN, ")", 0, 0, line, col, 0, 0}
 	case '[':
-		return Token{TOK_LBRACKET, "[", 0, 0, line, col}
+		return Token{TOK_LBRACKET, "[", 0, 0, line, col, 0, 0}
 	case ']':
-		return Token{TOK_RBRACKET, "]", 0, 0, line, col}
+		return Token{TOK_RBRACKET, "]", 0, 0, line, col, 0, 0}
 	case '{':
-		return Token{TOK_LBRACE, "{", 0, 0, line, col}
+		return Token{TOK_LBRACE, "{", 0, 0, line, col, 0, 0}
 	case '}':
-		return Token{TOK_RBRACE, "}", 0, 0, line, col}
+		return Token{TOK_RBRACE, "}", 0, 0, line, col, 0, 0}
 	case ';':
-		return Token{TOK_SEMICOLON, ";", 0, 0, line, col}
+		return Token{TOK_SEMICOLON, ";", 0, 0, line, col, 0, 0}
 	case ',':
-		return Token{TOK_COMMA, ",", 0, 0, line, col}
+		return Token{TOK_COMMA, ",", 0, 0, line, col, 0, 0}
 	case ':':
-		return Token{TOK_COLON, ":", 0, 0, line, col}
+		return Token{TOK_COLON, ":", 0, 0, line, col, 0, 0}
 	}
 
-	l.errorf("unexpected character: '%c' (0x%02X)", ch, ch)
-	return l.NextToken()
+	d := l.errorf("unexpected-character", line, col, 1, "unexpected character: '%c' (0x%02X)", ch, ch)
+	d.Fixes = []diag.FixIt{{Range: d.Range}}
+	return l.nextToken()
 }
 
 func isDigit(ch byte) bool        { return ch >= '0' && ch <= '9' }
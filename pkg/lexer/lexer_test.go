@@ -0,0 +1,121 @@
+package lexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPragma(t *testing.T) {
+	l := NewLexer("#pragma bound 16  \nfor\n#pragma unroll", "test.HC")
+	tests := []struct {
+		typ     TokenType
+		literal string
+		line    int
+	}{
+		{TOK_PRAGMA, "bound 16", 1},
+		{TOK_FOR, "for", 2},
+		{TOK_PRAGMA, "unroll", 3},
+		{TOK_EOF, "", 3},
+	}
+	for _, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.typ || tok.Literal != tt.literal || tok.Line != tt.line {
+			t.Errorf("token %v %q line %d, want %v %q line %d", tok.Type, tok.Literal, tok.Line, tt.typ, tt.literal, tt.line)
+		}
+	}
+}
+
+func TestTokenEnd(t *testing.T) {
+	l := NewLexer("I64 abc = 0x1F;\n\"a\\nb\" /* x\ny */ +=", "test.HC")
+	want := []string{
+		"1:1-1:4", "1:5-1:8", "1:9-1:10", "1:11-1:15", "1:15-1:16",
+		"2:1-2:7", "3:6-3:8",
+	}
+	for _, w := range want {
+		tok := l.NextToken()
+		if got := fmt.Sprintf("%d:%d-%d:%d", tok.Line, tok.Col, tok.EndLine, tok.EndCol); got != w {
+			t.Errorf("%q at %s, want %s", tok.Literal, got, w)
+		}
+	}
+}
+
+// TestEOFColumn vérifie que la fin du fichier a une colonne comptée à
+// partir de 1, même après un '\n' final.
+func TestEOFColumn(t *testing.T) {
+	for src, want := range map[string]string{"": "1:1", "x": "1:2", "x;\n": "2:1"} {
+		l := NewLexer(src, "test.HC")
+		tok := l.NextToken()
+		for tok.Type != TOK_EOF {
+			tok = l.NextToken()
+		}
+		if got := fmt.Sprintf("%d:%d", tok.Line, tok.Col); got != want {
+			t.Errorf("%q: end of file at %s, want %s", src, got, want)
+		}
+	}
+}
+
+// TestUnexpectedCharacter vérifie que le caractère inconnu est signalé à sa
+// position, avec un fix-it qui le supprime, et que l'analyse continue.
+func TestUnexpectedCharacter(t *testing.T) {
+	l := NewLexer("I64 x = 1 @ 2;", "test.HC")
+	var lits []string
+	for tok := l.NextToken(); tok.Type != TOK_EOF; tok = l.NextToken() {
+		lits = append(lits, tok.Literal)
+	}
+	if got := fmt.Sprint(lits); got != "[I64 x = 1 2 ;]" {
+		t.Errorf("tokens %s", got)
+	}
+	if len(l.Diagnostics) != 1 {
+		t.Fatalf("diagnostics %v", l.Diagnostics)
+	}
+	d := l.Diagnostics[0]
+	if got, want := d.String(), "test.HC:1:11: error: unexpected character: '@' (0x40) [unexpected-character]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(d.Fixes) != 1 || d.Fixes[0].Text != "" || d.Fixes[0].Range != d.Range || d.Range.End.Col != 12 {
+		t.Errorf("range %v, fixes %v", d.Range, d.Fixes)
+	}
+}
+
+// TestTokenNames vérifie que chaque type de lexème a un nom, et que les
+// mots-clés se relisent sous leur nom.
+func TestTokenNames(t *testing.T) {
+	seen := make(map[string]TokenType)
+	for tt := TOK_EOF; tt <= TOK_COLON; tt++ {
+		name := tt.String()
+		if name == fmt.Sprintf("token(%d)", int(tt)) {
+			t.Errorf("token %d has no name", int(tt))
+		}
+		if prev, ok := seen[name]; ok {
+			t.Errorf("tokens %d and %d are both named %q", int(prev), int(tt), name)
+		}
+		seen[name] = tt
+	}
+	for _, kw := range Keywords() {
+		if got := LookupIdent(kw).String(); got != kw {
+			t.Errorf("keyword %q named %q", kw, got)
+		}
+	}
+	if got := TokenType(-1).String(); got != "token(-1)" {
+		t.Errorf("TokenType(-1) = %q", got)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		tok  Token
+		want string
+	}{
+		{Token{Type: TOK_SEMICOLON, Literal: ";"}, "';'"},
+		{Token{Type: TOK_RETURN, Literal: "return"}, "'return'"},
+		{Token{Type: TOK_SHL_EQ, Literal: "<<="}, "'<<='"},
+		{Token{Type: TOK_IDENT, Literal: "x"}, "identifier 'x'"},
+		{Token{Type: TOK_INT, Literal: "0x10"}, "integer literal '0x10'"},
+		{Token{Type: TOK_EOF}, "end of file"},
+	}
+	for _, tt := range tests {
+		if got := tt.tok.Describe(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package lexer
 
+import (
+	"fmt"
+	"sort"
+
+	"holyc-compiler/pkg/diag"
+)
+
 type TokenType int
 
 const (
@@ -46,6 +53,7 @@ const (
 	TOK_GOTO
 	TOK_INCLUDE
 	TOK_DEFINE
+	TOK_PRAGMA
 
 	// Opérateurs
 	TOK_PLUS     // +
@@ -101,6 +109,69 @@ const (
 	TOK_COLON     // :
 )
 
+// tokenNames donne le nom de chaque type de lexème dans les messages : sa
+// graphie pour les mots-clés et les symboles, sa catégorie pour les autres.
+var tokenNames = [...]string{
+	TOK_EOF: "end of file", TOK_INT: "integer literal", TOK_FLOAT: "float literal",
+	TOK_STRING: "string literal", TOK_CHAR: "character literal", TOK_IDENT: "identifier",
+
+	TOK_U0: "U0", TOK_U8: "U8", TOK_U16: "U16", TOK_U32: "U32", TOK_U64: "U64",
+	TOK_I8: "I8", TOK_I16: "I16", TOK_I32: "I32", TOK_I64: "I64", TOK_F64: "F64", TOK_BOOL: "Bool",
+
+	TOK_IF: "if", TOK_ELSE: "else", TOK_WHILE: "while", TOK_DO: "do", TOK_FOR: "for",
+	TOK_SWITCH: "switch", TOK_CASE: "case", TOK_DEFAULT: "default", TOK_BREAK: "break",
+	TOK_RETURN: "return", TOK_CLASS: "class", TOK_UNION: "union", TOK_PUBLIC: "public",
+	TOK_EXTERN: "extern", TOK_STATIC: "static", TOK_SIZEOF: "sizeof", TOK_TRY: "try",
+	TOK_CATCH: "catch", TOK_GOTO: "goto",
+	TOK_INCLUDE: "#include", TOK_DEFINE: "#define", TOK_PRAGMA: "#pragma",
+
+	TOK_PLUS: "+", TOK_MINUS: "-", TOK_STAR: "*", TOK_SLASH: "/", TOK_PERCENT: "%",
+	TOK_AMP: "&", TOK_PIPE: "|", TOK_CARET: "^", TOK_TILDE: "~", TOK_BANG: "!",
+	TOK_LT: "<", TOK_GT: ">", TOK_ASSIGN: "=", TOK_DOT: ".", TOK_ARROW: "->", TOK_HASH: "#",
+
+	TOK_PLUS_PLUS: "++", TOK_MINUS_MINUS: "--", TOK_SHL: "<<", TOK_SHR: ">>",
+	TOK_EQ: "==", TOK_NEQ: "!=", TOK_LTE: "<=", TOK_GTE: ">=", TOK_AND_AND: "&&", TOK_OR_OR: "||",
+	TOK_PLUS_EQ: "+=", TOK_MINUS_EQ: "-=", TOK_STAR_EQ: "*=", TOK_SLASH_EQ: "/=",
+	TOK_PERCENT_EQ: "%=", TOK_AMP_EQ: "&=", TOK_PIPE_EQ: "|=", TOK_CARET_EQ: "^=",
+	TOK_SHL_EQ: "<<=", TOK_SHR_EQ: ">>=", TOK_BACKTICK: "`", TOK_ELLIPSIS: "...",
+
+	TOK_LPAREN: "(", TOK_RPAREN: ")", TOK_LBRACKET: "[", TOK_RBRACKET: "]",
+	TOK_LBRACE: "{", TOK_RBRACE: "}", TOK_SEMICOLON: ";", TOK_COMMA: ",", TOK_COLON: ":",
+}
+
+func (t TokenType) String() string {
+	if t >= 0 && int(t) < len(tokenNames) && tokenNames[t] != "" {
+		return tokenNames[t]
+	}
+	return fmt.Sprintf("token(%d)", int(t))
+}
+
+// IsClass indique si t désigne une catégorie de lexèmes (identifiant,
+// littéral, fin de fichier) plutôt qu'un mot-clé ou un symbole précis.
+func (t TokenType) IsClass() bool { return t <= TOK_IDENT }
+
+// Describe décrit le lexème dans un message : « ';' », « 'return' »,
+// « identifier 'x' », « end of file ».
+func (t Token) Describe() string {
+	switch {
+	case t.Type == TOK_EOF:
+		return t.Type.String()
+	case t.Type.IsClass():
+		return fmt.Sprintf("%s '%s'", t.Type, t.Literal)
+	}
+	return fmt.Sprintf("'%s'", t.Type)
+}
+
+// Keywords retourne les mots-clés du langage, types compris, triés.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Token struct {
 	Type     TokenType
 	Literal  string
@@ -108,8 +179,23 @@ type Token struct {
 	FloatVal float64
 	Line     int
 	Col      int
+	EndLine  int // fin du lexème : ligne et colonne qui suit son dernier caractère
+	EndCol   int
 }
 
+// Pos et Span sont définis dans diag, que les diagnostics du lexer
+// utilisent déjà ; les alias les gardent accessibles ici.
+type (
+	Pos  = diag.Pos
+	Span = diag.Span
+)
+
+// Start retourne la position de début du lexème.
+func (t Token) Start(file string) Pos { return Pos{File: file, Line: t.Line, Col: t.Col} }
+
+// End retourne la position qui suit le lexème.
+func (t Token) End(file string) Pos { return Pos{File: file, Line: t.EndLine, Col: t.EndCol} }
+
 var keywords = map[string]TokenType{
 	"U0": TOK_U0, "U8": TOK_U8, "U16": TOK_U16, "U32": TOK_U32, "U64": TOK_U64,
 	"I8": TOK_I8, "I16": TOK_I16, "I32": TOK_I32, "I64": TOK_I64,
@@ -5,24 +5,46 @@ import "holyc-compiler/pkg/lexer"
 // Chaque nœud de l'AST implémente Node.
 type Node interface {
 	nodeType() string
+	Span() lexer.Span
+	SetSpan(lexer.Span)
 }
 
+// node, inclus dans chaque nœud, porte son étendue dans le source. Les
+// passes qui créent ou recopient des nœuds la reportent avec SetSpan.
+type node struct{ span lexer.Span }
+
+func (n *node) Span() lexer.Span { return n.span }
+func (n *node) SetSpan(s lexer.Span) { n.span = s }
+
 // ---- Expressions ----
 
-type IntLiteral struct{ Value int64 }
+type IntLiteral struct {
+	node
+	Value int64
+}
 func (n *IntLiteral) nodeType() string { return "IntLiteral" }
 
-type FloatLiteral struct{ Value float64 }
+type FloatLiteral struct {
+	node
+	Value float64
+}
 func (n *FloatLiteral) nodeType() string { return "FloatLiteral" }
 
-type StringLiteral struct{ Value string }
+type StringLiteral struct {
+	node
+	Value string
+}
 func (n *StringLiteral) nodeType() string { return "StringLiteral" }
 
-type Identifier struct{ Name string }
+type Identifier struct {
+	node
+	Name string
+}
 func (n *Identifier) nodeType() string { return "Identifier" }
 
 // Opération binaire: a + b, a * b, etc.
 type BinaryExpr struct {
+	node
 	Op    lexer.TokenType
 	Left  Node
 	Right Node
@@ -31,6 +53,7 @@ func (n *BinaryExpr) nodeType() string { return "BinaryExpr" }
 
 // Opération unaire: -a, ~a, !a
 type UnaryExpr struct {
+	node
 	Op      lexer.TokenType
 	Operand Node
 }
@@ -38,13 +61,25 @@ func (n *UnaryExpr) nodeType() string { return "UnaryExpr" }
 
 // Appel de fonction: Print("hello"), MulMod(a, b, m)
 type CallExpr struct {
+	node
 	Func string
 	Args []Node
 }
 func (n *CallExpr) nodeType() string { return "CallExpr" }
 
+// Appel de fonction remplacé par son corps : Stmts s'exécutent dans une
+// portée propre, puis Value donne la valeur de l'appel (nil : aucune).
+type InlineExpr struct {
+	node
+	Func  string
+	Stmts []Node
+	Value Node
+}
+func (n *InlineExpr) nodeType() string { return "InlineExpr" }
+
 // Accès tableau: a[i]
 type IndexExpr struct {
+	node
 	Array Node
 	Index Node
 }
@@ -52,6 +87,7 @@ func (n *IndexExpr) nodeType() string { return "IndexExpr" }
 
 // Accès membre: a.x, a->x
 type MemberExpr struct {
+	node
 	Object Node
 	Member string
 	Arrow  bool
@@ -60,6 +96,7 @@ func (n *MemberExpr) nodeType() string { return "MemberExpr" }
 
 // Assignation: a = b, a += b, etc.
 type AssignExpr struct {
+	node
 	Op     lexer.TokenType
 	Target Node
 	Value  Node
@@ -68,6 +105,7 @@ func (n *AssignExpr) nodeType() string { return "AssignExpr" }
 
 // Post-incrément/décrément: a++, a--
 type PostfixExpr struct {
+	node
 	Op      lexer.TokenType
 	Operand Node
 }
@@ -75,19 +113,24 @@ func (n *PostfixExpr) nodeType() string { return "PostfixExpr" }
 
 // Cast HolyC postfix: expr(I64), expr(U8 *)
 type CastExpr struct {
+	node
 	Expr     Node
 	TypeName string
 }
 func (n *CastExpr) nodeType() string { return "CastExpr" }
 
 // sizeof(Type)
-type SizeofExpr struct{ TypeName string }
+type SizeofExpr struct {
+	node
+	TypeName string
+}
 func (n *SizeofExpr) nodeType() string { return "SizeofExpr" }
 
 // ---- Statements ----
 
 // Déclaration de variable: I64 x = 5;
 type VarDecl struct {
+	node
 	TypeName string
 	Name     string
 	Init     Node
@@ -96,15 +139,22 @@ type VarDecl struct {
 func (n *VarDecl) nodeType() string { return "VarDecl" }
 
 // Statement expression: une expression suivie de ;
-type ExprStmt struct{ Expr Node }
+type ExprStmt struct {
+	node
+	Expr Node
+}
 func (n *ExprStmt) nodeType() string { return "ExprStmt" }
 
 // return expr;
-type ReturnStmt struct{ Value Node }
+type ReturnStmt struct {
+	node
+	Value Node
+}
 func (n *ReturnStmt) nodeType() string { return "ReturnStmt" }
 
 // if (cond) body [else elsebody]
 type IfStmt struct {
+	node
 	Cond Node
 	Body Node
 	Else Node
@@ -112,40 +162,84 @@ type IfStmt struct {
 func (n *IfStmt) nodeType() string { return "IfStmt" }
 
 // while (cond) body
+// Bound est le nombre maximal d'itérations annoncé par `#pragma bound N` ou
+// `[[bound(N)]]` (0 = non annoté).
 type WhileStmt struct {
-	Cond Node
-	Body Node
+	node
+	Cond  Node
+	Body  Node
+	Bound int64
 }
 func (n *WhileStmt) nodeType() string { return "WhileStmt" }
 
 // for (init; cond; post) body
+// Unroll demande le déroulage de la boucle (`#pragma unroll` ou
+// `[[unroll]]`), quel qu'en soit le coût.
 type ForStmt struct {
-	Init Node
-	Cond Node
-	Post Node
-	Body Node
+	node
+	Init   Node
+	Cond   Node
+	Post   Node
+	Body   Node
+	Bound  int64
+	Unroll bool
 }
 func (n *ForStmt) nodeType() string { return "ForStmt" }
 
 // { stmts... }
-type Block struct{ Stmts []Node }
+type Block struct {
+	node
+	Stmts []Node
+}
 func (n *Block) nodeType() string { return "Block" }
 
 // Déclaration de fonction
 type FuncDecl struct {
+	node
 	ReturnType string
 	Name       string
 	Params     []FuncParam
 	Body       *Block
+	Public     bool
+	Inline     InlineHint
+	TailCall   bool // [[tailcall]] : les appels récursifs doivent être terminaux
 }
 func (n *FuncDecl) nodeType() string { return "FuncDecl" }
 
+// Indication d'inlining d'une fonction : [[inline]], [[noinline]].
+type InlineHint int
+
+const (
+	InlineAuto   InlineHint = iota // selon le modèle de coût
+	InlineAlways                   // [[inline]]
+	InlineNever                    // [[noinline]]
+)
+
 type FuncParam struct {
+	node
 	TypeName string
 	Name     string
 	Default  Node
 }
 
+// #define NOM valeur : Value est nil si la valeur n'est pas une expression.
+type DefineDecl struct {
+	node
+	Name  string
+	Value Node
+}
+func (n *DefineDecl) nodeType() string { return "DefineDecl" }
+
+// Attribut [[name(args...)]] placé devant une instruction ou une déclaration.
+type Attribute struct {
+	node
+	Name string
+	Args []Node
+}
+
 // Programme complet
-type Program struct{ Decls []Node }
+type Program struct {
+	node
+	Decls []Node
+}
 func (n *Program) nodeType() string { return "Program" }
@@ -1,17 +1,22 @@
 package parser
 
 import (
-	"fmt"
-	"os"
+	"strconv"
+	"strings"
 
+	"holyc-compiler/pkg/diag"
 	"holyc-compiler/pkg/lexer"
 )
 
 type Parser struct {
-	lex    *lexer.Lexer
-	cur    lexer.Token
-	peek   lexer.Token
-	Errors []string
+	lex  *lexer.Lexer
+	prev lexer.Token // dernier lexème consommé, fin du nœud en cours
+	cur  lexer.Token
+	peek lexer.Token
+	// Diagnostics reçoit les erreurs de syntaxe ; celles du lexer restent
+	// dans le Lexer.
+	Diagnostics []diag.Diagnostic
+	panicking   bool // une erreur de syntaxe attend un point de synchronisation
 }
 
 func NewParser(l *lexer.Lexer) *Parser {
@@ -22,23 +27,182 @@ func NewParser(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) advance() lexer.Token {
-	prev := p.cur
+	p.prev = p.cur
 	p.cur = p.peek
 	p.peek = p.lex.NextToken()
-	return prev
+	return p.prev
 }
 
+// start retourne la position du lexème courant, où commence un nœud.
+func (p *Parser) start() lexer.Pos { return p.cur.Start(p.lex.File) }
+
+// at retourne l'étendue d'un nœud commencé à start et dont le dernier
+// lexème vient d'être consommé.
+func (p *Parser) at(start lexer.Pos) node {
+	return node{span: lexer.Span{Start: start, End: p.prev.End(p.lex.File)}}
+}
+
+// expect consomme un lexème de type t. S'il manque, l'erreur est signalée
+// sans rien consommer : la suite est analysée comme si t était là, et le
+// lexème retourné n'a que son type.
 func (p *Parser) expect(t lexer.TokenType) lexer.Token {
-	if p.cur.Type != t {
-		p.errorf("expected token %d, got %d ('%s')", t, p.cur.Type, p.cur.Literal)
+	if p.cur.Type == t {
+		return p.advance()
+	}
+	d := p.errorf("expected-token", "expected %s, got %s", quote(t), p.cur.Describe())
+	switch t {
+	case lexer.TOK_SEMICOLON, lexer.TOK_RPAREN, lexer.TOK_RBRACKET:
+		if d != nil && p.prev.EndLine != 0 {
+			end := lexer.Span{Start: p.prev.End(p.lex.File), End: p.prev.End(p.lex.File)}
+			d.Fixes = []diag.FixIt{{Range: end, Text: t.String()}}
+			if p.cur.Line > p.prev.EndLine {
+				d.Range = end // en fin de ligne, là où il manque
+			}
+		}
+	}
+	// Un ';' oublié en fin de ligne : la ligne suivante commence une
+	// nouvelle instruction, rien n'est à sauter.
+	if t == lexer.TOK_SEMICOLON && p.prev.EndLine != 0 && p.cur.Line > p.prev.EndLine {
+		p.panicking = false
+	}
+	return lexer.Token{Type: t}
+}
+
+// quote nomme t dans un message : entre apostrophes pour un mot-clé ou un
+// symbole (« ';' »), tel quel pour une catégorie (« identifier »).
+func quote(t lexer.TokenType) string {
+	if t.IsClass() {
+		return t.String()
+	}
+	return "'" + t.String() + "'"
+}
+
+// errorf signale une erreur de syntaxe sur le lexème courant. Les erreurs
+// suivantes sont tues jusqu'au prochain point de synchronisation (voir
+// synchronize) : ce sont le plus souvent des conséquences de la première.
+// Une erreur tue retourne nil.
+func (p *Parser) errorf(code, format string, args ...any) *diag.Diagnostic {
+	if p.panicking {
+		return nil
+	}
+	p.panicking = true
+	if p.afterLexError() {
+		return nil
+	}
+	return p.errorAt(code, lexer.Span{Start: p.start(), End: p.cur.End(p.lex.File)}, format, args...)
+}
+
+// afterLexError indique si le lexer a sauté un caractère fautif juste
+// devant le lexème courant : il l'a déjà signalé, et l'erreur de syntaxe
+// qui en découle n'apprendrait rien de plus.
+func (p *Parser) afterLexError() bool {
+	from := lexer.Pos{Line: p.prev.EndLine, Col: p.prev.EndCol}
+	to := lexer.Pos{Line: p.cur.Line, Col: p.cur.Col}
+	for _, d := range p.lex.Diagnostics {
+		if at := d.Range.Start; !before(at, from) && before(at, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// before indique si a précède b dans le source.
+func before(a, b lexer.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+// synchronize reprend l'analyse après une erreur de syntaxe : il saute les
+// lexèmes jusqu'au prochain point de synchronisation, juste après un ';'
+// ou un '}', ou devant un '}' ou le début d'une déclaration. before est le
+// lexème où commençait l'instruction fautive ; si elle n'a rien consommé,
+// il est sauté pour que l'analyse avance.
+func (p *Parser) synchronize(before lexer.Token) {
+	p.panicking = false
+	if p.cur == before {
+		if p.cur.Type == lexer.TOK_EOF {
+			return
+		}
+		p.advance()
+	}
+	if p.prev.Type == lexer.TOK_SEMICOLON || p.prev.Type == lexer.TOK_RBRACE {
+		return
+	}
+	for {
+		switch {
+		case p.cur.Type == lexer.TOK_EOF || p.cur.Type == lexer.TOK_RBRACE || p.atDeclaration():
+			return
+		case p.cur.Type == lexer.TOK_SEMICOLON:
+			p.advance()
+			return
+		}
+		p.advance()
+	}
+}
+
+// atDeclaration indique si le lexème courant commence une déclaration : un
+// type, public, une directive ou des attributs.
+func (p *Parser) atDeclaration() bool {
+	switch p.cur.Type {
+	case lexer.TOK_PUBLIC, lexer.TOK_DEFINE, lexer.TOK_INCLUDE, lexer.TOK_PRAGMA:
+		return true
+	case lexer.TOK_LBRACKET:
+		return p.peek.Type == lexer.TOK_LBRACKET
+	}
+	return lexer.IsType(p.cur.Type)
+}
+
+// statementKeywords sont les mots-clés qui commencent une instruction ou
+// une déclaration, proposés à la place d'un identifiant mal orthographié.
+var statementKeywords = []string{
+	"U0", "U8", "U16", "U32", "U64", "I8", "I16", "I32", "I64", "F64", "Bool",
+	"if", "while", "for", "return", "public",
+}
+
+// misspelledKeyword reconnaît un mot-clé mal orthographié en tête
+// d'instruction : un identifiant suivi d'un identifiant, d'un littéral ou
+// d'un type, ce qu'aucune expression n'admet. S'il ressemble à un mot-clé,
+// l'erreur le propose et l'analyse continue comme s'il était écrit ;
+// sinon, l'instruction échouera sur le ';' manquant.
+func (p *Parser) misspelledKeyword() {
+	if p.cur.Type != lexer.TOK_IDENT {
+		return
+	}
+	switch next := p.peek.Type; {
+	case next == lexer.TOK_IDENT, next >= lexer.TOK_INT && next <= lexer.TOK_CHAR, lexer.IsType(next):
+	default:
+		return
 	}
-	return p.advance()
+	kw := diag.Suggest(p.cur.Literal, statementKeywords)
+	if kw == "" {
+		return
+	}
+	rng := lexer.Span{Start: p.start(), End: p.cur.End(p.lex.File)}
+	d := p.errorAt("unknown-keyword", rng, "unknown keyword or type '%s'; did you mean '%s'?", p.cur.Literal, kw)
+	d.Fixes = []diag.FixIt{{Range: rng, Text: kw}}
+	p.cur.Type, p.cur.Literal = lexer.LookupIdent(kw), kw
 }
 
-func (p *Parser) errorf(format string, args ...any) {
-	msg := fmt.Sprintf("%s:%d:%d: %s", p.lex.File, p.cur.Line, p.cur.Col, fmt.Sprintf(format, args...))
-	p.Errors = append(p.Errors, msg)
-	fmt.Fprintln(os.Stderr, msg)
+// errorAt signale une erreur sur rng et retourne le diagnostic pour que
+// l'appelant le complète (fix-its).
+func (p *Parser) errorAt(code string, rng lexer.Span, format string, args ...any) *diag.Diagnostic {
+	p.Diagnostics = append(p.Diagnostics, diag.Errorf(code, rng, format, args...))
+	return &p.Diagnostics[len(p.Diagnostics)-1]
+}
+
+// spanOf retourne l'étendue de node, ou celle du lexème courant s'il n'en
+// a pas.
+func (p *Parser) spanOf(node Node) lexer.Span {
+	if node != nil {
+		if span := node.Span(); !span.IsZero() {
+			return span
+		}
+	}
+	return lexer.Span{Start: p.start(), End: p.cur.End(p.lex.File)}
+}
+
+// bareAttribute propose de réécrire attr sans ses arguments.
+func bareAttribute(attr Attribute) []diag.FixIt {
+	return []diag.FixIt{{Range: attr.Span(), Text: attr.Name}}
 }
 
 func (p *Parser) match(types ...lexer.TokenType) bool {
@@ -52,20 +216,52 @@ func (p *Parser) match(types ...lexer.TokenType) bool {
 
 func (p *Parser) Parse() *Program {
 	prog := &Program{}
+	start := p.start()
 	for p.cur.Type != lexer.TOK_EOF {
+		before := p.cur
 		node := p.parseTopLevel()
 		if node != nil {
 			prog.Decls = append(prog.Decls, node)
 		}
+		if p.panicking || p.cur == before {
+			p.synchronize(before)
+		}
 	}
+	prog.node = p.at(start)
 	return prog
 }
 
 func (p *Parser) parseTopLevel() Node {
-	if p.cur.Type == lexer.TOK_INCLUDE || p.cur.Type == lexer.TOK_DEFINE {
+	p.misspelledKeyword()
+	if p.cur.Type == lexer.TOK_INCLUDE {
 		p.advance()
 		return nil
 	}
+	if p.cur.Type == lexer.TOK_DEFINE {
+		return p.parseDefine()
+	}
+	if p.cur.Type == lexer.TOK_LBRACKET && p.peek.Type == lexer.TOK_LBRACKET {
+		attrs := p.parseAttributes()
+		node := p.parseTopLevel()
+		for _, attr := range attrs {
+			p.applyStmtAttribute(node, attr)
+		}
+		return node
+	}
+	if p.cur.Type == lexer.TOK_PUBLIC {
+		start := p.start()
+		p.advance()
+		if !lexer.IsType(p.cur.Type) {
+			p.errorf("expected-declaration", "expected declaration after 'public'")
+			return nil
+		}
+		node := p.parseDeclaration()
+		if fn, ok := node.(*FuncDecl); ok {
+			fn.Public = true
+			fn.node = p.at(start)
+		}
+		return node
+	}
 	if lexer.IsType(p.cur.Type) {
 		return p.parseDeclaration()
 	}
@@ -73,6 +269,7 @@ func (p *Parser) parseTopLevel() Node {
 }
 
 func (p *Parser) parseDeclaration() Node {
+	start := p.start()
 	typeName := p.cur.Literal
 	p.advance()
 
@@ -84,8 +281,8 @@ func (p *Parser) parseDeclaration() Node {
 	}
 
 	if p.cur.Type != lexer.TOK_IDENT {
-		p.errorf("expected identifier after type")
-		p.advance()
+		p.errorf("expected-identifier", "expected identifier after type, got %s", p.cur.Describe())
+		p.skipBad()
 		return nil
 	}
 
@@ -93,7 +290,7 @@ func (p *Parser) parseDeclaration() Node {
 	p.advance()
 
 	if p.cur.Type == lexer.TOK_LPAREN {
-		return p.parseFuncDecl(typeName, name)
+		return p.parseFuncDecl(typeName, name, start)
 	}
 
 	var init Node
@@ -101,28 +298,33 @@ func (p *Parser) parseDeclaration() Node {
 		p.advance()
 		init = p.parseExpression()
 	}
-	if p.cur.Type == lexer.TOK_SEMICOLON {
-		p.advance()
-	}
-	return &VarDecl{TypeName: typeName, Name: name, Init: init, IsPtr: isPtr}
+	p.expect(lexer.TOK_SEMICOLON)
+	return &VarDecl{TypeName: typeName, Name: name, Init: init, IsPtr: isPtr, node: p.at(start)}
 }
 
-func (p *Parser) parseFuncDecl(retType, name string) Node {
+func (p *Parser) parseFuncDecl(retType, name string, start lexer.Pos) Node {
 	p.expect(lexer.TOK_LPAREN)
 	var params []FuncParam
-	for p.cur.Type != lexer.TOK_RPAREN && p.cur.Type != lexer.TOK_EOF {
+	for p.cur.Type != lexer.TOK_RPAREN && p.cur.Type != lexer.TOK_EOF && !p.panicking {
 		if len(params) > 0 {
 			p.expect(lexer.TOK_COMMA)
 		}
 		params = append(params, p.parseFuncParam())
 	}
 	p.expect(lexer.TOK_RPAREN)
+	if p.cur.Type == lexer.TOK_SEMICOLON && !p.panicking {
+		// Un prototype : une seule erreur, et l'analyse reprend après le ';'.
+		p.errorf("missing-body", "function '%s' has no body; prototypes are not supported, define the function instead", name)
+		p.advance()
+		return nil
+	}
 	body := p.parseBlock()
-	return &FuncDecl{ReturnType: retType, Name: name, Params: params, Body: body}
+	return &FuncDecl{ReturnType: retType, Name: name, Params: params, Body: body, node: p.at(start)}
 }
 
 func (p *Parser) parseFuncParam() FuncParam {
 	param := FuncParam{}
+	start := p.start()
 	if lexer.IsType(p.cur.Type) {
 		param.TypeName = p.cur.Literal
 		p.advance()
@@ -139,23 +341,31 @@ func (p *Parser) parseFuncParam() FuncParam {
 		p.advance()
 		param.Default = p.parseExpression()
 	}
+	param.node = p.at(start)
 	return param
 }
 
 func (p *Parser) parseBlock() *Block {
+	start := p.start()
 	p.expect(lexer.TOK_LBRACE)
 	block := &Block{}
 	for p.cur.Type != lexer.TOK_RBRACE && p.cur.Type != lexer.TOK_EOF {
+		before := p.cur
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Stmts = append(block.Stmts, stmt)
 		}
+		if p.panicking || p.cur == before {
+			p.synchronize(before)
+		}
 	}
 	p.expect(lexer.TOK_RBRACE)
+	block.node = p.at(start)
 	return block
 }
 
 func (p *Parser) parseStatement() Node {
+	p.misspelledKeyword()
 	switch p.cur.Type {
 	case lexer.TOK_LBRACE:
 		return p.parseBlock()
@@ -170,30 +380,200 @@ func (p *Parser) parseStatement() Node {
 	case lexer.TOK_SEMICOLON:
 		p.advance()
 		return nil
+	case lexer.TOK_PRAGMA:
+		return p.parsePragma()
+	case lexer.TOK_DEFINE:
+		return p.parseDefine()
+	case lexer.TOK_LBRACKET:
+		if p.peek.Type == lexer.TOK_LBRACKET {
+			attrs := p.parseAttributes()
+			stmt := p.parseStatement()
+			for _, attr := range attrs {
+				p.applyStmtAttribute(stmt, attr)
+			}
+			return stmt
+		}
 	}
 	if lexer.IsType(p.cur.Type) {
 		return p.parseDeclaration()
 	}
+	start := p.start()
 	expr := p.parseExpression()
-	if p.cur.Type == lexer.TOK_SEMICOLON {
-		p.advance()
+	if call, ok := expr.(*CallExpr); ok && p.cur.Type == lexer.TOK_LBRACE {
+		// `whiel (x) {` : un appel suivi d'un bloc est un if, un while ou un
+		// for mal orthographié. Le bloc est analysé comme l'instruction
+		// suivante.
+		if kw := diag.Suggest(call.Func, []string{"if", "while", "for"}); kw != "" {
+			name := lexer.Span{Start: start, End: lexer.Pos{File: start.File, Line: start.Line, Col: start.Col + len(call.Func)}}
+			d := p.errorAt("unknown-keyword", name, "unknown keyword '%s'; did you mean '%s'?", call.Func, kw)
+			d.Fixes = []diag.FixIt{{Range: name, Text: kw}}
+			return &ExprStmt{Expr: expr, node: p.at(start)}
+		}
+	}
+	p.expect(lexer.TOK_SEMICOLON)
+	return &ExprStmt{Expr: expr, node: p.at(start)}
+}
+
+// parseDefine analyse `#define NOM valeur`. La valeur n'est retenue que si
+// elle forme une expression complète ; sinon la macro est ignorée.
+func (p *Parser) parseDefine() Node {
+	start := p.start()
+	name, value, _ := strings.Cut(p.advance().Literal, " ")
+	def := &DefineDecl{Name: name, node: p.at(start)}
+	if value == "" {
+		return def
+	}
+	sub := NewParser(lexer.NewLexerAt(value, p.lex.File, p.prev.EndLine, p.prev.EndCol-len(value)))
+	expr := sub.parseExpression()
+	if len(sub.Diagnostics) == 0 && len(sub.lex.Diagnostics) == 0 && sub.cur.Type == lexer.TOK_EOF {
+		def.Value = expr
 	}
-	return &ExprStmt{Expr: expr}
+	return def
+}
+
+// parsePragma traite `#pragma bound N` et `#pragma unroll`, qui annotent
+// la boucle suivante ; les autres pragmas sont ignorés.
+func (p *Parser) parsePragma() Node {
+	fields := strings.Fields(p.cur.Literal)
+	p.advance()
+	pragma := lexer.Span{Start: p.prev.Start(p.lex.File), End: p.prev.End(p.lex.File)}
+	if len(fields) == 1 && fields[0] == "unroll" {
+		stmt := p.parseStatement()
+		p.setUnroll(stmt)
+		return stmt
+	}
+	if len(fields) == 0 || fields[0] != "bound" {
+		return nil
+	}
+	if len(fields) != 2 {
+		p.errorAt("invalid-pragma", pragma, "#pragma bound expects one iteration count")
+		return p.parseStatement()
+	}
+	n, err := strconv.ParseInt(fields[1], 0, 64)
+	if err != nil || n <= 0 {
+		p.errorAt("invalid-pragma", pragma, "#pragma bound: invalid iteration count '%s'", fields[1])
+		return p.parseStatement()
+	}
+	stmt := p.parseStatement()
+	p.setLoopBound(stmt, n)
+	return stmt
+}
+
+// parseAttributes lit une liste [[name, name(args...), ...]].
+func (p *Parser) parseAttributes() []Attribute {
+	p.expect(lexer.TOK_LBRACKET)
+	p.expect(lexer.TOK_LBRACKET)
+	var attrs []Attribute
+	for p.cur.Type != lexer.TOK_RBRACKET && p.cur.Type != lexer.TOK_EOF && !p.panicking {
+		if len(attrs) > 0 {
+			p.expect(lexer.TOK_COMMA)
+		}
+		start := p.start()
+		attr := Attribute{Name: p.expect(lexer.TOK_IDENT).Literal}
+		if p.cur.Type == lexer.TOK_LPAREN {
+			p.advance()
+			for p.cur.Type != lexer.TOK_RPAREN && p.cur.Type != lexer.TOK_EOF && !p.panicking {
+				if len(attr.Args) > 0 {
+					p.expect(lexer.TOK_COMMA)
+				}
+				attr.Args = append(attr.Args, p.parseExpression())
+			}
+			p.expect(lexer.TOK_RPAREN)
+		}
+		attr.node = p.at(start)
+		attrs = append(attrs, attr)
+	}
+	p.expect(lexer.TOK_RBRACKET)
+	p.expect(lexer.TOK_RBRACKET)
+	return attrs
+}
+
+func (p *Parser) applyStmtAttribute(stmt Node, attr Attribute) {
+	switch attr.Name {
+	case "bound":
+		lit, ok := singleIntArg(attr)
+		if !ok || lit <= 0 {
+			p.errorAt("invalid-attribute", attr.Span(), "[[bound]] expects one positive integer literal")
+			return
+		}
+		p.setLoopBound(stmt, lit)
+	case "unroll":
+		if len(attr.Args) > 0 {
+			p.errorAt("invalid-attribute", attr.Span(), "[[unroll]] takes no arguments").Fixes = bareAttribute(attr)
+			return
+		}
+		p.setUnroll(stmt)
+	case "inline", "noinline":
+		fn, ok := stmt.(*FuncDecl)
+		if !ok || len(attr.Args) > 0 {
+			d := p.errorAt("invalid-attribute", attr.Span(), "[[%s]] applies to a function declaration and takes no arguments", attr.Name)
+			if ok {
+				d.Fixes = bareAttribute(attr)
+			}
+			return
+		}
+		fn.Inline = InlineAlways
+		if attr.Name == "noinline" {
+			fn.Inline = InlineNever
+		}
+	case "tailcall":
+		fn, ok := stmt.(*FuncDecl)
+		if !ok || len(attr.Args) > 0 {
+			d := p.errorAt("invalid-attribute", attr.Span(), "[[tailcall]] applies to a function declaration and takes no arguments")
+			if ok {
+				d.Fixes = bareAttribute(attr)
+			}
+			return
+		}
+		fn.TailCall = true
+	default:
+		p.errorAt("unknown-attribute", attr.Span(), "unknown statement attribute '%s'", attr.Name)
+	}
+}
+
+func singleIntArg(attr Attribute) (int64, bool) {
+	if len(attr.Args) != 1 {
+		return 0, false
+	}
+	lit, ok := attr.Args[0].(*IntLiteral)
+	if !ok {
+		return 0, false
+	}
+	return lit.Value, true
+}
+
+func (p *Parser) setLoopBound(stmt Node, n int64) {
+	switch s := stmt.(type) {
+	case *WhileStmt:
+		s.Bound = n
+	case *ForStmt:
+		s.Bound = n
+	default:
+		p.errorAt("misplaced-loop-hint", p.spanOf(stmt), "loop bound must precede a 'for' or 'while' loop")
+	}
+}
+
+func (p *Parser) setUnroll(stmt Node) {
+	if s, ok := stmt.(*ForStmt); ok {
+		s.Unroll = true
+		return
+	}
+	p.errorAt("misplaced-loop-hint", p.spanOf(stmt), "unroll request must precede a 'for' loop")
 }
 
 func (p *Parser) parseReturn() Node {
+	start := p.start()
 	p.advance()
 	var val Node
 	if p.cur.Type != lexer.TOK_SEMICOLON {
 		val = p.parseExpression()
 	}
-	if p.cur.Type == lexer.TOK_SEMICOLON {
-		p.advance()
-	}
-	return &ReturnStmt{Value: val}
+	p.expect(lexer.TOK_SEMICOLON)
+	return &ReturnStmt{Value: val, node: p.at(start)}
 }
 
 func (p *Parser) parseIf() Node {
+	start := p.start()
 	p.advance()
 	p.expect(lexer.TOK_LPAREN)
 	cond := p.parseExpression()
@@ -204,19 +584,21 @@ func (p *Parser) parseIf() Node {
 		p.advance()
 		elseBody = p.parseStatement()
 	}
-	return &IfStmt{Cond: cond, Body: body, Else: elseBody}
+	return &IfStmt{Cond: cond, Body: body, Else: elseBody, node: p.at(start)}
 }
 
 func (p *Parser) parseWhile() Node {
+	start := p.start()
 	p.advance()
 	p.expect(lexer.TOK_LPAREN)
 	cond := p.parseExpression()
 	p.expect(lexer.TOK_RPAREN)
 	body := p.parseStatement()
-	return &WhileStmt{Cond: cond, Body: body}
+	return &WhileStmt{Cond: cond, Body: body, node: p.at(start)}
 }
 
 func (p *Parser) parseFor() Node {
+	start := p.start()
 	p.advance()
 	p.expect(lexer.TOK_LPAREN)
 	var init Node
@@ -241,7 +623,7 @@ func (p *Parser) parseFor() Node {
 	}
 	p.expect(lexer.TOK_RPAREN)
 	body := p.parseStatement()
-	return &ForStmt{Init: init, Cond: cond, Post: post, Body: body}
+	return &ForStmt{Init: init, Cond: cond, Post: post, Body: body, node: p.at(start)}
 }
 
 // ---- Expression parsing (Pratt / precedence climbing) ----
@@ -255,7 +637,8 @@ func (p *Parser) parseAssign() Node {
 		lexer.TOK_CARET_EQ, lexer.TOK_SHL_EQ, lexer.TOK_SHR_EQ) {
 		op := p.cur.Type
 		p.advance()
-		return &AssignExpr{Op: op, Target: left, Value: p.parseAssign()}
+		value := p.parseAssign()
+		return &AssignExpr{Op: op, Target: left, Value: value, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -264,7 +647,8 @@ func (p *Parser) parseOr() Node {
 	left := p.parseAnd()
 	for p.cur.Type == lexer.TOK_OR_OR {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_OR_OR, Left: left, Right: p.parseAnd()}
+		right := p.parseAnd()
+		left = &BinaryExpr{Op: lexer.TOK_OR_OR, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -273,7 +657,8 @@ func (p *Parser) parseAnd() Node {
 	left := p.parseBitOr()
 	for p.cur.Type == lexer.TOK_AND_AND {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_AND_AND, Left: left, Right: p.parseBitOr()}
+		right := p.parseBitOr()
+		left = &BinaryExpr{Op: lexer.TOK_AND_AND, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -282,7 +667,8 @@ func (p *Parser) parseBitOr() Node {
 	left := p.parseBitXor()
 	for p.cur.Type == lexer.TOK_PIPE {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_PIPE, Left: left, Right: p.parseBitXor()}
+		right := p.parseBitXor()
+		left = &BinaryExpr{Op: lexer.TOK_PIPE, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -291,7 +677,8 @@ func (p *Parser) parseBitXor() Node {
 	left := p.parseBitAnd()
 	for p.cur.Type == lexer.TOK_CARET {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_CARET, Left: left, Right: p.parseBitAnd()}
+		right := p.parseBitAnd()
+		left = &BinaryExpr{Op: lexer.TOK_CARET, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -300,7 +687,8 @@ func (p *Parser) parseBitAnd() Node {
 	left := p.parseEquality()
 	for p.cur.Type == lexer.TOK_AMP {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_AMP, Left: left, Right: p.parseEquality()}
+		right := p.parseEquality()
+		left = &BinaryExpr{Op: lexer.TOK_AMP, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -310,7 +698,8 @@ func (p *Parser) parseEquality() Node {
 	for p.cur.Type == lexer.TOK_EQ || p.cur.Type == lexer.TOK_NEQ {
 		op := p.cur.Type
 		p.advance()
-		left = &BinaryExpr{Op: op, Left: left, Right: p.parseComparison()}
+		right := p.parseComparison()
+		left = &BinaryExpr{Op: op, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -320,7 +709,8 @@ func (p *Parser) parseComparison() Node {
 	for p.match(lexer.TOK_LT, lexer.TOK_GT, lexer.TOK_LTE, lexer.TOK_GTE) {
 		op := p.cur.Type
 		p.advance()
-		left = &BinaryExpr{Op: op, Left: left, Right: p.parseShift()}
+		right := p.parseShift()
+		left = &BinaryExpr{Op: op, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -330,7 +720,8 @@ func (p *Parser) parseShift() Node {
 	for p.cur.Type == lexer.TOK_SHL || p.cur.Type == lexer.TOK_SHR {
 		op := p.cur.Type
 		p.advance()
-		left = &BinaryExpr{Op: op, Left: left, Right: p.parseAddSub()}
+		right := p.parseAddSub()
+		left = &BinaryExpr{Op: op, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -340,7 +731,8 @@ func (p *Parser) parseAddSub() Node {
 	for p.cur.Type == lexer.TOK_PLUS || p.cur.Type == lexer.TOK_MINUS {
 		op := p.cur.Type
 		p.advance()
-		left = &BinaryExpr{Op: op, Left: left, Right: p.parseMulDiv()}
+		right := p.parseMulDiv()
+		left = &BinaryExpr{Op: op, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -350,7 +742,8 @@ func (p *Parser) parseMulDiv() Node {
 	for p.match(lexer.TOK_STAR, lexer.TOK_SLASH, lexer.TOK_PERCENT) {
 		op := p.cur.Type
 		p.advance()
-		left = &BinaryExpr{Op: op, Left: left, Right: p.parsePower()}
+		right := p.parsePower()
+		left = &BinaryExpr{Op: op, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
@@ -359,28 +752,30 @@ func (p *Parser) parsePower() Node {
 	left := p.parseUnary()
 	if p.cur.Type == lexer.TOK_BACKTICK {
 		p.advance()
-		left = &BinaryExpr{Op: lexer.TOK_BACKTICK, Left: left, Right: p.parseUnary()}
+		right := p.parseUnary()
+		left = &BinaryExpr{Op: lexer.TOK_BACKTICK, Left: left, Right: right, node: p.at(left.Span().Start)}
 	}
 	return left
 }
 
 func (p *Parser) parseUnary() Node {
+	start := p.start()
 	switch p.cur.Type {
 	case lexer.TOK_MINUS:
 		p.advance()
-		return &UnaryExpr{Op: lexer.TOK_MINUS, Operand: p.parseUnary()}
+		return &UnaryExpr{Op: lexer.TOK_MINUS, Operand: p.parseUnary(), node: p.at(start)}
 	case lexer.TOK_TILDE:
 		p.advance()
-		return &UnaryExpr{Op: lexer.TOK_TILDE, Operand: p.parseUnary()}
+		return &UnaryExpr{Op: lexer.TOK_TILDE, Operand: p.parseUnary(), node: p.at(start)}
 	case lexer.TOK_BANG:
 		p.advance()
-		return &UnaryExpr{Op: lexer.TOK_BANG, Operand: p.parseUnary()}
+		return &UnaryExpr{Op: lexer.TOK_BANG, Operand: p.parseUnary(), node: p.at(start)}
 	case lexer.TOK_PLUS_PLUS:
 		p.advance()
-		return &UnaryExpr{Op: lexer.TOK_PLUS_PLUS, Operand: p.parseUnary()}
+		return &UnaryExpr{Op: lexer.TOK_PLUS_PLUS, Operand: p.parseUnary(), node: p.at(start)}
 	case lexer.TOK_MINUS_MINUS:
 		p.advance()
-		return &UnaryExpr{Op: lexer.TOK_MINUS_MINUS, Operand: p.parseUnary()}
+		return &UnaryExpr{Op: lexer.TOK_MINUS_MINUS, Operand: p.parseUnary(), node: p.at(start)}
 	}
 	return p.parsePostfix()
 }
@@ -391,13 +786,13 @@ func (p *Parser) parsePostfix() Node {
 		switch p.cur.Type {
 		case lexer.TOK_PLUS_PLUS:
 			p.advance()
-			left = &PostfixExpr{Op: lexer.TOK_PLUS_PLUS, Operand: left}
+			left = &PostfixExpr{Op: lexer.TOK_PLUS_PLUS, Operand: left, node: p.at(left.Span().Start)}
 		case lexer.TOK_MINUS_MINUS:
 			p.advance()
-			left = &PostfixExpr{Op: lexer.TOK_MINUS_MINUS, Operand: left}
+			left = &PostfixExpr{Op: lexer.TOK_MINUS_MINUS, Operand: left, node: p.at(left.Span().Start)}
 		case lexer.TOK_LPAREN:
 			if ident, ok := left.(*Identifier); ok {
-				left = p.parseCallExpr(ident.Name)
+				left = p.parseCallExpr(ident.Name, ident.Span().Start)
 			} else {
 				return left
 			}
@@ -405,64 +800,82 @@ func (p *Parser) parsePostfix() Node {
 			p.advance()
 			index := p.parseExpression()
 			p.expect(lexer.TOK_RBRACKET)
-			left = &IndexExpr{Array: left, Index: index}
+			left = &IndexExpr{Array: left, Index: index, node: p.at(left.Span().Start)}
 		case lexer.TOK_DOT:
 			p.advance()
-			left = &MemberExpr{Object: left, Member: p.expect(lexer.TOK_IDENT).Literal, Arrow: false}
+			member := p.expect(lexer.TOK_IDENT).Literal
+			left = &MemberExpr{Object: left, Member: member, Arrow: false, node: p.at(left.Span().Start)}
 		case lexer.TOK_ARROW:
 			p.advance()
-			left = &MemberExpr{Object: left, Member: p.expect(lexer.TOK_IDENT).Literal, Arrow: true}
+			member := p.expect(lexer.TOK_IDENT).Literal
+			left = &MemberExpr{Object: left, Member: member, Arrow: true, node: p.at(left.Span().Start)}
 		default:
 			return left
 		}
 	}
 }
 
-func (p *Parser) parseCallExpr(name string) Node {
+func (p *Parser) parseCallExpr(name string, start lexer.Pos) Node {
 	p.expect(lexer.TOK_LPAREN)
 	var args []Node
-	for p.cur.Type != lexer.TOK_RPAREN && p.cur.Type != lexer.TOK_EOF {
+	for p.cur.Type != lexer.TOK_RPAREN && p.cur.Type != lexer.TOK_EOF && !p.panicking {
 		if len(args) > 0 {
 			p.expect(lexer.TOK_COMMA)
 		}
 		args = append(args, p.parseExpression())
 	}
 	p.expect(lexer.TOK_RPAREN)
-	return &CallExpr{Func: name, Args: args}
+	return &CallExpr{Func: name, Args: args, node: p.at(start)}
 }
 
 func (p *Parser) parsePrimary() Node {
+	start := p.start()
 	switch p.cur.Type {
 	case lexer.TOK_INT, lexer.TOK_CHAR:
 		val := p.cur.IntVal
 		p.advance()
-		return &IntLiteral{Value: val}
+		return &IntLiteral{Value: val, node: p.at(start)}
 	case lexer.TOK_FLOAT:
 		val := p.cur.FloatVal
 		p.advance()
-		return &FloatLiteral{Value: val}
+		return &FloatLiteral{Value: val, node: p.at(start)}
 	case lexer.TOK_STRING:
 		val := p.cur.Literal
 		p.advance()
-		return &StringLiteral{Value: val}
+		return &StringLiteral{Value: val, node: p.at(start)}
 	case lexer.TOK_IDENT:
 		name := p.cur.Literal
 		p.advance()
-		return &Identifier{Name: name}
+		return &Identifier{Name: name, node: p.at(start)}
 	case lexer.TOK_SIZEOF:
 		p.advance()
 		p.expect(lexer.TOK_LPAREN)
 		typeName := p.cur.Literal
 		p.advance()
 		p.expect(lexer.TOK_RPAREN)
-		return &SizeofExpr{TypeName: typeName}
+		return &SizeofExpr{TypeName: typeName, node: p.at(start)}
 	case lexer.TOK_LPAREN:
 		p.advance()
 		expr := p.parseExpression()
 		p.expect(lexer.TOK_RPAREN)
+		expr.SetSpan(p.at(start).span) // les parenthèses comprises
 		return expr
 	}
-	p.errorf("unexpected token in expression: '%s'", p.cur.Literal)
+	p.errorf("unexpected-token", "unexpected %s in expression", p.cur.Describe())
+	if !p.skipBad() {
+		return &IntLiteral{Value: 0, node: node{span: lexer.Span{Start: start, End: start}}}
+	}
+	return &IntLiteral{Value: 0, node: p.at(start)}
+}
+
+// skipBad consomme le lexème qui a causé une erreur, sauf un délimiteur
+// fermant, qui reste à l'instruction ou à la liste en cours. Retourne vrai
+// s'il a été consommé.
+func (p *Parser) skipBad() bool {
+	switch p.cur.Type {
+	case lexer.TOK_SEMICOLON, lexer.TOK_RBRACE, lexer.TOK_RPAREN, lexer.TOK_RBRACKET, lexer.TOK_COMMA, lexer.TOK_EOF:
+		return false
+	}
 	p.advance()
-	return &IntLiteral{Value: 0}
+	return true
 }
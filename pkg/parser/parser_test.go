@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"holyc-compiler/pkg/lexer"
+)
+
+func parse(src string) (*Program, []string) {
+	l := lexer.NewLexer(src, "test.HC")
+	p := NewParser(l)
+	prog := p.Parse()
+	var errs []string
+	for _, d := range append(l.Diagnostics, p.Diagnostics...) {
+		errs = append(errs, d.Message)
+	}
+	return prog, errs
+}
+
+func TestLoopBounds(t *testing.T) {
+	prog, errs := parse(`I64 s = 0;
+#pragma bound 16
+for (I64 i = 0; i < 16; i++) s = s + i;
+[[bound(0x8)]]
+while (s > 0) s = s - 1;
+while (s < 3) s = s + 1;
+`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var bounds []int64
+	for _, d := range prog.Decls {
+		switch n := d.(type) {
+		case *ForStmt:
+			bounds = append(bounds, n.Bound)
+		case *WhileStmt:
+			bounds = append(bounds, n.Bound)
+		}
+	}
+	if len(bounds) != 3 || bounds[0] != 16 || bounds[1] != 8 || bounds[2] != 0 {
+		t.Errorf("bounds = %v, want [16 8 0]", bounds)
+	}
+}
+
+func TestPublicFunction(t *testing.T) {
+	prog, errs := parse("public I64 F() { return 1; }\nI64 G() { return 2; }\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(prog.Decls) != 2 {
+		t.Fatalf("%d declarations, want 2", len(prog.Decls))
+	}
+	for i, want := range []bool{true, false} {
+		fn, ok := prog.Decls[i].(*FuncDecl)
+		if !ok || fn.Public != want {
+			t.Errorf("decl %d: %#v, want a function with Public=%v", i, prog.Decls[i], want)
+		}
+	}
+}
+
+func TestAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"[[bound(0)]] while (1) {}", "[[bound]] expects one positive integer literal"},
+		{"[[bound(1, 2)]] while (1) {}", "[[bound]] expects one positive integer literal"},
+		{"[[frobnicate]] while (1) {}", "unknown statement attribute 'frobnicate'"},
+		{"#pragma bound\nwhile (1) {}", "#pragma bound expects one iteration count"},
+		{"#pragma bound x\nwhile (1) {}", "invalid iteration count 'x'"},
+		{"#pragma bound 4\nI64 x = 1;", "loop bound must precede a 'for' or 'while' loop"},
+		{"public 3;", "expected declaration after 'public'"},
+	}
+	for _, tt := range tests {
+		_, errs := parse(tt.src)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want %q", tt.src, errs, tt.want)
+		}
+	}
+	// Les autres pragmas sont ignorés.
+	if _, errs := parse("#pragma once\nI64 x = 1;"); len(errs) > 0 {
+		t.Errorf("#pragma once: %v", errs)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	prog, errs := parse("#define N 4\n#define F(x) x\n#define S \"a\" +\n#define E\nI64 x = N;\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var defs []*DefineDecl
+	for _, d := range prog.Decls {
+		if def, ok := d.(*DefineDecl); ok {
+			defs = append(defs, def)
+		}
+	}
+	// F(x) est une macro à paramètres : ignorée.
+	if len(defs) != 3 {
+		t.Fatalf("%d defines, want 3: %v", len(defs), defs)
+	}
+	if lit, ok := defs[0].Value.(*IntLiteral); defs[0].Name != "N" || !ok || lit.Value != 4 {
+		t.Errorf("define 0 = %s %#v, want N 4", defs[0].Name, defs[0].Value)
+	}
+	for _, def := range defs[1:] {
+		if def.Value != nil {
+			t.Errorf("define %s = %#v, want no value", def.Name, def.Value)
+		}
+	}
+}
+
+func TestInlineHints(t *testing.T) {
+	prog, errs := parse("[[inline]] I64 F() { return 1; }\n[[noinline]] public I64 G() { return 2; }\nI64 H() { return 3; }\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for i, want := range []InlineHint{InlineAlways, InlineNever, InlineAuto} {
+		fn, ok := prog.Decls[i].(*FuncDecl)
+		if !ok || fn.Inline != want {
+			t.Errorf("decl %d: %#v, want a function with Inline=%v", i, prog.Decls[i], want)
+		}
+	}
+	for _, src := range []string{"[[inline]] I64 x = 1;", "[[noinline(2)]] I64 F() { return 1; }"} {
+		if _, errs := parse(src); len(errs) == 0 || !strings.Contains(errs[0], "applies to a function declaration and takes no arguments") {
+			t.Errorf("%q: errors %q", src, errs)
+		}
+	}
+}
+
+func TestUnroll(t *testing.T) {
+	for _, src := range []string{"#pragma unroll\nfor (I64 i = 0; i < 4; i++) {}", "[[unroll]] for (I64 i = 0; i < 4; i++) {}"} {
+		prog, errs := parse(src)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if f, ok := prog.Decls[0].(*ForStmt); !ok || !f.Unroll {
+			t.Errorf("%q: %#v, want a for with Unroll", src, prog.Decls[0])
+		}
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#pragma unroll\nwhile (1) {}", "unroll request must precede a 'for' loop"},
+		{"[[unroll(2)]] for (;;) {}", "[[unroll]] takes no arguments"},
+	}
+	for _, tt := range tests {
+		if _, errs := parse(tt.src); len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want %q", tt.src, errs, tt.want)
+		}
+	}
+}
+
+func TestTailCallAttribute(t *testing.T) {
+	prog, errs := parse("[[tailcall]] I64 F(I64 n) { return F(n); }\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if fn, ok := prog.Decls[0].(*FuncDecl); !ok || !fn.TailCall {
+		t.Errorf("%#v, want a function with TailCall", prog.Decls[0])
+	}
+	if _, errs := parse("[[tailcall]] while (1) {}"); len(errs) == 0 || !strings.Contains(errs[0], "[[tailcall]] applies to a function declaration") {
+		t.Errorf("errors %q", errs)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	prog, errs := parse("I64 x = a + 12;\nif (x)\n  SStore(1, -x);\n")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	decl := prog.Decls[0].(*VarDecl)
+	bin := decl.Init.(*BinaryExpr)
+	stmt := prog.Decls[1].(*IfStmt)
+	call := stmt.Body.(*ExprStmt).Expr.(*CallExpr)
+	tests := []struct {
+		node Node
+		want string
+	}{
+		{decl, "test.HC:1:1-1:16"},
+		{bin, "test.HC:1:9-1:15"},
+		{bin.Left, "test.HC:1:9-1:10"},
+		{bin.Right, "test.HC:1:13-1:15"},
+		{stmt, "test.HC:2:1-3:17"},
+		{stmt.Cond, "test.HC:2:5-2:6"},
+		{call, "test.HC:3:3-3:16"},
+		{call.Args[1], "test.HC:3:13-3:15"},
+	}
+	for _, tt := range tests {
+		s := tt.node.Span()
+		if got := fmt.Sprintf("%s-%d:%d", s.Start, s.End.Line, s.End.Col); got != tt.want {
+			t.Errorf("%T at %s, want %s", tt.node, got, tt.want)
+		}
+	}
+}
+
+// TestDiagnostics vérifie le code, l'étendue et les fix-its des erreurs de
+// syntaxe.
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		fix  string
+	}{
+		{"for (i = 0 i < 3; i++) {}\n", "test.HC:1:12: error: expected ';', got identifier 'i' [expected-token]", "test.HC:1:11-1:11 ';'"},
+		{"I64 x;\n\t[[unroll(2)]] for (I64 i = 0; i < 3; i++) { x += i; }\n", "test.HC:2:4: error: [[unroll]] takes no arguments [invalid-attribute]", "test.HC:2:4-2:13 'unroll'"},
+		{"[[inline(1)]] I64 F() { return 1; }\n", "test.HC:1:3: error: [[inline]] applies to a function declaration and takes no arguments [invalid-attribute]", "test.HC:1:3-1:12 'inline'"},
+		{"[[fast]] for (;;) {}\n", "test.HC:1:3: error: unknown statement attribute 'fast' [unknown-attribute]", ""},
+		{"I64 x = );\n", "test.HC:1:9: error: unexpected ')' in expression [unexpected-token]", ""},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.src, "test.HC"))
+		p.Parse()
+		if len(p.Diagnostics) == 0 {
+			t.Errorf("%q: no diagnostic", tt.src)
+			continue
+		}
+		d := p.Diagnostics[0]
+		if !strings.HasPrefix(d.String(), tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.src, d.String(), tt.want)
+		}
+		var fix string
+		for _, f := range d.Fixes {
+			fix = fmt.Sprintf("%s-%d:%d '%s'", f.Range.Start, f.Range.End.Line, f.Range.End.Col, f.Text)
+		}
+		if fix != tt.fix {
+			t.Errorf("%q: fix %q, want %q", tt.src, fix, tt.fix)
+		}
+	}
+}
+
+// TestRecovery vérifie qu'après une erreur de syntaxe l'analyse reprend au
+// point de synchronisation suivant : une erreur par faute, aucune en
+// cascade.
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"U0 F() {\n  retrun 1;\n}\nI64 x = 1\nSStore(1, x);\n", []string{
+			"test.HC:2:3: error: unknown keyword or type 'retrun'; did you mean 'return'? [unknown-keyword]",
+			"test.HC:4:10: error: expected ';', got identifier 'SStore' [expected-token]",
+		}},
+		{"I64 a = (1 + ;\nI64 b = 2;\nI64 = 3;\nI64 c = 4;\n", []string{
+			"test.HC:1:14: error: unexpected ';' in expression [unexpected-token]",
+			"test.HC:3:5: error: expected identifier after type, got '=' [expected-identifier]",
+		}},
+		{"whiel (1) {\n  SStore(1, 2);\n}\n", []string{
+			"test.HC:1:1: error: unknown keyword 'whiel'; did you mean 'while'? [unknown-keyword]",
+		}},
+		{"I64 F() {\n  I64 y = ) + ;\n  return 1;\n}\nI64 G() { return 2; }\n", []string{
+			"test.HC:2:11: error: unexpected ')' in expression [unexpected-token]",
+		}},
+		// Un prototype : une erreur, et G reste une fonction.
+		{"I64 F(I64 a);\nI64 G() { return 2; }\n", []string{
+			"test.HC:1:13: error: function 'F' has no body; prototypes are not supported, define the function instead [missing-body]",
+		}},
+		// Le lexer a déjà signalé le caractère sauté.
+		{"I64 x = 1 @ 2;\nI64 y = 3;\n", nil},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.src, "test.HC"))
+		p.Parse()
+		var got []string
+		for _, d := range p.Diagnostics {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", tt.src, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// TestRecoveryKeepsDeclarations vérifie que les déclarations qui suivent une
+// erreur restent dans l'AST.
+func TestRecoveryKeepsDeclarations(t *testing.T) {
+	prog, errs := parse("I64 x = );\nI64 y = 2;\nI64 P();\nI64 F() { return y; }\n")
+	if len(errs) != 2 {
+		t.Errorf("errors %q", errs)
+	}
+	var names []string
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *VarDecl:
+			names = append(names, d.Name)
+		case *FuncDecl:
+			names = append(names, d.Name)
+		}
+	}
+	if got := strings.Join(names, " "); got != "x y F" {
+		t.Errorf("declarations %q", got)
+	}
+}